@@ -0,0 +1,29 @@
+// Package blockfilter builds the BIP158 basic filters of blocks served to
+// light clients, along with their BIP157 filter headers.
+package blockfilter
+
+import (
+	"github.com/btcsuite/btcd/btcutil/gcs/builder"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BuildBasic returns the serialized basic filter of a block, given the
+// scripts of the outputs spent by its inputs, and its header, given the
+// header of the filter of the previous block, which is all zeros for the
+// genesis block.
+func BuildBasic(block *wire.MsgBlock, prevOutScripts [][]byte, prevHeader chainhash.Hash) ([]byte, chainhash.Hash, error) {
+	filter, err := builder.BuildBasicFilter(block, prevOutScripts)
+	if err != nil {
+		return nil, chainhash.Hash{}, err
+	}
+	header, err := builder.MakeHeaderForFilter(filter, prevHeader)
+	if err != nil {
+		return nil, chainhash.Hash{}, err
+	}
+	serialized, err := filter.NBytes()
+	if err != nil {
+		return nil, chainhash.Hash{}, err
+	}
+	return serialized, header, nil
+}
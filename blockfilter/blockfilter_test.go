@@ -0,0 +1,24 @@
+package blockfilter
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// TestBuildBasic checks the testnet genesis block against the BIP158 test
+// vectors.
+func TestBuildBasic(t *testing.T) {
+	filter, header, err := BuildBasic(chaincfg.TestNet3Params.GenesisBlock, nil, chainhash.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(filter); got != "019dfca8" {
+		t.Errorf("got filter %s, want 019dfca8", got)
+	}
+	if want := "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750"; header.String() != want {
+		t.Errorf("got header %s, want %s", header, want)
+	}
+}
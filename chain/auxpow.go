@@ -0,0 +1,322 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// auxPoWVersion is the version bit of headers followed by an AuxPoW.
+	auxPoWVersion = 1 << 8
+
+	// chainIDShift is the position of the chain ID in the header version.
+	chainIDShift = 16
+
+	// maxChainBranchLength is the maximum depth of the merkle tree of the
+	// chains merge mined together.
+	maxChainBranchLength = 30
+)
+
+// mergedMiningHeader marks the chain merkle root in the coinbase of the
+// parent block.
+var mergedMiningHeader = []byte{0xfa, 0xbe, 'm', 'm'}
+
+// AuxPoWParams are the merge mining rules of a chain.
+type AuxPoWParams struct {
+	// ChainID is the ID of the chain, set in the version of its headers.
+	ChainID int32
+
+	// StrictChainID is set if headers must carry ChainID, and parent
+	// blocks must not.
+	StrictChainID bool
+
+	// Height is the height from which legacy headers, of version 1, are
+	// no longer accepted. Headers can carry an AuxPoW from then on.
+	Height int32
+}
+
+// IsAuxPoW returns whether a header of the given version is followed by an
+// AuxPoW.
+func IsAuxPoW(version int32) bool {
+	return version&auxPoWVersion != 0
+}
+
+func chainID(version int32) int32 {
+	return version >> chainIDShift
+}
+
+// isLegacy returns whether a header of the given version predates merge
+// mining.
+func isLegacy(version int32) bool {
+	return version == 1 || (version == 2 && chainID(version) == 0)
+}
+
+// CheckVersion checks the version of a header at the given height.
+func (p *AuxPoWParams) CheckVersion(header *wire.BlockHeader, height int32) error {
+	if isLegacy(header.Version) {
+		if height >= p.Height {
+			return fmt.Errorf("header %v has legacy version %d after merge mining started", header.BlockHash(), header.Version)
+		}
+		return nil
+	}
+	if p.StrictChainID && chainID(header.Version) != p.ChainID {
+		return fmt.Errorf("header %v has chain ID %d, expected %d", header.BlockHash(), chainID(header.Version), p.ChainID)
+	}
+	if IsAuxPoW(header.Version) && height < p.Height {
+		return fmt.Errorf("header %v has an AuxPoW before merge mining started", header.BlockHash())
+	}
+	return nil
+}
+
+// AuxPoW is the proof that the work of a parent block, typically of another
+// chain, commits to a merge mined block.
+type AuxPoW struct {
+	// CoinbaseTx is the coinbase of the parent block, which commits to the
+	// root of the chain merkle tree.
+	CoinbaseTx *wire.MsgTx
+	// ParentHash is the hash of the parent block. It is not checked.
+	ParentHash chainhash.Hash
+	// CoinbaseBranch is the merkle branch from the coinbase to the merkle
+	// root of the parent block.
+	CoinbaseBranch []chainhash.Hash
+	CoinbaseIndex  int32
+	// ChainBranch is the merkle branch from the block to the root of the
+	// chain merkle tree.
+	ChainBranch []chainhash.Hash
+	ChainIndex  int32
+	ParentBlock wire.BlockHeader
+}
+
+func readBranch(r io.Reader) ([]chainhash.Hash, int32, error) {
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n > maxChainBranchLength*2 {
+		// No merkle tree of a block is this deep
+		return nil, 0, fmt.Errorf("merkle branch of %d hashes is too long", n)
+	}
+	branch := make([]chainhash.Hash, n)
+	for i := range branch {
+		if _, err := io.ReadFull(r, branch[i][:]); err != nil {
+			return nil, 0, err
+		}
+	}
+	var index int32
+	if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+		return nil, 0, err
+	}
+	return branch, index, nil
+}
+
+// ReadAuxPoW reads an AuxPoW as it follows a header on the wire.
+func ReadAuxPoW(r io.Reader) (*AuxPoW, error) {
+	a := &AuxPoW{CoinbaseTx: new(wire.MsgTx)}
+	if err := a.CoinbaseTx.Deserialize(r); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, a.ParentHash[:]); err != nil {
+		return nil, err
+	}
+	var err error
+	if a.CoinbaseBranch, a.CoinbaseIndex, err = readBranch(r); err != nil {
+		return nil, err
+	}
+	if a.ChainBranch, a.ChainIndex, err = readBranch(r); err != nil {
+		return nil, err
+	}
+	if err := a.ParentBlock.Deserialize(r); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// merkleRoot returns the root of the merkle tree with hash at index and the
+// given branch.
+func merkleRoot(hash chainhash.Hash, branch []chainhash.Hash, index int32) chainhash.Hash {
+	buf := make([]byte, 2*chainhash.HashSize)
+	for _, h := range branch {
+		if index&1 == 1 {
+			copy(buf, h[:])
+			copy(buf[chainhash.HashSize:], hash[:])
+		} else {
+			copy(buf, hash[:])
+			copy(buf[chainhash.HashSize:], h[:])
+		}
+		hash = chainhash.DoubleHashH(buf)
+		index >>= 1
+	}
+	return hash
+}
+
+// expectedIndex returns the position a chain has in a chain merkle tree of
+// the given height, picked from the nonce of the tree.
+func expectedIndex(nonce uint32, chainID int32, height int) int32 {
+	rand := nonce*1103515245 + 12345
+	rand += uint32(chainID)
+	rand = rand*1103515245 + 12345
+	return int32(rand % (1 << height))
+}
+
+// Check checks that the AuxPoW commits to the block with the given hash and
+// chain ID: the block is in the chain merkle tree at the position for the
+// chain, and the root of the tree is in the coinbase of the parent block. The
+// proof of work of the parent block is not checked.
+func (a *AuxPoW) Check(hash chainhash.Hash, id int32, strictChainID bool) error {
+	if a.CoinbaseIndex != 0 {
+		return fmt.Errorf("AuxPoW coinbase is not the first transaction of the parent block")
+	}
+	if strictChainID && chainID(a.ParentBlock.Version) == id {
+		return fmt.Errorf("AuxPoW parent block has the chain ID %d of the block", id)
+	}
+	if len(a.ChainBranch) > maxChainBranchLength {
+		return fmt.Errorf("AuxPoW chain merkle branch is too long")
+	}
+	if !blockchain.IsCoinBaseTx(a.CoinbaseTx) {
+		return fmt.Errorf("AuxPoW coinbase is not a coinbase transaction")
+	}
+	if merkleRoot(a.CoinbaseTx.TxHash(), a.CoinbaseBranch, a.CoinbaseIndex) != a.ParentBlock.MerkleRoot {
+		return fmt.Errorf("AuxPoW coinbase is not in the parent block")
+	}
+
+	// The root is committed to in display order
+	root := merkleRoot(hash, a.ChainBranch, a.ChainIndex)
+	for i, j := 0, len(root)-1; i < j; i, j = i+1, j-1 {
+		root[i], root[j] = root[j], root[i]
+	}
+	script := a.CoinbaseTx.TxIn[0].SignatureScript
+	pos := bytes.Index(script, root[:])
+	if pos < 0 {
+		return fmt.Errorf("AuxPoW coinbase does not commit to the chain merkle root")
+	}
+	if header := bytes.Index(script, mergedMiningHeader); header >= 0 {
+		if bytes.Contains(script[header+1:], mergedMiningHeader) {
+			return fmt.Errorf("AuxPoW coinbase has more than one merged mining header")
+		}
+		if header+len(mergedMiningHeader) != pos {
+			return fmt.Errorf("AuxPoW merged mining header is not just before the chain merkle root")
+		}
+	} else if pos > 20 {
+		// Without the header, the root has to be near the start of the
+		// script
+		return fmt.Errorf("AuxPoW chain merkle root starts too late in the coinbase")
+	}
+
+	pos += len(root)
+	if len(script)-pos < 8 {
+		return fmt.Errorf("AuxPoW coinbase is missing the chain merkle tree size and nonce")
+	}
+	size := binary.LittleEndian.Uint32(script[pos:])
+	if size != 1<<len(a.ChainBranch) {
+		return fmt.Errorf("AuxPoW chain merkle tree size does not match the branch")
+	}
+	nonce := binary.LittleEndian.Uint32(script[pos+4:])
+	if a.ChainIndex != expectedIndex(nonce, id, len(a.ChainBranch)) {
+		return fmt.Errorf("AuxPoW chain index is not the one of the chain")
+	}
+	return nil
+}
+
+// checkAuxPoW checks that the AuxPoW commits to the header and that the
+// parent block meets the target of the header.
+func (c *Chain) checkAuxPoW(header *wire.BlockHeader, a *AuxPoW) error {
+	hash := header.BlockHash()
+	if err := a.Check(hash, chainID(header.Version), c.AuxPoW.StrictChainID); err != nil {
+		return fmt.Errorf("header %v: %v", hash, err)
+	}
+	target := blockchain.CompactToBig(header.Bits)
+	parentHash := c.ProofOfWorkHash(&a.ParentBlock)
+	if blockchain.HashToBig(&parentHash).Cmp(target) > 0 {
+		return fmt.Errorf("AuxPoW parent block of header %v does not meet its proof of work target", hash)
+	}
+	return nil
+}
+
+// StripAuxPoW returns the payload of a headers or block message of a merge
+// mined chain without the AuxPoWs that follow the headers, so that it
+// decodes like a Bitcoin message. The AuxPoWs of headers are checked, as the
+// proof of work of their parent blocks cannot be checked once they are
+// stripped. Payloads of other messages are returned as is.
+func (c *Chain) StripAuxPoW(command string, payload []byte) ([]byte, error) {
+	if c.AuxPoW == nil {
+		return payload, nil
+	}
+	r := bytes.NewReader(payload)
+	out := bytes.NewBuffer(make([]byte, 0, len(payload)))
+	switch command {
+	case wire.CmdHeaders:
+		n, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if n > wire.MaxBlockHeadersPerMsg {
+			return nil, fmt.Errorf("too many headers for message [count %d, max %d]", n, wire.MaxBlockHeadersPerMsg)
+		}
+		if err := wire.WriteVarInt(out, 0, n); err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			header, err := c.readHeader(r, true)
+			if err != nil {
+				return nil, err
+			}
+			if err := header.Serialize(out); err != nil {
+				return nil, err
+			}
+			// The transaction count, always zero
+			txs, err := wire.ReadVarInt(r, 0)
+			if err != nil {
+				return nil, err
+			}
+			if err := wire.WriteVarInt(out, 0, txs); err != nil {
+				return nil, err
+			}
+		}
+
+	case wire.CmdBlock:
+		// The header of a block was checked with the headers
+		header, err := c.readHeader(r, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := header.Serialize(out); err != nil {
+			return nil, err
+		}
+
+	default:
+		return payload, nil
+	}
+	// Anything left is copied as is
+	if _, err := r.WriteTo(out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// readHeader reads a header and the AuxPoW that follows it, which is checked
+// if check is set.
+func (c *Chain) readHeader(r io.Reader, check bool) (*wire.BlockHeader, error) {
+	header := new(wire.BlockHeader)
+	if err := header.Deserialize(r); err != nil {
+		return nil, err
+	}
+	if !IsAuxPoW(header.Version) {
+		return header, nil
+	}
+	auxPoW, err := ReadAuxPoW(r)
+	if err != nil {
+		return nil, err
+	}
+	if check {
+		if err := c.checkAuxPoW(header, auxPoW); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
@@ -0,0 +1,195 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func writeBranch(w *bytes.Buffer, branch []chainhash.Hash, index int32) {
+	wire.WriteVarInt(w, 0, uint64(len(branch)))
+	for _, hash := range branch {
+		w.Write(hash[:])
+	}
+	binary.Write(w, binary.LittleEndian, index)
+}
+
+// writeAuxPoW writes an AuxPoW as it follows a header on the wire.
+func writeAuxPoW(w *bytes.Buffer, a *AuxPoW) {
+	if err := a.CoinbaseTx.Serialize(w); err != nil {
+		panic(err)
+	}
+	w.Write(a.ParentHash[:])
+	writeBranch(w, a.CoinbaseBranch, a.CoinbaseIndex)
+	writeBranch(w, a.ChainBranch, a.ChainIndex)
+	if err := a.ParentBlock.Serialize(w); err != nil {
+		panic(err)
+	}
+}
+
+// testAuxPoW returns a merge mined header and its AuxPoW, whose parent
+// block only has a coinbase committing to the header.
+func testAuxPoW(c *Chain) (*wire.BlockHeader, *AuxPoW) {
+	prevHash := c.Params.GenesisHash
+	header := wire.NewBlockHeader(c.AuxPoW.ChainID<<chainIDShift|auxPoWVersion|4, prevHash, &chainhash.Hash{1}, c.Params.PowLimitBits, 0)
+	header.Timestamp = c.Params.GenesisBlock.Header.Timestamp.Add(c.Params.TargetTimePerBlock)
+	hash := header.BlockHash()
+
+	// The root of a chain merkle tree with only the header, in display
+	// order, followed by the size of the tree and its nonce
+	root := make([]byte, chainhash.HashSize)
+	for i := range root {
+		root[i] = hash[len(hash)-1-i]
+	}
+	commitment := append(append(append([]byte{}, mergedMiningHeader...), root...), 1, 0, 0, 0, 0, 0, 0, 0)
+	script, err := txscript.NewScriptBuilder().AddInt64(1).AddData(commitment).Script()
+	if err != nil {
+		panic(err)
+	}
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), script, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50e8, []byte{txscript.OP_TRUE}))
+
+	coinbaseHash := coinbase.TxHash()
+	a := &AuxPoW{
+		CoinbaseTx:  coinbase,
+		ParentBlock: *wire.NewBlockHeader(2, &chainhash.Hash{2}, &coinbaseHash, header.Bits, 0),
+	}
+	return header, a
+}
+
+// mineParent sets the nonce of the parent block so that it meets the target
+// of the header, or misses it if valid is false.
+func mineParent(c *Chain, header *wire.BlockHeader, a *AuxPoW, valid bool) {
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := c.ProofOfWorkHash(&a.ParentBlock)
+		if (blockchain.HashToBig(&hash).Cmp(target) <= 0) == valid {
+			return
+		}
+		a.ParentBlock.Nonce++
+	}
+}
+
+func TestReadAuxPoW(t *testing.T) {
+	c := lookup(t, "dogecoin", "regtest")
+	header, a := testAuxPoW(c)
+	buf := new(bytes.Buffer)
+	writeAuxPoW(buf, a)
+
+	read, err := ReadAuxPoW(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.CoinbaseTx.TxHash() != a.CoinbaseTx.TxHash() || read.ParentBlock.BlockHash() != a.ParentBlock.BlockHash() ||
+		len(read.CoinbaseBranch) != 0 || len(read.ChainBranch) != 0 || read.CoinbaseIndex != 0 || read.ChainIndex != 0 {
+		t.Fatalf("got AuxPoW %+v, want %+v", read, a)
+	}
+	if err := read.Check(header.BlockHash(), c.AuxPoW.ChainID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A branch longer than any merkle tree is rejected before it is read
+	buf.Reset()
+	a.CoinbaseBranch = make([]chainhash.Hash, maxChainBranchLength*2+1)
+	writeAuxPoW(buf, a)
+	if _, err := ReadAuxPoW(bytes.NewReader(buf.Bytes())); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("got error %v, want a branch that is too long", err)
+	}
+}
+
+func TestStripAuxPoW(t *testing.T) {
+	c := lookup(t, "dogecoin", "regtest")
+	tests := []struct {
+		name    string
+		auxPoW  func(header *wire.BlockHeader, a *AuxPoW)
+		noPoW   bool
+		wantErr string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:    "parent block misses the target",
+			noPoW:   true,
+			wantErr: "does not meet its proof of work target",
+		},
+		{
+			name: "coinbase not in the parent block",
+			auxPoW: func(header *wire.BlockHeader, a *AuxPoW) {
+				a.ParentBlock.MerkleRoot = chainhash.Hash{3}
+			},
+			wantErr: "coinbase is not in the parent block",
+		},
+		{
+			name: "parent block with the chain ID",
+			auxPoW: func(header *wire.BlockHeader, a *AuxPoW) {
+				a.ParentBlock.Version = header.Version
+			},
+			wantErr: "has the chain ID",
+		},
+		{
+			name: "coinbase committing to another block",
+			auxPoW: func(header *wire.BlockHeader, a *AuxPoW) {
+				header.Nonce++
+			},
+			wantErr: "does not commit to the chain merkle root",
+		},
+		{
+			name: "chain branch not matching the tree size",
+			auxPoW: func(header *wire.BlockHeader, a *AuxPoW) {
+				// The coinbase commits to the root with the extra hash
+				a.ChainBranch = []chainhash.Hash{{4}}
+				root := merkleRoot(header.BlockHash(), a.ChainBranch, a.ChainIndex)
+				script := a.CoinbaseTx.TxIn[0].SignatureScript
+				pos := bytes.Index(script, mergedMiningHeader) + len(mergedMiningHeader)
+				for i := range root {
+					script[pos+i] = root[len(root)-1-i]
+				}
+				a.ParentBlock.MerkleRoot = a.CoinbaseTx.TxHash()
+			},
+			wantErr: "size does not match the branch",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header, a := testAuxPoW(c)
+			if test.auxPoW != nil {
+				test.auxPoW(header, a)
+			}
+			mineParent(c, header, a, !test.noPoW)
+
+			msg := new(bytes.Buffer)
+			wire.WriteVarInt(msg, 0, 1)
+			if err := header.Serialize(msg); err != nil {
+				t.Fatal(err)
+			}
+			writeAuxPoW(msg, a)
+			wire.WriteVarInt(msg, 0, 0)
+
+			payload, err := c.StripAuxPoW(wire.CmdHeaders, msg.Bytes())
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers := wire.NewMsgHeaders()
+			if err := headers.BtcDecode(bytes.NewReader(payload), 0, wire.BaseEncoding); err != nil {
+				t.Fatal(err)
+			}
+			if len(headers.Headers) != 1 || headers.Headers[0].BlockHash() != header.BlockHash() {
+				t.Fatalf("got headers %v, want only %s", headers.Headers, header.BlockHash())
+			}
+		})
+	}
+}
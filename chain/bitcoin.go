@@ -0,0 +1,20 @@
+package chain
+
+import "github.com/btcsuite/btcd/chaincfg"
+
+func init() {
+	for network, params := range map[string]*chaincfg.Params{
+		"mainnet": &chaincfg.MainNetParams,
+		"testnet": &chaincfg.TestNet3Params,
+		"regtest": &chaincfg.RegressionNetParams,
+	} {
+		mustRegister(&Chain{
+			Name:          "bitcoin",
+			Network:       network,
+			Params:        params,
+			Segwit:        true,
+			Retarget:      BitcoinRetarget,
+			NoRetargeting: network == "regtest",
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package chain describes the Bitcoin-like chains the indexer can index:
+// their network parameters, how the proof of work of their headers is
+// computed and retargeted, whether they are merge mined, and the protocol
+// their peers speak. Bitcoin, Litecoin and Dogecoin are registered for their
+// main, test and regression test networks.
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Headers gives access to the headers of the chain a new header builds on.
+type Headers interface {
+	// HeaderAt returns the header at the given height.
+	HeaderAt(height int32) (*wire.BlockHeader, error)
+}
+
+// Retarget returns the target bits of the header at the given height that
+// follows prev and has the given timestamp.
+type Retarget func(c *Chain, headers Headers, prev *wire.BlockHeader, height int32, timestamp time.Time) (uint32, error)
+
+// Chain is a network of a Bitcoin-like chain.
+type Chain struct {
+	// Name is the name of the chain, e.g. "litecoin".
+	Name string
+
+	// Network is the name of the network, "mainnet", "testnet" or
+	// "regtest".
+	Network string
+
+	// Params are the network parameters: the P2P magic, port and seeds,
+	// the address encoding, the genesis block and the proof of work limit.
+	Params *chaincfg.Params
+
+	// ProtocolVersion is the highest P2P protocol version advertised to
+	// peers.
+	ProtocolVersion uint32
+
+	// Segwit is set on chains with segregated witness, whose blocks and
+	// transactions are requested with their witness data.
+	Segwit bool
+
+	// PowHash returns the hash the proof of work of a header is checked
+	// against. It is the block hash if PowHash is nil.
+	PowHash func(header *wire.BlockHeader) chainhash.Hash
+
+	// Retarget returns the target bits of a new header.
+	Retarget Retarget
+
+	// NoRetargeting is set on networks whose difficulty never changes
+	// after its retarget intervals.
+	NoRetargeting bool
+
+	// AuxPoW is set on merge mined chains.
+	AuxPoW *AuxPoWParams
+
+	// BlockSubsidy returns the coins a new block creates. It is the
+	// Bitcoin halving schedule if BlockSubsidy is nil.
+	BlockSubsidy func(c *Chain, height int32, prevHash chainhash.Hash) int64
+}
+
+// ProofOfWorkHash returns the hash the proof of work of the header is checked
+// against.
+func (c *Chain) ProofOfWorkHash(header *wire.BlockHeader) chainhash.Hash {
+	if c.PowHash == nil {
+		return header.BlockHash()
+	}
+	return c.PowHash(header)
+}
+
+// RequiredBits returns the target bits of the header at the given height that
+// follows prev and has the given timestamp.
+func (c *Chain) RequiredBits(headers Headers, prev *wire.BlockHeader, height int32, timestamp time.Time) (uint32, error) {
+	return c.Retarget(c, headers, prev, height, timestamp)
+}
+
+func (c *Chain) String() string {
+	return c.Name + " " + c.Network
+}
+
+var (
+	mu     sync.RWMutex
+	chains = map[string]*Chain{}
+)
+
+func registryKey(name, network string) string {
+	return strings.ToLower(name) + "/" + strings.ToLower(network)
+}
+
+// Register adds a chain, which can then be looked up by name and network.
+func Register(c *Chain) error {
+	if c.Params == nil || c.Retarget == nil {
+		return fmt.Errorf("chain %v has no params or retarget rule", c)
+	}
+	if c.ProtocolVersion == 0 {
+		c.ProtocolVersion = peer.MaxProtocolVersion
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	key := registryKey(c.Name, c.Network)
+	if _, ok := chains[key]; ok {
+		return fmt.Errorf("chain %v is already registered", c)
+	}
+	chains[key] = c
+	return nil
+}
+
+func mustRegister(c *Chain) {
+	if err := Register(c); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the chain registered under the name and network. An empty
+// name stands for bitcoin.
+func Lookup(name, network string) (*Chain, error) {
+	if name == "" {
+		name = "bitcoin"
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := chains[registryKey(name, network)]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %q on network %q", name, network)
+	}
+	return c, nil
+}
+
+// Registered returns the names of the registered chains and networks.
+func Registered() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(chains))
+	for key := range chains {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForParams returns the registered chain with the given network parameters.
+// Parameters that are not registered are taken to be those of a network
+// following the rules of Bitcoin.
+func ForParams(params *chaincfg.Params) *Chain {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, c := range chains {
+		if c.Params == params {
+			return c
+		}
+	}
+	return &Chain{
+		Name:            "bitcoin",
+		Network:         params.Name,
+		Params:          params,
+		ProtocolVersion: peer.MaxProtocolVersion,
+		Segwit:          true,
+		Retarget:        BitcoinRetarget,
+	}
+}
@@ -0,0 +1,194 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func lookup(t *testing.T, name, network string) *Chain {
+	t.Helper()
+	c, err := Lookup(name, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGenesisHash(t *testing.T) {
+	for _, test := range []struct {
+		name, network string
+		hash          string
+	}{
+		{"litecoin", "mainnet", "12a765e31ffd4059bada1e25190f6e98c99d9714d334efa41a195a7e7e04bfe2"},
+		{"litecoin", "testnet", "4966625a4b2851d9fdee139e56211a0d88575f59ed816ff5e6a63deb4e3e29a0"},
+		{"litecoin", "regtest", "530827f38f93b43ed12af0b3ad25a288dc02ed74d6d7857862df51fc56c416f9"},
+		{"dogecoin", "mainnet", "1a91e3dace36e2be3bf030a65679fe821aa1d6ef92e7c9902eb318182c355691"},
+		{"dogecoin", "testnet", "bb0a78264637406b6360aad926284d544d7049f45189db5664f3c4d07350559e"},
+		{"dogecoin", "regtest", "3d2160a3b5dc4a9d62e7e66a295f70313ac808440ef7400d6c0772171ce973a5"},
+	} {
+		c := lookup(t, test.name, test.network)
+		if got := c.Params.GenesisHash.String(); got != test.hash {
+			t.Errorf("%v: got genesis hash %s, want %s", c, got, test.hash)
+		}
+	}
+}
+
+// TestScryptHash checks the proof of work hashes of Litecoin main network
+// headers, from the scrypt tests of Litecoin Core.
+func TestScryptHash(t *testing.T) {
+	c := lookup(t, "litecoin", "mainnet")
+	for _, test := range []struct {
+		header string
+		hash   string
+	}{
+		{
+			"020000004c1271c211717198227392b029a64a7971931d351b387bb80db027f270411e398a07046f7d4a08dd815412a8712f874a7ebf0507e3878bd24e20a3b73fd750a667d2f451eac7471b00de6659",
+			"00000000002bef4107f882f6115e0b01f348d21195dacd3582aa2dabd7985806",
+		},
+		{
+			"0200000011503ee6a855e900c00cfdd98f5f55fffeaee9b6bf55bea9b852d9de2ce35828e204eef76acfd36949ae56d1fbe81c1ac9c0209e6331ad56414f9072506a77f8c6faf551eac7471b00389d01",
+			"00000000003a0d11bdd5eb634e08b7feddcfbbf228ed35d250daf19f1c88fc94",
+		},
+		{
+			"02000000a72c8a177f523946f42f22c3e86b8023221b4105e8007e59e81f6beb013e29aaf635295cb9ac966213fb56e046dc71df5b3f7f67ceaeab24038e743f883aff1aaafaf551eac7471b0166249b",
+			"00000000000b40f895f288e13244728a6c2d9d59d8aff29c65f8dd5114a8ca81",
+		},
+		{
+			"010000007824bc3a8a1b4628485eee3024abd8626721f7f870f8ad4d2f33a27155167f6a4009d1285049603888fe85a84b6c803a53305a8d497965a5e896e1a00568359589faf551eac7471b0065434e",
+			"00000000003007005891cd4923031e99d8e8d72f6e8e7edc6a86181897e105fe",
+		},
+		{
+			"0200000050bfd4e4a307a8cb6ef4aef69abc5c0f2d579648bd80d7733e1ccc3fbc90ed664a7f74006cb11bde87785f229ecd366c2d4e44432832580e0608c579e4cb76f383f7f551eac7471b00c36982",
+			"000000000018f0b426a4afc7130ccb47fa02af730d345b4fe7c7724d3800ec8c",
+		},
+	} {
+		header := new(wire.BlockHeader)
+		if err := header.Deserialize(bytes.NewReader(mustDecodeHex(test.header))); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.ProofOfWorkHash(header).String(); got != test.hash {
+			t.Errorf("got proof of work hash %s for header %s, want %s", got, header.BlockHash(), test.hash)
+		}
+	}
+}
+
+// headerTimes are headers that only have a timestamp, by height.
+type headerTimes map[int32]int64
+
+func (h headerTimes) HeaderAt(height int32) (*wire.BlockHeader, error) {
+	timestamp, ok := h[height]
+	if !ok {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+	return &wire.BlockHeader{Timestamp: time.Unix(timestamp, 0)}, nil
+}
+
+// TestRequiredBits checks retargets of main network headers, from the proof
+// of work tests of Bitcoin Core, Litecoin Core and Dogecoin Core.
+func TestRequiredBits(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		chain       string
+		firstHeight int32
+		firstTime   int64
+		prevHeight  int32
+		prevTime    int64
+		prevBits    uint32
+		want        uint32
+	}{
+		{"bitcoin retarget", "bitcoin", 30240, 1261130161, 32255, 1262152739, 0x1d00ffff, 0x1d00d86a},
+		{"bitcoin proof of work limit", "bitcoin", 0, 1231006505, 2015, 1233061996, 0x1d00ffff, 0x1d00ffff},
+		{"bitcoin lower limit", "bitcoin", 66528, 1279008237, 68543, 1279297671, 0x1c05a3f4, 0x1c0168fd},
+		{"bitcoin upper limit", "bitcoin", 44352, 1263163443, 46367, 1269211443, 0x1c387f6f, 0x1d00e1fd},
+		{"litecoin retarget", "litecoin", 278207, 1358118740, 280223, 1358378777, 0x1c0ac141, 0x1c093f8d},
+		{"litecoin proof of work limit", "litecoin", 0, 1317972665, 2015, 1318480354, 0x1e0ffff0, 0x1e0fffff},
+		{"litecoin lower limit", "litecoin", 578591, 1401682934, 580607, 1401757934, 0x1b075cf1, 0x1b01d73c},
+		{"litecoin upper limit", "litecoin", 999935, 1463690315, 1001951, 1464900315, 0x1b015318, 0x1b054c60},
+		{"dogecoin first retarget", "dogecoin", 0, 1386474927, 239, 1386475638, 0x1e0ffff0, 0x1e00ffff},
+		{"dogecoin before digishield", "dogecoin", 9359, 1386942008, 9599, 1386954113, 0x1c1a1206, 0x1c15ea59},
+		{"dogecoin digishield", "dogecoin", 144999, 1395094427, 145000, 1395094679, 0x1b499dfd, 0x1b671062},
+		{"dogecoin digishield upper limit", "dogecoin", 145106, 1395100835, 145107, 1395101360, 0x1b3439cd, 0x1b4e56b3},
+		{"dogecoin digishield lower limit", "dogecoin", 149422, 1395380517, 149423, 1395380447, 0x1b446f21, 0x1b335358},
+		{"dogecoin digishield rounding", "dogecoin", 145000, 1395094679, 145001, 1395094727, 0x1b671062, 0x1b6558a4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := lookup(t, test.chain, "mainnet")
+			prev := &wire.BlockHeader{Timestamp: time.Unix(test.prevTime, 0), Bits: test.prevBits}
+			headers := headerTimes{test.firstHeight: test.firstTime}
+			bits, err := c.RequiredBits(headers, prev, test.prevHeight+1, prev.Timestamp.Add(c.Params.TargetTimePerBlock))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bits != test.want {
+				t.Fatalf("got bits %08x, want %08x", bits, test.want)
+			}
+		})
+	}
+}
+
+// TestSubsidy checks the Dogecoin rewards against the subsidy tests of
+// Dogecoin Core, which seed the random rewards with a zero hash.
+func TestSubsidy(t *testing.T) {
+	dogecoin := lookup(t, "dogecoin", "mainnet")
+	sum := func(from, to int32) int64 {
+		total := int64(0)
+		for height := from; height <= to; height++ {
+			subsidy := dogecoin.Subsidy(height, chainhash.Hash{})
+			if subsidy <= 0 || subsidy > 1000000*btcutil.SatoshiPerBitcoin {
+				t.Fatalf("got subsidy %d at height %d", subsidy, height)
+			}
+			total += subsidy
+		}
+		return total
+	}
+	if got := sum(0, 100000); got != 54894174438*btcutil.SatoshiPerBitcoin {
+		t.Errorf("got %d in the first 100,000 blocks, want 54894174438 coins", got)
+	}
+	if got := sum(100000, 145000); got != 12349960000*btcutil.SatoshiPerBitcoin {
+		t.Errorf("got %d in blocks 100,000 to 145,000, want 12349960000 coins", got)
+	}
+
+	// The random rewards are seeded with digits 7 to 13 of the hash
+	prevHash, err := chainhash.NewHashFromStr("fffffff0000000ffffffffffffffffffffffffffffffffffffffffffffffffff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dogecoin.Subsidy(1, *prevHash), dogecoin.Subsidy(1, chainhash.Hash{}); got != want {
+		t.Errorf("got subsidy %d with a zero seed, want %d", got, want)
+	}
+	prevHash[26] = 0x10
+	if got, other := dogecoin.Subsidy(1, *prevHash), dogecoin.Subsidy(1, chainhash.Hash{}); got == other {
+		t.Errorf("got subsidy %d with another seed, want it to differ", got)
+	}
+
+	for _, test := range []struct {
+		name, network string
+		height        int32
+		want          int64
+	}{
+		{"dogecoin", "mainnet", 145000, 250000e8},
+		{"dogecoin", "mainnet", 199999, 250000e8},
+		{"dogecoin", "mainnet", 200000, 125000e8},
+		{"dogecoin", "mainnet", 599999, 15625e8},
+		{"dogecoin", "mainnet", 600000, 10000e8},
+		{"dogecoin", "mainnet", 5000000, 10000e8},
+		{"dogecoin", "regtest", 0, 500000e8},
+		{"dogecoin", "regtest", 150, 250000e8},
+		{"dogecoin", "regtest", 900, 10000e8},
+		{"litecoin", "mainnet", 0, 50e8},
+		{"litecoin", "mainnet", 840000, 25e8},
+		{"litecoin", "mainnet", 2520000, 6.25e8},
+		{"bitcoin", "mainnet", 210000, 25e8},
+	} {
+		c := lookup(t, test.name, test.network)
+		if got := c.Subsidy(test.height, chainhash.Hash{}); got != test.want {
+			t.Errorf("%v: got subsidy %d at height %d, want %d", c, got, test.height, test.want)
+		}
+	}
+}
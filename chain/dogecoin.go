@@ -0,0 +1,210 @@
+package chain
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// dogecoinProtocolVersion is the protocol version of Dogecoin Core.
+	dogecoinProtocolVersion = 70015
+
+	// dogecoinChainID is the chain ID of Dogecoin headers.
+	dogecoinChainID = 0x62
+
+	// digishieldIntervalHeight is the height after which the difficulty is
+	// retargeted every block, on every network.
+	digishieldIntervalHeight = 145000
+
+	// digishieldMinDifficultyHeight is the height after which the networks
+	// that allow minimum difficulty blocks allow them on retargets.
+	digishieldMinDifficultyHeight = 157500
+)
+
+var (
+	dogecoinGenesisScript = mustDecodeHex("04ffff001d0104084e696e746f6e646f")
+
+	dogecoinMainNetGenesis = newGenesisBlock(dogecoinGenesisScript, 88e8, 1386325540, 0x1e0ffff0, 99943)
+	dogecoinTestNetGenesis = newGenesisBlock(dogecoinGenesisScript, 88e8, 1391503289, 0x1e0ffff0, 997879)
+	dogecoinRegTestGenesis = newGenesisBlock(dogecoinGenesisScript, 88e8, 1296688602, 0x207fffff, 2)
+)
+
+// DogecoinMainNetParams are the parameters of the Dogecoin main network.
+var DogecoinMainNetParams = chaincfg.Params{
+	Name:        "mainnet",
+	Net:         wire.BitcoinNet(0xc0c0c0c0),
+	DefaultPort: "22556",
+	DNSSeeds: []chaincfg.DNSSeed{
+		{Host: "seed.multidoge.org", HasFiltering: true},
+		{Host: "seed2.multidoge.org", HasFiltering: false},
+	},
+
+	GenesisBlock:             dogecoinMainNetGenesis,
+	GenesisHash:              genesisHash(dogecoinMainNetGenesis),
+	PowLimit:                 powLimit(236),
+	PowLimitBits:             0x1e0fffff,
+	BIP0034Height:            1034383,
+	BIP0065Height:            3464751,
+	BIP0066Height:            1034383,
+	CoinbaseMaturity:         240,
+	SubsidyReductionInterval: 100000,
+	TargetTimespan:           4 * time.Hour,
+	TargetTimePerBlock:       time.Minute,
+	RetargetAdjustmentFactor: 4,
+
+	PubKeyHashAddrID: 0x1e,
+	ScriptHashAddrID: 0x16,
+	PrivateKeyID:     0x9e,
+	HDPrivateKeyID:   [4]byte{0x02, 0xfa, 0xc3, 0x98},
+	HDPublicKeyID:    [4]byte{0x02, 0xfa, 0xca, 0xfd},
+	HDCoinType:       3,
+}
+
+// DogecoinTestNetParams are the parameters of the Dogecoin test network.
+var DogecoinTestNetParams = chaincfg.Params{
+	Name:        "testnet3",
+	Net:         wire.BitcoinNet(0xdcb7c1fc),
+	DefaultPort: "44556",
+	DNSSeeds: []chaincfg.DNSSeed{
+		{Host: "testseed.jrn.me.uk", HasFiltering: false},
+	},
+
+	GenesisBlock:             dogecoinTestNetGenesis,
+	GenesisHash:              genesisHash(dogecoinTestNetGenesis),
+	PowLimit:                 powLimit(236),
+	PowLimitBits:             0x1e0fffff,
+	BIP0034Height:            708658,
+	BIP0065Height:            1854705,
+	BIP0066Height:            708658,
+	CoinbaseMaturity:         240,
+	SubsidyReductionInterval: 100000,
+	TargetTimespan:           4 * time.Hour,
+	TargetTimePerBlock:       time.Minute,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     2 * time.Minute,
+
+	PubKeyHashAddrID: 0x71,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xf1,
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// DogecoinRegressionNetParams are the parameters of the Dogecoin regression
+// test network.
+var DogecoinRegressionNetParams = chaincfg.Params{
+	Name:        "regtest",
+	Net:         wire.BitcoinNet(0xdab5bffa),
+	DefaultPort: "18444",
+
+	GenesisBlock:             dogecoinRegTestGenesis,
+	GenesisHash:              genesisHash(dogecoinRegTestGenesis),
+	PowLimit:                 powLimit(255),
+	PowLimitBits:             0x207fffff,
+	BIP0034Height:            100000000,
+	BIP0065Height:            1351,
+	BIP0066Height:            1251,
+	CoinbaseMaturity:         60,
+	SubsidyReductionInterval: 150,
+	TargetTimespan:           4 * time.Hour,
+	TargetTimePerBlock:       time.Minute,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     2 * time.Minute,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// DogecoinRetarget returns a Retarget rule with the Dogecoin retargets: every
+// 240 blocks at first, with the first retargets allowed wider swings, and
+// with DigiShield every block from digishieldHeight on, moving an eighth of
+// the way towards the target spacing.
+func DogecoinRetarget(digishieldHeight int32) Retarget {
+	return func(c *Chain, headers Headers, prev *wire.BlockHeader, height int32, timestamp time.Time) (uint32, error) {
+		params := c.Params
+		digishield := height >= digishieldHeight
+		targetTimespan := params.TargetTimespan
+		if digishield {
+			targetTimespan = params.TargetTimePerBlock
+		}
+
+		if params.ReduceMinDifficulty && height-1 >= digishieldMinDifficultyHeight &&
+			timestamp.After(prev.Timestamp.Add(params.MinDiffReductionTime)) {
+			return params.PowLimitBits, nil
+		}
+
+		// The retarget interval does not follow digishieldHeight on the
+		// test networks
+		interval := blocksPerRetarget(params, params.TargetTimespan)
+		if height-1 >= digishieldIntervalHeight {
+			interval = 1
+		}
+		if height%interval != 0 {
+			return nonRetargetBits(params, headers, prev, height, blocksPerRetarget(params, targetTimespan), timestamp)
+		}
+		if c.NoRetargeting {
+			return prev.Bits, nil
+		}
+
+		first := height - interval - 1
+		if height == interval {
+			first = 0
+		}
+		firstHeader, err := headers.HeaderAt(first)
+		if err != nil {
+			return 0, err
+		}
+
+		target := int64(targetTimespan / time.Second)
+		timespan := prev.Timestamp.Unix() - firstHeader.Timestamp.Unix()
+		var minTimespan, maxTimespan int64
+		switch {
+		case digishield:
+			timespan = target + (timespan-target)/8
+			minTimespan, maxTimespan = target-target/4, target+target/2
+		case height > 10000:
+			minTimespan, maxTimespan = target/4, target*4
+		case height > 5000:
+			minTimespan, maxTimespan = target/8, target*4
+		default:
+			minTimespan, maxTimespan = target/16, target*4
+		}
+		return scaleBits(params, prev.Bits, clamp(timespan, minTimespan, maxTimespan), target), nil
+	}
+}
+
+func init() {
+	for _, c := range []struct {
+		network          string
+		params           *chaincfg.Params
+		digishieldHeight int32
+		simplifiedHeight int32
+		auxPoW           AuxPoWParams
+	}{
+		{"mainnet", &DogecoinMainNetParams, 145000, 145000, AuxPoWParams{dogecoinChainID, true, 371337}},
+		{"testnet", &DogecoinTestNetParams, 145000, 145000, AuxPoWParams{dogecoinChainID, false, 158100}},
+		{"regtest", &DogecoinRegressionNetParams, 10, 0, AuxPoWParams{dogecoinChainID, true, 20}},
+	} {
+		auxPoW := c.auxPoW
+		mustRegister(&Chain{
+			Name:            "dogecoin",
+			Network:         c.network,
+			Params:          c.params,
+			ProtocolVersion: dogecoinProtocolVersion,
+			PowHash:         ScryptHash,
+			Retarget:        DogecoinRetarget(c.digishieldHeight),
+			NoRetargeting:   c.network == "regtest",
+			AuxPoW:          &auxPoW,
+			BlockSubsidy:    DogecoinSubsidy(c.simplifiedHeight),
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package chain
+
+import (
+	"encoding/hex"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var bigOne = big.NewInt(1)
+
+// powLimit returns 2^bits - 1.
+func powLimit(bits uint) *big.Int {
+	return new(big.Int).Sub(new(big.Int).Lsh(bigOne, bits), bigOne)
+}
+
+// genesisPkScript is the output script of the genesis coinbase of Litecoin
+// and Dogecoin.
+var genesisPkScript = mustDecodeHex("41040184710fa689ad5023690c80f3a49c8f13f8d45b8c857fbcbc8bc4a8e4d3eb4b10f4d4604fa08dce601aaf0f470216fe1b51850b4acf21b179c45070ac7b03a9ac")
+
+// newGenesisBlock returns a genesis block whose coinbase has the given
+// signature script and pays value to genesisPkScript.
+func newGenesisBlock(signatureScript []byte, value int64, timestamp int64, bits, nonce uint32) *wire.MsgBlock {
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  signatureScript,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(value, genesisPkScript))
+
+	merkles := blockchain.BuildMerkleTreeStore([]*btcutil.Tx{btcutil.NewTx(coinbase)}, false)
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, merkles[len(merkles)-1], bits, nonce))
+	block.Header.Timestamp = time.Unix(timestamp, 0)
+	block.AddTransaction(coinbase)
+	return block
+}
+
+// genesisHash returns the hash of the genesis block.
+func genesisHash(block *wire.MsgBlock) *chainhash.Hash {
+	hash := block.BlockHash()
+	return &hash
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
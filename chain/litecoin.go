@@ -0,0 +1,141 @@
+package chain
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// litecoinProtocolVersion is the protocol version before MWEB, whose peers
+// are served blocks and transactions without their MWEB data.
+const litecoinProtocolVersion = 70016
+
+var (
+	litecoinGenesisScript = mustDecodeHex("04ffff001d0104404e592054696d65732030352f4f63742f32303131205374657665204a6f62732c204170706c65e280997320566973696f6e6172792c2044696573206174203536")
+
+	litecoinMainNetGenesis = newGenesisBlock(litecoinGenesisScript, 50e8, 1317972665, 0x1e0ffff0, 2084524493)
+	litecoinTestNetGenesis = newGenesisBlock(litecoinGenesisScript, 50e8, 1486949366, 0x1e0ffff0, 293345)
+	litecoinRegTestGenesis = newGenesisBlock(litecoinGenesisScript, 50e8, 1296688602, 0x207fffff, 0)
+)
+
+// LitecoinMainNetParams are the parameters of the Litecoin main network.
+var LitecoinMainNetParams = chaincfg.Params{
+	Name:        "mainnet",
+	Net:         wire.BitcoinNet(0xdbb6c0fb),
+	DefaultPort: "9333",
+	DNSSeeds: []chaincfg.DNSSeed{
+		{Host: "seed-a.litecoin.loshan.co.uk", HasFiltering: true},
+		{Host: "dnsseed.thrasher.io", HasFiltering: true},
+		{Host: "dnsseed.litecointools.com", HasFiltering: false},
+		{Host: "dnsseed.litecoinpool.org", HasFiltering: false},
+		{Host: "dnsseed.koin-project.com", HasFiltering: false},
+	},
+
+	GenesisBlock:             litecoinMainNetGenesis,
+	GenesisHash:              genesisHash(litecoinMainNetGenesis),
+	PowLimit:                 powLimit(236),
+	PowLimitBits:             0x1e0fffff,
+	BIP0034Height:            710000,
+	BIP0065Height:            918684,
+	BIP0066Height:            811879,
+	CoinbaseMaturity:         100,
+	SubsidyReductionInterval: 840000,
+	TargetTimespan:           84 * time.Hour,
+	TargetTimePerBlock:       150 * time.Second,
+	RetargetAdjustmentFactor: 4,
+
+	Bech32HRPSegwit:  "ltc",
+	PubKeyHashAddrID: 0x30,
+	ScriptHashAddrID: 0x32,
+	PrivateKeyID:     0xb0,
+	HDPrivateKeyID:   [4]byte{0x04, 0x88, 0xad, 0xe4},
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e},
+	HDCoinType:       2,
+}
+
+// LitecoinTestNetParams are the parameters of the Litecoin test network
+// (version 4).
+var LitecoinTestNetParams = chaincfg.Params{
+	Name:        "testnet4",
+	Net:         wire.BitcoinNet(0xf1c8d2fd),
+	DefaultPort: "19335",
+	DNSSeeds: []chaincfg.DNSSeed{
+		{Host: "testnet-seed.litecointools.com", HasFiltering: false},
+		{Host: "seed-b.litecoin.loshan.co.uk", HasFiltering: true},
+		{Host: "dnsseed-testnet.thrasher.io", HasFiltering: true},
+	},
+
+	GenesisBlock:             litecoinTestNetGenesis,
+	GenesisHash:              genesisHash(litecoinTestNetGenesis),
+	PowLimit:                 powLimit(236),
+	PowLimitBits:             0x1e0fffff,
+	BIP0034Height:            76,
+	BIP0065Height:            76,
+	BIP0066Height:            76,
+	CoinbaseMaturity:         100,
+	SubsidyReductionInterval: 840000,
+	TargetTimespan:           84 * time.Hour,
+	TargetTimePerBlock:       150 * time.Second,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     5 * time.Minute,
+
+	Bech32HRPSegwit:  "tltc",
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0x3a,
+	PrivateKeyID:     0xef,
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// LitecoinRegressionNetParams are the parameters of the Litecoin regression
+// test network.
+var LitecoinRegressionNetParams = chaincfg.Params{
+	Name:        "regtest",
+	Net:         wire.BitcoinNet(0xdab5bffa),
+	DefaultPort: "19444",
+
+	GenesisBlock:             litecoinRegTestGenesis,
+	GenesisHash:              genesisHash(litecoinRegTestGenesis),
+	PowLimit:                 powLimit(255),
+	PowLimitBits:             0x207fffff,
+	BIP0034Height:            500,
+	BIP0065Height:            1351,
+	BIP0066Height:            1251,
+	CoinbaseMaturity:         100,
+	SubsidyReductionInterval: 150,
+	TargetTimespan:           84 * time.Hour,
+	TargetTimePerBlock:       150 * time.Second,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     5 * time.Minute,
+
+	Bech32HRPSegwit:  "rltc",
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0x3a,
+	PrivateKeyID:     0xef,
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+func init() {
+	for network, params := range map[string]*chaincfg.Params{
+		"mainnet": &LitecoinMainNetParams,
+		"testnet": &LitecoinTestNetParams,
+		"regtest": &LitecoinRegressionNetParams,
+	} {
+		mustRegister(&Chain{
+			Name:            "litecoin",
+			Network:         network,
+			Params:          params,
+			ProtocolVersion: litecoinProtocolVersion,
+			Segwit:          true,
+			PowHash:         ScryptHash,
+			Retarget:        LitecoinRetarget,
+			NoRetargeting:   network == "regtest",
+		})
+	}
+}
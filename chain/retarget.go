@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BitcoinRetarget returns the target bits of a header the same way btcd does,
+// retargeting every TargetTimespan over the blocks since the previous
+// retarget and allowing minimum difficulty blocks on the networks that have
+// them.
+func BitcoinRetarget(c *Chain, headers Headers, prev *wire.BlockHeader, height int32, timestamp time.Time) (uint32, error) {
+	interval := blocksPerRetarget(c.Params, c.Params.TargetTimespan)
+	if height%interval != 0 {
+		return nonRetargetBits(c.Params, headers, prev, height, interval, timestamp)
+	}
+	if c.NoRetargeting {
+		return prev.Bits, nil
+	}
+	return retargetBits(c.Params, headers, prev, height-interval, c.Params.TargetTimespan)
+}
+
+// LitecoinRetarget returns the target bits of a header like BitcoinRetarget,
+// except that the timespan of a retarget starts at the last block of the
+// previous period rather than at its first, so that every block is counted.
+func LitecoinRetarget(c *Chain, headers Headers, prev *wire.BlockHeader, height int32, timestamp time.Time) (uint32, error) {
+	interval := blocksPerRetarget(c.Params, c.Params.TargetTimespan)
+	if height%interval != 0 {
+		return nonRetargetBits(c.Params, headers, prev, height, interval, timestamp)
+	}
+	if c.NoRetargeting {
+		return prev.Bits, nil
+	}
+	first := height - interval - 1
+	if height == interval {
+		first = 0
+	}
+	return retargetBits(c.Params, headers, prev, first, c.Params.TargetTimespan)
+}
+
+func blocksPerRetarget(params *chaincfg.Params, timespan time.Duration) int32 {
+	return int32(timespan / params.TargetTimePerBlock)
+}
+
+// nonRetargetBits returns the target bits of a header between two retargets:
+// those of the previous header, unless the network allows minimum difficulty
+// blocks.
+func nonRetargetBits(params *chaincfg.Params, headers Headers, prev *wire.BlockHeader, height, interval int32, timestamp time.Time) (uint32, error) {
+	if !params.ReduceMinDifficulty {
+		return prev.Bits, nil
+	}
+
+	// A block mined long enough after the previous one can have the
+	// minimum difficulty
+	if timestamp.After(prev.Timestamp.Add(params.MinDiffReductionTime)) {
+		return params.PowLimitBits, nil
+	}
+
+	// Otherwise it has the difficulty of the last block the rule was not
+	// applied to
+	last, lastHeight := prev, height-1
+	for lastHeight > 0 && lastHeight%interval != 0 && last.Bits == params.PowLimitBits {
+		lastHeight--
+		header, err := headers.HeaderAt(lastHeight)
+		if err != nil {
+			return 0, err
+		}
+		last = header
+	}
+	return last.Bits, nil
+}
+
+// retargetBits returns the target of prev scaled by the time the blocks
+// since the one at height first took over the expected timespan, by a factor
+// of RetargetAdjustmentFactor at most.
+func retargetBits(params *chaincfg.Params, headers Headers, prev *wire.BlockHeader, first int32, targetTimespan time.Duration) (uint32, error) {
+	firstHeader, err := headers.HeaderAt(first)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the header of the previous retarget: %v", err)
+	}
+
+	timespan := prev.Timestamp.Unix() - firstHeader.Timestamp.Unix()
+	target := int64(targetTimespan / time.Second)
+	minTimespan := target / params.RetargetAdjustmentFactor
+	maxTimespan := target * params.RetargetAdjustmentFactor
+	return scaleBits(params, prev.Bits, clamp(timespan, minTimespan, maxTimespan), target), nil
+}
+
+func clamp(timespan, min, max int64) int64 {
+	if timespan < min {
+		return min
+	}
+	if timespan > max {
+		return max
+	}
+	return timespan
+}
+
+// scaleBits returns the bits of the target scaled by timespan over
+// targetTimespan, limited to the proof of work limit.
+func scaleBits(params *chaincfg.Params, bits uint32, timespan, targetTimespan int64) uint32 {
+	target := new(big.Int).Mul(blockchain.CompactToBig(bits), big.NewInt(timespan))
+	target.Div(target, big.NewInt(targetTimespan))
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+	return blockchain.BigToCompact(target)
+}
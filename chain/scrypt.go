@@ -0,0 +1,27 @@
+package chain
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ScryptHash returns the scrypt proof of work hash of a header, as used by
+// Litecoin and Dogecoin.
+func ScryptHash(header *wire.BlockHeader) chainhash.Hash {
+	buf := bytes.NewBuffer(make([]byte, 0, wire.MaxBlockHeaderPayload))
+	if err := header.Serialize(buf); err != nil {
+		// Serializing to a buffer cannot fail
+		panic(err)
+	}
+	key, err := scrypt.Key(buf.Bytes(), buf.Bytes(), 1024, 1, 1, chainhash.HashSize)
+	if err != nil {
+		// The parameters are valid
+		panic(err)
+	}
+	var hash chainhash.Hash
+	copy(hash[:], key)
+	return hash
+}
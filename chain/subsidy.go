@@ -0,0 +1,95 @@
+package chain
+
+import (
+	"strconv"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// Subsidy returns the coins a block at the given height, following the
+// block prevHash, creates on top of the fees it collects.
+func (c *Chain) Subsidy(height int32, prevHash chainhash.Hash) int64 {
+	if c.BlockSubsidy == nil {
+		return blockchain.CalcBlockSubsidy(height, c.Params)
+	}
+	return c.BlockSubsidy(c, height, prevHash)
+}
+
+// DogecoinSubsidy returns a BlockSubsidy rule with the Dogecoin rewards:
+// random rewards seeded by the hash of the previous block until
+// simplifiedHeight, then fixed rewards halving every
+// SubsidyReductionInterval blocks, and 10,000 coins per block after the
+// sixth interval.
+func DogecoinSubsidy(simplifiedHeight int32) func(c *Chain, height int32, prevHash chainhash.Hash) int64 {
+	return func(c *Chain, height int32, prevHash chainhash.Hash) int64 {
+		interval := c.Params.SubsidyReductionInterval
+		halvings := uint(height / interval)
+		switch {
+		case height < simplifiedHeight:
+			// The seed is read from the hex of the previous block hash,
+			// which has 64 digits and always parses
+			seed, _ := strconv.ParseUint(prevHash.String()[7:14], 16, 32)
+			maxReward := uint32(1000000>>halvings) - 1
+			return int64(1+uniformInt(newMT19937(uint32(seed)), 1, maxReward)) * btcutil.SatoshiPerBitcoin
+		case height < 6*interval:
+			return 500000 * btcutil.SatoshiPerBitcoin >> halvings
+		default:
+			return 10000 * btcutil.SatoshiPerBitcoin
+		}
+	}
+}
+
+// uniformInt returns a number in [min, max] drawn from the generator the way
+// boost::uniform_int does, which Dogecoin Core uses for its random rewards.
+func uniformInt(mt *mt19937, min, max uint32) uint32 {
+	r := max - min
+	bucketSize := ^uint32(0) / (r + 1)
+	if ^uint32(0)%(r+1) == r {
+		bucketSize++
+	}
+	for {
+		if result := mt.next() / bucketSize; result <= r {
+			return result + min
+		}
+	}
+}
+
+// mt19937 is the 32-bit Mersenne Twister.
+type mt19937 struct {
+	state [624]uint32
+	index int
+}
+
+func newMT19937(seed uint32) *mt19937 {
+	mt := &mt19937{index: len(mt19937{}.state)}
+	mt.state[0] = seed
+	for i := 1; i < len(mt.state); i++ {
+		prev := mt.state[i-1]
+		mt.state[i] = 1812433253*(prev^(prev>>30)) + uint32(i)
+	}
+	return mt
+}
+
+func (mt *mt19937) next() uint32 {
+	const n, m = len(mt19937{}.state), 397
+	if mt.index >= n {
+		for i := 0; i < n; i++ {
+			y := mt.state[i]&0x80000000 | mt.state[(i+1)%n]&0x7fffffff
+			next := mt.state[(i+m)%n] ^ y>>1
+			if y&1 != 0 {
+				next ^= 0x9908b0df
+			}
+			mt.state[i] = next
+		}
+		mt.index = 0
+	}
+	y := mt.state[mt.index]
+	mt.index++
+	y ^= y >> 11
+	y ^= y << 7 & 0x9d2c5680
+	y ^= y << 15 & 0xefc60000
+	y ^= y >> 18
+	return y
+}
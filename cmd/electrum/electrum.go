@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/config"
+	"github.com/catalogfi/indexer/electrum"
+	"github.com/catalogfi/indexer/model"
+	"github.com/catalogfi/indexer/store"
+)
+
+func main() {
+	cfg, err := config.Load("electrum", config.Default(), os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
+	dialector, err := cfg.DB.Dialector()
+	if err != nil {
+		panic(err)
+	}
+	db, err := model.NewDB(dialector, cfg.GormConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := chain.Lookup(cfg.Chain, cfg.Network)
+	if err != nil {
+		panic(err)
+	}
+	str := store.NewStorage(c.Params, db)
+
+	server := electrum.NewServer(str, cfg.Electrum.PollInterval)
+	if cfg.Electrum.TLS.Enabled() {
+		tlsConfig, err := cfg.Electrum.TLS.Config()
+		if err != nil {
+			panic(err)
+		}
+		if err := server.ListenAndServeTLS(cfg.Electrum.Addr, tlsConfig); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if err := server.ListenAndServe(cfg.Electrum.Addr); err != nil {
+		panic(err)
+	}
+}
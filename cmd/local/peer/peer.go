@@ -1,23 +1,58 @@
 package main
 
 import (
-	"github.com/btcsuite/btcd/chaincfg"
+	"net"
+	"os"
+
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/config"
 	"github.com/catalogfi/indexer/model"
 	"github.com/catalogfi/indexer/peer"
 	"github.com/catalogfi/indexer/store"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 func main() {
-	db, err := model.NewDB(sqlite.Open("gorm.db"), &gorm.Config{})
+	defaults := config.Default()
+	defaults.Network = "testnet"
+	defaults.DB = config.DB{Driver: config.DriverSQLite, DSN: "gorm.db"}
+	defaults.Peer.URLs = []string{"localhost:18333"}
+	cfg, err := config.Load("peer", defaults, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
+	dialector, err := cfg.DB.Dialector()
 	if err != nil {
 		panic(err)
 	}
-	str := store.NewStorage(&chaincfg.TestNet3Params, db)
-	p, err := peer.NewPeer("", str)
+	db, err := model.Open(dialector, cfg.GormConfig())
+	if err != nil {
+		panic(err)
+	}
+	if err := model.MigrateUp(db, model.SchemaVersion()); err != nil {
+		panic(err)
+	}
+
+	c, err := chain.Lookup(cfg.Chain, cfg.Network)
 	if err != nil {
 		panic(err)
 	}
-	p.Run()
+	str := store.NewStorage(c.Params, db)
+	if err := str.CheckConsistency(); err != nil {
+		panic(err)
+	}
+	if err := str.BackfillBlockFilters(); err != nil {
+		panic(err)
+	}
+	peerOpts := []peer.Option{peer.WithDiscovery(cfg.Peer.TargetOutbound, net.LookupIP)}
+	if cfg.Peer.DataDir != "" {
+		peerOpts = append(peerOpts, peer.WithDataDir(cfg.Peer.DataDir))
+	}
+	p, err := peer.NewPeer(cfg.Peer.URLs, str, peerOpts...)
+	if err != nil {
+		panic(err)
+	}
+	if err := p.Run(); err != nil {
+		panic(err)
+	}
 }
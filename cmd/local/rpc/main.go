@@ -1,24 +1,64 @@
 package main
 
 import (
-	"github.com/btcsuite/btcd/chaincfg"
+	"os"
+
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/config"
+	"github.com/catalogfi/indexer/esplora"
 	"github.com/catalogfi/indexer/model"
 	"github.com/catalogfi/indexer/rpc"
 	"github.com/catalogfi/indexer/store"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
 func main() {
-	db, err := model.NewDB(sqlite.Open("gorm.db"), &gorm.Config{})
+	defaults := config.Default()
+	defaults.Network = "regtest"
+	defaults.DB = config.DB{Driver: config.DriverSQLite, DSN: "gorm.db"}
+	cfg, err := config.Load("rpc", defaults, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+
+	dialector, err := cfg.DB.Dialector()
+	if err != nil {
+		panic(err)
+	}
+	db, err := model.Open(dialector, cfg.GormConfig())
 	if err != nil {
 		panic(err)
 	}
-	str := store.NewStorage(&chaincfg.RegressionNetParams, db)
-	rpcserver := rpc.Default(str)
+	if err := model.MigrateUp(db, model.SchemaVersion()); err != nil {
+		panic(err)
+	}
 
+	c, err := chain.Lookup(cfg.Chain, cfg.Network)
+	if err != nil {
+		panic(err)
+	}
+	str := store.NewStorage(c.Params, db)
+	rpcserver, err := rpc.Enabled(str, cfg.RPC.Methods)
+	if err != nil {
+		panic(err)
+	}
+
+	gin.SetMode(cfg.GinMode())
 	s := gin.Default()
-	s.POST("/", rpcserver.HandleJSONRPC)
-	s.Run(":8080")
+	handlers := []gin.HandlerFunc{}
+	if cfg.RPC.User != "" {
+		handlers = append(handlers, gin.BasicAuth(gin.Accounts{cfg.RPC.User: cfg.RPC.Password}))
+	}
+	s.POST("/", append(handlers, rpcserver.HandleJSONRPC)...)
+	if cfg.RPC.Esplora {
+		esplora.New(str).Register(s.Group("/api"))
+	}
+	if cfg.RPC.TLS.Enabled() {
+		err = s.RunTLS(cfg.RPC.Addr, cfg.RPC.TLS.Cert, cfg.RPC.TLS.Key)
+	} else {
+		err = s.Run(cfg.RPC.Addr)
+	}
+	if err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,114 @@
+// Command migrate applies and reverts the schema migrations of the SQL
+// database, and reports which of them are applied. The peer, RPC and Electrum
+// servers refuse to start until the database is at the version of the schema
+// they use. A database created before the schema was versioned is at version
+// 1, the legacy schema, and up converts it to the current one. The database is
+// configured like for the other commands.
+//
+//	migrate [config flags] up [-to version]
+//	migrate [config flags] down [-to version]
+//	migrate [config flags] status
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/catalogfi/indexer/config"
+	"github.com/catalogfi/indexer/model"
+	"gorm.io/gorm"
+)
+
+func main() {
+	// The schema does not depend on the chain.
+	defaults := config.Default()
+	defaults.Network = "mainnet"
+	cfg, err := config.Load("migrate", defaults, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	args := cfg.Args()
+	if len(args) < 1 {
+		usage()
+	}
+	cmd := args[0]
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	to := flags.Int("to", -1, "version to migrate to, the latest for up and the previous one for down by default")
+	flags.Parse(args[1:])
+
+	dialector, err := cfg.DB.Dialector()
+	if err != nil {
+		panic(err)
+	}
+	db, err := model.Open(dialector, cfg.GormConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	switch cmd {
+	case "up":
+		if *to < 0 {
+			*to = model.SchemaVersion()
+		}
+		if err := model.MigrateUp(db, *to); err != nil {
+			panic(err)
+		}
+	case "down":
+		if *to < 0 {
+			version, err := model.CurrentVersion(db)
+			if err != nil {
+				panic(err)
+			}
+			if version == 0 {
+				fmt.Println("no migrations applied")
+				return
+			}
+			*to = version - 1
+		}
+		if err := model.MigrateDown(db, *to); err != nil {
+			panic(err)
+		}
+	case "status":
+	default:
+		usage()
+	}
+
+	if err := status(db); err != nil {
+		panic(err)
+	}
+}
+
+func status(db *gorm.DB) error {
+	applied, err := model.AppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	version, err := model.CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	appliedAt := map[int]string{}
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt.Format("2006-01-02 15:04:05")
+	}
+	for _, migration := range model.Migrations() {
+		state, ok := appliedAt[migration.Version]
+		switch {
+		case ok:
+		case migration.Version <= version:
+			// Created by AutoMigrate before the schema was versioned
+			state = "legacy"
+		default:
+			state = "pending"
+		}
+		fmt.Printf("%4d  %-30s  %s\n", migration.Version, migration.Name, state)
+	}
+	fmt.Printf("database is at version %d, the indexer uses version %d\n", version, model.SchemaVersion())
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate [config flags] up|down|status [-to version]")
+	os.Exit(2)
+}
@@ -1,37 +1,64 @@
 package main
 
 import (
+	"net"
 	"os"
 
-	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/config"
 	"github.com/catalogfi/indexer/model"
+	"github.com/catalogfi/indexer/notify"
 	"github.com/catalogfi/indexer/peer"
 	"github.com/catalogfi/indexer/store"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
 func main() {
-	db, err := model.NewDB(postgres.Open(os.Getenv("PSQL_URL")), &gorm.Config{})
+	cfg, err := config.Load("peer", config.Default(), os.Args[1:])
 	if err != nil {
 		panic(err)
 	}
 
-	var params *chaincfg.Params
-	switch os.Getenv("NETWORK") {
-	case "mainnet":
-		params = &chaincfg.MainNetParams
-	case "testnet":
-		params = &chaincfg.TestNet3Params
-	case "regtest":
-		params = &chaincfg.RegressionNetParams
-	default:
-		panic("invalid network")
+	dialector, err := cfg.DB.Dialector()
+	if err != nil {
+		panic(err)
+	}
+	db, err := model.NewDB(dialector, cfg.GormConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := chain.Lookup(cfg.Chain, cfg.Network)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []store.Option{}
+	if cfg.ZMQ != (notify.ZMQConfig{}) {
+		notifier, err := notify.NewZMQNotifier(cfg.ZMQ)
+		if err != nil {
+			panic(err)
+		}
+		defer notifier.Close()
+		opts = append(opts, store.WithNotifier(notifier))
+	}
+
+	str := store.NewStorage(c.Params, db, opts...)
+	if err := str.CheckConsistency(); err != nil {
+		panic(err)
+	}
+	if err := str.BackfillBlockFilters(); err != nil {
+		panic(err)
+	}
+	peerOpts := []peer.Option{peer.WithDiscovery(cfg.Peer.TargetOutbound, net.LookupIP)}
+	if cfg.Peer.DataDir != "" {
+		peerOpts = append(peerOpts, peer.WithDataDir(cfg.Peer.DataDir))
 	}
-	str := store.NewStorage(params, db)
-	p, err := peer.NewPeer(os.Getenv("PEER_URL"), str)
+
+	p, err := peer.NewPeer(cfg.Peer.URLs, str, peerOpts...)
 	if err != nil {
 		panic(err)
 	}
-	p.Run()
+	if err := p.Run(); err != nil {
+		panic(err)
+	}
 }
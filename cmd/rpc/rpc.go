@@ -3,36 +3,59 @@ package main
 import (
 	"os"
 
-	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/config"
+	"github.com/catalogfi/indexer/esplora"
 	"github.com/catalogfi/indexer/model"
 	"github.com/catalogfi/indexer/rpc"
 	"github.com/catalogfi/indexer/store"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
 func main() {
-	db, err := model.NewDB(postgres.Open(os.Getenv("PSQL_URL")), &gorm.Config{})
+	cfg, err := config.Load("rpc", config.Default(), os.Args[1:])
 	if err != nil {
 		panic(err)
 	}
 
-	var params *chaincfg.Params
-	switch os.Getenv("NETWORK") {
-	case "mainnet":
-		params = &chaincfg.MainNetParams
-	case "testnet":
-		params = &chaincfg.TestNet3Params
-	case "regtest":
-		params = &chaincfg.RegressionNetParams
-	default:
-		panic("invalid network")
-	}
-	str := store.NewStorage(params, db)
-	rpcserver := rpc.Default(str)
+	dialector, err := cfg.DB.Dialector()
+	if err != nil {
+		panic(err)
+	}
+	db, err := model.NewDB(dialector, cfg.GormConfig())
+	if err != nil {
+		panic(err)
+	}
 
+	c, err := chain.Lookup(cfg.Chain, cfg.Network)
+	if err != nil {
+		panic(err)
+	}
+	str := store.NewStorage(c.Params, db)
+	rpcserver, err := rpc.Enabled(str, cfg.RPC.Methods)
+	if err != nil {
+		panic(err)
+	}
+
+	gin.SetMode(cfg.GinMode())
 	s := gin.Default()
-	s.POST("/", rpcserver.HandleJSONRPC)
-	s.Run(":8080")
+	handlers := []gin.HandlerFunc{}
+	if cfg.RPC.User != "" {
+		handlers = append(handlers, gin.BasicAuth(gin.Accounts{cfg.RPC.User: cfg.RPC.Password}))
+	}
+	// The Esplora API is behind the same authentication as the JSON-RPC
+	// requests
+	api := s.Group("/", handlers...)
+	api.POST("/", rpcserver.HandleJSONRPC)
+	if cfg.RPC.Esplora {
+		esplora.New(str).Register(api.Group("/api"))
+	}
+	if cfg.RPC.TLS.Enabled() {
+		err = s.RunTLS(cfg.RPC.Addr, cfg.RPC.TLS.Cert, cfg.RPC.TLS.Key)
+	} else {
+		err = s.Run(cfg.RPC.Addr)
+	}
+	if err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,114 @@
+// Command standalone runs the peer, the RPC and Esplora server and the
+// Electrum server in a single process on an embedded LevelDB database, which
+// unlike the SQL database cannot be shared between processes.
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/config"
+	"github.com/catalogfi/indexer/electrum"
+	"github.com/catalogfi/indexer/esplora"
+	"github.com/catalogfi/indexer/notify"
+	"github.com/catalogfi/indexer/peer"
+	"github.com/catalogfi/indexer/rpc"
+	"github.com/catalogfi/indexer/store/kv"
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func main() {
+	defaults := config.Default()
+	defaults.DB = config.DB{Driver: config.DriverLevelDB, DSN: "indexer.db"}
+	cfg, err := config.Load("standalone", defaults, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	if cfg.DB.Driver != config.DriverLevelDB {
+		panic("the standalone command only supports the leveldb driver")
+	}
+
+	db, err := leveldb.OpenFile(cfg.DB.DSN, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	c, err := chain.Lookup(cfg.Chain, cfg.Network)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []kv.Option{}
+	if cfg.ZMQ != (notify.ZMQConfig{}) {
+		notifier, err := notify.NewZMQNotifier(cfg.ZMQ)
+		if err != nil {
+			panic(err)
+		}
+		defer notifier.Close()
+		opts = append(opts, kv.WithNotifier(notifier))
+	}
+	str := kv.NewStorage(c.Params, db, opts...)
+	if err := str.BackfillBlockFilters(); err != nil {
+		panic(err)
+	}
+
+	peerOpts := []peer.Option{peer.WithDiscovery(cfg.Peer.TargetOutbound, net.LookupIP)}
+	if cfg.Peer.DataDir != "" {
+		peerOpts = append(peerOpts, peer.WithDataDir(cfg.Peer.DataDir))
+	}
+	p, err := peer.NewPeer(cfg.Peer.URLs, str, peerOpts...)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		if err := p.Run(); err != nil {
+			panic(err)
+		}
+	}()
+
+	go func() {
+		server := electrum.NewServer(str, cfg.Electrum.PollInterval)
+		if cfg.Electrum.TLS.Enabled() {
+			tlsConfig, err := cfg.Electrum.TLS.Config()
+			if err != nil {
+				panic(err)
+			}
+			if err := server.ListenAndServeTLS(cfg.Electrum.Addr, tlsConfig); err != nil {
+				panic(err)
+			}
+			return
+		}
+		if err := server.ListenAndServe(cfg.Electrum.Addr); err != nil {
+			panic(err)
+		}
+	}()
+
+	rpcserver, err := rpc.Enabled(str, cfg.RPC.Methods)
+	if err != nil {
+		panic(err)
+	}
+	gin.SetMode(cfg.GinMode())
+	s := gin.Default()
+	handlers := []gin.HandlerFunc{}
+	if cfg.RPC.User != "" {
+		handlers = append(handlers, gin.BasicAuth(gin.Accounts{cfg.RPC.User: cfg.RPC.Password}))
+	}
+	// The Esplora API is behind the same authentication as the JSON-RPC
+	// requests
+	api := s.Group("/", handlers...)
+	api.POST("/", rpcserver.HandleJSONRPC)
+	if cfg.RPC.Esplora {
+		esplora.New(str).Register(api.Group("/api"))
+	}
+	if cfg.RPC.TLS.Enabled() {
+		err = s.RunTLS(cfg.RPC.Addr, cfg.RPC.TLS.Cert, cfg.RPC.TLS.Key)
+	} else {
+		err = s.Run(cfg.RPC.Addr)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
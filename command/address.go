@@ -0,0 +1,171 @@
+package command
+
+import "math"
+
+type AddressDelta struct {
+	Address    string
+	TxHash     string
+	Index      uint32
+	BlockIndex uint32
+	Height     int32
+	Value      int64
+}
+
+type AddressQueryOptions struct {
+	StartHeight int32
+	EndHeight   int32
+	Offset      int
+	Limit       int
+}
+
+// parseAddressQuery parses the first parameter of the address index
+// commands, which is either a single address or an object of the form
+// {"addresses": [...], "start": n, "end": n, "offset": n, "limit": n}.
+func parseAddressQuery(params []interface{}) ([]string, AddressQueryOptions, error) {
+	options := AddressQueryOptions{
+		StartHeight: 0,
+		EndHeight:   math.MaxInt32,
+		Offset:      0,
+		Limit:       -1,
+	}
+	if len(params) != 1 {
+		return nil, options, invalidParamsCount(len(params), "1")
+	}
+
+	switch param := params[0].(type) {
+	case string:
+		return []string{param}, options, nil
+	case map[string]interface{}:
+		addrs, ok := param["addresses"].([]interface{})
+		if !ok {
+			return nil, options, invalidParamType(param["addresses"], "array")
+		}
+		addresses := make([]string, len(addrs))
+		for i, addr := range addrs {
+			addresses[i], ok = addr.(string)
+			if !ok {
+				return nil, options, invalidParamType(addr, "string")
+			}
+		}
+		if len(addresses) == 0 {
+			return nil, options, NewError(ErrRPCInvalidAddressOrKey, "No addresses provided")
+		}
+
+		_, hasStart := param["start"]
+		_, hasEnd := param["end"]
+		if hasStart != hasEnd {
+			return nil, options, NewError(ErrRPCInvalidParameter, "Both start and end must be provided")
+		}
+		if hasStart {
+			start, ok := param["start"].(float64)
+			if !ok {
+				return nil, options, invalidParamType(param["start"], "number")
+			}
+			end, ok := param["end"].(float64)
+			if !ok {
+				return nil, options, invalidParamType(param["end"], "number")
+			}
+			if end < start {
+				return nil, options, NewError(ErrRPCInvalidParameter, "End value is expected to be greater than start")
+			}
+			options.StartHeight = int32(start)
+			options.EndHeight = int32(end)
+		}
+
+		if offset, ok := param["offset"]; ok {
+			offsetF, ok := offset.(float64)
+			if !ok || offsetF < 0 {
+				return nil, options, NewError(ErrRPCInvalidParameter, "offset must be a non-negative number")
+			}
+			options.Offset = int(offsetF)
+		}
+		if limit, ok := param["limit"]; ok {
+			limitF, ok := limit.(float64)
+			if !ok || limitF < 1 {
+				return nil, options, NewError(ErrRPCInvalidParameter, "limit must be a positive number")
+			}
+			options.Limit = int(limitF)
+		}
+		return addresses, options, nil
+	default:
+		return nil, options, invalidParamType(params[0], "string or object")
+	}
+}
+
+// getaddresstxids
+type getAddressTxIDs struct {
+}
+
+func GetAddressTxIDs() Command {
+	return &getAddressTxIDs{}
+}
+
+func (g *getAddressTxIDs) Name() string {
+	return "getaddresstxids"
+}
+
+func (g *getAddressTxIDs) Query(str Storage, params []interface{}) (interface{}, error) {
+	addresses, options, err := parseAddressQuery(params)
+	if err != nil {
+		return nil, err
+	}
+	return str.GetAddressTxIDs(addresses, options)
+}
+
+// getaddressdeltas
+type getAddressDeltas struct {
+}
+
+func GetAddressDeltas() Command {
+	return &getAddressDeltas{}
+}
+
+func (g *getAddressDeltas) Name() string {
+	return "getaddressdeltas"
+}
+
+func (g *getAddressDeltas) Query(str Storage, params []interface{}) (interface{}, error) {
+	addresses, options, err := parseAddressQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas, err := str.GetAddressDeltas(addresses, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]VerboseAddressDelta, len(deltas))
+	for i, delta := range deltas {
+		result[i] = EncodeAddressDelta(delta)
+	}
+	return result, nil
+}
+
+// getaddressbalance
+type getAddressBalance struct {
+}
+
+func GetAddressBalance() Command {
+	return &getAddressBalance{}
+}
+
+func (g *getAddressBalance) Name() string {
+	return "getaddressbalance"
+}
+
+func (g *getAddressBalance) Query(str Storage, params []interface{}) (interface{}, error) {
+	addresses, _, err := parseAddressQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, received, err := str.GetAddressBalance(addresses)
+	if err != nil {
+		return nil, err
+	}
+	return AddressBalance{
+		Balance:  balance,
+		Received: received,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// addressStorage answers the address queries with fixed deltas and
+// records the query it got.
+type addressStorage struct {
+	Storage
+	deltas    []AddressDelta
+	addresses []string
+	options   AddressQueryOptions
+}
+
+func (s *addressStorage) GetAddressDeltas(addresses []string, options AddressQueryOptions) ([]AddressDelta, error) {
+	s.addresses, s.options = addresses, options
+	return s.deltas, nil
+}
+
+func (s *addressStorage) GetAddressTxIDs(addresses []string, options AddressQueryOptions) ([]string, error) {
+	s.addresses, s.options = addresses, options
+	txids := []string{}
+	for _, delta := range s.deltas {
+		txids = append(txids, delta.TxHash)
+	}
+	return txids, nil
+}
+
+func (s *addressStorage) GetAddressBalance(addresses []string) (int64, int64, error) {
+	s.addresses = addresses
+	return 5, 8, nil
+}
+
+// params decodes the params of a request as the RPC server does.
+func params(t *testing.T, raw string) []interface{} {
+	t.Helper()
+	params := []interface{}{}
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+func TestParseAddressQuery(t *testing.T) {
+	defaults := AddressQueryOptions{StartHeight: 0, EndHeight: math.MaxInt32, Offset: 0, Limit: -1}
+	tests := []struct {
+		name          string
+		params        string
+		wantAddresses []string
+		wantOptions   AddressQueryOptions
+		wantCode      int
+	}{
+		{"address", `["a"]`, []string{"a"}, defaults, 0},
+		{"object", `[{"addresses":["a","b"]}]`, []string{"a", "b"}, defaults, 0},
+		{"height range", `[{"addresses":["a"],"start":2,"end":5}]`, []string{"a"}, AddressQueryOptions{StartHeight: 2, EndHeight: 5, Limit: -1}, 0},
+		{"page", `[{"addresses":["a"],"offset":3,"limit":2}]`, []string{"a"}, AddressQueryOptions{EndHeight: math.MaxInt32, Offset: 3, Limit: 2}, 0},
+		{"no params", `[]`, nil, defaults, ErrRPCInvalidParams},
+		{"too many params", `["a","b"]`, nil, defaults, ErrRPCInvalidParams},
+		{"number", `[1]`, nil, defaults, ErrRPCType},
+		{"missing addresses", `[{}]`, nil, defaults, ErrRPCType},
+		{"no addresses", `[{"addresses":[]}]`, nil, defaults, ErrRPCInvalidAddressOrKey},
+		{"address not a string", `[{"addresses":[1]}]`, nil, defaults, ErrRPCType},
+		{"start without end", `[{"addresses":["a"],"start":2}]`, nil, defaults, ErrRPCInvalidParameter},
+		{"end before start", `[{"addresses":["a"],"start":5,"end":2}]`, nil, defaults, ErrRPCInvalidParameter},
+		{"start not a number", `[{"addresses":["a"],"start":"2","end":5}]`, nil, defaults, ErrRPCType},
+		{"negative offset", `[{"addresses":["a"],"offset":-1}]`, nil, defaults, ErrRPCInvalidParameter},
+		{"zero limit", `[{"addresses":["a"],"limit":0}]`, nil, defaults, ErrRPCInvalidParameter},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses, options, err := parseAddressQuery(params(t, test.params))
+			if test.wantCode != 0 {
+				rpcErr := &Error{}
+				if !errors.As(err, &rpcErr) || rpcErr.Code != test.wantCode {
+					t.Fatalf("got error %v, want code %d", err, test.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addresses, test.wantAddresses) || options != test.wantOptions {
+				t.Fatalf("got %v with %+v, want %v with %+v", addresses, options, test.wantAddresses, test.wantOptions)
+			}
+		})
+	}
+}
+
+func TestAddressCommands(t *testing.T) {
+	str := &addressStorage{deltas: []AddressDelta{
+		{Address: "a", TxHash: "t1", Index: 1, BlockIndex: 2, Height: 3, Value: 4},
+		{Address: "b", TxHash: "t2", Index: 0, BlockIndex: 1, Height: 5, Value: -4},
+	}}
+	tests := []struct {
+		command Command
+		params  string
+		want    string
+	}{
+		{GetAddressDeltas(), `[{"addresses":["a","b"],"offset":1}]`, `[{"satoshis":4,"txid":"t1","index":1,"blockindex":2,"height":3,"address":"a"},{"satoshis":-4,"txid":"t2","index":0,"blockindex":1,"height":5,"address":"b"}]`},
+		{GetAddressTxIDs(), `[{"addresses":["a","b"],"offset":1}]`, `["t1","t2"]`},
+		{GetAddressBalance(), `[{"addresses":["a","b"],"offset":1}]`, `{"balance":5,"received":8}`},
+	}
+	for _, test := range tests {
+		t.Run(test.command.Name(), func(t *testing.T) {
+			result, err := test.command.Query(str, params(t, test.params))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+			if !reflect.DeepEqual(str.addresses, []string{"a", "b"}) {
+				t.Fatalf("got query for %v, want a and b", str.addresses)
+			}
+		})
+	}
+	if str.options.Offset != 1 {
+		t.Fatalf("got offset %d, want the offset of the params", str.options.Offset)
+	}
+}
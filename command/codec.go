@@ -9,10 +9,12 @@ import (
 	"math/big"
 	"strconv"
 
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/chain"
 	"github.com/catalogfi/indexer/model"
 )
 
@@ -87,7 +89,7 @@ type VerboseBlock struct {
 	Transactions         interface{} `json:"tx"`
 }
 
-func EncodeBlock(block *btcutil.Block, confirmations uint32, medianTime int64, nextBlockHash string, verbose int) (VerboseBlock, error) {
+func EncodeBlock(block *btcutil.Block, confirmations uint32, medianTime int64, nextBlockHash string, verbose int, params *chaincfg.Params) (VerboseBlock, error) {
 	return VerboseBlock{
 		Hash:                 block.Hash().String(),
 		Confirmations:        confirmations,
@@ -98,7 +100,7 @@ func EncodeBlock(block *btcutil.Block, confirmations uint32, medianTime int64, n
 		Version:              block.MsgBlock().Header.Version,
 		VersionHex:           strconv.FormatInt(int64(block.MsgBlock().Header.Version), 16),
 		MerkleRoot:           block.MsgBlock().Header.MerkleRoot.String(),
-		Transactions:         getTxs(block, confirmations, verbose),
+		Transactions:         getTxs(block, confirmations, verbose, params),
 		Time:                 block.MsgBlock().Header.Timestamp.Unix(),
 		MedianTime:           medianTime,
 		Bits:                 strconv.FormatInt(int64(block.MsgBlock().Header.Bits), 16),
@@ -110,13 +112,13 @@ func EncodeBlock(block *btcutil.Block, confirmations uint32, medianTime int64, n
 	}, nil
 }
 
-func getTxs(block *btcutil.Block, confirmations uint32, verbose int) interface{} {
+func getTxs(block *btcutil.Block, confirmations uint32, verbose int, params *chaincfg.Params) interface{} {
 	txs := make([]interface{}, len(block.Transactions()))
 	for i, tx := range block.Transactions() {
 		if verbose == 1 {
 			txs[i] = tx.Hash().String()
 		} else {
-			txs[i] = EncodeTransaction(tx.MsgTx(), block.Hash().String(), confirmations, block.MsgBlock().Header.Timestamp.Unix())
+			txs[i] = EncodeTransaction(tx.MsgTx(), block.Hash().String(), confirmations, block.MsgBlock().Header.Timestamp.Unix(), params)
 		}
 	}
 	return txs
@@ -138,6 +140,11 @@ type VerboseTransaction struct {
 	Confirmations uint32       `json:"confirmations"`
 	BlockTime     int64        `json:"blocktime"`
 	Time          int64        `json:"time"`
+
+	// The unconfirmed transactions spending the same outputs that were
+	// evicted by or evicted the transaction, and the one that replaced it.
+	WalletConflicts []string `json:"walletconflicts,omitempty"`
+	ReplacedBy      string   `json:"replaced_by_txid,omitempty"`
 }
 
 type VerboseIn struct {
@@ -172,7 +179,7 @@ type ScriptPubKey struct {
 	Address string `json:"address,omitempty"`
 }
 
-func EncodeTransaction(tx *wire.MsgTx, blockHash string, confirmations uint32, time int64) VerboseTransaction {
+func EncodeTransaction(tx *wire.MsgTx, blockHash string, confirmations uint32, time int64, params *chaincfg.Params) VerboseTransaction {
 	buf := new(bytes.Buffer)
 	if err := tx.Serialize(buf); err != nil {
 		panic(err)
@@ -187,7 +194,7 @@ func EncodeTransaction(tx *wire.MsgTx, blockHash string, confirmations uint32, t
 		VSize:         vsize,
 		Weight:        weight,
 		VINs:          EncodeVINs(tx.TxIn),
-		VOUTs:         EncodeVOUTs(tx.TxOut),
+		VOUTs:         EncodeVOUTs(tx.TxOut, params),
 		Version:       tx.Version,
 		LockTime:      tx.LockTime,
 		BlockHash:     blockHash,
@@ -239,7 +246,9 @@ func EncodeVINs(txins []*wire.TxIn) interface{} {
 	return vins
 }
 
-func EncodeVOUTs(txouts []*wire.TxOut) []VerboseOut {
+// EncodeVOUTs encodes the outputs, with the addresses of the network of
+// params.
+func EncodeVOUTs(txouts []*wire.TxOut, params *chaincfg.Params) []VerboseOut {
 	vouts := make([]VerboseOut, len(txouts))
 	for i, vout := range txouts {
 		asm, err := txscript.DisasmString(vout.PkScript)
@@ -259,7 +268,7 @@ func EncodeVOUTs(txouts []*wire.TxOut) []VerboseOut {
 
 		pks, err := txscript.ParsePkScript(vout.PkScript)
 		if err == nil {
-			addr, err := pks.Address(&chaincfg.RegressionNetParams)
+			addr, err := pks.Address(params)
 			if err != nil {
 				panic(err)
 			}
@@ -303,13 +312,355 @@ type Unspent struct {
 
 func EncodeUnspent(op model.OutPoint, confirmations uint32) Unspent {
 	return Unspent{
-		TxID:          op.FundingTxHash,
+		TxID:          string(op.FundingTxHash),
 		Vout:          op.FundingTxIndex,
 		Address:       op.Spender,
 		Label:         "",
 		Amount:        float64(op.Value) / float64(100000000),
-		ScriptPubKey:  op.PkScript,
-		WitnessScript: op.Witness,
+		ScriptPubKey:  hex.EncodeToString(op.PkScript),
+		Confirmations: confirmations,
+	}
+}
+
+// getaddressdeltas
+type VerboseAddressDelta struct {
+	Satoshis   int64  `json:"satoshis"`
+	TxID       string `json:"txid"`
+	Index      uint32 `json:"index"`
+	BlockIndex uint32 `json:"blockindex"`
+	Height     int32  `json:"height"`
+	Address    string `json:"address"`
+}
+
+func EncodeAddressDelta(delta AddressDelta) VerboseAddressDelta {
+	return VerboseAddressDelta{
+		Satoshis:   delta.Value,
+		TxID:       delta.TxHash,
+		Index:      delta.Index,
+		BlockIndex: delta.BlockIndex,
+		Height:     delta.Height,
+		Address:    delta.Address,
+	}
+}
+
+// getaddressbalance
+type AddressBalance struct {
+	Balance  int64 `json:"balance"`
+	Received int64 `json:"received"`
+}
+
+// getmempoolentry
+type VerboseMempoolEntry struct {
+	VSize             int         `json:"vsize"`
+	Weight            int         `json:"weight"`
+	Time              int64       `json:"time"`
+	Height            int32       `json:"height"`
+	DescendantCount   int         `json:"descendantcount"`
+	DescendantSize    int         `json:"descendantsize"`
+	AncestorCount     int         `json:"ancestorcount"`
+	AncestorSize      int         `json:"ancestorsize"`
+	WTxID             string      `json:"wtxid"`
+	Fees              MempoolFees `json:"fees"`
+	Depends           []string    `json:"depends"`
+	SpentBy           []string    `json:"spentby"`
+	BIP125Replaceable bool        `json:"bip125-replaceable"`
+	Unbroadcast       bool        `json:"unbroadcast"`
+}
+
+type MempoolFees struct {
+	Base       float64 `json:"base"`
+	Modified   float64 `json:"modified"`
+	Ancestor   float64 `json:"ancestor"`
+	Descendant float64 `json:"descendant"`
+}
+
+// EncodeMempoolEntry encodes the entry, the counts, sizes and fees of its
+// ancestors and descendants include the entry itself.
+func EncodeMempoolEntry(entry MempoolEntry, ancestors, descendants []MempoolEntry) VerboseMempoolEntry {
+	ancestorSize, ancestorFee := entry.VSize(), entry.Fee
+	replaceable := entry.Signals
+	for _, ancestor := range ancestors {
+		ancestorSize += ancestor.VSize()
+		ancestorFee += ancestor.Fee
+		replaceable = replaceable || ancestor.Signals
+	}
+	descendantSize, descendantFee := entry.VSize(), entry.Fee
+	for _, descendant := range descendants {
+		descendantSize += descendant.VSize()
+		descendantFee += descendant.Fee
+	}
+
+	depends := entry.Depends
+	if depends == nil {
+		depends = []string{}
+	}
+	spentBy := entry.SpentBy
+	if spentBy == nil {
+		spentBy = []string{}
+	}
+	return VerboseMempoolEntry{
+		VSize:           entry.VSize(),
+		Weight:          entry.Weight,
+		Time:            entry.Time.Unix(),
+		Height:          entry.Height,
+		DescendantCount: len(descendants) + 1,
+		DescendantSize:  descendantSize,
+		AncestorCount:   len(ancestors) + 1,
+		AncestorSize:    ancestorSize,
+		WTxID:           entry.WitnessHash,
+		Fees: MempoolFees{
+			Base:       float64(entry.Fee) / float64(100000000),
+			Modified:   float64(entry.Fee) / float64(100000000),
+			Ancestor:   float64(ancestorFee) / float64(100000000),
+			Descendant: float64(descendantFee) / float64(100000000),
+		},
+		Depends:           depends,
+		SpentBy:           spentBy,
+		BIP125Replaceable: replaceable,
+		Unbroadcast:       entry.Unbroadcast,
+	}
+}
+
+// getmempoolinfo
+type MempoolInfo struct {
+	Loaded              bool    `json:"loaded"`
+	Size                int     `json:"size"`
+	Bytes               int     `json:"bytes"`
+	Usage               int     `json:"usage"`
+	TotalFee            float64 `json:"total_fee"`
+	MaxMempool          int     `json:"maxmempool"`
+	MempoolMinFee       float64 `json:"mempoolminfee"`
+	MinRelayTxFee       float64 `json:"minrelaytxfee"`
+	IncrementalRelayFee float64 `json:"incrementalrelayfee"`
+	UnbroadcastCount    int     `json:"unbroadcastcount"`
+	FullRBF             bool    `json:"fullrbf"`
+}
+
+// EncodeMempoolInfo summarizes the mempool. The memory usage is approximated
+// by the serialized size of the transactions.
+func EncodeMempoolInfo(entries []MempoolEntry) MempoolInfo {
+	info := MempoolInfo{
+		Loaded:              true,
+		Size:                len(entries),
+		MaxMempool:          maxMempool,
+		MempoolMinFee:       minRelayTxFee,
+		MinRelayTxFee:       minRelayTxFee,
+		IncrementalRelayFee: incrementalRelayFee,
+	}
+	totalFee := int64(0)
+	for _, entry := range entries {
+		info.Bytes += entry.VSize()
+		info.Usage += entry.Size
+		totalFee += entry.Fee
+		if entry.Unbroadcast {
+			info.UnbroadcastCount++
+		}
+	}
+	info.TotalFee = float64(totalFee) / float64(100000000)
+	return info
+}
+
+// gettxout
+type VerboseTxOut struct {
+	BestBlock     string       `json:"bestblock"`
+	Confirmations uint32       `json:"confirmations"`
+	Value         float64      `json:"value"`
+	ScriptPubKey  ScriptPubKey `json:"scriptPubKey"`
+	Coinbase      bool         `json:"coinbase"`
+}
+
+// EncodeTxOut encodes an unspent output, the address is the one recorded
+// when the output was stored.
+func EncodeTxOut(txOut TxOut, bestBlock string, confirmations uint32, params *chaincfg.Params) VerboseTxOut {
+	vout := EncodeVOUTs([]*wire.TxOut{wire.NewTxOut(txOut.OutPoint.Value, txOut.OutPoint.PkScript)}, params)[0]
+	vout.ScriptPubKey.Address = txOut.OutPoint.Spender
+	return VerboseTxOut{
+		BestBlock:     bestBlock,
 		Confirmations: confirmations,
+		Value:         vout.Value,
+		ScriptPubKey:  vout.ScriptPubKey,
+		Coinbase:      txOut.Coinbase,
+	}
+}
+
+// gettxoutsetinfo
+type VerboseTxOutSetInfo struct {
+	Height          int32   `json:"height"`
+	BestBlock       string  `json:"bestblock"`
+	Transactions    int64   `json:"transactions"`
+	TxOuts          int64   `json:"txouts"`
+	BogoSize        int64   `json:"bogosize"`
+	HashSerialized3 string  `json:"hash_serialized_3,omitempty"`
+	TotalAmount     float64 `json:"total_amount"`
+}
+
+func EncodeTxOutSetInfo(info TxOutSetInfo) VerboseTxOutSetInfo {
+	return VerboseTxOutSetInfo{
+		Height:          info.Height,
+		BestBlock:       info.BestBlock,
+		Transactions:    info.Transactions,
+		TxOuts:          info.TxOuts,
+		BogoSize:        info.BogoSize,
+		HashSerialized3: info.Hash,
+		TotalAmount:     float64(info.TotalAmount) / float64(100000000),
+	}
+}
+
+// estimatesmartfee
+type SmartFee struct {
+	FeeRate float64  `json:"feerate,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+	Blocks  int32    `json:"blocks"`
+}
+
+// EncodeSmartFee encodes a fee rate in sat/vB as BTC/kvB.
+func EncodeSmartFee(target int32, feeRate float64, errors []string) SmartFee {
+	return SmartFee{
+		FeeRate: math.Round(feeRate*1000) / 100000000,
+		Errors:  errors,
+		Blocks:  target,
+	}
+}
+
+// getblockstats
+
+// EncodeBlockStats encodes the statistics of a block by name, so that
+// getblockstats can select some of them. The coinbase transaction is left
+// out of all but the output statistics. Amounts are in satoshis and fee
+// rates in sat/vB, as bitcoind reports them.
+func EncodeBlockStats(block *btcutil.Block, transactions []model.Transaction, spent []model.OutPoint, medianTime int64, params *chaincfg.Params) map[string]interface{} {
+	fees := make(map[uint32]int64, len(transactions))
+	for _, transaction := range transactions {
+		fees[transaction.BlockIndex] = transaction.Fee
+	}
+
+	var (
+		ins, outs, utxos                                 int64
+		totalOut, totalSize, totalWeight, totalFee       int64
+		swTxs, swTotalSize, swTotalWeight                int64
+		utxoSizeInc, utxoSizeIncActual                   int64
+		maxFee, minFee, maxFeeRate, minFeeRate           int64
+		maxTxSize, minTxSize                             int64
+		feeArray, feeRateArray, weightArray, txSizeArray []int64
+	)
+	for i, tx := range block.Transactions() {
+		msgTx := tx.MsgTx()
+		outs += int64(len(msgTx.TxOut))
+		txOut := int64(0)
+		for _, out := range msgTx.TxOut {
+			txOut += out.Value
+			size := int64(out.SerializeSize()) + perUTXOOverhead
+			utxoSizeInc += size
+			if txscript.IsUnspendable(out.PkScript) {
+				continue
+			}
+			utxos++
+			utxoSizeIncActual += size
+		}
+		if i == 0 {
+			continue
+		}
+
+		ins += int64(len(msgTx.TxIn))
+		totalOut += txOut
+		size := int64(msgTx.SerializeSize())
+		weight := blockchain.GetTransactionWeight(tx)
+		totalSize += size
+		totalWeight += weight
+		if msgTx.HasWitness() {
+			swTxs++
+			swTotalSize += size
+			swTotalWeight += weight
+		}
+
+		fee := fees[uint32(i)]
+		feeRate := int64(0)
+		if weight > 0 {
+			feeRate = fee * blockchain.WitnessScaleFactor / weight
+		}
+		totalFee += fee
+		if i == 1 || fee > maxFee {
+			maxFee = fee
+		}
+		if i == 1 || fee < minFee {
+			minFee = fee
+		}
+		if i == 1 || feeRate > maxFeeRate {
+			maxFeeRate = feeRate
+		}
+		if i == 1 || feeRate < minFeeRate {
+			minFeeRate = feeRate
+		}
+		if i == 1 || size > maxTxSize {
+			maxTxSize = size
+		}
+		if i == 1 || size < minTxSize {
+			minTxSize = size
+		}
+		feeArray = append(feeArray, fee)
+		feeRateArray = append(feeRateArray, feeRate)
+		weightArray = append(weightArray, weight)
+		txSizeArray = append(txSizeArray, size)
+	}
+	for _, op := range spent {
+		size := int64(wire.NewTxOut(op.Value, op.PkScript).SerializeSize()) + perUTXOOverhead
+		utxoSizeInc -= size
+		utxoSizeIncActual -= size
+	}
+
+	txs := int64(len(block.Transactions()))
+	avgFee, avgTxSize, avgFeeRate := int64(0), int64(0), int64(0)
+	if txs > 1 {
+		avgFee = totalFee / (txs - 1)
+		avgTxSize = totalSize / (txs - 1)
+	}
+	if totalWeight > 0 {
+		avgFeeRate = totalFee * blockchain.WitnessScaleFactor / totalWeight
 	}
+
+	return map[string]interface{}{
+		"avgfee":               avgFee,
+		"avgfeerate":           avgFeeRate,
+		"avgtxsize":            avgTxSize,
+		"blockhash":            block.Hash().String(),
+		"feerate_percentiles":  percentilesByWeight(feeRateArray, weightArray),
+		"height":               block.Height(),
+		"ins":                  ins,
+		"maxfee":               maxFee,
+		"maxfeerate":           maxFeeRate,
+		"maxtxsize":            maxTxSize,
+		"medianfee":            truncatedMedian(feeArray),
+		"mediantime":           medianTime,
+		"mediantxsize":         truncatedMedian(txSizeArray),
+		"minfee":               minFee,
+		"minfeerate":           minFeeRate,
+		"mintxsize":            minTxSize,
+		"outs":                 outs,
+		"subsidy":              chain.ForParams(params).Subsidy(block.Height(), block.MsgBlock().Header.PrevBlock),
+		"swtotal_size":         swTotalSize,
+		"swtotal_weight":       swTotalWeight,
+		"swtxs":                swTxs,
+		"time":                 block.MsgBlock().Header.Timestamp.Unix(),
+		"total_out":            totalOut,
+		"total_size":           totalSize,
+		"total_weight":         totalWeight,
+		"totalfee":             totalFee,
+		"txs":                  txs,
+		"utxo_increase":        outs - ins,
+		"utxo_increase_actual": utxos - ins,
+		"utxo_size_inc":        utxoSizeInc,
+		"utxo_size_inc_actual": utxoSizeIncActual,
+	}
+}
+
+// getchaintxstats
+type ChainTxStats struct {
+	Time                   int64    `json:"time"`
+	TxCount                int64    `json:"txcount"`
+	WindowFinalBlockHash   string   `json:"window_final_block_hash"`
+	WindowFinalBlockHeight int32    `json:"window_final_block_height"`
+	WindowBlockCount       int32    `json:"window_block_count"`
+	WindowTxCount          *int64   `json:"window_tx_count,omitempty"`
+	WindowInterval         *int64   `json:"window_interval,omitempty"`
+	TxRate                 *float64 `json:"txrate,omitempty"`
 }
@@ -3,11 +3,12 @@ package command
 import (
 	"bytes"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"math"
 	"strconv"
 
 	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/catalogfi/indexer/model"
 )
@@ -17,10 +18,27 @@ type Storage interface {
 	GetLatestBlockHash() (string, error)
 	GetLatestBlockHeight() (int32, error)
 	GetTransaction(hash string) (Transaction, error)
+	GetEvictedTransaction(hash string) (Transaction, error)
+	GetWalletConflicts(hash string) ([]string, error)
 	GetBlockFromHash(hash string) (*btcutil.Block, error)
 	GetHeaderFromHeight(height int32) (BlockHeader, error)
 	GetHeaderFromHash(hash string) (BlockHeader, error)
 	ListUnspent(startBlock, endBlock int, addresses []string, includeUnsafe bool, queryOptions ListUnspentQueryOptions) ([]model.OutPoint, error)
+	GetAddressTxIDs(addresses []string, options AddressQueryOptions) ([]string, error)
+	GetAddressDeltas(addresses []string, options AddressQueryOptions) ([]AddressDelta, error)
+	GetAddressBalance(addresses []string) (int64, int64, error)
+	GetOutPoint(hash string, index uint32) (model.OutPoint, error)
+	GetTxOut(hash string, index uint32, includeMempool bool) (TxOut, error)
+	GetTxOutSetInfo(withHash bool) (TxOutSetInfo, error)
+	GetFeeStats(target, blocks int32) ([]FeeStats, error)
+	GetBlockTransactions(hash string) ([]model.Transaction, error)
+	GetSpentOutPoints(blockHash string) ([]model.OutPoint, error)
+	GetChainTxCount(height int32) (int64, error)
+	GetBlockFilter(blockHash string) (BlockFilter, error)
+	Params() *chaincfg.Params
+	SubmitTx(tx *wire.MsgTx) error
+	GetMempool() ([]MempoolEntry, error)
+	GetMempoolEntries(hashes []string) ([]MempoolEntry, error)
 }
 
 type Command interface {
@@ -57,11 +75,23 @@ func (g *getBlockHash) Name() string {
 }
 
 func (g *getBlockHash) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, invalidParamsCount(len(params), "1")
+	}
+
 	height, ok := params[0].(float64)
 	if !ok {
-		return nil, fmt.Errorf("invalid parameter type: %T, required a number", params[0])
+		return nil, invalidParamType(params[0], "number")
+	}
+
+	hash, err := str.GetBlockHash(int32(height))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidParameter, "Block height out of range")
+		}
+		return nil, err
 	}
-	return str.GetBlockHash(int32(height))
+	return hash, nil
 }
 
 // getblockcount
@@ -99,17 +129,20 @@ type BlockHeader struct {
 }
 
 func (g *getBlockHeader) Query(str Storage, params []interface{}) (interface{}, error) {
-	if len(params) < 1 && len(params) > 2 {
-		return nil, fmt.Errorf("invalid number of parameters: %d, required 1 or 2", len(params))
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
 	}
 
 	blockHash, ok := params[0].(string)
 	if !ok {
-		return nil, fmt.Errorf("invalid parameter type: %T, required string", params[0])
+		return nil, invalidParamType(params[0], "string")
 	}
 
 	header, err := str.GetHeaderFromHash(blockHash)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Block not found")
+		}
 		return nil, err
 	}
 
@@ -117,7 +150,7 @@ func (g *getBlockHeader) Query(str Storage, params []interface{}) (interface{},
 	if len(params) == 2 {
 		verbose, ok = params[1].(bool)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required boolean", params[1])
+			return nil, invalidParamType(params[1], "boolean")
 		}
 	}
 
@@ -168,17 +201,20 @@ func (g *getBlock) Name() string {
 }
 
 func (g *getBlock) Query(str Storage, params []interface{}) (interface{}, error) {
-	if len(params) < 1 && len(params) > 2 {
-		return nil, fmt.Errorf("invalid number of parameters: %d, required 1 or 2", len(params))
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
 	}
 
 	blockHash, ok := params[0].(string)
 	if !ok {
-		return nil, fmt.Errorf("invalid parameter type: %T, required string", params[0])
+		return nil, invalidParamType(params[0], "string")
 	}
 
 	block, err := str.GetBlockFromHash(blockHash)
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Block not found")
+		}
 		return nil, err
 	}
 
@@ -186,7 +222,7 @@ func (g *getBlock) Query(str Storage, params []interface{}) (interface{}, error)
 	if len(params) == 2 {
 		verboseF, ok := params[1].(float64)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required boolean", params[1])
+			return nil, invalidParamType(params[1], "number")
 		}
 		verbose = int(verboseF)
 	}
@@ -209,7 +245,7 @@ func (g *getBlock) Query(str Storage, params []interface{}) (interface{}, error)
 	}
 	nextBlockHash, _ := str.GetBlockHash(block.Height() + 1)
 
-	return EncodeBlock(block, uint32(tip-block.Height())+1, medianHeader.Header.Timestamp.Unix(), nextBlockHash, verbose)
+	return EncodeBlock(block, uint32(tip-block.Height())+1, medianHeader.Header.Timestamp.Unix(), nextBlockHash, verbose, str.Params())
 }
 
 // getrawtransaction
@@ -229,6 +265,8 @@ type Transaction struct {
 	BlockHash string
 	BlockTime int64
 	Height    int32
+	// ReplacedBy is the transaction that replaced an evicted transaction.
+	ReplacedBy string
 }
 
 func (g *getRawTransaction) Query(str Storage, params []interface{}) (interface{}, error) {
@@ -241,22 +279,29 @@ func (g *getRawTransaction) Query(str Storage, params []interface{}) (interface{
 	case 2:
 		param2, ok := params[1].(bool)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required boolean", params[1])
+			return nil, invalidParamType(params[1], "boolean")
 		}
 		verbose = param2
 		fallthrough
 	case 1:
 		param1, ok := params[0].(string)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required string", params[0])
+			return nil, invalidParamType(params[0], "string")
 		}
 		txHash = param1
 	default:
-		return nil, fmt.Errorf("invalid number of parameters: %d, required 1, 2 or 3", len(params))
+		return nil, invalidParamsCount(len(params), "1, 2 or 3")
 	}
 
+	// Transactions evicted from the mempool can still be looked up
 	tx, err := str.GetTransaction(txHash)
+	if errors.Is(err, ErrNotFound) {
+		tx, err = str.GetEvictedTransaction(txHash)
+	}
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "No such mempool or blockchain transaction")
+		}
 		return nil, err
 	}
 
@@ -268,12 +313,23 @@ func (g *getRawTransaction) Query(str Storage, params []interface{}) (interface{
 		return hex.EncodeToString(buf.Bytes()), nil
 	}
 
-	tip, err := str.GetLatestBlockHeight()
+	confirmations := uint32(0)
+	if tx.BlockHash != "" {
+		tip, err := str.GetLatestBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		confirmations = uint32(tip-tx.Height) + 1
+	}
+	conflicts, err := str.GetWalletConflicts(txHash)
 	if err != nil {
 		return nil, err
 	}
 
-	return EncodeTransaction(tx.Tx, tx.BlockHash, uint32(tip-tx.Height)+1, tx.BlockTime), nil
+	verboseTx := EncodeTransaction(tx.Tx, tx.BlockHash, confirmations, tx.BlockTime, str.Params())
+	verboseTx.WalletConflicts = conflicts
+	verboseTx.ReplacedBy = tx.ReplacedBy
+	return verboseTx, nil
 }
 
 // listunspent
@@ -320,7 +376,7 @@ func (g *listUnspent) Query(str Storage, params []interface{}) (interface{}, err
 	case 5:
 		qoj, ok := params[4].(map[string]interface{})
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required object", params[4])
+			return nil, invalidParamType(params[4], "object")
 		}
 
 		maxAmt, ok := parseAmount(qoj["maximumAmount"])
@@ -348,7 +404,7 @@ func (g *listUnspent) Query(str Storage, params []interface{}) (interface{}, err
 		// and are not eligible for spending by fundrawtransaction and sendtoaddress
 		param3, ok := params[3].(bool)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required boolean", params[3])
+			return nil, invalidParamType(params[3], "boolean")
 		}
 		includeUnsafe = param3
 		fallthrough
@@ -356,31 +412,31 @@ func (g *listUnspent) Query(str Storage, params []interface{}) (interface{}, err
 		// The bitcoin addresses to filter
 		param2, ok := params[2].([]interface{}) // only this is used right now
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required array", params[2])
+			return nil, invalidParamType(params[2], "array")
 		}
 		addresses = make([]string, len(param2))
 		for i, addr := range param2 {
 			addresses[i], ok = addr.(string)
 			if !ok {
-				return nil, fmt.Errorf("invalid parameter type: %T, required string", addr)
+				return nil, invalidParamType(addr, "string")
 			}
 		}
 
 		// The maximum confirmations to filter
 		param1, ok := params[1].(float64)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required number", params[1])
+			return nil, invalidParamType(params[1], "number")
 		}
 		maxconf = int(param1)
 
 		// The minimum confirmations to filter
 		param0, ok := params[0].(float64)
 		if !ok {
-			return nil, fmt.Errorf("invalid parameter type: %T, required number", params[0])
+			return nil, invalidParamType(params[0], "number")
 		}
 		minconf = int(param0)
 	default:
-		return nil, fmt.Errorf("invalid number of parameters needed 3-5, got %d", len(params))
+		return nil, invalidParamsCount(len(params), "3 to 5")
 	}
 
 	tip, err := str.GetLatestBlockHeight()
@@ -406,7 +462,7 @@ func (g *listUnspent) Query(str Storage, params []interface{}) (interface{}, err
 	unspents := []Unspent{}
 	sumAmount := int64(0)
 	for _, op := range outpoints {
-		tx, err := str.GetTransaction(op.FundingTxHash)
+		tx, err := str.GetTransaction(string(op.FundingTxHash))
 		if err != nil {
 			return nil, err
 		}
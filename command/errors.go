@@ -0,0 +1,71 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RPC error codes, as defined by Bitcoin Core in src/rpc/protocol.h
+const (
+	// Standard JSON-RPC 2.0 errors
+	ErrRPCInvalidRequest = -32600
+	ErrRPCMethodNotFound = -32601
+	ErrRPCInvalidParams  = -32602
+	ErrRPCInternal       = -32603
+	ErrRPCParse          = -32700
+
+	// General application defined errors
+	ErrRPCMisc                 = -1
+	ErrRPCType                 = -3
+	ErrRPCInvalidAddressOrKey  = -5
+	ErrRPCOutOfMemory          = -7
+	ErrRPCInvalidParameter     = -8
+	ErrRPCDatabase             = -20
+	ErrRPCDeserialization      = -22
+	ErrRPCVerify               = -25
+	ErrRPCVerifyRejected       = -26
+	ErrRPCVerifyAlreadyInChain = -27
+	ErrRPCInWarmup             = -28
+)
+
+// ErrNotFound is returned by the storage when the requested block,
+// transaction or outpoint does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Error is an error with a Bitcoin Core compatible RPC error code.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// ToError converts any error returned by a command into an *Error, errors
+// without a code are reported as miscellaneous errors.
+func ToError(err error) *Error {
+	rpcErr := &Error{}
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return &Error{
+		Code:    ErrRPCMisc,
+		Message: err.Error(),
+	}
+}
+
+func invalidParamsCount(got int, required string) *Error {
+	return NewError(ErrRPCInvalidParams, "invalid number of parameters: %d, required %s", got, required)
+}
+
+func invalidParamType(param interface{}, required string) *Error {
+	return NewError(ErrRPCType, "invalid parameter type: %T, required %s", param, required)
+}
@@ -0,0 +1,278 @@
+package command
+
+import (
+	"math"
+	"strings"
+)
+
+// Fee rate buckets in sat/vB, bitcoind's: the first bucket holds fee rates up
+// to minBucketFeeRate, and each following one fee rates up to feeSpacing
+// times the previous one, up to maxBucketFeeRate.
+const (
+	minBucketFeeRate = 1.0
+	maxBucketFeeRate = 10000.0
+	feeSpacing       = 1.05
+)
+
+var feeBuckets = int(math.Ceil(math.Log(maxBucketFeeRate/minBucketFeeRate)/math.Log(feeSpacing))) + 1
+
+// FeeRateBucket returns the bucket of a fee rate in sat/vB.
+func FeeRateBucket(feeRate float64) int {
+	if feeRate <= minBucketFeeRate {
+		return 0
+	}
+	bucket := int(math.Ceil(math.Log(feeRate/minBucketFeeRate) / math.Log(feeSpacing)))
+	if bucket >= feeBuckets {
+		return feeBuckets - 1
+	}
+	return bucket
+}
+
+// FeeStats counts the transactions of a fee rate bucket.
+type FeeStats struct {
+	Bucket int
+	// Age is the number of blocks mined on top of the block that confirmed
+	// the transactions, -1 for transactions still in the mempool.
+	Age int32
+	// Confirmed counts the transactions confirmed within the target, and
+	// Total all of them, including the ones still in the mempool that waited
+	// for longer than the target.
+	Confirmed int64
+	Total     int64
+	// FeeRate is the sum of the fee rates of the confirmed transactions in
+	// sat/vB.
+	FeeRate float64
+}
+
+// feeHorizon is a window of blocks transactions are tracked over, with the
+// weight of older blocks decaying, after bitcoind's short, medium and long
+// horizons.
+type feeHorizon struct {
+	maxTarget int32
+	decay     float64
+	// blocks is how many blocks are looked at, older blocks weigh too
+	// little to matter.
+	blocks int32
+	// sufficient is the weighted number of transactions per block needed
+	// for a range of buckets to be evaluated.
+	sufficient float64
+}
+
+var feeHorizons = []feeHorizon{
+	{maxTarget: 12, decay: 0.962, blocks: 100, sufficient: 0.5},
+	{maxTarget: 48, decay: 0.9952, blocks: 1000, sufficient: 0.1},
+	{maxTarget: 1008, decay: 0.99931, blocks: 4000, sufficient: 0.1},
+}
+
+// Success thresholds of the estimates for half, exactly and double the
+// target.
+const (
+	halfSuccess   = 0.6
+	success       = 0.85
+	doubleSuccess = 0.95
+)
+
+// feeEstimator estimates fee rates from the statistics of the storage.
+type feeEstimator struct {
+	str Storage
+}
+
+// horizon returns the shortest horizon tracking the target.
+func (f *feeEstimator) horizon(target int32) feeHorizon {
+	for _, horizon := range feeHorizons {
+		if target <= horizon.maxTarget {
+			return horizon
+		}
+	}
+	return feeHorizons[len(feeHorizons)-1]
+}
+
+// estimate returns the lowest fee rate in sat/vB at which transactions were
+// confirmed within the target often enough, and whether there was enough
+// data to find one. Going from the highest fee rate down, buckets are
+// grouped until they hold enough transactions, and the groups have to meet
+// the threshold; the estimate is the median fee rate of the last one that
+// does.
+func (f *feeEstimator) estimate(target int32, threshold float64, horizon feeHorizon) (float64, bool, error) {
+	if target > horizon.maxTarget {
+		target = horizon.maxTarget
+	}
+	stats, err := f.str.GetFeeStats(target, horizon.blocks)
+	if err != nil {
+		return 0, false, err
+	}
+
+	confirmed := make([]float64, feeBuckets)
+	total := make([]float64, feeBuckets)
+	count := make([]float64, feeBuckets)
+	feeRate := make([]float64, feeBuckets)
+	for _, stat := range stats {
+		if stat.Age < 0 {
+			total[stat.Bucket] += float64(stat.Total)
+			continue
+		}
+		weight := math.Pow(horizon.decay, float64(stat.Age))
+		confirmed[stat.Bucket] += weight * float64(stat.Confirmed)
+		total[stat.Bucket] += weight * float64(stat.Total)
+		count[stat.Bucket] += weight * float64(stat.Total)
+		feeRate[stat.Bucket] += weight * stat.FeeRate
+	}
+
+	sufficient := horizon.sufficient / (1 - horizon.decay)
+	passLow, passHigh := -1, -1
+	curConfirmed, curTotal := 0.0, 0.0
+	curHigh := feeBuckets - 1
+	for bucket := feeBuckets - 1; bucket >= 0; bucket-- {
+		curConfirmed += confirmed[bucket]
+		curTotal += total[bucket]
+		if curTotal < sufficient {
+			continue
+		}
+		if curConfirmed/curTotal < threshold {
+			break
+		}
+		passLow, passHigh = bucket, curHigh
+		curConfirmed, curTotal = 0, 0
+		curHigh = bucket - 1
+	}
+	if passLow < 0 {
+		return 0, false, nil
+	}
+
+	rangeCount := 0.0
+	for bucket := passLow; bucket <= passHigh; bucket++ {
+		rangeCount += count[bucket]
+	}
+	seen := 0.0
+	for bucket := passLow; bucket <= passHigh; bucket++ {
+		seen += count[bucket]
+		if count[bucket] > 0 && seen >= rangeCount/2 {
+			return feeRate[bucket] / count[bucket], true, nil
+		}
+	}
+	return minBucketFeeRate * math.Pow(feeSpacing, float64(passLow)), true, nil
+}
+
+// feeEstimate is a target to estimate a fee rate for, along with the share
+// of transactions that have to be confirmed within it and the horizon they
+// are tracked over.
+type feeEstimate struct {
+	target    int32
+	threshold float64
+	horizon   feeHorizon
+}
+
+// smartFee combines the estimates for half, exactly and double the target
+// like bitcoind's estimatesmartfee, taking the highest of them. Conservative
+// estimates also require the double target to be met on the long horizon.
+func (f *feeEstimator) smartFee(target int32, conservative bool) (float64, bool, error) {
+	longest := feeHorizons[len(feeHorizons)-1]
+	estimates := []feeEstimate{
+		{target / 2, halfSuccess, f.horizon(target / 2)},
+		{target, success, f.horizon(target)},
+		{2 * target, doubleSuccess, f.horizon(2 * target)},
+	}
+	if conservative {
+		estimates = append(estimates, feeEstimate{2 * target, doubleSuccess, longest})
+	}
+
+	best, found := 0.0, false
+	for _, e := range estimates {
+		if e.target < 1 {
+			continue
+		}
+		feeRate, ok, err := f.estimate(e.target, e.threshold, e.horizon)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok && feeRate > best {
+			best, found = feeRate, true
+		}
+	}
+	return best, found, nil
+}
+
+// MaxFeeTarget is the highest confirmation target fee rates are estimated
+// for.
+var MaxFeeTarget = feeHorizons[len(feeHorizons)-1].maxTarget
+
+// ParseEstimateMode reports whether an estimate mode of estimatesmartfee
+// asks for a conservative estimate.
+func ParseEstimateMode(mode string) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "unset", "economical":
+		return false, nil
+	case "conservative":
+		return true, nil
+	default:
+		return false, NewError(ErrRPCInvalidParameter, `Invalid estimate_mode parameter, must be one of: "unset", "economical", "conservative"`)
+	}
+}
+
+// SmartFeeRate estimates the fee rate in sat/vB for a transaction to be
+// confirmed within the target like estimatesmartfee, never below the
+// minimum relay fee rate. It returns the target the estimate is for and
+// whether there was enough data to make one.
+func SmartFeeRate(str Storage, target int32, conservative bool) (float64, int32, bool, error) {
+	// Transactions are not confirmed before the next block
+	if target == 1 {
+		target = 2
+	}
+	f := &feeEstimator{str: str}
+	feeRate, ok, err := f.smartFee(target, conservative)
+	if err != nil || !ok {
+		return 0, target, false, err
+	}
+	if feeRate < minRelayTxFee*1e5 {
+		feeRate = minRelayTxFee * 1e5
+	}
+	return feeRate, target, true, nil
+}
+
+// estimatesmartfee
+type estimateSmartFee struct {
+}
+
+func EstimateSmartFee() Command {
+	return &estimateSmartFee{}
+}
+
+func (e *estimateSmartFee) Name() string {
+	return "estimatesmartfee"
+}
+
+func (e *estimateSmartFee) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
+	}
+
+	targetF, ok := params[0].(float64)
+	if !ok {
+		return nil, invalidParamType(params[0], "number")
+	}
+	if targetF < 1 || targetF > float64(MaxFeeTarget) {
+		return nil, NewError(ErrRPCInvalidParameter, "Invalid conf_target, must be between 1 and %d", MaxFeeTarget)
+	}
+
+	// bitcoind defaults to economical estimates since version 28
+	conservative := false
+	if len(params) == 2 {
+		mode, ok := params[1].(string)
+		if !ok {
+			return nil, invalidParamType(params[1], "string")
+		}
+		var err error
+		if conservative, err = ParseEstimateMode(mode); err != nil {
+			return nil, err
+		}
+	}
+
+	feeRate, target, ok, err := SmartFeeRate(str, int32(targetF), conservative)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return EncodeSmartFee(target, 0, []string{"Insufficient data or no feerate found"}), nil
+	}
+	return EncodeSmartFee(target, feeRate, nil), nil
+}
@@ -0,0 +1,60 @@
+package command
+
+import "errors"
+
+// BlockFilter is the BIP158 filter of a block, hex encoded, and its BIP157
+// filter header.
+type BlockFilter struct {
+	Filter string `json:"filter"`
+	Header string `json:"header"`
+}
+
+// basicFilter is the only filter type, BIP158's basic filter.
+const basicFilter = "basic"
+
+// getblockfilter
+type getBlockFilter struct {
+}
+
+func GetBlockFilter() Command {
+	return &getBlockFilter{}
+}
+
+func (g *getBlockFilter) Name() string {
+	return "getblockfilter"
+}
+
+func (g *getBlockFilter) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
+	}
+
+	blockHash, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParamType(params[0], "string")
+	}
+	if len(params) == 2 {
+		filterType, ok := params[1].(string)
+		if !ok {
+			return nil, invalidParamType(params[1], "string")
+		}
+		if filterType != basicFilter {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Unknown filtertype")
+		}
+	}
+
+	if _, err := str.GetHeaderFromHash(blockHash); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Block not found")
+		}
+		return nil, err
+	}
+	filter, err := str.GetBlockFilter(blockHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCMisc, "Filter not found. Block was not connected to active chain.")
+		}
+		return nil, err
+	}
+	return filter, nil
+}
@@ -0,0 +1,321 @@
+package command
+
+import "time"
+
+// Fee rates of the mempool policy reported by getmempoolinfo, in BTC/kvB,
+// bitcoind's defaults.
+const (
+	minRelayTxFee       = 0.00001
+	incrementalRelayFee = 0.00001
+
+	// maxMempool is bitcoind's default -maxmempool in bytes.
+	maxMempool = 300000000
+)
+
+// MempoolEntry is an unconfirmed transaction.
+type MempoolEntry struct {
+	Hash        string
+	WitnessHash string
+	Size        int
+	Weight      int
+	Fee         int64
+	Time        time.Time
+	Height      int32
+
+	// Depends are the unconfirmed transactions the entry spends outputs of,
+	// and SpentBy the ones spending its outputs.
+	Depends []string
+	SpentBy []string
+
+	// Signals is set if one of the inputs opts in to replacement as defined
+	// by BIP125.
+	Signals bool
+	// Unbroadcast is set if the transaction was submitted through the RPC
+	// server and not relayed yet.
+	Unbroadcast bool
+}
+
+func (e MempoolEntry) VSize() int {
+	return (e.Weight + 3) / 4
+}
+
+// mempool resolves the ancestors and descendants of mempool entries, loading
+// the entries it does not know yet from the storage.
+type mempool struct {
+	str     Storage
+	entries map[string]MempoolEntry
+}
+
+func newMempool(str Storage, entries []MempoolEntry) *mempool {
+	m := &mempool{
+		str:     str,
+		entries: make(map[string]MempoolEntry, len(entries)),
+	}
+	for _, entry := range entries {
+		m.entries[entry.Hash] = entry
+	}
+	return m
+}
+
+// entry returns the mempool entry of the transaction.
+func (m *mempool) entry(hash string) (MempoolEntry, error) {
+	if entry, ok := m.entries[hash]; ok {
+		return entry, nil
+	}
+	entries, err := m.str.GetMempoolEntries([]string{hash})
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+	if len(entries) == 0 {
+		return MempoolEntry{}, NewError(ErrRPCInvalidAddressOrKey, "Transaction not in mempool")
+	}
+	m.entries[hash] = entries[0]
+	return entries[0], nil
+}
+
+func (m *mempool) ancestors(entry MempoolEntry) ([]MempoolEntry, error) {
+	return m.relatives(entry, func(e MempoolEntry) []string { return e.Depends })
+}
+
+func (m *mempool) descendants(entry MempoolEntry) ([]MempoolEntry, error) {
+	return m.relatives(entry, func(e MempoolEntry) []string { return e.SpentBy })
+}
+
+// relatives walks the mempool from the entry along edges, and returns the
+// entries reached, without the entry itself.
+func (m *mempool) relatives(entry MempoolEntry, edges func(MempoolEntry) []string) ([]MempoolEntry, error) {
+	visited := map[string]bool{entry.Hash: true}
+	relatives := []MempoolEntry{}
+	next := edges(entry)
+	for len(next) > 0 {
+		missing := []string{}
+		for _, hash := range next {
+			if _, ok := m.entries[hash]; !ok {
+				missing = append(missing, hash)
+			}
+		}
+		if len(missing) > 0 {
+			entries, err := m.str.GetMempoolEntries(missing)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				m.entries[e.Hash] = e
+			}
+		}
+
+		current := next
+		next = nil
+		for _, hash := range current {
+			e, ok := m.entries[hash]
+			if !ok || visited[hash] {
+				// Confirmed or removed since the entry was loaded
+				continue
+			}
+			visited[hash] = true
+			relatives = append(relatives, e)
+			next = append(next, edges(e)...)
+		}
+	}
+	return relatives, nil
+}
+
+// verbose encodes the entry along with the totals of its ancestors and
+// descendants.
+func (m *mempool) verbose(entry MempoolEntry) (VerboseMempoolEntry, error) {
+	ancestors, err := m.ancestors(entry)
+	if err != nil {
+		return VerboseMempoolEntry{}, err
+	}
+	descendants, err := m.descendants(entry)
+	if err != nil {
+		return VerboseMempoolEntry{}, err
+	}
+	return EncodeMempoolEntry(entry, ancestors, descendants), nil
+}
+
+// getrawmempool
+type getRawMempool struct {
+}
+
+func GetRawMempool() Command {
+	return &getRawMempool{}
+}
+
+func (g *getRawMempool) Name() string {
+	return "getrawmempool"
+}
+
+func (g *getRawMempool) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "0 to 2")
+	}
+
+	verbose := false
+	if len(params) > 0 {
+		var ok bool
+		verbose, ok = params[0].(bool)
+		if !ok {
+			return nil, invalidParamType(params[0], "boolean")
+		}
+	}
+	if len(params) > 1 {
+		sequence, ok := params[1].(bool)
+		if !ok {
+			return nil, invalidParamType(params[1], "boolean")
+		}
+		if sequence {
+			return nil, NewError(ErrRPCInvalidParameter, "mempool_sequence is not supported")
+		}
+	}
+
+	entries, err := str.GetMempool()
+	if err != nil {
+		return nil, err
+	}
+	if !verbose {
+		hashes := make([]string, len(entries))
+		for i, entry := range entries {
+			hashes[i] = entry.Hash
+		}
+		return hashes, nil
+	}
+
+	m := newMempool(str, entries)
+	result := make(map[string]VerboseMempoolEntry, len(entries))
+	for _, entry := range entries {
+		if result[entry.Hash], err = m.verbose(entry); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// getmempoolentry
+type getMempoolEntry struct {
+}
+
+func GetMempoolEntry() Command {
+	return &getMempoolEntry{}
+}
+
+func (g *getMempoolEntry) Name() string {
+	return "getmempoolentry"
+}
+
+func (g *getMempoolEntry) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, invalidParamsCount(len(params), "1")
+	}
+	txHash, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParamType(params[0], "string")
+	}
+
+	m := newMempool(str, nil)
+	entry, err := m.entry(txHash)
+	if err != nil {
+		return nil, err
+	}
+	return m.verbose(entry)
+}
+
+// getmempoolancestors
+type getMempoolAncestors struct {
+}
+
+func GetMempoolAncestors() Command {
+	return &getMempoolAncestors{}
+}
+
+func (g *getMempoolAncestors) Name() string {
+	return "getmempoolancestors"
+}
+
+func (g *getMempoolAncestors) Query(str Storage, params []interface{}) (interface{}, error) {
+	return queryRelatives(str, params, (*mempool).ancestors)
+}
+
+// getmempooldescendants
+type getMempoolDescendants struct {
+}
+
+func GetMempoolDescendants() Command {
+	return &getMempoolDescendants{}
+}
+
+func (g *getMempoolDescendants) Name() string {
+	return "getmempooldescendants"
+}
+
+func (g *getMempoolDescendants) Query(str Storage, params []interface{}) (interface{}, error) {
+	return queryRelatives(str, params, (*mempool).descendants)
+}
+
+// queryRelatives answers getmempoolancestors and getmempooldescendants,
+// which take a txid and a verbose flag.
+func queryRelatives(str Storage, params []interface{}, relatives func(*mempool, MempoolEntry) ([]MempoolEntry, error)) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
+	}
+	txHash, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParamType(params[0], "string")
+	}
+	verbose := false
+	if len(params) == 2 {
+		verbose, ok = params[1].(bool)
+		if !ok {
+			return nil, invalidParamType(params[1], "boolean")
+		}
+	}
+
+	m := newMempool(str, nil)
+	entry, err := m.entry(txHash)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := relatives(m, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if !verbose {
+		hashes := make([]string, len(entries))
+		for i, e := range entries {
+			hashes[i] = e.Hash
+		}
+		return hashes, nil
+	}
+	result := make(map[string]VerboseMempoolEntry, len(entries))
+	for _, e := range entries {
+		if result[e.Hash], err = m.verbose(e); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// getmempoolinfo
+type getMempoolInfo struct {
+}
+
+func GetMempoolInfo() Command {
+	return &getMempoolInfo{}
+}
+
+func (g *getMempoolInfo) Name() string {
+	return "getmempoolinfo"
+}
+
+func (g *getMempoolInfo) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) != 0 {
+		return nil, invalidParamsCount(len(params), "0")
+	}
+
+	entries, err := str.GetMempool()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeMempoolInfo(entries), nil
+}
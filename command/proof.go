@@ -0,0 +1,154 @@
+package command
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/merkle"
+)
+
+// gettxoutproof
+type getTxOutProof struct {
+}
+
+func GetTxOutProof() Command {
+	return &getTxOutProof{}
+}
+
+func (g *getTxOutProof) Name() string {
+	return "gettxoutproof"
+}
+
+func (g *getTxOutProof) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
+	}
+
+	txHashes, ok := params[0].([]interface{})
+	if !ok {
+		return nil, invalidParamType(params[0], "array")
+	}
+	if len(txHashes) == 0 {
+		return nil, NewError(ErrRPCInvalidParameter, "Invalid parameter, txids must not be empty")
+	}
+	wanted := make(map[string]bool, len(txHashes))
+	first := ""
+	for _, txHash := range txHashes {
+		txid, ok := txHash.(string)
+		if !ok {
+			return nil, invalidParamType(txHash, "string")
+		}
+		if wanted[txid] {
+			return nil, NewError(ErrRPCInvalidParameter, "Invalid parameter, duplicated txid: %s", txid)
+		}
+		wanted[txid] = true
+		if first == "" {
+			first = txid
+		}
+	}
+
+	var blockHash string
+	if len(params) == 2 && params[1] != nil {
+		blockHash, ok = params[1].(string)
+		if !ok {
+			return nil, invalidParamType(params[1], "string")
+		}
+	} else {
+		tx, err := str.GetTransaction(first)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err != nil || tx.BlockHash == "" {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Transaction not yet in block")
+		}
+		blockHash = tx.BlockHash
+	}
+
+	block, err := str.GetBlockFromHash(blockHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Block not found")
+		}
+		return nil, err
+	}
+
+	txids := make([]chainhash.Hash, len(block.Transactions()))
+	matches := make([]bool, len(txids))
+	found := 0
+	for i, tx := range block.Transactions() {
+		txids[i] = *tx.Hash()
+		if wanted[txids[i].String()] {
+			matches[i] = true
+			found++
+		}
+	}
+	if found != len(wanted) {
+		return nil, NewError(ErrRPCInvalidAddressOrKey, "Not all transactions found in specified or retrieved block")
+	}
+
+	mb := merkle.NewMerkleBlock(block.MsgBlock().Header, txids, matches)
+	buf := new(bytes.Buffer)
+	if err := mb.BtcEncode(buf, wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// verifytxoutproof
+type verifyTxOutProof struct {
+}
+
+func VerifyTxOutProof() Command {
+	return &verifyTxOutProof{}
+}
+
+func (v *verifyTxOutProof) Name() string {
+	return "verifytxoutproof"
+}
+
+func (v *verifyTxOutProof) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) != 1 {
+		return nil, invalidParamsCount(len(params), "1")
+	}
+
+	proofHex, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParamType(params[0], "string")
+	}
+	proof, err := hex.DecodeString(proofHex)
+	if err != nil {
+		return nil, NewError(ErrRPCInvalidParameter, "proof must be hexadecimal string (not '%s')", proofHex)
+	}
+	mb := &wire.MsgMerkleBlock{}
+	if err := mb.BtcDecode(bytes.NewReader(proof), wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, NewError(ErrRPCDeserialization, "Proof decode failed")
+	}
+
+	// Proofs that do not commit to the merkle root of their header prove
+	// nothing
+	root, matched, _, err := merkle.ExtractMatches(mb)
+	if err != nil || root != mb.Header.MerkleRoot {
+		return []string{}, nil
+	}
+
+	blockHash := mb.Header.BlockHash().String()
+	header, err := str.GetHeaderFromHash(blockHash)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, NewError(ErrRPCInvalidAddressOrKey, "Block proof is not in best chain")
+	}
+	if mainHash, err := str.GetBlockHash(header.Height); err != nil || mainHash != blockHash {
+		return nil, NewError(ErrRPCInvalidAddressOrKey, "Block proof is not in best chain")
+	}
+
+	txids := make([]string, len(matched))
+	for i, hash := range matched {
+		txids[i] = hash.String()
+	}
+	return txids, nil
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// defaultMaxFeeRate is the default maximum fee rate in BTC/kvB accepted by
+// sendrawtransaction.
+const defaultMaxFeeRate = 0.10
+
+// sendrawtransaction
+type sendRawTransaction struct {
+}
+
+func SendRawTransaction() Command {
+	return &sendRawTransaction{}
+}
+
+func (s *sendRawTransaction) Name() string {
+	return "sendrawtransaction"
+}
+
+func (s *sendRawTransaction) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
+	}
+
+	txHex, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParamType(params[0], "string")
+	}
+
+	maxFeeRate := defaultMaxFeeRate
+	if len(params) == 2 {
+		maxFeeRate, ok = parseFeeRate(params[1])
+		if !ok {
+			return nil, invalidParamType(params[1], "number")
+		}
+	}
+
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, NewError(ErrRPCDeserialization, "TX decode failed")
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, NewError(ErrRPCDeserialization, "TX decode failed")
+	}
+	if err := blockchain.CheckTransactionSanity(btcutil.NewTx(tx)); err != nil {
+		return nil, NewError(ErrRPCVerifyRejected, "%v", err)
+	}
+	if blockchain.IsCoinBaseTx(tx) {
+		return nil, NewError(ErrRPCVerifyRejected, "coinbase")
+	}
+
+	txHash := tx.TxHash().String()
+	existing, err := str.GetTransaction(txHash)
+	if err == nil && existing.BlockHash != "" {
+		return nil, NewError(ErrRPCVerifyAlreadyInChain, "Transaction already in block chain")
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	inputValue := int64(0)
+	for _, txIn := range tx.TxIn {
+		op, err := str.GetOutPoint(txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, NewError(ErrRPCVerify, "bad-txns-inputs-missingorspent")
+			}
+			return nil, err
+		}
+		// Unconfirmed spenders are replaced by the storage if the BIP125
+		// rules allow it
+		if op.SpendingTxHash != "" && string(op.SpendingTxHash) != txHash {
+			spender, err := str.GetTransaction(string(op.SpendingTxHash))
+			if err != nil {
+				return nil, err
+			}
+			if spender.BlockHash != "" {
+				return nil, NewError(ErrRPCVerify, "bad-txns-inputs-missingorspent")
+			}
+		}
+		inputValue += op.Value
+	}
+
+	outputValue := int64(0)
+	for _, txOut := range tx.TxOut {
+		outputValue += txOut.Value
+	}
+	if inputValue < outputValue {
+		return nil, NewError(ErrRPCVerifyRejected, "bad-txns-in-belowout")
+	}
+
+	vsize := (3*tx.SerializeSizeStripped() + tx.SerializeSize() + 3) / 4
+	feeRate := float64(inputValue-outputValue) / 1e8 / float64(vsize) * 1000
+	if maxFeeRate != 0 && feeRate > maxFeeRate {
+		return nil, NewError(ErrRPCVerify, "Fee exceeds maximum configured by user (e.g. -maxtxfee, maxfeerate)")
+	}
+
+	if err := str.SubmitTx(tx); err != nil {
+		return nil, err
+	}
+	return txHash, nil
+}
+
+// parseFeeRate parses a fee rate in BTC/kvB given as a number or a string.
+func parseFeeRate(rate interface{}) (float64, bool) {
+	amt, ok := parseAmount(rate)
+	if !ok || amt < 0 {
+		return 0, false
+	}
+	return float64(amt) / 1e8, true
+}
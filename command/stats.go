@@ -0,0 +1,274 @@
+package command
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// getblockstats
+type getBlockStats struct {
+}
+
+func GetBlockStats() Command {
+	return &getBlockStats{}
+}
+
+func (g *getBlockStats) Name() string {
+	return "getblockstats"
+}
+
+func (g *getBlockStats) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) < 1 || len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "1 or 2")
+	}
+
+	var blockHash string
+	switch hashOrHeight := params[0].(type) {
+	case string:
+		blockHash = hashOrHeight
+	case float64:
+		height := int32(hashOrHeight)
+		tip, err := str.GetLatestBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		if height < 0 {
+			return nil, NewError(ErrRPCInvalidParameter, "Target block height %d is negative", height)
+		}
+		if height > tip {
+			return nil, NewError(ErrRPCInvalidParameter, "Target block height %d after current tip %d", height, tip)
+		}
+		if blockHash, err = str.GetBlockHash(height); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, invalidParamType(params[0], "string or number")
+	}
+
+	selected := []string{}
+	if len(params) == 2 {
+		stats, ok := params[1].([]interface{})
+		if !ok {
+			return nil, invalidParamType(params[1], "array")
+		}
+		for _, stat := range stats {
+			name, ok := stat.(string)
+			if !ok {
+				return nil, invalidParamType(stat, "string")
+			}
+			selected = append(selected, name)
+		}
+	}
+
+	header, err := str.GetHeaderFromHash(blockHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Block not found")
+		}
+		return nil, err
+	}
+	if mainHash, err := str.GetBlockHash(header.Height); err != nil || mainHash != blockHash {
+		// Transactions are only attached to blocks of the main chain
+		return nil, NewError(ErrRPCMisc, "Block not available (not on the main chain)")
+	}
+	block, err := str.GetBlockFromHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := str.GetBlockTransactions(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	spent, err := str.GetSpentOutPoints(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	medianTime, err := medianTimePast(str, header.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := EncodeBlockStats(block, transactions, spent, medianTime, str.Params())
+	if len(selected) == 0 {
+		return stats, nil
+	}
+	result := make(map[string]interface{}, len(selected))
+	for _, name := range selected {
+		value, ok := stats[name]
+		if !ok {
+			return nil, NewError(ErrRPCInvalidParameter, "Invalid selected statistic '%s'", name)
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+// medianTimeBlocks is the number of blocks the median time past is taken
+// over.
+const medianTimeBlocks = 11
+
+// medianTimePast returns the median timestamp of the block at the given
+// height and the 10 blocks before it, as validation computes it.
+func medianTimePast(str Storage, height int32) (int64, error) {
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for h := height; h >= 0 && len(timestamps) < medianTimeBlocks; h-- {
+		header, err := str.GetHeaderFromHeight(h)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Header.Timestamp.Unix())
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// perUTXOOverhead is the size bitcoind adds to the serialized size of an
+// output for the outpoint, height and coinbase flag of an unspent output.
+const perUTXOOverhead = 41
+
+// truncatedMedian returns the median of the values, the mean of the two
+// middle ones rounded down for an even number of values.
+func truncatedMedian(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// percentilesByWeight returns the fee rates at the 10th, 25th, 50th, 75th
+// and 90th percentiles of the weight of the transactions.
+func percentilesByWeight(feeRates, weights []int64) [5]int64 {
+	result := [5]int64{}
+	if len(feeRates) == 0 {
+		return result
+	}
+	order := make([]int, len(feeRates))
+	totalWeight := int64(0)
+	for i := range order {
+		order[i] = i
+		totalWeight += weights[i]
+	}
+	sort.SliceStable(order, func(i, j int) bool { return feeRates[order[i]] < feeRates[order[j]] })
+
+	thresholds := [5]float64{0.10, 0.25, 0.50, 0.75, 0.90}
+	next := 0
+	cumulative := int64(0)
+	for _, i := range order {
+		cumulative += weights[i]
+		for next < len(thresholds) && float64(cumulative) >= float64(totalWeight)*thresholds[next] {
+			result[next] = feeRates[i]
+			next++
+		}
+	}
+	for ; next < len(thresholds); next++ {
+		result[next] = feeRates[order[len(order)-1]]
+	}
+	return result
+}
+
+// getchaintxstats
+type getChainTxStats struct {
+}
+
+func GetChainTxStats() Command {
+	return &getChainTxStats{}
+}
+
+func (g *getChainTxStats) Name() string {
+	return "getchaintxstats"
+}
+
+// chainTxStatsWindow is the default window of getchaintxstats.
+const chainTxStatsWindow = 30 * 24 * time.Hour
+
+func (g *getChainTxStats) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) > 2 {
+		return nil, invalidParamsCount(len(params), "0 to 2")
+	}
+
+	var (
+		blockHash string
+		err       error
+	)
+	if len(params) == 2 && params[1] != nil {
+		var ok bool
+		blockHash, ok = params[1].(string)
+		if !ok {
+			return nil, invalidParamType(params[1], "string")
+		}
+	} else if blockHash, err = str.GetLatestBlockHash(); err != nil {
+		return nil, err
+	}
+
+	header, err := str.GetHeaderFromHash(blockHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, NewError(ErrRPCInvalidAddressOrKey, "Block not found")
+		}
+		return nil, err
+	}
+	if mainHash, err := str.GetBlockHash(header.Height); err != nil || mainHash != blockHash {
+		return nil, NewError(ErrRPCInvalidParameter, "Block is not in main chain")
+	}
+
+	blockCount := int32(chainTxStatsWindow / str.Params().TargetTimePerBlock)
+	if len(params) > 0 && params[0] != nil {
+		count, ok := params[0].(float64)
+		if !ok {
+			return nil, invalidParamType(params[0], "number")
+		}
+		blockCount = int32(count)
+		if blockCount < 0 || (blockCount > 0 && blockCount >= header.Height) {
+			return nil, NewError(ErrRPCInvalidParameter, "Invalid block count: should be between 0 and the block's height - 1")
+		}
+	} else if blockCount > header.Height-1 {
+		blockCount = header.Height - 1
+	}
+	if blockCount < 0 {
+		blockCount = 0
+	}
+
+	txCount, err := str.GetChainTxCount(header.Height)
+	if err != nil {
+		return nil, err
+	}
+	stats := ChainTxStats{
+		Time:                   header.Header.Timestamp.Unix(),
+		TxCount:                txCount,
+		WindowFinalBlockHash:   blockHash,
+		WindowFinalBlockHeight: header.Height,
+		WindowBlockCount:       blockCount,
+	}
+	if blockCount == 0 {
+		return stats, nil
+	}
+
+	pastTxCount, err := str.GetChainTxCount(header.Height - blockCount)
+	if err != nil {
+		return nil, err
+	}
+	finalMedianTime, err := medianTimePast(str, header.Height)
+	if err != nil {
+		return nil, err
+	}
+	pastMedianTime, err := medianTimePast(str, header.Height-blockCount)
+	if err != nil {
+		return nil, err
+	}
+	windowTxCount := txCount - pastTxCount
+	windowInterval := finalMedianTime - pastMedianTime
+	stats.WindowTxCount = &windowTxCount
+	stats.WindowInterval = &windowInterval
+	if windowInterval > 0 {
+		txRate := float64(windowTxCount) / float64(windowInterval)
+		stats.TxRate = &txRate
+	}
+	return stats, nil
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// chainStorage serves a main chain of blocks with the given timestamps,
+// each block hashed as its height and holding two transactions.
+type chainStorage struct {
+	Storage
+	timestamps []int64
+}
+
+func (s *chainStorage) Params() *chaincfg.Params {
+	return &chaincfg.RegressionNetParams
+}
+
+func (s *chainStorage) GetLatestBlockHash() (string, error) {
+	return strconv.Itoa(len(s.timestamps) - 1), nil
+}
+
+func (s *chainStorage) GetBlockHash(height int32) (string, error) {
+	return strconv.Itoa(int(height)), nil
+}
+
+func (s *chainStorage) GetHeaderFromHash(hash string) (BlockHeader, error) {
+	height, err := strconv.Atoi(hash)
+	if err != nil {
+		return BlockHeader{}, ErrNotFound
+	}
+	return s.GetHeaderFromHeight(int32(height))
+}
+
+func (s *chainStorage) GetHeaderFromHeight(height int32) (BlockHeader, error) {
+	if height < 0 || int(height) >= len(s.timestamps) {
+		return BlockHeader{}, ErrNotFound
+	}
+	header := &wire.BlockHeader{Timestamp: time.Unix(s.timestamps[height], 0)}
+	return BlockHeader{Header: header, Height: height}, nil
+}
+
+func (s *chainStorage) GetChainTxCount(height int32) (int64, error) {
+	return 2*int64(height) + 1, nil
+}
+
+func TestMedianTimePast(t *testing.T) {
+	// Block 14 is far ahead of the others
+	str := &chainStorage{}
+	for h := int64(0); h <= 20; h++ {
+		str.timestamps = append(str.timestamps, 600*h)
+	}
+	str.timestamps[14] = 100000
+
+	tests := []struct {
+		height int32
+		want   int64
+	}{
+		{0, 0},
+		{1, 600},
+		{2, 600},
+		{10, 3000},
+		{14, 5400},
+		{20, 9600},
+	}
+	for _, test := range tests {
+		if got, err := medianTimePast(str, test.height); err != nil || got != test.want {
+			t.Fatalf("height %d: got %d (%v), want %d", test.height, got, err, test.want)
+		}
+	}
+
+	// The window interval is the difference of the median times past
+	result, err := GetChainTxStats().Query(str, []interface{}{float64(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := result.(ChainTxStats)
+	if stats.TxCount != 41 || stats.WindowTxCount == nil || *stats.WindowTxCount != 20 || stats.WindowInterval == nil || *stats.WindowInterval != 9600-3000 {
+		t.Fatalf("got %+v, want 20 transactions in an interval of %d", stats, 9600-3000)
+	}
+}
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"errors"
+
+	"github.com/catalogfi/indexer/model"
+)
+
+// TxOut is an unspent output, BlockHash is empty if it is unconfirmed.
+type TxOut struct {
+	OutPoint  model.OutPoint
+	BlockHash string
+	Height    int32
+	Coinbase  bool
+}
+
+// TxOutSetInfo summarizes the unspent outputs of the main chain.
+type TxOutSetInfo struct {
+	Height       int32
+	BestBlock    string
+	Transactions int64
+	TxOuts       int64
+	BogoSize     int64
+	TotalAmount  int64
+	// Hash is the hash_serialized_3 of the set, empty unless it was asked
+	// for.
+	Hash string
+}
+
+// gettxout
+type getTxOut struct {
+}
+
+func GetTxOut() Command {
+	return &getTxOut{}
+}
+
+func (g *getTxOut) Name() string {
+	return "gettxout"
+}
+
+func (g *getTxOut) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) < 2 || len(params) > 3 {
+		return nil, invalidParamsCount(len(params), "2 or 3")
+	}
+
+	txHash, ok := params[0].(string)
+	if !ok {
+		return nil, invalidParamType(params[0], "string")
+	}
+	index, ok := params[1].(float64)
+	if !ok {
+		return nil, invalidParamType(params[1], "number")
+	}
+	includeMempool := true
+	if len(params) == 3 {
+		includeMempool, ok = params[2].(bool)
+		if !ok {
+			return nil, invalidParamType(params[2], "boolean")
+		}
+	}
+
+	txOut, err := str.GetTxOut(txHash, uint32(index), includeMempool)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Spent and unknown outputs are null
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	bestBlock, err := str.GetLatestBlockHash()
+	if err != nil {
+		return nil, err
+	}
+	confirmations := uint32(0)
+	if txOut.BlockHash != "" {
+		tip, err := str.GetLatestBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		confirmations = uint32(tip-txOut.Height) + 1
+	}
+	return EncodeTxOut(txOut, bestBlock, confirmations, str.Params()), nil
+}
+
+// gettxoutsetinfo
+type getTxOutSetInfo struct {
+}
+
+func GetTxOutSetInfo() Command {
+	return &getTxOutSetInfo{}
+}
+
+func (g *getTxOutSetInfo) Name() string {
+	return "gettxoutsetinfo"
+}
+
+// Hash types of gettxoutsetinfo. muhash needs bitcoind's coinstats index
+// and is not supported.
+const (
+	hashTypeSerialized = "hash_serialized_3"
+	hashTypeMuHash     = "muhash"
+	hashTypeNone       = "none"
+)
+
+func (g *getTxOutSetInfo) Query(str Storage, params []interface{}) (interface{}, error) {
+	if len(params) > 1 {
+		return nil, invalidParamsCount(len(params), "0 or 1")
+	}
+
+	hashType := hashTypeSerialized
+	if len(params) == 1 {
+		var ok bool
+		hashType, ok = params[0].(string)
+		if !ok {
+			return nil, invalidParamType(params[0], "string")
+		}
+	}
+	switch hashType {
+	case hashTypeSerialized, hashTypeNone:
+	case hashTypeMuHash:
+		return nil, NewError(ErrRPCInvalidParameter, "hash_type %s is not supported", hashType)
+	default:
+		return nil, NewError(ErrRPCInvalidParameter, "'%s' is not a valid hash_type", hashType)
+	}
+
+	info, err := str.GetTxOutSetInfo(hashType == hashTypeSerialized)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeTxOutSetInfo(info), nil
+}
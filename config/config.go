@@ -0,0 +1,244 @@
+// Package config loads the configuration shared by the indexer's commands.
+// Every setting has a name, a key path in the YAML config file such as
+// rpc.tls.cert, which is also the name of its command line flag, and most
+// have an environment variable. Flags override environment variables, which
+// override the config file, which overrides the defaults of the command.
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/notify"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Database drivers.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverLevelDB  = "leveldb"
+)
+
+// Log levels.
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+type Config struct {
+	// Chain and Network select the chain to index, see chain.Lookup.
+	Chain   string `yaml:"chain"`
+	Network string `yaml:"network"`
+	// LogLevel sets the level of the SQL logs, and runs the HTTP server in
+	// debug mode at the debug level.
+	LogLevel string `yaml:"log-level"`
+
+	DB       DB               `yaml:"db"`
+	Peer     Peer             `yaml:"peer"`
+	ZMQ      notify.ZMQConfig `yaml:"zmq"`
+	RPC      RPC              `yaml:"rpc"`
+	Electrum Electrum         `yaml:"electrum"`
+
+	args []string
+}
+
+type DB struct {
+	// Driver is postgres or sqlite for the SQL storage, or leveldb for the
+	// standalone command.
+	Driver string `yaml:"driver"`
+	// DSN is the postgres connection string, or the path of the sqlite or
+	// LevelDB database.
+	DSN string `yaml:"dsn"`
+}
+
+type Peer struct {
+	// URLs are the nodes to keep connected to.
+	URLs []string `yaml:"urls"`
+	// TargetOutbound is the number of discovered nodes to connect to. A
+	// negative value stands for 8 without configured nodes and none with
+	// them, and is resolved when the configuration is loaded.
+	TargetOutbound int `yaml:"target-outbound"`
+	// DataDir is where the address book and ban list are saved.
+	DataDir string `yaml:"data-dir"`
+}
+
+type RPC struct {
+	Addr string `yaml:"addr"`
+	TLS  TLS    `yaml:"tls"`
+	// User and Password enable HTTP basic authentication of the JSON-RPC
+	// and Esplora requests.
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// Methods are the enabled JSON-RPC methods, all of them when empty. The
+	// Esplora API has to be disabled to restrict them.
+	Methods []string `yaml:"methods"`
+	// Esplora enables the Esplora REST API under /api.
+	Esplora bool `yaml:"esplora"`
+}
+
+type Electrum struct {
+	Addr string `yaml:"addr"`
+	TLS  TLS    `yaml:"tls"`
+	// PollInterval is how often the storage is polled for the
+	// notifications of subscriptions.
+	PollInterval time.Duration `yaml:"poll-interval"`
+}
+
+// TLS is a certificate and its key, both PEM encoded files. TLS is enabled
+// when they are set.
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// Default returns the defaults shared by the commands, which connect to the
+// postgres database at PSQL_URL.
+func Default() Config {
+	return Config{
+		Chain:    "bitcoin",
+		LogLevel: LogInfo,
+		DB: DB{
+			Driver: DriverPostgres,
+		},
+		Peer: Peer{
+			TargetOutbound: -1,
+		},
+		RPC: RPC{
+			Addr:    ":8080",
+			Esplora: true,
+		},
+		Electrum: Electrum{
+			Addr:         ":50001",
+			PollInterval: 5 * time.Second,
+		},
+	}
+}
+
+// Args returns the command line arguments left after the flags.
+func (c *Config) Args() []string {
+	return c.args
+}
+
+// Validate returns an error describing the first invalid setting.
+func (c *Config) Validate() error {
+	switch c.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		return fmt.Errorf("invalid log-level %q, must be debug, info, warn or error", c.LogLevel)
+	}
+	if _, err := chain.Lookup(c.Chain, c.Network); err != nil {
+		return err
+	}
+
+	switch c.DB.Driver {
+	case DriverPostgres:
+		// An empty connection string is completed from the PG* environment
+		// variables.
+	case DriverSQLite, DriverLevelDB:
+		if c.DB.DSN == "" {
+			return fmt.Errorf("db.dsn is required for the %s driver", c.DB.Driver)
+		}
+	default:
+		return fmt.Errorf("invalid db.driver %q, must be postgres, sqlite or leveldb", c.DB.Driver)
+	}
+
+	zmq := c.ZMQ
+	zmq.HighWaterMark = 0
+	if zmq != (notify.ZMQConfig{}) && !notify.ZMQSupported {
+		return errors.New("zmq endpoints are set but the command was built without zmq support, rebuild it with -tags zmq")
+	}
+
+	if c.Peer.TargetOutbound < 0 {
+		return errors.New("peer.target-outbound must not be negative")
+	}
+
+	if c.RPC.Addr == "" {
+		return errors.New("rpc.addr is required")
+	}
+	if err := c.RPC.TLS.validate("rpc.tls"); err != nil {
+		return err
+	}
+	if (c.RPC.User == "") != (c.RPC.Password == "") {
+		return errors.New("rpc.user and rpc.password must be set together")
+	}
+	if len(c.RPC.Methods) > 0 && c.RPC.Esplora {
+		// The Esplora API serves the data of every method
+		return errors.New("rpc.methods restricts the JSON-RPC methods only, rpc.esplora must be disabled with it")
+	}
+
+	if c.Electrum.Addr == "" {
+		return errors.New("electrum.addr is required")
+	}
+	if err := c.Electrum.TLS.validate("electrum.tls"); err != nil {
+		return err
+	}
+	if c.Electrum.PollInterval <= 0 {
+		return errors.New("electrum.poll-interval must be positive")
+	}
+	return nil
+}
+
+func (t TLS) validate(name string) error {
+	if (t.Cert == "") != (t.Key == "") {
+		return fmt.Errorf("%s.cert and %s.key must be set together", name, name)
+	}
+	return nil
+}
+
+// Enabled reports whether a certificate is configured.
+func (t TLS) Enabled() bool {
+	return t.Cert != ""
+}
+
+// Config loads the certificate into a server TLS configuration.
+func (t TLS) Config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Dialector returns the dialector of the SQL database.
+func (d DB) Dialector() (gorm.Dialector, error) {
+	switch d.Driver {
+	case DriverPostgres:
+		return postgres.Open(d.DSN), nil
+	case DriverSQLite:
+		return sqlite.Open(d.DSN), nil
+	default:
+		return nil, fmt.Errorf("db.driver %s is not a SQL database", d.Driver)
+	}
+}
+
+// GormConfig returns the gorm configuration, logging every SQL statement at
+// the debug level, slow statements and errors at the info and warn levels, and
+// only errors at the error level.
+func (c *Config) GormConfig() *gorm.Config {
+	level := logger.Warn
+	switch c.LogLevel {
+	case LogDebug:
+		level = logger.Info
+	case LogError:
+		level = logger.Error
+	}
+	return &gorm.Config{Logger: logger.Default.LogMode(level)}
+}
+
+// GinMode returns the mode of the HTTP server, debug at the debug level.
+func (c *Config) GinMode() string {
+	if c.LogLevel == LogDebug {
+		return gin.DebugMode
+	}
+	return gin.ReleaseMode
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// option is a setting that can be set by a flag and an environment
+// variable.
+type option struct {
+	name  string
+	env   string
+	usage string
+	value interface{}
+}
+
+func (c *Config) options() []option {
+	return []option{
+		{"chain", "CHAIN", "chain to index, bitcoin, litecoin or dogecoin", &c.Chain},
+		{"network", "NETWORK", "network of the chain, mainnet, testnet or regtest", &c.Network},
+		{"log-level", "LOG_LEVEL", "log level, debug, info, warn or error", &c.LogLevel},
+
+		{"db.driver", "DB_DRIVER", "database driver, postgres, sqlite or leveldb", &c.DB.Driver},
+		{"db.dsn", "DB_DSN", "postgres connection string, or path of the sqlite or LevelDB database", &c.DB.DSN},
+
+		{"peer.urls", "PEER_URL", "comma separated nodes to connect to", &c.Peer.URLs},
+		{"peer.target-outbound", "PEER_TARGET_OUTBOUND", "number of discovered nodes to connect to, 8 without peer.urls and 0 with them when negative", &c.Peer.TargetOutbound},
+		{"peer.data-dir", "PEER_DATA_DIR", "directory of the address book and ban list", &c.Peer.DataDir},
+
+		{"zmq.hashblock", "ZMQ_PUB_HASHBLOCK", "ZMQ endpoint of the hashblock notifications", &c.ZMQ.HashBlock},
+		{"zmq.hashtx", "ZMQ_PUB_HASHTX", "ZMQ endpoint of the hashtx notifications", &c.ZMQ.HashTx},
+		{"zmq.rawblock", "ZMQ_PUB_RAWBLOCK", "ZMQ endpoint of the rawblock notifications", &c.ZMQ.RawBlock},
+		{"zmq.rawtx", "ZMQ_PUB_RAWTX", "ZMQ endpoint of the rawtx notifications", &c.ZMQ.RawTx},
+		{"zmq.sequence", "ZMQ_PUB_SEQUENCE", "ZMQ endpoint of the sequence notifications", &c.ZMQ.Sequence},
+		{"zmq.high-water-mark", "ZMQ_HIGH_WATER_MARK", "send high water mark of the ZMQ sockets, 1000 when 0", &c.ZMQ.HighWaterMark},
+
+		{"rpc.addr", "RPC_ADDR", "listen address of the RPC and Esplora server", &c.RPC.Addr},
+		{"rpc.tls.cert", "RPC_TLS_CERT", "TLS certificate file of the RPC and Esplora server", &c.RPC.TLS.Cert},
+		{"rpc.tls.key", "RPC_TLS_KEY", "TLS key file of the RPC and Esplora server", &c.RPC.TLS.Key},
+		{"rpc.user", "RPC_USER", "user of the JSON-RPC and Esplora basic authentication", &c.RPC.User},
+		{"rpc.password", "RPC_PASSWORD", "password of the JSON-RPC and Esplora basic authentication", &c.RPC.Password},
+		{"rpc.methods", "RPC_METHODS", "comma separated JSON-RPC methods to enable, all of them when empty, requires rpc.esplora=false", &c.RPC.Methods},
+		{"rpc.esplora", "RPC_ESPLORA", "serve the Esplora REST API under /api", &c.RPC.Esplora},
+
+		{"electrum.addr", "ELECTRUM_ADDR", "listen address of the Electrum server", &c.Electrum.Addr},
+		{"electrum.tls.cert", "ELECTRUM_TLS_CERT", "TLS certificate file of the Electrum server", &c.Electrum.TLS.Cert},
+		{"electrum.tls.key", "ELECTRUM_TLS_KEY", "TLS key file of the Electrum server", &c.Electrum.TLS.Key},
+		{"electrum.poll-interval", "ELECTRUM_POLL_INTERVAL", "interval of the polling for Electrum subscriptions", &c.Electrum.PollInterval},
+	}
+}
+
+// Load loads the configuration of a command from the config file given by
+// the -config flag or CONFIG_FILE, the environment and the command line
+// arguments, on top of the given defaults, and validates it. With
+// -print-config, it prints the configuration and exits.
+func Load(name string, defaults Config, args []string) (*Config, error) {
+	c := defaults
+	path := configPath(args)
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.String("config", path, "YAML config file ($CONFIG_FILE)")
+	printConfig := flags.Bool("print-config", false, "print the configuration and exit")
+	options := c.options()
+	for _, o := range options {
+		usage := fmt.Sprintf("%s ($%s)", o.usage, o.env)
+		switch v := o.value.(type) {
+		case *string:
+			flags.StringVar(v, o.name, *v, usage)
+		case *int:
+			flags.IntVar(v, o.name, *v, usage)
+		case *bool:
+			flags.BoolVar(v, o.name, *v, usage)
+		case *time.Duration:
+			flags.DurationVar(v, o.name, *v, usage)
+		case *[]string:
+			flags.Var((*listValue)(v), o.name, usage)
+		}
+	}
+	for _, o := range options {
+		if env := os.Getenv(o.env); env != "" {
+			if err := flags.Lookup(o.name).Value.Set(env); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", o.env, env, err)
+			}
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	c.args = flags.Args()
+
+	// The connection strings had their own variables before the
+	// configuration was unified. They apply to the driver in the end, and
+	// give way to DB_DSN and -db.dsn.
+	dsnSet := os.Getenv("DB_DSN") != ""
+	flags.Visit(func(f *flag.Flag) {
+		dsnSet = dsnSet || f.Name == "db.dsn"
+	})
+	if !dsnSet {
+		c.DB.DSN = legacyDSN(c.DB.Driver, c.DB.DSN)
+	}
+
+	if c.Peer.TargetOutbound < 0 {
+		c.Peer.TargetOutbound = 0
+		if len(c.Peer.URLs) == 0 {
+			c.Peer.TargetOutbound = 8
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if *printConfig {
+		if err := c.Print(os.Stdout); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+	return &c, nil
+}
+
+// legacyDSN returns the connection string of the variable the driver had
+// before the configuration was unified, if it is set, and dsn otherwise.
+func legacyDSN(driver, dsn string) string {
+	env := ""
+	switch driver {
+	case DriverPostgres:
+		env = os.Getenv("PSQL_URL")
+	case DriverLevelDB:
+		env = os.Getenv("LEVELDB_PATH")
+	}
+	if env != "" {
+		return env
+	}
+	return dsn
+}
+
+// configPath returns the value of the -config flag, which has to be known
+// before the other flags are parsed, or of CONFIG_FILE.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		switch {
+		case arg == "-config" || arg == "--config":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case strings.HasPrefix(arg, "-config="):
+			return strings.TrimPrefix(arg, "-config=")
+		case strings.HasPrefix(arg, "--config="):
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Print writes the configuration in the format of the config file, with
+// the passwords redacted.
+func (c *Config) Print(w io.Writer) error {
+	redacted := *c
+	redacted.DB.DSN = redactDSN(c.DB.DSN)
+	if redacted.RPC.Password != "" {
+		redacted.RPC.Password = "xxxxx"
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&redacted); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+var passwordPattern = regexp.MustCompile(`(password=)\S*`)
+
+// redactDSN redacts the password of a postgres URL or key/value connection
+// string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return passwordPattern.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// listValue is a comma separated list flag.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package electrum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/catalogfi/indexer/command"
+)
+
+const (
+	serverVersion   = "indexer 1.0.0"
+	protocolVersion = "1.4"
+
+	// maxLineSize bounds the size of a single request line.
+	maxLineSize = 1 << 20
+)
+
+// Electrum error codes
+const (
+	ErrBadRequest     = 1
+	ErrDaemon         = 2
+	ErrMethodNotFound = -32601
+	ErrInvalidParams  = -32602
+	ErrParse          = -32700
+)
+
+type Storage interface {
+	command.Storage
+	Params() *chaincfg.Params
+	GetScriptHashOutputs(scriptHash string) ([]Output, error)
+	GetBlockTxIDs(blockHash string) ([]string, error)
+}
+
+// Output is an output paying to a script hash together with the
+// transaction that spends it, heights are 0 for unconfirmed transactions.
+type Output struct {
+	TxHash     string
+	Index      uint32
+	Value      int64
+	Height     int32
+	BlockIndex uint32
+
+	SpendingTxHash     string
+	SpendingHeight     int32
+	SpendingBlockIndex uint32
+}
+
+type Request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type Response struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Result  interface{} `json:"result"`
+	Error   *Error      `json:"error,omitempty"`
+	ID      interface{} `json:"id"`
+}
+
+type Notification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code int, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+type Server struct {
+	storage      Storage
+	pollInterval time.Duration
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+}
+
+// NewServer returns an Electrum protocol server, subscriptions are served by
+// polling the storage every pollInterval.
+func NewServer(storage Storage, pollInterval time.Duration) *Server {
+	return &Server{
+		storage:      storage,
+		pollInterval: pollInterval,
+		sessions:     make(map[*session]struct{}),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// ListenAndServeTLS serves the Electrum protocol over TLS.
+func (s *Server) ListenAndServeTLS(addr string, config *tls.Config) error {
+	listener, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	go s.poll()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		sess := newSession(s, conn)
+		s.mu.Lock()
+		s.sessions[sess] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			sess.serve()
+			s.mu.Lock()
+			delete(s.sessions, sess)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// poll notifies subscribed sessions about new headers and script hash status
+// changes.
+func (s *Server) poll() {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		sessions := make([]*session, 0, len(s.sessions))
+		for sess := range s.sessions {
+			sessions = append(sessions, sess)
+		}
+		s.mu.Unlock()
+
+		tip, err := s.tipHeader()
+		if err != nil {
+			fmt.Printf("error getting the tip header: %v\n", err)
+			continue
+		}
+		for _, sess := range sessions {
+			sess.notifyHeader(tip)
+			sess.notifyScriptHashes()
+		}
+	}
+}
+
+type session struct {
+	server *Server
+	conn   net.Conn
+
+	writeMu sync.Mutex
+
+	mu                sync.Mutex
+	headersSubscribed bool
+	lastTip           string
+	scriptHashes      map[string]string
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server:       server,
+		conn:         conn,
+		scriptHashes: make(map[string]string),
+	}
+}
+
+func (s *session) serve() {
+	defer s.conn.Close()
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] == '[' {
+			batch := []json.RawMessage{}
+			if err := json.Unmarshal(line, &batch); err != nil {
+				s.write(Response{JSONRPC: "2.0", Error: newError(ErrParse, "parse error")})
+				continue
+			}
+			resps := make([]Response, len(batch))
+			for i, raw := range batch {
+				resps[i] = s.handle(raw)
+			}
+			s.write(resps)
+			continue
+		}
+		s.write(s.handle(line))
+	}
+}
+
+func (s *session) handle(raw []byte) Response {
+	req := Request{}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return Response{JSONRPC: "2.0", Error: newError(ErrBadRequest, "invalid request: %v", err)}
+	}
+
+	handler, ok := handlers[req.Method]
+	if !ok {
+		return Response{JSONRPC: "2.0", Error: newError(ErrMethodNotFound, "unknown method %q", req.Method), ID: req.ID}
+	}
+
+	result, err := handler(s, req.Params)
+	if err != nil {
+		if electrumErr, ok := err.(*Error); ok {
+			return Response{JSONRPC: "2.0", Error: electrumErr, ID: req.ID}
+		}
+		return Response{JSONRPC: "2.0", Error: newError(ErrDaemon, err.Error()), ID: req.ID}
+	}
+	return Response{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+func (s *session) write(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Printf("error encoding electrum message: %v\n", err)
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := s.conn.Write(append(data, '\n')); err != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *session) notifyHeader(tip HeaderNotification) {
+	s.mu.Lock()
+	if !s.headersSubscribed || s.lastTip == tip.Hex {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTip = tip.Hex
+	s.mu.Unlock()
+
+	s.write(Notification{JSONRPC: "2.0", Method: "blockchain.headers.subscribe", Params: []interface{}{tip}})
+}
+
+func (s *session) notifyScriptHashes() {
+	s.mu.Lock()
+	scriptHashes := make(map[string]string, len(s.scriptHashes))
+	for scriptHash, status := range s.scriptHashes {
+		scriptHashes[scriptHash] = status
+	}
+	s.mu.Unlock()
+
+	for scriptHash, status := range scriptHashes {
+		newStatus, err := s.server.scriptHashStatus(scriptHash)
+		if err != nil {
+			fmt.Printf("error getting the status of %s: %v\n", scriptHash, err)
+			continue
+		}
+		if newStatus == status {
+			continue
+		}
+
+		s.mu.Lock()
+		s.scriptHashes[scriptHash] = newStatus
+		s.mu.Unlock()
+
+		var result interface{}
+		if newStatus != "" {
+			result = newStatus
+		}
+		s.write(Notification{JSONRPC: "2.0", Method: "blockchain.scripthash.subscribe", Params: []interface{}{scriptHash, result}})
+	}
+}
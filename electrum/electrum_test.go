@@ -0,0 +1,325 @@
+package electrum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+)
+
+// fakeStorage serves the outputs of script hashes, the unconfirmed parents
+// of transactions and a chain of headers.
+type fakeStorage struct {
+	command.Storage
+
+	mu      sync.Mutex
+	outputs map[string][]Output
+	depends map[string][]string
+	headers []wire.BlockHeader
+	fees    []command.FeeStats
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		outputs: map[string][]Output{},
+		depends: map[string][]string{},
+		headers: []wire.BlockHeader{chaincfg.RegressionNetParams.GenesisBlock.Header},
+	}
+}
+
+func (s *fakeStorage) Params() *chaincfg.Params {
+	return &chaincfg.RegressionNetParams
+}
+
+func (s *fakeStorage) GetScriptHashOutputs(scriptHash string) ([]Output, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Output{}, s.outputs[scriptHash]...), nil
+}
+
+func (s *fakeStorage) GetBlockTxIDs(blockHash string) ([]string, error) {
+	return nil, command.ErrNotFound
+}
+
+// GetMempoolEntries returns an entry for every transaction, whose parents
+// are the ones in depends.
+func (s *fakeStorage) GetMempoolEntries(hashes []string) ([]command.MempoolEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := []command.MempoolEntry{}
+	for _, hash := range hashes {
+		entries = append(entries, command.MempoolEntry{Hash: hash, Depends: s.depends[hash]})
+	}
+	return entries, nil
+}
+
+func (s *fakeStorage) GetLatestBlockHeight() (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int32(len(s.headers) - 1), nil
+}
+
+func (s *fakeStorage) GetHeaderFromHeight(height int32) (command.BlockHeader, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height < 0 || int(height) >= len(s.headers) {
+		return command.BlockHeader{}, command.ErrNotFound
+	}
+	header := s.headers[height]
+	return command.BlockHeader{Header: &header, Height: height}, nil
+}
+
+func (s *fakeStorage) GetFeeStats(target, blocks int32) ([]command.FeeStats, error) {
+	return s.fees, nil
+}
+
+func (s *fakeStorage) setOutputs(scriptHash string, outputs ...Output) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputs[scriptHash] = outputs
+}
+
+func (s *fakeStorage) addHeader() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	header := s.headers[len(s.headers)-1]
+	header.PrevBlock = header.BlockHash()
+	s.headers = append(s.headers, header)
+}
+
+func txid(i byte) string {
+	return chainhash.Hash{i}.String()
+}
+
+var scriptHash = strings.Repeat("ab", sha256.Size)
+
+func TestHistoryAndStatus(t *testing.T) {
+	str := newFakeStorage()
+	// The unconfirmed transaction 5 spends an output of 6, while 9 only
+	// spends confirmed outputs
+	str.setOutputs(scriptHash,
+		Output{TxHash: txid(1), Value: 1, Height: 3, BlockIndex: 1, SpendingTxHash: txid(2), SpendingHeight: 5, SpendingBlockIndex: 2},
+		Output{TxHash: txid(3), Value: 2, Height: 5, BlockIndex: 1, SpendingTxHash: txid(9)},
+		Output{TxHash: txid(5), Value: 3},
+		Output{TxHash: txid(9), Index: 1, Value: 4},
+	)
+	str.depends[txid(5)] = []string{txid(6)}
+	server := NewServer(str, time.Hour)
+
+	items, err := server.scriptHashHistory(scriptHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HistoryItem{{txid(1), 3, 1}, {txid(3), 5, 1}, {txid(2), 5, 2}, {txid(9), 0, 0}, {txid(5), -1, 0}}
+	if len(items) != len(want) {
+		t.Fatalf("got history %v, want %v", items, want)
+	}
+	for i := range want {
+		if items[i].TxHash != want[i].TxHash || items[i].Height != want[i].Height {
+			t.Fatalf("got history %v, want %v", items, want)
+		}
+	}
+
+	preimage := txid(1) + ":3:" + txid(3) + ":5:" + txid(2) + ":5:" + txid(9) + ":0:" + txid(5) + ":-1:"
+	hash := sha256.Sum256([]byte(preimage))
+	if got, want := status(items), hex.EncodeToString(hash[:]); got != want {
+		t.Fatalf("got status %s, want %s", got, want)
+	}
+	if got := status(nil); got != "" {
+		t.Fatalf("got status %q for no history, want it empty", got)
+	}
+
+	balance, err := (&session{server: server}).scriptHashGetBalance([]interface{}{scriptHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != (Balance{Confirmed: 2, Unconfirmed: 5}) {
+		t.Fatalf("got balance %+v, want 2 confirmed and 5 unconfirmed", balance)
+	}
+}
+
+// client is the other end of a session.
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newClient(t *testing.T, server *Server) (*client, *session) {
+	conn, serverConn := net.Pipe()
+	sess := newSession(server, serverConn)
+	go sess.serve()
+	t.Cleanup(func() { conn.Close() })
+	return &client{conn: conn, reader: bufio.NewReader(conn)}, sess
+}
+
+// call sends a request and returns its result.
+func (c *client) call(t *testing.T, method string, params ...interface{}) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(Request{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	resp := struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}{}
+	if err := json.Unmarshal(c.read(t), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil {
+		t.Fatalf("%s: %v", method, resp.Error)
+	}
+	return resp.Result
+}
+
+// read returns the next message, or nil if there is none for a while.
+func (c *client) read(t *testing.T) []byte {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	defer c.conn.SetReadDeadline(time.Time{})
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil
+	}
+	return line
+}
+
+// notify runs fn, which notifies the session, and returns the notification
+// it sent if any.
+func (c *client) notify(t *testing.T, fn func()) []byte {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	line := c.read(t)
+	<-done
+	return line
+}
+
+func TestSubscriptions(t *testing.T) {
+	str := newFakeStorage()
+	server := NewServer(str, time.Hour)
+	c, sess := newClient(t, server)
+
+	// A script hash without history has no status
+	if result := c.call(t, "blockchain.scripthash.subscribe", scriptHash); string(result) != "null" {
+		t.Fatalf("got status %s for no history, want null", result)
+	}
+	tip, err := server.tipHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := c.call(t, "blockchain.headers.subscribe")
+	header := HeaderNotification{}
+	if err := json.Unmarshal(result, &header); err != nil || header != tip {
+		t.Fatalf("got tip %s (%v), want %+v", result, err, tip)
+	}
+
+	notifyScriptHashes := func() []byte { return c.notify(t, sess.notifyScriptHashes) }
+	notifyHeader := func() []byte {
+		return c.notify(t, func() {
+			tip, err := server.tipHeader()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sess.notifyHeader(tip)
+		})
+	}
+
+	// Nothing is sent until the history or the tip changes
+	if msg := notifyScriptHashes(); msg != nil {
+		t.Fatalf("got %s without a change", msg)
+	}
+	if msg := notifyHeader(); msg != nil {
+		t.Fatalf("got %s without a new tip", msg)
+	}
+
+	str.setOutputs(scriptHash, Output{TxHash: txid(1), Value: 1})
+	wantStatus := status([]HistoryItem{{TxHash: txid(1)}})
+	msg := notifyScriptHashes()
+	notification := Notification{}
+	if err := json.Unmarshal(msg, &notification); err != nil {
+		t.Fatalf("got %s: %v", msg, err)
+	}
+	if notification.Method != "blockchain.scripthash.subscribe" || len(notification.Params) != 2 || notification.Params[0] != scriptHash || notification.Params[1] != wantStatus {
+		t.Fatalf("got notification %s, want status %s", msg, wantStatus)
+	}
+	if msg := notifyScriptHashes(); msg != nil {
+		t.Fatalf("got %s for the same status", msg)
+	}
+
+	str.addHeader()
+	msg = notifyHeader()
+	if err := json.Unmarshal(msg, &notification); err != nil {
+		t.Fatalf("got %s: %v", msg, err)
+	}
+	if notification.Method != "blockchain.headers.subscribe" || !bytes.Contains(msg, []byte(`"height":1`)) {
+		t.Fatalf("got notification %s, want the header at height 1", msg)
+	}
+
+	// Once unsubscribed, changes are not sent any more
+	if result := c.call(t, "blockchain.scripthash.unsubscribe", scriptHash); string(result) != "true" {
+		t.Fatalf("got %s unsubscribing, want true", result)
+	}
+	if result := c.call(t, "blockchain.scripthash.unsubscribe", scriptHash); string(result) != "false" {
+		t.Fatalf("got %s unsubscribing twice, want false", result)
+	}
+	str.setOutputs(scriptHash)
+	if msg := notifyScriptHashes(); msg != nil {
+		t.Fatalf("got %s after unsubscribing", msg)
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	str := newFakeStorage()
+	sess := &session{server: NewServer(str, time.Hour)}
+	if result, err := sess.estimateFee([]interface{}{float64(6)}); err != nil || result != -1 {
+		t.Fatalf("got %v (%v) without fee data, want -1", result, err)
+	}
+
+	// Every transaction paying 10 sat/vB was confirmed in the last block
+	str.fees = []command.FeeStats{{Bucket: command.FeeRateBucket(10), Age: 0, Confirmed: 200, Total: 200, FeeRate: 200 * 10}}
+	tests := []struct {
+		params []interface{}
+		want   interface{}
+	}{
+		{[]interface{}{float64(1)}, 0.0001},
+		{[]interface{}{float64(6)}, 0.0001},
+		{[]interface{}{float64(6), "CONSERVATIVE"}, 0.0001},
+		{[]interface{}{float64(6), "economical"}, 0.0001},
+		{[]interface{}{float64(0)}, nil},
+		{[]interface{}{float64(command.MaxFeeTarget + 1)}, nil},
+		{[]interface{}{float64(6), "fast"}, nil},
+		{[]interface{}{"6"}, nil},
+		{[]interface{}{}, nil},
+	}
+	for _, test := range tests {
+		result, err := sess.estimateFee(test.params)
+		if test.want == nil {
+			if err == nil {
+				t.Fatalf("%v: got %v, want an error", test.params, result)
+			}
+			continue
+		}
+		if err != nil || result != test.want {
+			t.Fatalf("%v: got %v (%v), want %v", test.params, result, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package electrum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/merkle"
+)
+
+// maxHeaders is the maximum number of headers returned by
+// blockchain.block.headers.
+const maxHeaders = 2016
+
+type handler func(s *session, params []interface{}) (interface{}, error)
+
+var handlers = map[string]handler{
+	"server.version":                    (*session).serverVersion,
+	"server.ping":                       (*session).serverPing,
+	"server.banner":                     (*session).serverBanner,
+	"server.donation_address":           (*session).serverDonationAddress,
+	"server.features":                   (*session).serverFeatures,
+	"server.peers.subscribe":            (*session).serverPeersSubscribe,
+	"blockchain.headers.subscribe":      (*session).headersSubscribe,
+	"blockchain.block.header":           (*session).blockHeader,
+	"blockchain.block.headers":          (*session).blockHeaders,
+	"blockchain.estimatefee":            (*session).estimateFee,
+	"blockchain.relayfee":               (*session).relayFee,
+	"blockchain.scripthash.get_balance": (*session).scriptHashGetBalance,
+	"blockchain.scripthash.get_history": (*session).scriptHashGetHistory,
+	"blockchain.scripthash.listunspent": (*session).scriptHashListUnspent,
+	"blockchain.scripthash.subscribe":   (*session).scriptHashSubscribe,
+	"blockchain.scripthash.unsubscribe": (*session).scriptHashUnsubscribe,
+	"blockchain.transaction.get":        (*session).transactionGet,
+	"blockchain.transaction.get_merkle": (*session).transactionGetMerkle,
+	"mempool.get_fee_histogram":         (*session).mempoolGetFeeHistogram,
+}
+
+func paramString(params []interface{}, i int) (string, error) {
+	if len(params) <= i {
+		return "", newError(ErrInvalidParams, "missing parameter %d", i)
+	}
+	str, ok := params[i].(string)
+	if !ok {
+		return "", newError(ErrInvalidParams, "invalid parameter type: %T, required string", params[i])
+	}
+	return str, nil
+}
+
+func paramInt(params []interface{}, i int, def int) (int, error) {
+	if len(params) <= i {
+		return def, nil
+	}
+	num, ok := params[i].(float64)
+	if !ok {
+		return 0, newError(ErrInvalidParams, "invalid parameter type: %T, required number", params[i])
+	}
+	return int(num), nil
+}
+
+func paramBool(params []interface{}, i int, def bool) (bool, error) {
+	if len(params) <= i {
+		return def, nil
+	}
+	b, ok := params[i].(bool)
+	if !ok {
+		return false, newError(ErrInvalidParams, "invalid parameter type: %T, required boolean", params[i])
+	}
+	return b, nil
+}
+
+func paramScriptHash(params []interface{}, i int) (string, error) {
+	scriptHash, err := paramString(params, i)
+	if err != nil {
+		return "", err
+	}
+	if b, err := hex.DecodeString(scriptHash); err != nil || len(b) != sha256.Size {
+		return "", newError(ErrBadRequest, "%s is not a valid script hash", scriptHash)
+	}
+	return scriptHash, nil
+}
+
+// server
+func (s *session) serverVersion(params []interface{}) (interface{}, error) {
+	return []string{serverVersion, protocolVersion}, nil
+}
+
+func (s *session) serverPing(params []interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *session) serverBanner(params []interface{}) (interface{}, error) {
+	return fmt.Sprintf("Welcome to %s", serverVersion), nil
+}
+
+func (s *session) serverDonationAddress(params []interface{}) (interface{}, error) {
+	return "", nil
+}
+
+type Features struct {
+	GenesisHash   string                 `json:"genesis_hash"`
+	Hosts         map[string]interface{} `json:"hosts"`
+	ProtocolMax   string                 `json:"protocol_max"`
+	ProtocolMin   string                 `json:"protocol_min"`
+	Pruning       interface{}            `json:"pruning"`
+	ServerVersion string                 `json:"server_version"`
+	HashFunction  string                 `json:"hash_function"`
+}
+
+func (s *session) serverFeatures(params []interface{}) (interface{}, error) {
+	return Features{
+		GenesisHash:   s.server.storage.Params().GenesisHash.String(),
+		Hosts:         map[string]interface{}{},
+		ProtocolMax:   protocolVersion,
+		ProtocolMin:   protocolVersion,
+		Pruning:       nil,
+		ServerVersion: serverVersion,
+		HashFunction:  "sha256",
+	}, nil
+}
+
+func (s *session) serverPeersSubscribe(params []interface{}) (interface{}, error) {
+	return []interface{}{}, nil
+}
+
+// blockchain.headers
+type HeaderNotification struct {
+	Hex    string `json:"hex"`
+	Height int32  `json:"height"`
+}
+
+func (s *Server) headerHex(height int32) (string, error) {
+	header, err := s.storage.GetHeaderFromHeight(height)
+	if err != nil {
+		if errors.Is(err, command.ErrNotFound) {
+			return "", newError(ErrBadRequest, "height %d out of range", height)
+		}
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := header.Header.Serialize(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+func (s *Server) tipHeader() (HeaderNotification, error) {
+	height, err := s.storage.GetLatestBlockHeight()
+	if err != nil {
+		return HeaderNotification{}, err
+	}
+	headerHex, err := s.headerHex(height)
+	if err != nil {
+		return HeaderNotification{}, err
+	}
+	return HeaderNotification{
+		Hex:    headerHex,
+		Height: height,
+	}, nil
+}
+
+func (s *session) headersSubscribe(params []interface{}) (interface{}, error) {
+	tip, err := s.server.tipHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.headersSubscribed = true
+	s.lastTip = tip.Hex
+	s.mu.Unlock()
+	return tip, nil
+}
+
+func (s *session) blockHeader(params []interface{}) (interface{}, error) {
+	height, err := paramInt(params, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 {
+		return nil, newError(ErrBadRequest, "invalid height")
+	}
+	cpHeight, err := paramInt(params, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cpHeight != 0 {
+		return nil, newError(ErrBadRequest, "checkpoint proofs are not supported")
+	}
+	return s.server.headerHex(int32(height))
+}
+
+type Headers struct {
+	Count int    `json:"count"`
+	Hex   string `json:"hex"`
+	Max   int    `json:"max"`
+}
+
+func (s *session) blockHeaders(params []interface{}) (interface{}, error) {
+	start, err := paramInt(params, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	count, err := paramInt(params, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || count < 0 {
+		return nil, newError(ErrBadRequest, "invalid start height or count")
+	}
+	if count > maxHeaders {
+		count = maxHeaders
+	}
+
+	tip, err := s.server.storage.GetLatestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := ""
+	n := 0
+	for height := int32(start); n < count && height <= tip; height++ {
+		headerHex, err := s.server.headerHex(height)
+		if err != nil {
+			return nil, err
+		}
+		headers += headerHex
+		n++
+	}
+	return Headers{
+		Count: n,
+		Hex:   headers,
+		Max:   maxHeaders,
+	}, nil
+}
+
+// estimateFee estimates the fee rate in BTC/kB for a transaction to be
+// confirmed within the number of blocks like estimatesmartfee, or returns
+// -1 if there is not enough data.
+func (s *session) estimateFee(params []interface{}) (interface{}, error) {
+	target, err := paramInt(params, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if target < 1 || target > int(command.MaxFeeTarget) {
+		return nil, newError(ErrBadRequest, "number of blocks must be between 1 and %d", command.MaxFeeTarget)
+	}
+	conservative := false
+	if len(params) > 1 {
+		mode, err := paramString(params, 1)
+		if err != nil {
+			return nil, err
+		}
+		if conservative, err = command.ParseEstimateMode(mode); err != nil {
+			return nil, newError(ErrBadRequest, err.Error())
+		}
+	}
+
+	feeRate, blocks, ok, err := command.SmartFeeRate(s.server.storage, int32(target), conservative)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return -1, nil
+	}
+	return command.EncodeSmartFee(blocks, feeRate, nil).FeeRate, nil
+}
+
+func (s *session) relayFee(params []interface{}) (interface{}, error) {
+	return 0.00001, nil
+}
+
+// blockchain.scripthash
+type HistoryItem struct {
+	TxHash string `json:"tx_hash"`
+	Height int32  `json:"height"`
+
+	blockIndex uint32
+}
+
+type Balance struct {
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
+}
+
+type Unspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height int32  `json:"height"`
+	Value  int64  `json:"value"`
+}
+
+// history returns every transaction funding or spending the script hash,
+// confirmed transactions in block order followed by unconfirmed ones. As in
+// ElectrumX, unconfirmed transactions are at height -1 if they spend
+// unconfirmed outputs, which come after the ones at height 0.
+func history(outputs []Output, unconfirmedInputs map[string]bool) []HistoryItem {
+	seen := map[string]bool{}
+	items := []HistoryItem{}
+	add := func(txHash string, height int32, blockIndex uint32) {
+		if seen[txHash] {
+			return
+		}
+		seen[txHash] = true
+		if height == 0 && unconfirmedInputs[txHash] {
+			height = -1
+		}
+		items = append(items, HistoryItem{TxHash: txHash, Height: height, blockIndex: blockIndex})
+	}
+	for _, output := range outputs {
+		add(output.TxHash, output.Height, output.BlockIndex)
+		if output.SpendingTxHash != "" {
+			add(output.SpendingTxHash, output.SpendingHeight, output.SpendingBlockIndex)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if (items[i].Height > 0) != (items[j].Height > 0) {
+			return items[i].Height > 0
+		}
+		if items[i].Height != items[j].Height {
+			if items[i].Height > 0 {
+				return items[i].Height < items[j].Height
+			}
+			return items[i].Height > items[j].Height
+		}
+		if items[i].blockIndex != items[j].blockIndex {
+			return items[i].blockIndex < items[j].blockIndex
+		}
+		return items[i].TxHash < items[j].TxHash
+	})
+	return items
+}
+
+// status is the electrum status of a script hash history, the sha256 of
+// "tx_hash:height:" for every item, or empty if there is no history.
+func status(items []HistoryItem) string {
+	if len(items) == 0 {
+		return ""
+	}
+	buf := new(bytes.Buffer)
+	for _, item := range items {
+		fmt.Fprintf(buf, "%s:%d:", item.TxHash, item.Height)
+	}
+	hash := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(hash[:])
+}
+
+// scriptHashHistory returns the history of the script hash, looking up
+// which of its unconfirmed transactions depend on other ones.
+func (s *Server) scriptHashHistory(scriptHash string) ([]HistoryItem, error) {
+	outputs, err := s.storage.GetScriptHashOutputs(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for _, output := range outputs {
+		if output.Height == 0 {
+			pending = append(pending, output.TxHash)
+		}
+		if output.SpendingTxHash != "" && output.SpendingHeight == 0 {
+			pending = append(pending, output.SpendingTxHash)
+		}
+	}
+
+	unconfirmedInputs := map[string]bool{}
+	if len(pending) > 0 {
+		entries, err := s.storage.GetMempoolEntries(pending)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			unconfirmedInputs[entry.Hash] = len(entry.Depends) > 0
+		}
+	}
+	return history(outputs, unconfirmedInputs), nil
+}
+
+func (s *Server) scriptHashStatus(scriptHash string) (string, error) {
+	items, err := s.scriptHashHistory(scriptHash)
+	if err != nil {
+		return "", err
+	}
+	return status(items), nil
+}
+
+func (s *session) scriptHashGetBalance(params []interface{}) (interface{}, error) {
+	scriptHash, err := paramScriptHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := s.server.storage.GetScriptHashOutputs(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := Balance{}
+	for _, output := range outputs {
+		switch {
+		case output.Height > 0 && output.SpendingTxHash == "":
+			balance.Confirmed += output.Value
+		case output.Height > 0 && output.SpendingHeight == 0:
+			// spent by an unconfirmed transaction
+			balance.Confirmed += output.Value
+			balance.Unconfirmed -= output.Value
+		case output.Height == 0 && output.SpendingTxHash == "":
+			balance.Unconfirmed += output.Value
+		}
+	}
+	return balance, nil
+}
+
+func (s *session) scriptHashGetHistory(params []interface{}) (interface{}, error) {
+	scriptHash, err := paramScriptHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.server.scriptHashHistory(scriptHash)
+}
+
+func (s *session) scriptHashListUnspent(params []interface{}) (interface{}, error) {
+	scriptHash, err := paramScriptHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := s.server.storage.GetScriptHashOutputs(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+
+	unspents := []Unspent{}
+	for _, output := range outputs {
+		if output.SpendingTxHash != "" {
+			continue
+		}
+		unspents = append(unspents, Unspent{
+			TxHash: output.TxHash,
+			TxPos:  output.Index,
+			Height: output.Height,
+			Value:  output.Value,
+		})
+	}
+	return unspents, nil
+}
+
+func (s *session) scriptHashSubscribe(params []interface{}) (interface{}, error) {
+	scriptHash, err := paramScriptHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.server.scriptHashStatus(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.scriptHashes[scriptHash] = status
+	s.mu.Unlock()
+
+	if status == "" {
+		return nil, nil
+	}
+	return status, nil
+}
+
+func (s *session) scriptHashUnsubscribe(params []interface{}) (interface{}, error) {
+	scriptHash, err := paramScriptHash(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.scriptHashes[scriptHash]
+	delete(s.scriptHashes, scriptHash)
+	return ok, nil
+}
+
+// blockchain.transaction
+func (s *session) transactionGet(params []interface{}) (interface{}, error) {
+	txHash, err := paramString(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	verbose, err := paramBool(params, 1, false)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.server.storage.GetTransaction(txHash)
+	if err != nil {
+		if errors.Is(err, command.ErrNotFound) {
+			return nil, newError(ErrBadRequest, "no such mempool or blockchain transaction")
+		}
+		return nil, err
+	}
+
+	if !verbose {
+		buf := new(bytes.Buffer)
+		if err := tx.Tx.Serialize(buf); err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(buf.Bytes()), nil
+	}
+
+	confirmations := uint32(0)
+	if tx.BlockHash != "" {
+		tip, err := s.server.storage.GetLatestBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		confirmations = uint32(tip-tx.Height) + 1
+	}
+	return command.EncodeTransaction(tx.Tx, tx.BlockHash, confirmations, tx.BlockTime, s.server.storage.Params()), nil
+}
+
+type Merkle struct {
+	BlockHeight int32    `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
+func (s *session) transactionGetMerkle(params []interface{}) (interface{}, error) {
+	txHash, err := paramString(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	height, err := paramInt(params, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	blockHash, err := s.server.storage.GetBlockHash(int32(height))
+	if err != nil {
+		if errors.Is(err, command.ErrNotFound) {
+			return nil, newError(ErrBadRequest, "height %d out of range", height)
+		}
+		return nil, err
+	}
+	txids, err := s.server.storage.GetBlockTxIDs(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	branch, pos, err := merkle.TxBranch(txids, txHash)
+	if err != nil {
+		return nil, err
+	}
+	if pos < 0 {
+		return nil, newError(ErrBadRequest, "tx %s not in block at height %d", txHash, height)
+	}
+	return Merkle{
+		BlockHeight: int32(height),
+		Merkle:      branch,
+		Pos:         pos,
+	}, nil
+}
+
+// mempool
+func (s *session) mempoolGetFeeHistogram(params []interface{}) (interface{}, error) {
+	return [][]interface{}{}, nil
+}
@@ -0,0 +1,236 @@
+package esplora
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/model"
+)
+
+// block
+type Block struct {
+	ID                string  `json:"id"`
+	Height            int32   `json:"height"`
+	Version           int32   `json:"version"`
+	Timestamp         int64   `json:"timestamp"`
+	TxCount           int     `json:"tx_count"`
+	Size              int     `json:"size"`
+	Weight            int     `json:"weight"`
+	MerkleRoot        string  `json:"merkle_root"`
+	PreviousBlockHash string  `json:"previousblockhash"`
+	MedianTime        int64   `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              uint32  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+}
+
+func EncodeBlock(block *btcutil.Block, medianTime int64) Block {
+	header := block.MsgBlock().Header
+	return Block{
+		ID:                block.Hash().String(),
+		Height:            block.Height(),
+		Version:           header.Version,
+		Timestamp:         header.Timestamp.Unix(),
+		TxCount:           len(block.Transactions()),
+		Size:              block.MsgBlock().SerializeSize(),
+		Weight:            3*block.MsgBlock().SerializeSizeStripped() + block.MsgBlock().SerializeSize(),
+		MerkleRoot:        header.MerkleRoot.String(),
+		PreviousBlockHash: header.PrevBlock.String(),
+		MedianTime:        medianTime,
+		Nonce:             header.Nonce,
+		Bits:              header.Bits,
+		Difficulty:        difficulty(header.Bits),
+	}
+}
+
+// difficulty returns how many times harder the target is than the
+// difficulty 1 target.
+func difficulty(bits uint32) float64 {
+	maxTarget := new(big.Float).SetInt(blockchain.CompactToBig(0x1d00ffff))
+	target := new(big.Float).SetInt(blockchain.CompactToBig(bits))
+	diff, _ := new(big.Float).Quo(maxTarget, target).Float64()
+	return diff
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// tx
+type TxStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int32  `json:"block_height,omitempty"`
+	BlockHash   string `json:"block_hash,omitempty"`
+	BlockTime   int64  `json:"block_time,omitempty"`
+}
+
+type Tx struct {
+	TxID     string   `json:"txid"`
+	Version  int32    `json:"version"`
+	LockTime uint32   `json:"locktime"`
+	Vin      []Vin    `json:"vin"`
+	Vout     []Vout   `json:"vout"`
+	Size     int      `json:"size"`
+	Weight   int      `json:"weight"`
+	Fee      int64    `json:"fee"`
+	Status   TxStatus `json:"status"`
+}
+
+type Vin struct {
+	TxID         string   `json:"txid"`
+	Vout         uint32   `json:"vout"`
+	Prevout      *Vout    `json:"prevout"`
+	ScriptSig    string   `json:"scriptsig"`
+	ScriptSigAsm string   `json:"scriptsig_asm"`
+	Witness      []string `json:"witness,omitempty"`
+	IsCoinbase   bool     `json:"is_coinbase"`
+	Sequence     uint32   `json:"sequence"`
+}
+
+type Vout struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyAsm     string `json:"scriptpubkey_asm"`
+	ScriptPubKeyType    string `json:"scriptpubkey_type"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address,omitempty"`
+	Value               int64  `json:"value"`
+}
+
+type OutSpend struct {
+	Spent  bool      `json:"spent"`
+	TxID   string    `json:"txid,omitempty"`
+	Vin    *uint32   `json:"vin,omitempty"`
+	Status *TxStatus `json:"status,omitempty"`
+}
+
+type MerkleProof struct {
+	BlockHeight int32    `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
+type Utxo struct {
+	TxID   string   `json:"txid"`
+	Vout   uint32   `json:"vout"`
+	Status TxStatus `json:"status"`
+	Value  int64    `json:"value"`
+}
+
+// EncodeTx encodes the transaction, inputs are the outpoints spent by it in
+// the order of its inputs.
+func EncodeTx(tx *wire.MsgTx, inputs []model.OutPoint, status TxStatus, params *chaincfg.Params) Tx {
+	prevouts := make(map[uint32]model.OutPoint, len(inputs))
+	for _, input := range inputs {
+		prevouts[input.SpendingTxIndex] = input
+	}
+
+	coinbase := blockchain.IsCoinBaseTx(tx)
+	inputValue := int64(0)
+	vins := make([]Vin, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		asm, _ := txscript.DisasmString(txIn.SignatureScript)
+		vins[i] = Vin{
+			TxID:         txIn.PreviousOutPoint.Hash.String(),
+			Vout:         txIn.PreviousOutPoint.Index,
+			ScriptSig:    hex.EncodeToString(txIn.SignatureScript),
+			ScriptSigAsm: asm,
+			IsCoinbase:   coinbase,
+			Sequence:     txIn.Sequence,
+		}
+		for _, w := range txIn.Witness {
+			vins[i].Witness = append(vins[i].Witness, hex.EncodeToString(w))
+		}
+
+		if prevout, ok := prevouts[uint32(i)]; ok && !coinbase {
+			vout := encodeVout(prevout.PkScript, prevout.Value, params)
+			vins[i].Prevout = &vout
+			inputValue += prevout.Value
+		}
+	}
+
+	outputValue := int64(0)
+	vouts := make([]Vout, len(tx.TxOut))
+	for i, txOut := range tx.TxOut {
+		vouts[i] = encodeVout(txOut.PkScript, txOut.Value, params)
+		outputValue += txOut.Value
+	}
+
+	fee := int64(0)
+	if !coinbase {
+		fee = inputValue - outputValue
+	}
+
+	return Tx{
+		TxID:     tx.TxHash().String(),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Vin:      vins,
+		Vout:     vouts,
+		Size:     tx.SerializeSize(),
+		Weight:   3*tx.SerializeSizeStripped() + tx.SerializeSize(),
+		Fee:      fee,
+		Status:   status,
+	}
+}
+
+func encodeVout(pkScript []byte, value int64, params *chaincfg.Params) Vout {
+	asm, _ := txscript.DisasmString(pkScript)
+	class, addrs, _, _ := txscript.ExtractPkScriptAddrs(pkScript, params)
+
+	vout := Vout{
+		ScriptPubKey:     hex.EncodeToString(pkScript),
+		ScriptPubKeyAsm:  asm,
+		ScriptPubKeyType: scriptType(class),
+		Value:            value,
+	}
+	switch class {
+	case txscript.PubKeyHashTy, txscript.ScriptHashTy, txscript.WitnessV0PubKeyHashTy, txscript.WitnessV0ScriptHashTy, txscript.WitnessV1TaprootTy:
+		if len(addrs) == 1 {
+			vout.ScriptPubKeyAddress = addrs[0].EncodeAddress()
+		}
+	}
+	return vout
+}
+
+// scriptType returns the name esplora uses for the script class.
+func scriptType(class txscript.ScriptClass) string {
+	switch class {
+	case txscript.PubKeyHashTy:
+		return "p2pkh"
+	case txscript.ScriptHashTy:
+		return "p2sh"
+	case txscript.WitnessV0PubKeyHashTy:
+		return "v0_p2wpkh"
+	case txscript.WitnessV0ScriptHashTy:
+		return "v0_p2wsh"
+	case txscript.WitnessV1TaprootTy:
+		return "v1_p2tr"
+	case txscript.PubKeyTy:
+		return "p2pk"
+	case txscript.MultiSigTy:
+		return "multisig"
+	case txscript.NullDataTy:
+		return "op_return"
+	default:
+		return "unknown"
+	}
+}
+
+func encodeTxHex(tx *wire.MsgTx) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tx.Serialize(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,410 @@
+package esplora
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/merkle"
+	"github.com/catalogfi/indexer/model"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxMempoolTxs and maxChainTxs are the number of unconfirmed and
+	// confirmed transactions returned for an address per request.
+	maxMempoolTxs = 50
+	maxChainTxs   = 25
+)
+
+type Storage interface {
+	command.Storage
+	Params() *chaincfg.Params
+	GetBlockTxIDs(blockHash string) ([]string, error)
+	GetTxStatus(txHash string) (TxStatus, error)
+	// GetTxStatuses returns the statuses of the transactions in the order
+	// of the hashes.
+	GetTxStatuses(txHashes []string) ([]TxStatus, error)
+	// GetTxDetails returns the transactions with the outpoints they spend
+	// and their statuses in the order of the hashes.
+	GetTxDetails(txHashes []string) ([]TxDetails, error)
+	GetTxOutPoints(txHash string) ([]model.OutPoint, []model.OutPoint, error)
+	GetAddressChainTxIDs(address, lastSeenTxID string, limit int) ([]string, error)
+	GetAddressMempoolTxIDs(address string, limit int) ([]string, error)
+	GetAddressUnspent(address string) ([]model.OutPoint, error)
+}
+
+// TxDetails is a transaction along with the outpoints spent by its inputs, in
+// the order of the inputs, and its status.
+type TxDetails struct {
+	Tx     *wire.MsgTx
+	Inputs []model.OutPoint
+	Status TxStatus
+}
+
+type Server struct {
+	storage Storage
+}
+
+// New returns a REST server implementing the Blockstream Esplora HTTP API.
+func New(storage Storage) *Server {
+	return &Server{
+		storage: storage,
+	}
+}
+
+func (s *Server) Register(router gin.IRouter) {
+	router.GET("/block/:hash", s.getBlock)
+	router.GET("/block/:hash/txids", s.getBlockTxIDs)
+	router.GET("/block-height/:height", s.getBlockHeight)
+	router.GET("/blocks/tip/height", s.getTipHeight)
+	router.GET("/blocks/tip/hash", s.getTipHash)
+	router.GET("/tx/:txid", s.getTx)
+	router.GET("/tx/:txid/hex", s.getTxHex)
+	router.GET("/tx/:txid/status", s.getTxStatus)
+	router.GET("/tx/:txid/outspends", s.getTxOutSpends)
+	router.GET("/tx/:txid/merkle-proof", s.getTxMerkleProof)
+	router.GET("/address/:address/txs", s.getAddressTxs)
+	router.GET("/address/:address/txs/chain", s.getAddressChainTxs)
+	router.GET("/address/:address/txs/chain/:last_seen_txid", s.getAddressChainTxs)
+	router.GET("/address/:address/utxo", s.getAddressUtxo)
+}
+
+// writeError replies with a plain text error like esplora does, missing
+// records are reported with a 404.
+func writeError(ctx *gin.Context, err error, notFound string) {
+	if errors.Is(err, command.ErrNotFound) {
+		ctx.String(http.StatusNotFound, notFound)
+		return
+	}
+	ctx.String(http.StatusInternalServerError, err.Error())
+}
+
+// address returns the address parameter in its canonical encoding, or
+// replies with a 400 if it is not an address of the network.
+func (s *Server) address(ctx *gin.Context) (string, bool) {
+	params := s.storage.Params()
+	address, err := btcutil.DecodeAddress(ctx.Param("address"), params)
+	if err != nil || !address.IsForNet(params) {
+		ctx.String(http.StatusBadRequest, "Invalid Bitcoin address")
+		return "", false
+	}
+	return address.EncodeAddress(), true
+}
+
+// hash returns the hash parameter with the given name in lower case, or
+// replies with a 400 if it is not a hex encoded hash.
+func hash(ctx *gin.Context, name string) (string, bool) {
+	b, err := hex.DecodeString(ctx.Param(name))
+	if err != nil || len(b) != chainhash.HashSize {
+		ctx.String(http.StatusBadRequest, "Invalid hex string")
+		return "", false
+	}
+	return hex.EncodeToString(b), true
+}
+
+// block
+func (s *Server) getBlock(ctx *gin.Context) {
+	blockHash, ok := hash(ctx, "hash")
+	if !ok {
+		return
+	}
+	block, err := s.storage.GetBlockFromHash(blockHash)
+	if err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	medianTime, err := s.medianTime(block.Height())
+	if err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, EncodeBlock(block, medianTime))
+}
+
+func (s *Server) getBlockTxIDs(ctx *gin.Context) {
+	blockHash, ok := hash(ctx, "hash")
+	if !ok {
+		return
+	}
+	if _, err := s.storage.GetHeaderFromHash(blockHash); err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	txids, err := s.storage.GetBlockTxIDs(blockHash)
+	if err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, txids)
+}
+
+func (s *Server) getBlockHeight(ctx *gin.Context) {
+	height, err := strconv.ParseInt(ctx.Param("height"), 10, 32)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid height")
+		return
+	}
+	hash, err := s.storage.GetBlockHash(int32(height))
+	if err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	ctx.String(http.StatusOK, hash)
+}
+
+func (s *Server) getTipHeight(ctx *gin.Context) {
+	height, err := s.storage.GetLatestBlockHeight()
+	if err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	ctx.String(http.StatusOK, strconv.FormatInt(int64(height), 10))
+}
+
+func (s *Server) getTipHash(ctx *gin.Context) {
+	hash, err := s.storage.GetLatestBlockHash()
+	if err != nil {
+		writeError(ctx, err, "Block not found")
+		return
+	}
+	ctx.String(http.StatusOK, hash)
+}
+
+// medianTime returns the median timestamp of the block at the given height
+// and the 10 blocks before it.
+func (s *Server) medianTime(height int32) (int64, error) {
+	timestamps := []int64{}
+	for h := height; h >= 0 && h > height-11; h-- {
+		header, err := s.storage.GetHeaderFromHeight(h)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Header.Timestamp.Unix())
+	}
+	return median(timestamps), nil
+}
+
+// tx
+// txs returns the transactions in the order of the txids.
+func (s *Server) txs(txids []string) ([]Tx, error) {
+	details, err := s.storage.GetTxDetails(txids)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]Tx, len(details))
+	for i, detail := range details {
+		txs[i] = EncodeTx(detail.Tx, detail.Inputs, detail.Status, s.storage.Params())
+	}
+	return txs, nil
+}
+
+func (s *Server) getTx(ctx *gin.Context) {
+	txid, ok := hash(ctx, "txid")
+	if !ok {
+		return
+	}
+	txs, err := s.txs([]string{txid})
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, txs[0])
+}
+
+func (s *Server) getTxHex(ctx *gin.Context) {
+	txid, ok := hash(ctx, "txid")
+	if !ok {
+		return
+	}
+	tx, err := s.storage.GetTransaction(txid)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	txHex, err := encodeTxHex(tx.Tx)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	ctx.String(http.StatusOK, txHex)
+}
+
+func (s *Server) getTxStatus(ctx *gin.Context) {
+	txid, ok := hash(ctx, "txid")
+	if !ok {
+		return
+	}
+	status, err := s.storage.GetTxStatus(txid)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
+func (s *Server) getTxOutSpends(ctx *gin.Context) {
+	txid, ok := hash(ctx, "txid")
+	if !ok {
+		return
+	}
+	if _, err := s.storage.GetTxStatus(txid); err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	_, outputs, err := s.storage.GetTxOutPoints(txid)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+
+	spenders := []string{}
+	for _, output := range outputs {
+		if output.SpendingTxHash != "" {
+			spenders = append(spenders, string(output.SpendingTxHash))
+		}
+	}
+	statuses, err := s.storage.GetTxStatuses(spenders)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+
+	outspends := make([]OutSpend, len(outputs))
+	for i, output := range outputs {
+		if output.SpendingTxHash == "" {
+			continue
+		}
+		vin := output.SpendingTxIndex
+		status := statuses[0]
+		statuses = statuses[1:]
+		outspends[i] = OutSpend{
+			Spent:  true,
+			TxID:   string(output.SpendingTxHash),
+			Vin:    &vin,
+			Status: &status,
+		}
+	}
+	ctx.JSON(http.StatusOK, outspends)
+}
+
+func (s *Server) getTxMerkleProof(ctx *gin.Context) {
+	txid, ok := hash(ctx, "txid")
+	if !ok {
+		return
+	}
+	status, err := s.storage.GetTxStatus(txid)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	if !status.Confirmed {
+		ctx.String(http.StatusNotFound, "Transaction not found")
+		return
+	}
+	txids, err := s.storage.GetBlockTxIDs(status.BlockHash)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	branch, pos, err := merkle.TxBranch(txids, txid)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	if pos < 0 {
+		ctx.String(http.StatusNotFound, "Transaction not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, MerkleProof{
+		BlockHeight: status.BlockHeight,
+		Merkle:      branch,
+		Pos:         pos,
+	})
+}
+
+// address
+func (s *Server) getAddressTxs(ctx *gin.Context) {
+	address, ok := s.address(ctx)
+	if !ok {
+		return
+	}
+	mempoolTxIDs, err := s.storage.GetAddressMempoolTxIDs(address, maxMempoolTxs)
+	if err != nil {
+		writeError(ctx, err, "Address not found")
+		return
+	}
+	chainTxIDs, err := s.storage.GetAddressChainTxIDs(address, "", maxChainTxs)
+	if err != nil {
+		writeError(ctx, err, "Address not found")
+		return
+	}
+	txs, err := s.txs(append(mempoolTxIDs, chainTxIDs...))
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, txs)
+}
+
+func (s *Server) getAddressChainTxs(ctx *gin.Context) {
+	address, ok := s.address(ctx)
+	if !ok {
+		return
+	}
+	lastSeenTxID := ""
+	if ctx.Param("last_seen_txid") != "" {
+		if lastSeenTxID, ok = hash(ctx, "last_seen_txid"); !ok {
+			return
+		}
+	}
+	txids, err := s.storage.GetAddressChainTxIDs(address, lastSeenTxID, maxChainTxs)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	txs, err := s.txs(txids)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+	ctx.JSON(http.StatusOK, txs)
+}
+
+func (s *Server) getAddressUtxo(ctx *gin.Context) {
+	address, ok := s.address(ctx)
+	if !ok {
+		return
+	}
+	outpoints, err := s.storage.GetAddressUnspent(address)
+	if err != nil {
+		writeError(ctx, err, "Address not found")
+		return
+	}
+	txids := make([]string, len(outpoints))
+	for i, op := range outpoints {
+		txids[i] = string(op.FundingTxHash)
+	}
+	statuses, err := s.storage.GetTxStatuses(txids)
+	if err != nil {
+		writeError(ctx, err, "Transaction not found")
+		return
+	}
+
+	utxos := make([]Utxo, len(outpoints))
+	for i, op := range outpoints {
+		utxos[i] = Utxo{
+			TxID:   string(op.FundingTxHash),
+			Vout:   op.FundingTxIndex,
+			Status: statuses[i],
+			Value:  op.Value,
+		}
+	}
+	ctx.JSON(http.StatusOK, utxos)
+}
@@ -0,0 +1,250 @@
+package esplora_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/esplora"
+	"github.com/catalogfi/indexer/store/kv"
+	"github.com/catalogfi/indexer/store/storetest"
+	"github.com/gin-gonic/gin"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// chain is the data the server under test serves: two blocks of coinbases,
+// a third one with txs, a chain of transactions each spending the output of
+// the previous one to Address(2), and pending spending the last of them to
+// Address(3).
+type chain struct {
+	router  *gin.Engine
+	blocks  []*wire.MsgBlock
+	txs     []*wire.MsgTx
+	pending *wire.MsgTx
+}
+
+func newChain(t *testing.T) *chain {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	str := kv.NewStorage(storetest.Params, db)
+
+	c := &chain{}
+	c.blocks = storetest.PutChain(t, str, storetest.Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	prev := c.blocks[0].Transactions[0]
+	for i := 0; i < 30; i++ {
+		tx := storetest.SpendTx(prev, 0, prev.TxOut[0].Value-1e5, storetest.Address(2), wire.MaxTxInSequenceNum)
+		c.txs = append(c.txs, tx)
+		prev = tx
+	}
+	block := storetest.NewBlock(c.blocks[1].BlockHash(), 3*600, append([]*wire.MsgTx{storetest.CoinbaseTx(3, 0, storetest.Address(1))}, c.txs...)...)
+	if err := str.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	c.blocks = append(c.blocks, block)
+	c.pending = storetest.SpendTx(prev, 0, 1e8, storetest.Address(3), wire.MaxTxInSequenceNum)
+	if err := str.PutTx(c.pending); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	c.router = gin.New()
+	esplora.New(str).Register(c.router.Group("/api"))
+	return c
+}
+
+func (c *chain) get(t *testing.T, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api"+path, nil))
+	return w
+}
+
+// getJSON decodes the response to a successful request into v.
+func (c *chain) getJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	w := c.get(t, path)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: got status %d: %s", path, w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: got %s: %v", path, w.Body, err)
+	}
+}
+
+// txids returns the txids of the transactions, newest first.
+func txids(txs ...*wire.MsgTx) []string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[len(txs)-1-i] = tx.TxHash().String()
+	}
+	return ids
+}
+
+func decodedTxIDs(txs []esplora.Tx) []string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.TxID
+	}
+	return ids
+}
+
+func TestBlocks(t *testing.T) {
+	c := newChain(t)
+	tip := c.blocks[2].BlockHash().String()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/blocks/tip/height", "3"},
+		{"/blocks/tip/hash", tip},
+		{"/block-height/3", tip},
+		{"/block-height/0", storetest.Params.GenesisHash.String()},
+	}
+	for _, test := range tests {
+		if w := c.get(t, test.path); w.Code != http.StatusOK || w.Body.String() != test.want {
+			t.Fatalf("GET %s: got %d %s, want %s", test.path, w.Code, w.Body, test.want)
+		}
+	}
+
+	block := esplora.Block{}
+	c.getJSON(t, "/block/"+tip, &block)
+	if block.ID != tip || block.Height != 3 || block.TxCount != 31 || block.PreviousBlockHash != c.blocks[1].BlockHash().String() {
+		t.Fatalf("got block %+v, want block 3 with 31 transactions", block)
+	}
+	ids := []string{}
+	c.getJSON(t, "/block/"+tip+"/txids", &ids)
+	if len(ids) != 31 || ids[0] != c.blocks[2].Transactions[0].TxHash().String() || ids[30] != c.txs[29].TxHash().String() {
+		t.Fatalf("got txids %v, want the 31 transactions of block 3 in order", ids)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	c := newChain(t)
+	tx := c.txs[0]
+	txid := tx.TxHash().String()
+
+	decoded := esplora.Tx{}
+	c.getJSON(t, "/tx/"+txid, &decoded)
+	if decoded.TxID != txid || !decoded.Status.Confirmed || decoded.Status.BlockHeight != 3 || decoded.Fee != 1e5 {
+		t.Fatalf("got %+v, want %s confirmed at height 3 with a fee of 1e5", decoded, txid)
+	}
+	if len(decoded.Vin) != 1 || decoded.Vin[0].Prevout == nil || decoded.Vin[0].Prevout.ScriptPubKeyAddress != storetest.Address(1).EncodeAddress() {
+		t.Fatalf("got inputs %+v, want the coinbase output of block 1", decoded.Vin)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tx.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if w := c.get(t, "/tx/"+txid+"/hex"); w.Code != http.StatusOK || w.Body.String() != hex.EncodeToString(buf.Bytes()) {
+		t.Fatalf("got %d %s, want the serialized transaction", w.Code, w.Body)
+	}
+
+	status := esplora.TxStatus{}
+	c.getJSON(t, "/tx/"+c.pending.TxHash().String()+"/status", &status)
+	if status.Confirmed || status.BlockHash != "" {
+		t.Fatalf("got status %+v for an unconfirmed transaction", status)
+	}
+
+	outspends := []esplora.OutSpend{}
+	c.getJSON(t, "/tx/"+c.txs[29].TxHash().String()+"/outspends", &outspends)
+	if len(outspends) != 1 || !outspends[0].Spent || outspends[0].TxID != c.pending.TxHash().String() || outspends[0].Status.Confirmed {
+		t.Fatalf("got outspends %+v, want the output spent by the unconfirmed transaction", outspends)
+	}
+
+	proof := esplora.MerkleProof{}
+	c.getJSON(t, "/tx/"+txid+"/merkle-proof", &proof)
+	if proof.BlockHeight != 3 || proof.Pos != 1 || len(proof.Merkle) != 5 {
+		t.Fatalf("got proof %+v, want position 1 at height 3 with 5 hashes", proof)
+	}
+	if w := c.get(t, "/tx/"+c.pending.TxHash().String()+"/merkle-proof"); w.Code != http.StatusNotFound {
+		t.Fatalf("got %d %s for the proof of an unconfirmed transaction, want 404", w.Code, w.Body)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	c := newChain(t)
+	address := storetest.Address(2).EncodeAddress()
+
+	// The unconfirmed transactions come first, followed by a page of the
+	// confirmed ones
+	txs := []esplora.Tx{}
+	c.getJSON(t, "/address/"+address+"/txs", &txs)
+	want := append(txids(c.pending), txids(c.txs[5:]...)...)
+	if got := decodedTxIDs(txs); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got txs\n%v\nwant\n%v", got, want)
+	}
+
+	// The pages of confirmed transactions follow each other and end with an
+	// empty one
+	pages := [][]string{txids(c.txs[5:]...), txids(c.txs[:5]...), {}}
+	path := "/address/" + address + "/txs/chain"
+	for i, page := range pages {
+		txs := []esplora.Tx{}
+		c.getJSON(t, path, &txs)
+		got := decodedTxIDs(txs)
+		if strings.Join(got, " ") != strings.Join(page, " ") {
+			t.Fatalf("page %d: got txs\n%v\nwant\n%v", i, got, page)
+		}
+		if len(got) > 0 {
+			path = "/address/" + address + "/txs/chain/" + got[len(got)-1]
+		}
+	}
+
+	utxos := []esplora.Utxo{}
+	c.getJSON(t, "/address/"+storetest.Address(3).EncodeAddress()+"/utxo", &utxos)
+	if len(utxos) != 1 || utxos[0].TxID != c.pending.TxHash().String() || utxos[0].Vout != 0 || utxos[0].Value != 1e8 || utxos[0].Status.Confirmed {
+		t.Fatalf("got utxos %+v, want the unconfirmed output", utxos)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newChain(t)
+	address := storetest.Address(2).EncodeAddress()
+	unknown := chainhash.Hash{1}.String()
+	// An address of another network is not valid either
+	mainnet := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/block/" + unknown, http.StatusNotFound, "Block not found"},
+		{"/block/" + unknown + "/txids", http.StatusNotFound, "Block not found"},
+		{"/block/zz", http.StatusBadRequest, "Invalid hex string"},
+		{"/block-height/4", http.StatusNotFound, "Block not found"},
+		{"/block-height/x", http.StatusBadRequest, "Invalid height"},
+		{"/tx/" + unknown, http.StatusNotFound, "Transaction not found"},
+		{"/tx/" + unknown + "/hex", http.StatusNotFound, "Transaction not found"},
+		{"/tx/" + unknown + "/status", http.StatusNotFound, "Transaction not found"},
+		{"/tx/" + unknown + "/outspends", http.StatusNotFound, "Transaction not found"},
+		{"/tx/" + unknown + "/merkle-proof", http.StatusNotFound, "Transaction not found"},
+		{"/tx/1234", http.StatusBadRequest, "Invalid hex string"},
+		{"/tx/" + strings.Repeat("z", 64) + "/status", http.StatusBadRequest, "Invalid hex string"},
+		{"/address/foo/txs", http.StatusBadRequest, "Invalid Bitcoin address"},
+		{"/address/" + mainnet + "/txs/chain", http.StatusBadRequest, "Invalid Bitcoin address"},
+		{"/address/" + mainnet + "/utxo", http.StatusBadRequest, "Invalid Bitcoin address"},
+		{"/address/" + address + "/txs/chain/" + unknown, http.StatusNotFound, "Transaction not found"},
+		{"/address/" + address + "/txs/chain/zz", http.StatusBadRequest, "Invalid hex string"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			w := c.get(t, test.path)
+			if w.Code != test.wantStatus || w.Body.String() != test.wantBody {
+				t.Fatalf("got %d %q, want %d %q", w.Code, w.Body, test.wantStatus, test.wantBody)
+			}
+		})
+	}
+}
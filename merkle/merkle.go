@@ -0,0 +1,220 @@
+// Package merkle builds and checks proofs that transactions are included in
+// a block: merkle branches as served by Electrum and Esplora servers, and
+// the partial merkle trees of BIP37 merkle blocks as bitcoind's
+// gettxoutproof serializes them.
+package merkle
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxTransactions is the most transactions a block can hold, each weighing
+// at least 240 weight units.
+const maxTransactions = blockchain.MaxBlockWeight / 240
+
+var ErrInvalidProof = errors.New("invalid merkle proof")
+
+func hashPair(left, right chainhash.Hash) chainhash.Hash {
+	return chainhash.DoubleHashH(append(left[:], right[:]...))
+}
+
+// Branch returns the hashes needed to compute the merkle root from the leaf
+// at pos, from the bottom of the tree to the top.
+func Branch(hashes []chainhash.Hash, pos int) []chainhash.Hash {
+	branch := []chainhash.Hash{}
+	level := append([]chainhash.Hash{}, hashes...)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, level[pos^1])
+
+		next := make([]chainhash.Hash, len(level)/2)
+		for i := range next {
+			next[i] = hashPair(level[2*i], level[2*i+1])
+		}
+		level = next
+		pos /= 2
+	}
+	return branch
+}
+
+// TxBranch returns the merkle branch of a transaction of a block given the
+// ids of the block's transactions, along with its position in the block. The
+// position is -1 if the block does not contain the transaction.
+func TxBranch(txids []string, txid string) ([]string, int, error) {
+	pos := -1
+	hashes := make([]chainhash.Hash, len(txids))
+	for i, id := range txids {
+		hash, err := chainhash.NewHashFromStr(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		hashes[i] = *hash
+		if id == txid {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return nil, -1, nil
+	}
+
+	branch := Branch(hashes, pos)
+	merkle := make([]string, len(branch))
+	for i, hash := range branch {
+		merkle[i] = hash.String()
+	}
+	return merkle, pos, nil
+}
+
+// partialTree is bitcoind's CPartialMerkleTree, the hashes and flag bits of
+// a depth first traversal of the merkle tree of a block that only descends
+// into the subtrees holding matched transactions.
+type partialTree struct {
+	transactions uint32
+	bits         []bool
+	hashes       []chainhash.Hash
+}
+
+// width returns the number of nodes at a height of the tree, leaves being
+// at height 0.
+func (t *partialTree) width(height uint) uint32 {
+	return (t.transactions + (1 << height) - 1) >> height
+}
+
+func (t *partialTree) height() uint {
+	height := uint(0)
+	for t.width(height) > 1 {
+		height++
+	}
+	return height
+}
+
+func (t *partialTree) calcHash(height uint, pos uint32, txids []chainhash.Hash) chainhash.Hash {
+	if height == 0 {
+		return txids[pos]
+	}
+	left := t.calcHash(height-1, pos*2, txids)
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		right = t.calcHash(height-1, pos*2+1, txids)
+	}
+	return hashPair(left, right)
+}
+
+func (t *partialTree) build(height uint, pos uint32, txids []chainhash.Hash, matches []bool) {
+	parentOfMatch := false
+	for p := pos << height; p < (pos+1)<<height && p < t.transactions; p++ {
+		parentOfMatch = parentOfMatch || matches[p]
+	}
+	t.bits = append(t.bits, parentOfMatch)
+	if height == 0 || !parentOfMatch {
+		t.hashes = append(t.hashes, t.calcHash(height, pos, txids))
+		return
+	}
+	t.build(height-1, pos*2, txids, matches)
+	if pos*2+1 < t.width(height-1) {
+		t.build(height-1, pos*2+1, txids, matches)
+	}
+}
+
+// extract walks the tree like build did, collecting the matched leaves,
+// and returns the hash of the subtree.
+func (t *partialTree) extract(height uint, pos uint32, bitsUsed, hashesUsed *int, matched *[]chainhash.Hash, indices *[]uint32) (chainhash.Hash, error) {
+	if *bitsUsed >= len(t.bits) {
+		return chainhash.Hash{}, ErrInvalidProof
+	}
+	parentOfMatch := t.bits[*bitsUsed]
+	*bitsUsed++
+	if height == 0 || !parentOfMatch {
+		if *hashesUsed >= len(t.hashes) {
+			return chainhash.Hash{}, ErrInvalidProof
+		}
+		hash := t.hashes[*hashesUsed]
+		*hashesUsed++
+		if height == 0 && parentOfMatch {
+			*matched = append(*matched, hash)
+			*indices = append(*indices, pos)
+		}
+		return hash, nil
+	}
+
+	left, err := t.extract(height-1, pos*2, bitsUsed, hashesUsed, matched, indices)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	right := left
+	if pos*2+1 < t.width(height-1) {
+		if right, err = t.extract(height-1, pos*2+1, bitsUsed, hashesUsed, matched, indices); err != nil {
+			return chainhash.Hash{}, err
+		}
+		// Identical children would allow proving a duplicated transaction,
+		// CVE-2012-2459
+		if right == left {
+			return chainhash.Hash{}, ErrInvalidProof
+		}
+	}
+	return hashPair(left, right), nil
+}
+
+// NewMerkleBlock returns the merkle block of a block proving that the
+// transactions whose entry in matches is set are included in it.
+func NewMerkleBlock(header wire.BlockHeader, txids []chainhash.Hash, matches []bool) *wire.MsgMerkleBlock {
+	t := &partialTree{transactions: uint32(len(txids))}
+	if len(txids) > 0 {
+		t.build(t.height(), 0, txids, matches)
+	}
+
+	mb := wire.NewMsgMerkleBlock(&header)
+	mb.Transactions = t.transactions
+	for i := range t.hashes {
+		mb.AddTxHash(&t.hashes[i])
+	}
+	mb.Flags = make([]byte, (len(t.bits)+7)/8)
+	for i, bit := range t.bits {
+		if bit {
+			mb.Flags[i/8] |= 1 << (i % 8)
+		}
+	}
+	return mb
+}
+
+// ExtractMatches checks the partial merkle tree of a merkle block, and
+// returns the merkle root it commits to along with the matched transactions
+// and their positions in the block. The root has to be checked against the
+// header of the block.
+func ExtractMatches(mb *wire.MsgMerkleBlock) (chainhash.Hash, []chainhash.Hash, []uint32, error) {
+	t := &partialTree{
+		transactions: mb.Transactions,
+		bits:         make([]bool, len(mb.Flags)*8),
+		hashes:       make([]chainhash.Hash, len(mb.Hashes)),
+	}
+	for i := range t.bits {
+		t.bits[i] = mb.Flags[i/8]&(1<<(i%8)) != 0
+	}
+	for i, hash := range mb.Hashes {
+		t.hashes[i] = *hash
+	}
+
+	if t.transactions == 0 || t.transactions > maxTransactions ||
+		uint32(len(t.hashes)) > t.transactions || len(t.bits) < len(t.hashes) {
+		return chainhash.Hash{}, nil, nil, ErrInvalidProof
+	}
+
+	bitsUsed, hashesUsed := 0, 0
+	matched, indices := []chainhash.Hash{}, []uint32{}
+	root, err := t.extract(t.height(), 0, &bitsUsed, &hashesUsed, &matched, &indices)
+	if err != nil {
+		return chainhash.Hash{}, nil, nil, err
+	}
+	// All hashes have to be used, and only the padding of the last flag
+	// byte can be left
+	if (bitsUsed+7)/8 != len(mb.Flags) || hashesUsed != len(t.hashes) {
+		return chainhash.Hash{}, nil, nil, ErrInvalidProof
+	}
+	return root, matched, indices, nil
+}
@@ -0,0 +1,168 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// proof is the merkle block of mainnet block
+// 000000000000b731f2eef9e8c63173adfb07e41bd53eb0ef0a6b720d6cb6dea4 proving
+// its fifth transaction, as gettxoutproof serializes it.
+const proof = "0100000082bb869cf3a793432a66e826e05a6fc37469f8efb7421dc88067010000000000" +
+	"7f16c5962e8bd963659c793ce370d95f093bc7e367117b3c30c1f8fdd0d97287" +
+	"76381b4d4c86041b554b8529" +
+	"07000000" +
+	"04" +
+	"3612262624047ee87660be1a707519a443b1c1ce3d248cbfc6c15870f6c5daa2" +
+	"019f5b01d4195ecbc9398fbf3c3b1fa9bb3183301d7a1fb3bd174fcfa40a2b65" +
+	"41ed70551dd7e841883ab8f0b16bf04176b7d1480e4f0af9f3d4c3595768d068" +
+	"20d2a7bc994987302e5b1ac80fc425fe25f8b63169ea78e68fbaaefa59379bbf" +
+	"01" +
+	"1d"
+
+func decodeProof(t *testing.T) *wire.MsgMerkleBlock {
+	t.Helper()
+	raw, err := hex.DecodeString(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := &wire.MsgMerkleBlock{}
+	if err := mb.BtcDecode(bytes.NewReader(raw), wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		t.Fatal(err)
+	}
+	return mb
+}
+
+// block returns the ids of n distinct transactions and their merkle root.
+func block(n int) ([]chainhash.Hash, chainhash.Hash) {
+	txs := make([]*btcutil.Tx, n)
+	txids := make([]chainhash.Hash, n)
+	for i := range txs {
+		tx := wire.NewMsgTx(1)
+		tx.LockTime = uint32(i)
+		txs[i] = btcutil.NewTx(tx)
+		txids[i] = tx.TxHash()
+	}
+	merkles := blockchain.BuildMerkleTreeStore(txs, false)
+	return txids, *merkles[len(merkles)-1]
+}
+
+func TestExtractMatches(t *testing.T) {
+	mb := decodeProof(t)
+	root, matched, indices, err := ExtractMatches(mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != mb.Header.MerkleRoot {
+		t.Fatalf("got root %s, want %s", root, mb.Header.MerkleRoot)
+	}
+	want := "652b0aa4cf4f17bdb31f7a1d308331bba91f3b3cbf8f39c9cb5e19d4015b9f01"
+	if len(matched) != 1 || matched[0].String() != want || !reflect.DeepEqual(indices, []uint32{4}) {
+		t.Fatalf("got matches %v at %v, want %s at 4", matched, indices, want)
+	}
+
+	// The proof serializes back to the same bytes
+	buf := new(bytes.Buffer)
+	if err := mb.BtcEncode(buf, wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(buf.Bytes()); got != proof {
+		t.Fatalf("got %s, want %s", got, proof)
+	}
+}
+
+func TestNewMerkleBlock(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		txids, root := block(n)
+		for _, matches := range [][]int{{0}, {n - 1}, {n / 2}, {0, n - 1}, {0, n / 3, n / 2}} {
+			match := make([]bool, n)
+			for _, i := range matches {
+				match[i] = true
+			}
+			mb := NewMerkleBlock(wire.BlockHeader{MerkleRoot: root}, txids, match)
+			gotRoot, matched, indices, err := ExtractMatches(mb)
+			if err != nil {
+				t.Fatalf("%d transactions, matching %v: %v", n, matches, err)
+			}
+			if gotRoot != root {
+				t.Fatalf("%d transactions, matching %v: got root %s, want %s", n, matches, gotRoot, root)
+			}
+			for i, index := range indices {
+				if !match[index] || matched[i] != txids[index] {
+					t.Fatalf("%d transactions, matching %v: got %s at %d", n, matches, matched[i], index)
+				}
+				match[index] = false
+			}
+			for i, m := range match {
+				if m {
+					t.Fatalf("%d transactions, matching %v: transaction %d is not matched", n, matches, i)
+				}
+			}
+		}
+	}
+}
+
+func TestExtractMatchesInvalid(t *testing.T) {
+	extra := chainhash.Hash{1}
+	tests := []struct {
+		name   string
+		modify func(mb *wire.MsgMerkleBlock)
+	}{
+		{"extra hash", func(mb *wire.MsgMerkleBlock) { mb.Hashes = append(mb.Hashes, &extra) }},
+		{"missing hash", func(mb *wire.MsgMerkleBlock) { mb.Hashes = mb.Hashes[:3] }},
+		{"leftover flag bits", func(mb *wire.MsgMerkleBlock) { mb.Flags = append(mb.Flags, 0) }},
+		{"missing flag bits", func(mb *wire.MsgMerkleBlock) { mb.Flags = nil }},
+		// Without the flag of the fourth hash, it is not used
+		{"unused hash", func(mb *wire.MsgMerkleBlock) { mb.Flags = []byte{0x01} }},
+		{"no transactions", func(mb *wire.MsgMerkleBlock) { mb.Transactions = 0 }},
+		{"too many transactions", func(mb *wire.MsgMerkleBlock) { mb.Transactions = maxTransactions + 1 }},
+		{"more hashes than transactions", func(mb *wire.MsgMerkleBlock) { mb.Transactions = 3 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mb := decodeProof(t)
+			test.modify(mb)
+			if _, _, _, err := ExtractMatches(mb); !errors.Is(err, ErrInvalidProof) {
+				t.Fatalf("got %v, want %v", err, ErrInvalidProof)
+			}
+		})
+	}
+
+	t.Run("duplicated transaction", func(t *testing.T) {
+		// A block of three transactions has the merkle root of the same
+		// transactions with the last one duplicated, CVE-2012-2459
+		txids, root := block(3)
+		txids = append(txids, txids[2])
+		mb := NewMerkleBlock(wire.BlockHeader{MerkleRoot: root}, txids, []bool{false, false, false, true})
+		if _, _, _, err := ExtractMatches(mb); !errors.Is(err, ErrInvalidProof) {
+			t.Fatalf("got %v, want %v", err, ErrInvalidProof)
+		}
+	})
+}
+
+func TestBranch(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txids, root := block(n)
+		for pos := range txids {
+			hash := txids[pos]
+			for i, sibling := range Branch(txids, pos) {
+				if (pos>>i)&1 == 0 {
+					hash = hashPair(hash, sibling)
+				} else {
+					hash = hashPair(sibling, hash)
+				}
+			}
+			if hash != root {
+				t.Fatalf("%d transactions, position %d: got root %s, want %s", n, pos, hash, root)
+			}
+		}
+	}
+}
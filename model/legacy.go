@@ -0,0 +1,530 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"gorm.io/gorm"
+)
+
+// migrationBatchSize is the number of rows copied at a time by the
+// migrations that convert tables.
+const migrationBatchSize = 500
+
+// binarySchemaUp converts the legacy tables of migration 1 to the tables of
+// migration 2. The legacy tables are renamed out of the way, their rows are
+// copied into the new tables with their IDs, and they are dropped. Rows with
+// the hash of an earlier block or transaction, which the legacy tables did
+// not prevent, are merged into it. The blocks are left incomplete, so that
+// CheckConsistency verifies them against their merkle roots.
+func binarySchemaUp(tx *gorm.DB) error {
+	for _, table := range []string{"blocks", "transactions", "out_points"} {
+		if err := renameTable(tx, table, "legacy_"+table); err != nil {
+			return err
+		}
+	}
+	// The outpoints of a transaction are looked up to rebuild it
+	for _, column := range []string{"spending_tx_id", "funding_tx_id"} {
+		if err := tx.Exec(fmt.Sprintf("CREATE INDEX idx_legacy_out_points_%s ON legacy_out_points (%s)", column, column)).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Migrator().CreateTable(&blockV2{}, &transactionV2{}, &outPointV2{}, &broadcastTxV2{}, &evictedTxV2{}, &feeStatV2{}, &blockFilterV2{}); err != nil {
+		return err
+	}
+
+	// Blocks stored again were left orphaned, so a block on the main chain
+	// is kept over its copies, and otherwise the last one stored
+	blocks, err := duplicateRows(tx, "legacy_blocks", "is_orphan, id DESC")
+	if err != nil {
+		return err
+	}
+	// Outpoints were attached to the first transaction with their hash
+	txs, err := duplicateRows(tx, "legacy_transactions", "id")
+	if err != nil {
+		return err
+	}
+	if err := copyBlocksV2(tx, blocks); err != nil {
+		return err
+	}
+	if err := copyTransactionsV2(tx, blocks, txs); err != nil {
+		return err
+	}
+	if err := copyOutPointsV2(tx, txs); err != nil {
+		return err
+	}
+	if err := resetSequences(tx, "blocks", "transactions", "out_points"); err != nil {
+		return err
+	}
+	return dropTables(tx, "legacy_out_points", "legacy_transactions", "legacy_blocks")
+}
+
+// binarySchemaDown converts the tables of migration 2 back to the legacy
+// tables, dropping the tables the legacy schema does not have and the
+// columns it does not have.
+func binarySchemaDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable(&blockFilterV2{}, &feeStatV2{}, &evictedTxV2{}, &broadcastTxV2{}); err != nil {
+		return err
+	}
+	for _, table := range []string{"blocks", "transactions", "out_points"} {
+		if err := renameTable(tx, table, "binary_"+table); err != nil {
+			return err
+		}
+	}
+	if err := tx.Migrator().CreateTable(&blockV1{}, &transactionV1{}, &outPointV1{}); err != nil {
+		return err
+	}
+	if err := copyBlocksV1(tx); err != nil {
+		return err
+	}
+	if err := copyTransactionsV1(tx); err != nil {
+		return err
+	}
+	if err := copyOutPointsV1(tx); err != nil {
+		return err
+	}
+	if err := resetSequences(tx, "blocks", "transactions", "out_points"); err != nil {
+		return err
+	}
+	return dropTables(tx, "binary_out_points", "binary_transactions", "binary_blocks")
+}
+
+// renameTable renames a table, dropping the index GORM creates on the
+// deleted_at column of every table first, so that the table replacing it
+// can create its own.
+func renameTable(tx *gorm.DB, from, to string) error {
+	index := "idx_" + from + "_deleted_at"
+	if tx.Migrator().HasIndex(from, index) {
+		if err := tx.Migrator().DropIndex(from, index); err != nil {
+			return err
+		}
+	}
+	return tx.Migrator().RenameTable(from, to)
+}
+
+// dropTables drops the tables in the given order, which DropTable does not
+// keep for tables given by name.
+func dropTables(tx *gorm.DB, tables ...string) error {
+	for _, table := range tables {
+		if err := tx.Migrator().DropTable(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetSequences moves the ID sequences of the tables past the rows copied
+// into them, which postgres does not do for rows inserted with their IDs.
+func resetSequences(tx *gorm.DB, tables ...string) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, table := range tables {
+		if err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s", table, table)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// duplicateRows maps the rows of a legacy table that have the hash of
+// another row to that row, which is the first of them in the given order.
+func duplicateRows(tx *gorm.DB, table, order string) (map[uint]uint, error) {
+	rows := []struct {
+		ID   uint
+		Hash string
+	}{}
+	duplicated := tx.Table(table).Select("hash").Group("hash").Having("COUNT(*) > 1")
+	if err := tx.Table(table).Select("id", "hash").Where("hash IN (?)", duplicated).
+		Order("hash, " + order).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	kept := map[string]uint{}
+	duplicates := map[uint]uint{}
+	for _, row := range rows {
+		if id, ok := kept[row.Hash]; ok {
+			duplicates[row.ID] = id
+			continue
+		}
+		kept[row.Hash] = row.ID
+	}
+	return duplicates, nil
+}
+
+// inBatches calls batch with zero and then with the ID it last returned,
+// until it returns zero.
+func inBatches(batch func(after uint) (uint, error)) error {
+	after := uint(0)
+	for {
+		last, err := batch(after)
+		if err != nil || last == 0 {
+			return err
+		}
+		after = last
+	}
+}
+
+// nextRows reads the rows of a table after the given ID into rows, deleted
+// or not.
+func nextRows(tx *gorm.DB, table string, after uint, rows interface{}) error {
+	return tx.Unscoped().Table(table).Where("id > ?", after).Order("id").Limit(migrationBatchSize).Find(rows).Error
+}
+
+func copyBlocksV2(tx *gorm.DB, duplicates map[uint]uint) error {
+	return inBatches(func(after uint) (uint, error) {
+		rows := []blockV1{}
+		if err := nextRows(tx, "legacy_blocks", after, &rows); err != nil || len(rows) == 0 {
+			return 0, err
+		}
+		blocks := []blockV2{}
+		for _, row := range rows {
+			if _, ok := duplicates[row.ID]; ok {
+				continue
+			}
+			blocks = append(blocks, blockV2{
+				Model:    row.Model,
+				Hash:     Hash(row.Hash),
+				Height:   row.Height,
+				IsOrphan: row.IsOrphan,
+
+				PreviousBlock: Hash(row.PreviousBlock),
+				Version:       row.Version,
+				Nonce:         row.Nonce,
+				Timestamp:     row.Timestamp,
+				Bits:          row.Bits,
+				MerkleRoot:    Hash(row.MerkleRoot),
+			})
+		}
+		if len(blocks) > 0 {
+			if err := tx.Create(&blocks).Error; err != nil {
+				return 0, err
+			}
+		}
+		return rows[len(rows)-1].ID, nil
+	})
+}
+
+func copyTransactionsV2(tx *gorm.DB, blocks, duplicates map[uint]uint) error {
+	// Unconfirmed transactions are taken to have entered the mempool at the
+	// tip when they were stored
+	var tip int32
+	if err := tx.Table("legacy_blocks").Select("COALESCE(MAX(height), 0)").Where("is_orphan = ?", false).Scan(&tip).Error; err != nil {
+		return err
+	}
+	return inBatches(func(after uint) (uint, error) {
+		rows := []transactionV1{}
+		if err := nextRows(tx, "legacy_transactions", after, &rows); err != nil || len(rows) == 0 {
+			return 0, err
+		}
+		ids := make([]uint, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+		}
+		msgTxs, err := legacyMsgTxs(tx, rows, ids)
+		if err != nil {
+			return 0, err
+		}
+
+		transactions := []transactionV2{}
+		for _, row := range rows {
+			if _, ok := duplicates[row.ID]; ok {
+				continue
+			}
+			transaction := transactionV2{
+				Model:    row.Model,
+				Hash:     Hash(row.Hash),
+				LockTime: row.LockTime,
+				Version:  row.Version,
+				Safe:     row.Safe,
+
+				BlockHash:  Hash(row.BlockHash),
+				BlockIndex: row.BlockIndex,
+			}
+			if row.BlockID != 0 {
+				blockID := row.BlockID
+				if id, ok := blocks[blockID]; ok {
+					blockID = id
+				}
+				transaction.BlockID = &blockID
+			} else {
+				transaction.FirstSeen = row.CreatedAt
+				transaction.FirstSeenHeight = tip
+			}
+			if msgTx, ok := msgTxs[row.ID]; ok {
+				transaction.WitnessHash = Hash(msgTx.msgTx.WitnessHash().String())
+				transaction.Size = msgTx.msgTx.SerializeSize()
+				transaction.Weight = 3*msgTx.msgTx.SerializeSizeStripped() + msgTx.msgTx.SerializeSize()
+				transaction.Fee = msgTx.fee
+			}
+			transactions = append(transactions, transaction)
+		}
+		if len(transactions) > 0 {
+			if err := tx.Create(&transactions).Error; err != nil {
+				return 0, err
+			}
+		}
+		return rows[len(rows)-1].ID, nil
+	})
+}
+
+type legacyMsgTx struct {
+	msgTx *wire.MsgTx
+	fee   int64
+}
+
+// legacyMsgTxs rebuilds the transactions with the given IDs that have
+// inputs from their legacy outpoints, along with their fees, which the
+// legacy tables did not store.
+func legacyMsgTxs(tx *gorm.DB, rows []transactionV1, ids []uint) (map[uint]legacyMsgTx, error) {
+	inputs := []outPointV1{}
+	if err := tx.Unscoped().Table("legacy_out_points").Where("spending_tx_id IN ?", ids).Order("spending_tx_index").Find(&inputs).Error; err != nil {
+		return nil, err
+	}
+	outputs := []outPointV1{}
+	if err := tx.Unscoped().Table("legacy_out_points").Where("funding_tx_id IN ?", ids).Order("funding_tx_index").Find(&outputs).Error; err != nil {
+		return nil, err
+	}
+
+	msgTxs := map[uint]legacyMsgTx{}
+	for _, row := range rows {
+		msgTx := wire.NewMsgTx(row.Version)
+		msgTx.LockTime = row.LockTime
+		msgTxs[row.ID] = legacyMsgTx{msgTx: msgTx}
+	}
+	for _, input := range inputs {
+		hash, err := chainhash.NewHashFromStr(input.FundingTxHash)
+		if err != nil {
+			return nil, fmt.Errorf("outpoint %d: %w", input.ID, err)
+		}
+		signatureScript, err := hex.DecodeString(input.SignatureScript)
+		if err != nil {
+			return nil, fmt.Errorf("outpoint %d: %w", input.ID, err)
+		}
+		witness, err := legacyWitness(input.Witness)
+		if err != nil {
+			return nil, fmt.Errorf("outpoint %d: %w", input.ID, err)
+		}
+		txIn := wire.NewTxIn(wire.NewOutPoint(hash, input.FundingTxIndex), signatureScript, witness)
+		txIn.Sequence = input.Sequence
+		msgTx := msgTxs[input.SpendingTxID]
+		msgTx.msgTx.AddTxIn(txIn)
+		msgTx.fee += input.Value
+		msgTxs[input.SpendingTxID] = msgTx
+	}
+	for _, output := range outputs {
+		pkScript, err := hex.DecodeString(output.PkScript)
+		if err != nil {
+			return nil, fmt.Errorf("outpoint %d: %w", output.ID, err)
+		}
+		msgTx := msgTxs[output.FundingTxID]
+		msgTx.msgTx.AddTxOut(wire.NewTxOut(output.Value, pkScript))
+		msgTx.fee -= output.Value
+		msgTxs[output.FundingTxID] = msgTx
+	}
+
+	// Transactions without inputs, like the placeholder for the genesis
+	// coinbase, are left without a size
+	for id, msgTx := range msgTxs {
+		switch {
+		case len(msgTx.msgTx.TxIn) == 0:
+			delete(msgTxs, id)
+		case blockchain.IsCoinBaseTx(msgTx.msgTx):
+			msgTx.fee = 0
+			msgTxs[id] = msgTx
+		}
+	}
+	return msgTxs, nil
+}
+
+func copyOutPointsV2(tx *gorm.DB, duplicates map[uint]uint) error {
+	return inBatches(func(after uint) (uint, error) {
+		rows := []outPointV1{}
+		if err := nextRows(tx, "legacy_out_points", after, &rows); err != nil || len(rows) == 0 {
+			return 0, err
+		}
+		outPoints := []outPointV2{}
+		for _, row := range rows {
+			// The outputs of a merged transaction are those of the
+			// transaction it was merged into
+			if _, ok := duplicates[row.FundingTxID]; ok {
+				continue
+			}
+			signatureScript, err := hex.DecodeString(row.SignatureScript)
+			if err != nil {
+				return 0, fmt.Errorf("outpoint %d: %w", row.ID, err)
+			}
+			witness, err := legacyWitness(row.Witness)
+			if err != nil {
+				return 0, fmt.Errorf("outpoint %d: %w", row.ID, err)
+			}
+			pkScript, err := hex.DecodeString(row.PkScript)
+			if err != nil {
+				return 0, fmt.Errorf("outpoint %d: %w", row.ID, err)
+			}
+			outPoint := outPointV2{
+				Model:           row.Model,
+				SpendingTxHash:  Hash(row.SpendingTxHash),
+				SpendingTxIndex: row.SpendingTxIndex,
+				Sequence:        row.Sequence,
+				SignatureScript: signatureScript,
+				Witness:         Witness(witness),
+
+				FundingTxHash:  Hash(row.FundingTxHash),
+				FundingTxIndex: row.FundingTxIndex,
+				PkScript:       pkScript,
+				Value:          row.Value,
+				Spender:        row.Spender,
+				Type:           row.Type,
+			}
+			if row.SpendingTxID != 0 {
+				spendingTxID := row.SpendingTxID
+				if id, ok := duplicates[spendingTxID]; ok {
+					spendingTxID = id
+				}
+				outPoint.SpendingTxID = &spendingTxID
+			}
+			if row.FundingTxID != 0 {
+				fundingTxID := row.FundingTxID
+				outPoint.FundingTxID = &fundingTxID
+				outPoint.ScriptHash = scriptHash(pkScript)
+			}
+			outPoints = append(outPoints, outPoint)
+		}
+		if len(outPoints) > 0 {
+			if err := tx.Create(&outPoints).Error; err != nil {
+				return 0, err
+			}
+		}
+		return rows[len(rows)-1].ID, nil
+	})
+}
+
+// legacyWitness decodes a witness stored as its hex items joined by commas.
+func legacyWitness(s string) (wire.TxWitness, error) {
+	if s == "" {
+		return nil, nil
+	}
+	items := strings.Split(s, ",")
+	witness := make(wire.TxWitness, len(items))
+	for i, item := range items {
+		var err error
+		if witness[i], err = hex.DecodeString(item); err != nil {
+			return nil, err
+		}
+	}
+	return witness, nil
+}
+
+// scriptHash is the Electrum script hash of an output script, the reversed
+// SHA256 of the script in hex.
+func scriptHash(pkScript []byte) Hash {
+	hash := sha256.Sum256(pkScript)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return Hash(hex.EncodeToString(hash[:]))
+}
+
+func copyBlocksV1(tx *gorm.DB) error {
+	return inBatches(func(after uint) (uint, error) {
+		rows := []blockV2{}
+		if err := nextRows(tx, "binary_blocks", after, &rows); err != nil || len(rows) == 0 {
+			return 0, err
+		}
+		blocks := make([]blockV1, len(rows))
+		for i, row := range rows {
+			blocks[i] = blockV1{
+				Model:    row.Model,
+				Hash:     string(row.Hash),
+				Height:   row.Height,
+				IsOrphan: row.IsOrphan,
+
+				PreviousBlock: string(row.PreviousBlock),
+				Version:       row.Version,
+				Nonce:         row.Nonce,
+				Timestamp:     row.Timestamp,
+				Bits:          row.Bits,
+				MerkleRoot:    string(row.MerkleRoot),
+			}
+		}
+		if err := tx.Create(&blocks).Error; err != nil {
+			return 0, err
+		}
+		return rows[len(rows)-1].ID, nil
+	})
+}
+
+func copyTransactionsV1(tx *gorm.DB) error {
+	return inBatches(func(after uint) (uint, error) {
+		rows := []transactionV2{}
+		if err := nextRows(tx, "binary_transactions", after, &rows); err != nil || len(rows) == 0 {
+			return 0, err
+		}
+		transactions := make([]transactionV1, len(rows))
+		for i, row := range rows {
+			transactions[i] = transactionV1{
+				Model:    row.Model,
+				Hash:     string(row.Hash),
+				LockTime: row.LockTime,
+				Version:  row.Version,
+				Safe:     row.Safe,
+
+				BlockHash:  string(row.BlockHash),
+				BlockIndex: row.BlockIndex,
+			}
+			if row.BlockID != nil {
+				transactions[i].BlockID = *row.BlockID
+			}
+		}
+		if err := tx.Create(&transactions).Error; err != nil {
+			return 0, err
+		}
+		return rows[len(rows)-1].ID, nil
+	})
+}
+
+func copyOutPointsV1(tx *gorm.DB) error {
+	return inBatches(func(after uint) (uint, error) {
+		rows := []outPointV2{}
+		if err := nextRows(tx, "binary_out_points", after, &rows); err != nil || len(rows) == 0 {
+			return 0, err
+		}
+		outPoints := make([]outPointV1, len(rows))
+		for i, row := range rows {
+			witness := make([]string, len(row.Witness))
+			for j, item := range row.Witness {
+				witness[j] = hex.EncodeToString(item)
+			}
+			outPoints[i] = outPointV1{
+				Model:           row.Model,
+				SpendingTxHash:  string(row.SpendingTxHash),
+				SpendingTxIndex: row.SpendingTxIndex,
+				Sequence:        row.Sequence,
+				SignatureScript: hex.EncodeToString(row.SignatureScript),
+				Witness:         strings.Join(witness, ","),
+
+				FundingTxHash:  string(row.FundingTxHash),
+				FundingTxIndex: row.FundingTxIndex,
+				PkScript:       hex.EncodeToString(row.PkScript),
+				Value:          row.Value,
+				Spender:        row.Spender,
+				Type:           row.Type,
+			}
+			if row.SpendingTxID != nil {
+				outPoints[i].SpendingTxID = *row.SpendingTxID
+			}
+			if row.FundingTxID != nil {
+				outPoints[i].FundingTxID = *row.FundingTxID
+			}
+		}
+		if err := tx.Create(&outPoints).Error; err != nil {
+			return 0, err
+		}
+		return rows[len(rows)-1].ID, nil
+	})
+}
@@ -0,0 +1,205 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a numbered change to the database schema. Up applies it and
+// Down reverts it, each in the transaction recording the change in the
+// schema_version table. Migrations must not depend on the current models,
+// which describe the latest schema, but define the tables and columns they
+// change themselves.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// AppliedMigration is a row of the schema_version table, recording a
+// migration applied to the database.
+type AppliedMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (AppliedMigration) TableName() string {
+	return "schema_version"
+}
+
+// ErrIncompatibleSchema is returned when the database is not at the version
+// of the schema the indexer uses.
+var ErrIncompatibleSchema = errors.New("incompatible database schema")
+
+// legacyVersion is the version of the databases created by AutoMigrate
+// before the schema was versioned, whose tables are those of migration 1.
+const legacyVersion = 1
+
+// Migrations returns the migrations in the order they are applied.
+func Migrations() []Migration {
+	return migrations
+}
+
+// SchemaVersion returns the version of the schema the indexer uses, the
+// version of the last migration.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Open opens the database without checking its schema, for commands that
+// migrate it.
+func Open(dialector gorm.Dialector, opts ...gorm.Option) (*gorm.DB, error) {
+	return gorm.Open(dialector, opts...)
+}
+
+// NewDB opens the database and refuses to use it unless its schema is at
+// the version the indexer uses.
+func NewDB(dialector gorm.Dialector, opts ...gorm.Option) (*gorm.DB, error) {
+	db, err := Open(dialector, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSchema(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// CheckSchema returns an error wrapping ErrIncompatibleSchema unless the
+// database schema is at the version the indexer uses.
+func CheckSchema(db *gorm.DB) error {
+	version, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version < SchemaVersion():
+		return fmt.Errorf("%w: database is at version %d, migrate it up to version %d", ErrIncompatibleSchema, version, SchemaVersion())
+	case version > SchemaVersion():
+		return fmt.Errorf("%w: database is at version %d, newer than version %d this indexer supports", ErrIncompatibleSchema, version, SchemaVersion())
+	}
+	return nil
+}
+
+// CurrentVersion returns the version of the last migration applied to the
+// database, zero for an empty database and the version of the legacy schema
+// for a database created before the schema was versioned.
+func CurrentVersion(db *gorm.DB) (int, error) {
+	if !db.Migrator().HasTable(&AppliedMigration{}) {
+		if db.Migrator().HasTable("blocks") {
+			return legacyVersion, nil
+		}
+		return 0, nil
+	}
+	var version int
+	if err := db.Model(&AppliedMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// AppliedMigrations returns the migrations recorded as applied to the
+// database by version, none for a database created before the schema was
+// versioned until it is migrated.
+func AppliedMigrations(db *gorm.DB) ([]AppliedMigration, error) {
+	applied := []AppliedMigration{}
+	if !db.Migrator().HasTable(&AppliedMigration{}) {
+		return applied, nil
+	}
+	if err := db.Order("version").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// MigrateUp applies the migrations up to and including the target version.
+func MigrateUp(db *gorm.DB, target int) error {
+	if target > SchemaVersion() {
+		return fmt.Errorf("no migration to version %d, the latest is %d", target, SchemaVersion())
+	}
+	version, err := versionSchema(db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.Version <= version || migration.Version > target {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&AppliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}); err != nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the migrations after the target version.
+func MigrateDown(db *gorm.DB, target int) error {
+	if target < 0 {
+		return fmt.Errorf("invalid version %d", target)
+	}
+	version, err := versionSchema(db)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion() {
+		return fmt.Errorf("database is at version %d, newer than version %d this indexer can revert", version, SchemaVersion())
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version > version || migration.Version <= target {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&AppliedMigration{}, migration.Version).Error
+		}); err != nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// versionSchema creates the schema_version table unless the database has
+// one, recording the migrations up to the legacy version as applied for a
+// database created before the schema was versioned, and returns the current
+// version.
+func versionSchema(db *gorm.DB) (int, error) {
+	version, err := CurrentVersion(db)
+	if err != nil || db.Migrator().HasTable(&AppliedMigration{}) {
+		return version, err
+	}
+	return version, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().CreateTable(&AppliedMigration{}); err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if migration.Version > version {
+				break
+			}
+			if err := tx.Create(&AppliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
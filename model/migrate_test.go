@@ -0,0 +1,356 @@
+package model
+
+import (
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T, name string) *gorm.DB {
+	db, err := Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared&_foreign_keys=1"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// sqliteSchema describes the tables of a sqlite database other than
+// schema_version: their columns, foreign keys and indexes.
+func sqliteSchema(t *testing.T, db *gorm.DB) map[string][]string {
+	tables := []string{}
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name <> 'schema_version'").Scan(&tables).Error; err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string][]string{}
+	for _, table := range tables {
+		desc := []string{}
+		columns := []struct {
+			Name      string
+			Type      string
+			NotNull   bool
+			DfltValue *string
+			PK        int
+		}{}
+		if err := db.Raw("SELECT name, type, \"notnull\" AS not_null, dflt_value, pk FROM pragma_table_info(?)", table).Scan(&columns).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, column := range columns {
+			column.Name = "column " + column.Name + " " + column.Type
+			if column.DfltValue != nil {
+				column.Name += " DEFAULT " + *column.DfltValue
+			}
+			if column.NotNull {
+				column.Name += " NOT NULL"
+			}
+			if column.PK > 0 {
+				column.Name += " PRIMARY KEY"
+			}
+			desc = append(desc, column.Name)
+		}
+		foreignKeys := []struct {
+			Table string
+			From  string
+			To    string
+		}{}
+		if err := db.Raw("SELECT \"table\", \"from\", \"to\" FROM pragma_foreign_key_list(?)", table).Scan(&foreignKeys).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, fk := range foreignKeys {
+			desc = append(desc, "foreign key "+fk.From+" references "+fk.Table+"("+fk.To+")")
+		}
+		indexes := []string{}
+		if err := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Scan(&indexes).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, index := range indexes {
+			desc = append(desc, "index "+index)
+		}
+		sort.Strings(desc)
+		schema[table] = desc
+	}
+	return schema
+}
+
+func TestMigrationsMatchModels(t *testing.T) {
+	migrated := newTestDB(t, t.Name()+"migrated")
+	if err := MigrateUp(migrated, SchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(migrated); err != nil {
+		t.Fatal(err)
+	}
+	models := newTestDB(t, t.Name()+"models")
+	if err := models.Migrator().CreateTable(&Block{}, &Transaction{}, &OutPoint{}, &BroadcastTx{}, &EvictedTx{}, &FeeStat{}, &BlockFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, want := sqliteSchema(t, migrated), sqliteSchema(t, models)
+	if len(got) != len(want) {
+		t.Errorf("got tables %v, want %v", reflect.ValueOf(got).MapKeys(), reflect.ValueOf(want).MapKeys())
+	}
+	for table, desc := range want {
+		if !reflect.DeepEqual(got[table], desc) {
+			t.Errorf("table %s\ngot  %q\nwant %q", table, got[table], desc)
+		}
+	}
+
+	// Reverting every migration leaves only the schema_version table
+	if err := MigrateDown(migrated, 0); err != nil {
+		t.Fatal(err)
+	}
+	if schema := sqliteSchema(t, migrated); len(schema) != 0 {
+		t.Fatalf("got tables %v after reverting every migration, want none", schema)
+	}
+}
+
+// putLegacyTx stores a transaction the way the indexer did before the schema
+// was versioned.
+func putLegacyTx(t *testing.T, db *gorm.DB, tx *wire.MsgTx, block *blockV1, index uint32) *transactionV1 {
+	transaction := &transactionV1{
+		Hash:     tx.TxHash().String(),
+		LockTime: tx.LockTime,
+		Version:  tx.Version,
+	}
+	if block != nil {
+		transaction.BlockID = block.ID
+		transaction.BlockHash = block.Hash
+		transaction.BlockIndex = index
+	}
+	if err := db.Create(transaction).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, txIn := range tx.TxIn {
+		witness := make([]string, len(txIn.Witness))
+		for j, item := range txIn.Witness {
+			witness[j] = hex.EncodeToString(item)
+		}
+		outPoint := &outPointV1{}
+		if txIn.PreviousOutPoint.Index != wire.MaxPrevOutIndex {
+			if err := db.First(outPoint, "funding_tx_hash = ? AND funding_tx_index = ?", txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index).Error; err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			outPoint.FundingTxHash = txIn.PreviousOutPoint.Hash.String()
+			outPoint.FundingTxIndex = txIn.PreviousOutPoint.Index
+		}
+		outPoint.SpendingTxID = transaction.ID
+		outPoint.SpendingTxHash = transaction.Hash
+		outPoint.SpendingTxIndex = uint32(i)
+		outPoint.Sequence = txIn.Sequence
+		outPoint.SignatureScript = hex.EncodeToString(txIn.SignatureScript)
+		outPoint.Witness = strings.Join(witness, ",")
+		if err := db.Save(outPoint).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, txOut := range tx.TxOut {
+		if err := db.Create(&outPointV1{
+			FundingTxID:    transaction.ID,
+			FundingTxHash:  transaction.Hash,
+			FundingTxIndex: uint32(i),
+			PkScript:       hex.EncodeToString(txOut.PkScript),
+			Value:          txOut.Value,
+			Spender:        "spender",
+			Type:           "witness_v0_keyhash",
+		}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return transaction
+}
+
+func TestMigrateLegacySchema(t *testing.T) {
+	db := newTestDB(t, t.Name())
+	if err := db.AutoMigrate(&blockV1{}, &transactionV1{}, &outPointV1{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(db); err == nil {
+		t.Fatal("a legacy database passes the schema check")
+	}
+
+	pkScript := append([]byte{0x00, 0x14}, make([]byte, 20)...)
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{0x51, 0x01}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50e8, pkScript))
+	spend := wire.NewMsgTx(2)
+	coinbaseHash := coinbase.TxHash()
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&coinbaseHash, 0), nil, wire.TxWitness{{0x30, 0x44}, {0x02, 0x03}}))
+	spend.TxIn[0].Sequence = wire.MaxTxInSequenceNum - 2
+	spend.AddTxOut(wire.NewTxOut(49e8, pkScript))
+
+	// The genesis block and its placeholder transaction were stored again
+	// with every block following it
+	genesis := &blockV1{Hash: strings.Repeat("01", 32), PreviousBlock: strings.Repeat("00", 32), MerkleRoot: strings.Repeat("02", 32), Timestamp: time.Unix(1296688602, 0)}
+	genesisCopy := *genesis
+	block := &blockV1{Hash: strings.Repeat("03", 32), Height: 1, PreviousBlock: genesis.Hash, MerkleRoot: coinbaseHash.String(), Timestamp: time.Unix(1296688802, 0)}
+	for _, row := range []interface{}{genesis, &transactionV1{Hash: strings.Repeat("00", 32)}, &genesisCopy, &transactionV1{Hash: strings.Repeat("00", 32)}, block} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	putLegacyTx(t, db, coinbase, block, 0)
+	putLegacyTx(t, db, spend, nil, 0)
+
+	legacy := []outPointV1{}
+	if err := db.Order("id").Find(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if version, err := CurrentVersion(db); err != nil || version != legacyVersion {
+		t.Fatalf("got version %d and error %v for a legacy database, want %d", version, err, legacyVersion)
+	}
+	if err := MigrateUp(db, SchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSchema(db); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := []Block{}
+	if err := db.Order("id").Find(&blocks).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].ID != genesisCopy.ID || string(blocks[0].Hash) != genesis.Hash || string(blocks[1].Hash) != block.Hash || blocks[1].Height != 1 || blocks[1].Complete {
+		t.Fatalf("got blocks %+v, want the genesis block once and block 1 incomplete", blocks)
+	}
+	if string(blocks[1].PreviousBlock) != genesis.Hash || string(blocks[1].MerkleRoot) != block.MerkleRoot || !blocks[1].Timestamp.Equal(block.Timestamp) {
+		t.Fatalf("got header %+v, want %+v", blocks[1], block)
+	}
+	if blocks[0].ChainTxCount != 0 || blocks[1].ChainTxCount != 1 {
+		t.Fatalf("got chain transaction counts %d and %d, want 0 and 1", blocks[0].ChainTxCount, blocks[1].ChainTxCount)
+	}
+
+	transactions := []Transaction{}
+	if err := db.Order("id").Find(&transactions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 3 || string(transactions[0].Hash) != strings.Repeat("00", 32) || transactions[0].Size != 0 {
+		t.Fatalf("got transactions %+v, want the placeholder once, the coinbase and its spend", transactions)
+	}
+	for i, want := range []struct {
+		tx      *wire.MsgTx
+		blockID *uint
+		fee     int64
+	}{
+		{coinbase, &block.ID, 0},
+		{spend, nil, 1e8},
+	} {
+		got := transactions[i+1]
+		if string(got.Hash) != want.tx.TxHash().String() || string(got.WitnessHash) != want.tx.WitnessHash().String() ||
+			got.Size != want.tx.SerializeSize() || got.Weight != 3*want.tx.SerializeSizeStripped()+want.tx.SerializeSize() || got.Fee != want.fee {
+			t.Fatalf("got transaction %+v, want %s with fee %d", got, want.tx.TxHash(), want.fee)
+		}
+		if !reflect.DeepEqual(got.BlockID, want.blockID) {
+			t.Fatalf("got block ID %v for %s, want %v", got.BlockID, got.Hash, want.blockID)
+		}
+	}
+	if unconfirmed := transactions[2]; unconfirmed.FirstSeenHeight != 1 || unconfirmed.FirstSeen.IsZero() {
+		t.Fatalf("got unconfirmed transaction first seen at height %d on %v, want height 1", unconfirmed.FirstSeenHeight, unconfirmed.FirstSeen)
+	}
+
+	outPoints := []OutPoint{}
+	if err := db.Order("id").Find(&outPoints).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(outPoints) != 3 {
+		t.Fatalf("got %d outpoints, want 3", len(outPoints))
+	}
+	input, spent, unspent := outPoints[0], outPoints[1], outPoints[2]
+	if input.FundingTxID != nil || input.SpendingTxID == nil || *input.SpendingTxID != transactions[1].ID ||
+		!reflect.DeepEqual(input.SignatureScript, coinbase.TxIn[0].SignatureScript) || input.Witness != nil {
+		t.Fatalf("got coinbase input %+v", input)
+	}
+	if spent.FundingTxID == nil || *spent.FundingTxID != transactions[1].ID || spent.SpendingTxID == nil || *spent.SpendingTxID != transactions[2].ID ||
+		!reflect.DeepEqual(wire.TxWitness(spent.Witness), spend.TxIn[0].Witness) || spent.Sequence != spend.TxIn[0].Sequence ||
+		!reflect.DeepEqual(spent.PkScript, pkScript) || spent.ScriptHash != scriptHash(pkScript) {
+		t.Fatalf("got spent output %+v", spent)
+	}
+	if unspent.SpendingTxID != nil || unspent.SpendingTxHash != "" || *unspent.FundingTxID != transactions[2].ID || unspent.Value != 49e8 {
+		t.Fatalf("got unspent output %+v", unspent)
+	}
+
+	// New rows do not reuse the IDs of the copied ones
+	if err := db.Create(&Block{Hash: Hash(strings.Repeat("04", 32)), Height: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Reverting the conversion restores the legacy rows that were not merged
+	if err := MigrateDown(db, legacyVersion); err != nil {
+		t.Fatal(err)
+	}
+	reverted := []outPointV1{}
+	if err := db.Order("id").Find(&reverted).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := range legacy {
+		legacy[i].Model, reverted[i].Model = gorm.Model{ID: legacy[i].ID}, gorm.Model{ID: reverted[i].ID}
+	}
+	if !reflect.DeepEqual(reverted, legacy) {
+		t.Fatalf("got outpoints %+v after reverting, want %+v", reverted, legacy)
+	}
+	if err := MigrateUp(db, SchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateDuplicateCoinbase(t *testing.T) {
+	db := newTestDB(t, t.Name())
+	if err := db.AutoMigrate(&blockV1{}, &transactionV1{}, &outPointV1{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 2 repeats the coinbase of block 1, as blocks 91842 and 91880 of
+	// mainnet did, and the coinbase was moved to it
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{0x51, 0x01}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50e8, append([]byte{0x00, 0x14}, make([]byte, 20)...)))
+	genesis := &blockV1{Hash: strings.Repeat("01", 32), PreviousBlock: strings.Repeat("00", 32), MerkleRoot: strings.Repeat("02", 32)}
+	first := &blockV1{Hash: strings.Repeat("03", 32), Height: 1, PreviousBlock: genesis.Hash, MerkleRoot: coinbase.TxHash().String()}
+	second := &blockV1{Hash: strings.Repeat("04", 32), Height: 2, PreviousBlock: first.Hash, MerkleRoot: coinbase.TxHash().String()}
+	for _, block := range []*blockV1{genesis, first, second} {
+		if err := db.Create(block).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	putLegacyTx(t, db, coinbase, second, 0)
+
+	if err := MigrateUp(db, SchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	transactions := []Transaction{}
+	if err := db.Find(&transactions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].BlockID == nil || *transactions[0].BlockID != second.ID || transactions[0].Size != coinbase.SerializeSize() {
+		t.Fatalf("got transactions %+v, want the coinbase once in block 2", transactions)
+	}
+	outPoints := int64(0)
+	if err := db.Model(&OutPoint{}).Count(&outPoints).Error; err != nil || outPoints != 2 {
+		t.Fatalf("got %d outpoints (%v), want the input and output of the coinbase", outPoints, err)
+	}
+
+	// Block 1 still counts its coinbase
+	blocks := []Block{}
+	if err := db.Order("height").Find(&blocks).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range blocks {
+		if block.ChainTxCount != int64(i) || (i > 0 && block.Complete) {
+			t.Fatalf("got block %d complete %v with %d chain transactions, want it incomplete with %d", block.Height, block.Complete, block.ChainTxCount, i)
+		}
+	}
+}
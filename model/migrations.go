@@ -0,0 +1,248 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var migrations = []Migration{
+	{
+		// The tables GORM's AutoMigrate created before the schema was
+		// versioned, databases created then are at this version.
+		Version: 1,
+		Name:    "legacy schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&blockV1{}, &transactionV1{}, &outPointV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&outPointV1{}, &transactionV1{}, &blockV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "binary columns and indexes",
+		Up:      binarySchemaUp,
+		Down:    binarySchemaDown,
+	},
+	{
+		Version: 3,
+		Name:    "chain transaction counts",
+		Up:      chainTxCountUp,
+		Down: func(tx *gorm.DB) error {
+			// DropColumn recreates sqlite tables, which the foreign keys
+			// referencing blocks do not allow
+			return tx.Exec("ALTER TABLE blocks DROP COLUMN chain_tx_count").Error
+		},
+	},
+}
+
+// chainTxCountUp adds the number of transactions in the main chain up to
+// each block, counting the transactions stored for the main chain blocks.
+func chainTxCountUp(tx *gorm.DB) error {
+	if err := tx.Migrator().AddColumn(&blockV3{}, "ChainTxCount"); err != nil {
+		return err
+	}
+	blocks := []struct {
+		ID     uint
+		Height int32
+		Txs    int64
+	}{}
+	if err := tx.Table("blocks").
+		Select("blocks.id, blocks.height, COUNT(transactions.id) AS txs").
+		Joins("LEFT JOIN transactions ON transactions.block_id = blocks.id AND transactions.deleted_at IS NULL").
+		Where("blocks.is_orphan = ? AND blocks.deleted_at IS NULL", false).
+		Group("blocks.id, blocks.height").Order("blocks.height").
+		Scan(&blocks).Error; err != nil {
+		return err
+	}
+	count := int64(0)
+	for _, block := range blocks {
+		// A coinbase that a later block repeated before BIP30 is stored
+		// with that block, the earlier block still has it
+		if block.Txs == 0 && block.Height > 0 {
+			block.Txs = 1
+		}
+		count += block.Txs
+		if err := tx.Table("blocks").Where("id = ?", block.ID).Update("chain_tx_count", count).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The tables as created by migration 1. Hashes and scripts are hex strings,
+// the items of a witness are joined by commas, and zero IDs stand for no
+// block or transaction.
+
+type blockV1 struct {
+	gorm.Model
+
+	Hash     string
+	Height   int32
+	IsOrphan bool
+
+	PreviousBlock string
+	Version       int32
+	Nonce         uint32
+	Timestamp     time.Time
+	Bits          uint32
+	MerkleRoot    string
+}
+
+func (blockV1) TableName() string { return "blocks" }
+
+type transactionV1 struct {
+	gorm.Model
+
+	Hash     string
+	LockTime uint32
+	Version  int32
+	Safe     bool
+
+	BlockID    uint
+	BlockHash  string
+	BlockIndex uint32
+}
+
+func (transactionV1) TableName() string { return "transactions" }
+
+type outPointV1 struct {
+	gorm.Model
+
+	SpendingTxID    uint
+	SpendingTxHash  string
+	SpendingTxIndex uint32
+	Sequence        uint32
+	SignatureScript string
+	Witness         string
+
+	FundingTxID    uint
+	FundingTxHash  string
+	FundingTxIndex uint32
+	PkScript       string
+	Value          int64
+	Spender        string
+	Type           string
+}
+
+func (outPointV1) TableName() string { return "out_points" }
+
+// The tables as created by migration 2.
+
+type blockV2 struct {
+	gorm.Model
+
+	Hash     Hash  `gorm:"uniqueIndex"`
+	Height   int32 `gorm:"index"`
+	IsOrphan bool
+	Complete bool
+	Raw      []byte
+
+	PreviousBlock Hash
+	Version       int32
+	Nonce         uint32
+	Timestamp     time.Time
+	Bits          uint32
+	MerkleRoot    Hash
+}
+
+func (blockV2) TableName() string { return "blocks" }
+
+type transactionV2 struct {
+	gorm.Model
+
+	Hash        Hash `gorm:"uniqueIndex"`
+	WitnessHash Hash
+	LockTime    uint32
+	Version     int32
+	Safe        bool
+
+	Size            int
+	Weight          int
+	Fee             int64
+	FirstSeen       time.Time
+	FirstSeenHeight int32
+
+	BlockID    *uint `gorm:"index"`
+	Block      *blockV2
+	BlockHash  Hash   `gorm:"index:idx_transactions_block"`
+	BlockIndex uint32 `gorm:"index:idx_transactions_block"`
+}
+
+func (transactionV2) TableName() string { return "transactions" }
+
+type outPointV2 struct {
+	gorm.Model
+
+	SpendingTxID    *uint `gorm:"index"`
+	SpendingTx      *transactionV2
+	SpendingTxHash  Hash   `gorm:"index:idx_out_points_spending"`
+	SpendingTxIndex uint32 `gorm:"index:idx_out_points_spending"`
+	Sequence        uint32
+	SignatureScript []byte
+	Witness         Witness
+
+	FundingTxID    *uint `gorm:"index"`
+	FundingTx      *transactionV2
+	FundingTxHash  Hash   `gorm:"uniqueIndex:idx_out_points_funding,where:funding_tx_id IS NOT NULL"`
+	FundingTxIndex uint32 `gorm:"uniqueIndex:idx_out_points_funding"`
+	PkScript       []byte
+	ScriptHash     Hash `gorm:"index"`
+	Value          int64
+	Spender        string `gorm:"index"`
+	Type           string
+}
+
+func (outPointV2) TableName() string { return "out_points" }
+
+type broadcastTxV2 struct {
+	gorm.Model
+
+	Hash    Hash `gorm:"uniqueIndex"`
+	Raw     []byte
+	Relayed bool
+}
+
+func (broadcastTxV2) TableName() string { return "broadcast_txes" }
+
+type evictedTxV2 struct {
+	gorm.Model
+
+	Hash      Hash `gorm:"index"`
+	Raw       []byte
+	Reason    string
+	EvictedBy Hash `gorm:"index"`
+}
+
+func (evictedTxV2) TableName() string { return "evicted_txes" }
+
+type feeStatV2 struct {
+	gorm.Model
+
+	BlockID uint `gorm:"index"`
+	Bucket  int
+	Blocks  int32
+	Count   int64
+	FeeRate float64
+}
+
+func (feeStatV2) TableName() string { return "fee_stats" }
+
+type blockFilterV2 struct {
+	gorm.Model
+
+	BlockHash Hash `gorm:"uniqueIndex"`
+	Filter    []byte
+	Header    Hash
+}
+
+func (blockFilterV2) TableName() string { return "block_filters" }
+
+// The column added by migration 3.
+
+type blockV3 struct {
+	ChainTxCount int64
+}
+
+func (blockV3) TableName() string { return "blocks" }
@@ -9,55 +9,129 @@ import (
 type Block struct {
 	gorm.Model
 
-	Hash     string
-	Height   int32
+	Hash     Hash  `gorm:"uniqueIndex"`
+	Height   int32 `gorm:"index"`
 	IsOrphan bool
+	// Complete is set once all of the block's transactions are known to be
+	// stored, blocks written before ingestion was atomic start out unset.
+	Complete bool
+	// Raw is the serialized block while it is not on the main chain, so that
+	// it can be connected again by a reorganization.
+	Raw []byte
+	// ChainTxCount is the number of transactions in the main chain up to
+	// and including the block, without the one of the genesis block, while
+	// the block is on the main chain.
+	ChainTxCount int64
 
-	PreviousBlock string
+	PreviousBlock Hash
 	Version       int32
 	Nonce         uint32
 	Timestamp     time.Time
 	Bits          uint32
-	MerkleRoot    string
+	MerkleRoot    Hash
 }
 
 type Transaction struct {
 	gorm.Model
 
-	Hash     string
-	LockTime uint32
-	Version  int32
-	Safe     bool
+	Hash        Hash `gorm:"uniqueIndex"`
+	WitnessHash Hash
+	LockTime    uint32
+	Version     int32
+	// Safe is set for confirmed transactions, and for unconfirmed ones that
+	// neither signal replaceability nor replaced other transactions and
+	// whose unconfirmed parents are safe.
+	Safe bool
 
-	BlockID    uint
-	BlockHash  string
-	BlockIndex uint32
+	// Size and Weight are the serialized size and the BIP141 weight of the
+	// transaction, and Fee is the value of its inputs minus the value of its
+	// outputs, zero for coinbase transactions.
+	Size   int
+	Weight int
+	Fee    int64
+	// FirstSeen and FirstSeenHeight are when and at which chain height the
+	// transaction last entered the mempool.
+	FirstSeen       time.Time
+	FirstSeenHeight int32
+
+	// BlockID is nil while the transaction is unconfirmed.
+	BlockID    *uint `gorm:"index"`
+	Block      *Block
+	BlockHash  Hash   `gorm:"index:idx_transactions_block"`
+	BlockIndex uint32 `gorm:"index:idx_transactions_block"`
 }
 
+// OutPoint is an output along with the input spending it. The inputs of
+// coinbase transactions, which spend no output, are stored as outpoints with
+// no funding transaction.
 type OutPoint struct {
 	gorm.Model
 
-	SpendingTxID    uint
-	SpendingTxHash  string
-	SpendingTxIndex uint32
+	// SpendingTxID is nil while the output is unspent.
+	SpendingTxID    *uint `gorm:"index"`
+	SpendingTx      *Transaction
+	SpendingTxHash  Hash   `gorm:"index:idx_out_points_spending"`
+	SpendingTxIndex uint32 `gorm:"index:idx_out_points_spending"`
 	Sequence        uint32
-	SignatureScript string
-	Witness         string
+	SignatureScript []byte
+	Witness         Witness
 
-	FundingTxID    uint
-	FundingTxHash  string
-	FundingTxIndex uint32
-	PkScript       string
+	FundingTxID    *uint `gorm:"index"`
+	FundingTx      *Transaction
+	FundingTxHash  Hash   `gorm:"uniqueIndex:idx_out_points_funding,where:funding_tx_id IS NOT NULL"`
+	FundingTxIndex uint32 `gorm:"uniqueIndex:idx_out_points_funding"`
+	PkScript       []byte
+	ScriptHash     Hash `gorm:"index"`
 	Value          int64
-	Spender        string
+	Spender        string `gorm:"index"`
 	Type           string
 }
 
-func NewDB(dialector gorm.Dialector, opts ...gorm.Option) (*gorm.DB, error) {
-	db, err := gorm.Open(dialector, opts...)
-	if err != nil {
-		return nil, err
-	}
-	db.AutoMigrate(&Block{}, &Transaction{}, &OutPoint{})
-	return db, nil
+// BroadcastTx is a transaction submitted through the RPC server that is
+// waiting to be relayed to the network by the peer.
+type BroadcastTx struct {
+	gorm.Model
+
+	Hash    Hash `gorm:"uniqueIndex"`
+	Raw     []byte
+	Relayed bool
+}
+
+// EvictedTx is an unconfirmed transaction that was removed from the mempool,
+// kept so that it can still be looked up.
+type EvictedTx struct {
+	gorm.Model
+
+	Hash   Hash `gorm:"index"`
+	Raw    []byte
+	Reason string
+	// EvictedBy is the transaction spending the same outputs that evicted
+	// it, empty for the descendants of evicted transactions and transactions
+	// evicted for other reasons.
+	EvictedBy Hash `gorm:"index"`
+}
+
+// FeeStat counts the transactions of a block that were seen in the mempool
+// before it confirmed them, by fee rate bucket and by the number of blocks
+// they waited for, for fee estimation.
+type FeeStat struct {
+	gorm.Model
+
+	BlockID uint `gorm:"index"`
+	Bucket  int
+	Blocks  int32
+	Count   int64
+	// FeeRate is the sum of the fee rates of the transactions in sat/vB.
+	FeeRate float64
+}
+
+// BlockFilter is the BIP158 basic filter of a block and its BIP157 filter
+// header, stored when the block is connected to the main chain and kept if
+// it is disconnected.
+type BlockFilter struct {
+	gorm.Model
+
+	BlockHash Hash `gorm:"uniqueIndex"`
+	Filter    []byte
+	Header    Hash
 }
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Hash is a block or transaction hash in the hex form it is displayed in.
+// It is stored as the 32 bytes the hex string encodes, so hashes sort the
+// same way in the database as their hex strings. The empty hash is stored as
+// NULL, and so is a string that is not a hash, which then matches no row
+// when used as a query argument.
+type Hash string
+
+// Hashes converts hex strings to hashes, to be used as query arguments.
+func Hashes(hashes []string) []Hash {
+	converted := make([]Hash, len(hashes))
+	for i, hash := range hashes {
+		converted[i] = Hash(hash)
+	}
+	return converted
+}
+
+// Strings converts hashes to hex strings.
+func Strings(hashes []Hash) []string {
+	converted := make([]string, len(hashes))
+	for i, hash := range hashes {
+		converted[i] = string(hash)
+	}
+	return converted
+}
+
+func (h Hash) Value() (driver.Value, error) {
+	b, err := hex.DecodeString(string(h))
+	if err != nil || len(b) != chainhash.HashSize {
+		return nil, nil
+	}
+	return b, nil
+}
+
+func (h *Hash) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*h = ""
+	case []byte:
+		*h = Hash(hex.EncodeToString(v))
+	default:
+		return fmt.Errorf("cannot scan %T into a hash", value)
+	}
+	return nil
+}
+
+func (Hash) GormDataType() string {
+	return "bytes"
+}
+
+// Witness is the witness of a transaction input, stored in its wire
+// serialization. An empty witness is stored as NULL.
+type Witness wire.TxWitness
+
+func (w Witness) Value() (driver.Value, error) {
+	if len(w) == 0 {
+		return nil, nil
+	}
+	buf := new(bytes.Buffer)
+	if err := wire.WriteVarInt(buf, 0, uint64(len(w))); err != nil {
+		return nil, err
+	}
+	for _, item := range w {
+		if err := wire.WriteVarBytes(buf, 0, item); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *Witness) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*w = nil
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			*w = nil
+			return nil
+		}
+		r := bytes.NewReader(v)
+		count, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return err
+		}
+		if count > uint64(len(v)) {
+			return fmt.Errorf("witness of %d items is longer than its %d bytes", count, len(v))
+		}
+		witness := make(Witness, count)
+		for i := range witness {
+			if witness[i], err = wire.ReadVarBytes(r, 0, uint32(len(v)), "witness item"); err != nil {
+				return err
+			}
+		}
+		*w = witness
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into a witness", value)
+	}
+}
+
+func (Witness) GormDataType() string {
+	return "bytes"
+}
@@ -0,0 +1,41 @@
+package notify
+
+import "github.com/btcsuite/btcd/wire"
+
+// Buffer is a Notifier that keeps the events it receives until they are
+// flushed, e.g. once the database transaction they belong to is committed.
+type Buffer struct {
+	events []func(Notifier)
+}
+
+func NewBuffer() *Buffer {
+	return &Buffer{}
+}
+
+func (b *Buffer) BlockConnected(block *wire.MsgBlock) {
+	b.events = append(b.events, func(n Notifier) { n.BlockConnected(block) })
+}
+
+func (b *Buffer) BlockDisconnected(block *wire.MsgBlock) {
+	b.events = append(b.events, func(n Notifier) { n.BlockDisconnected(block) })
+}
+
+func (b *Buffer) UpdatedBlockTip(block *wire.MsgBlock) {
+	b.events = append(b.events, func(n Notifier) { n.UpdatedBlockTip(block) })
+}
+
+func (b *Buffer) TxAccepted(tx *wire.MsgTx) {
+	b.events = append(b.events, func(n Notifier) { n.TxAccepted(tx) })
+}
+
+func (b *Buffer) TxRemoved(tx *wire.MsgTx) {
+	b.events = append(b.events, func(n Notifier) { n.TxRemoved(tx) })
+}
+
+// Flush sends the buffered events to n in the order they were received.
+func (b *Buffer) Flush(n Notifier) {
+	for _, event := range b.events {
+		event(n)
+	}
+	b.events = nil
+}
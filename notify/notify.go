@@ -0,0 +1,36 @@
+package notify
+
+import "github.com/btcsuite/btcd/wire"
+
+// Notifier receives chain and mempool events from the storage once they have
+// been written to the database.
+type Notifier interface {
+	// BlockConnected is called for every block that becomes part of the
+	// main chain.
+	BlockConnected(block *wire.MsgBlock)
+	// BlockDisconnected is called for every block that is removed from the
+	// main chain during a reorg.
+	BlockDisconnected(block *wire.MsgBlock)
+	// UpdatedBlockTip is called once the block has become the tip of the
+	// main chain, after BlockConnected was called for it and for the other
+	// blocks connected along with it by a reorg.
+	UpdatedBlockTip(block *wire.MsgBlock)
+	// TxAccepted is called when an unconfirmed transaction is first stored.
+	TxAccepted(tx *wire.MsgTx)
+	// TxRemoved is called when an unconfirmed transaction is dropped for a
+	// reason other than being included in a block.
+	TxRemoved(tx *wire.MsgTx)
+}
+
+type nop struct{}
+
+// Nop returns a Notifier that ignores every event.
+func Nop() Notifier {
+	return nop{}
+}
+
+func (nop) BlockConnected(block *wire.MsgBlock)    {}
+func (nop) BlockDisconnected(block *wire.MsgBlock) {}
+func (nop) UpdatedBlockTip(block *wire.MsgBlock)   {}
+func (nop) TxAccepted(tx *wire.MsgTx)              {}
+func (nop) TxRemoved(tx *wire.MsgTx)               {}
@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ZMQ topics, named after bitcoind's -zmqpub<topic> options.
+const (
+	TopicHashBlock = "hashblock"
+	TopicHashTx    = "hashtx"
+	TopicRawBlock  = "rawblock"
+	TopicRawTx     = "rawtx"
+	TopicSequence  = "sequence"
+)
+
+// Labels used by the sequence topic.
+const (
+	sequenceBlockConnected    = 'C'
+	sequenceBlockDisconnected = 'D'
+	sequenceTxAccepted        = 'A'
+	sequenceTxRemoved         = 'R'
+)
+
+const defaultHighWaterMark = 1000
+
+// ZMQConfig maps every topic to the endpoint it is published on, e.g.
+// "tcp://127.0.0.1:28332". Topics without an endpoint are disabled, and
+// topics sharing an endpoint share a socket.
+type ZMQConfig struct {
+	HashBlock     string `yaml:"hashblock"`
+	HashTx        string `yaml:"hashtx"`
+	RawBlock      string `yaml:"rawblock"`
+	RawTx         string `yaml:"rawtx"`
+	Sequence      string `yaml:"sequence"`
+	HighWaterMark int    `yaml:"high-water-mark"`
+}
+
+type socket interface {
+	SendMessage(parts ...interface{}) (int, error)
+	Close() error
+}
+
+type ZMQNotifier struct {
+	mu              sync.Mutex
+	sockets         map[string]socket
+	topics          map[string]socket
+	sequences       map[string]uint32
+	mempoolSequence uint64
+}
+
+// NewZMQNotifier binds a ZMQ PUB socket for every configured endpoint and
+// returns a Notifier that publishes messages in the same format as bitcoind:
+// a three part message of topic, body and a little endian uint32 sequence
+// number that is incremented separately for every topic.
+func NewZMQNotifier(cfg ZMQConfig) (*ZMQNotifier, error) {
+	hwm := cfg.HighWaterMark
+	if hwm == 0 {
+		hwm = defaultHighWaterMark
+	}
+
+	n := &ZMQNotifier{
+		sockets:   make(map[string]socket),
+		topics:    make(map[string]socket),
+		sequences: make(map[string]uint32),
+	}
+	for topic, endpoint := range map[string]string{
+		TopicHashBlock: cfg.HashBlock,
+		TopicHashTx:    cfg.HashTx,
+		TopicRawBlock:  cfg.RawBlock,
+		TopicRawTx:     cfg.RawTx,
+		TopicSequence:  cfg.Sequence,
+	} {
+		if endpoint == "" {
+			continue
+		}
+		sock, ok := n.sockets[endpoint]
+		if !ok {
+			var err error
+			sock, err = newSocket(endpoint, hwm)
+			if err != nil {
+				n.Close()
+				return nil, fmt.Errorf("failed to bind %s to %s: %v", topic, endpoint, err)
+			}
+			n.sockets[endpoint] = sock
+		}
+		n.topics[topic] = sock
+	}
+	return n, nil
+}
+
+func (n *ZMQNotifier) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var firstErr error
+	for endpoint, sock := range n.sockets {
+		if err := sock.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(n.sockets, endpoint)
+	}
+	n.topics = make(map[string]socket)
+	return firstErr
+}
+
+// BlockConnected publishes the transactions of every connected block, then
+// its sequence message, like bitcoind. The block itself is only published
+// once it is the tip.
+func (n *ZMQNotifier) BlockConnected(block *wire.MsgBlock) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		n.publishTx(tx)
+	}
+	n.publish(TopicSequence, sequenceBody(block.BlockHash(), sequenceBlockConnected, nil))
+}
+
+func (n *ZMQNotifier) BlockDisconnected(block *wire.MsgBlock) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		n.publishTx(tx)
+	}
+	n.publish(TopicSequence, sequenceBody(block.BlockHash(), sequenceBlockDisconnected, nil))
+}
+
+// UpdatedBlockTip publishes the hashblock and rawblock messages, which
+// bitcoind only sends for the new tip after a reorg.
+func (n *ZMQNotifier) UpdatedBlockTip(block *wire.MsgBlock) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.publishBlock(block)
+}
+
+func (n *ZMQNotifier) TxAccepted(tx *wire.MsgTx) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.mempoolSequence++
+	seq := n.mempoolSequence
+	n.publishTx(tx)
+	n.publish(TopicSequence, sequenceBody(tx.TxHash(), sequenceTxAccepted, &seq))
+}
+
+func (n *ZMQNotifier) TxRemoved(tx *wire.MsgTx) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.mempoolSequence++
+	seq := n.mempoolSequence
+	n.publish(TopicSequence, sequenceBody(tx.TxHash(), sequenceTxRemoved, &seq))
+}
+
+func (n *ZMQNotifier) publishBlock(block *wire.MsgBlock) {
+	n.publish(TopicHashBlock, reversed(block.BlockHash()))
+	if _, ok := n.topics[TopicRawBlock]; ok {
+		buf := new(bytes.Buffer)
+		if err := block.Serialize(buf); err != nil {
+			fmt.Printf("error serializing block (%s): %v\n", block.BlockHash().String(), err)
+			return
+		}
+		n.publish(TopicRawBlock, buf.Bytes())
+	}
+}
+
+func (n *ZMQNotifier) publishTx(tx *wire.MsgTx) {
+	n.publish(TopicHashTx, reversed(tx.TxHash()))
+	if _, ok := n.topics[TopicRawTx]; ok {
+		buf := new(bytes.Buffer)
+		if err := tx.Serialize(buf); err != nil {
+			fmt.Printf("error serializing tx (%s): %v\n", tx.TxHash().String(), err)
+			return
+		}
+		n.publish(TopicRawTx, buf.Bytes())
+	}
+}
+
+// publish must be called with the lock held.
+func (n *ZMQNotifier) publish(topic string, body []byte) {
+	sock, ok := n.topics[topic]
+	if !ok {
+		return
+	}
+
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, n.sequences[topic])
+	if _, err := sock.SendMessage(topic, body, seq); err != nil {
+		fmt.Printf("error publishing %s: %v\n", topic, err)
+		return
+	}
+	n.sequences[topic]++
+}
+
+// sequenceBody encodes a sequence topic message: the hash, a one byte label
+// and, for mempool events, the little endian uint64 mempool sequence.
+func sequenceBody(hash chainhash.Hash, label byte, mempoolSequence *uint64) []byte {
+	body := append(reversed(hash), label)
+	if mempoolSequence != nil {
+		seq := make([]byte, 8)
+		binary.LittleEndian.PutUint64(seq, *mempoolSequence)
+		body = append(body, seq...)
+	}
+	return body
+}
+
+// reversed returns the hash in the byte order it is displayed in, which is
+// the order bitcoind publishes hashes in.
+func reversed(hash chainhash.Hash) []byte {
+	b := make([]byte, chainhash.HashSize)
+	for i := range hash {
+		b[chainhash.HashSize-1-i] = hash[i]
+	}
+	return b
+}
@@ -0,0 +1,14 @@
+//go:build !zmq
+
+package notify
+
+import "errors"
+
+// ZMQSupported reports whether the build can publish ZMQ notifications.
+const ZMQSupported = false
+
+// ZMQ needs libzmq through cgo, so it is only available in builds tagged
+// with zmq.
+func newSocket(endpoint string, hwm int) (socket, error) {
+	return nil, errors.New("built without zmq support, rebuild with -tags zmq")
+}
@@ -0,0 +1,24 @@
+//go:build zmq
+
+package notify
+
+import zmq "github.com/pebbe/zmq4"
+
+// ZMQSupported reports whether the build can publish ZMQ notifications.
+const ZMQSupported = true
+
+func newSocket(endpoint string, hwm int) (socket, error) {
+	sock, err := zmq.NewSocket(zmq.PUB)
+	if err != nil {
+		return nil, err
+	}
+	if err := sock.SetSndhwm(hwm); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	if err := sock.Bind(endpoint); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	return sock, nil
+}
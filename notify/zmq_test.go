@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+type message struct {
+	topic string
+	body  []byte
+	seq   uint32
+}
+
+// String formats the message as topic, body in hex and sequence number.
+func (m message) String() string {
+	return fmt.Sprintf("%s %x %d", m.topic, m.body, m.seq)
+}
+
+// fakeSocket records the messages sent to it, and fails to send them while
+// fail is set.
+type fakeSocket struct {
+	messages []message
+	fail     bool
+}
+
+func (s *fakeSocket) SendMessage(parts ...interface{}) (int, error) {
+	if s.fail {
+		return 0, errors.New("send failed")
+	}
+	seq := parts[2].([]byte)
+	s.messages = append(s.messages, message{parts[0].(string), parts[1].([]byte), binary.LittleEndian.Uint32(seq)})
+	return len(parts), nil
+}
+
+func (s *fakeSocket) Close() error {
+	return nil
+}
+
+// newTestNotifier returns a notifier publishing every topic on a single
+// fake socket.
+func newTestNotifier() (*ZMQNotifier, *fakeSocket) {
+	sock := &fakeSocket{}
+	n := &ZMQNotifier{
+		sockets:   map[string]socket{"inproc://test": sock},
+		topics:    map[string]socket{},
+		sequences: map[string]uint32{},
+	}
+	for _, topic := range []string{TopicHashBlock, TopicHashTx, TopicRawBlock, TopicRawTx, TopicSequence} {
+		n.topics[topic] = sock
+	}
+	return n, sock
+}
+
+func testTx(i byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{i}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(int64(i), []byte{0x51}))
+	return tx
+}
+
+func serialize(t *testing.T, v interface{ Serialize(w io.Writer) error }) string {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := v.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestReversedHash(t *testing.T) {
+	// Hashes are published in the order they are displayed in
+	hash := chaincfg.MainNetParams.GenesisHash
+	want := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	if got := hex.EncodeToString(reversed(*hash)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestZMQNotifier(t *testing.T) {
+	n, sock := newTestNotifier()
+	tx1, tx2, tx3 := testTx(1), testTx(2), testTx(3)
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, 0, 0))
+	block.AddTransaction(tx1)
+	block.AddTransaction(tx2)
+
+	n.TxAccepted(tx1)
+	n.TxAccepted(tx3)
+	n.TxRemoved(tx3)
+	n.BlockConnected(block)
+	n.UpdatedBlockTip(block)
+	n.BlockDisconnected(block)
+
+	// The sequence topic carries the hash, the label and, for mempool
+	// events, the mempool sequence number
+	seqBody := func(hash chainhash.Hash, label string, mempoolSeq string) string {
+		return hex.EncodeToString(reversed(hash)) + hex.EncodeToString([]byte(label)) + mempoolSeq
+	}
+	hashTx := func(tx *wire.MsgTx) string { return hex.EncodeToString(reversed(tx.TxHash())) }
+	want := []string{
+		// Mempool events advance the mempool sequence number
+		"hashtx " + hashTx(tx1) + " 0",
+		"rawtx " + serialize(t, tx1) + " 0",
+		"sequence " + seqBody(tx1.TxHash(), "A", "0100000000000000") + " 0",
+		"hashtx " + hashTx(tx3) + " 1",
+		"rawtx " + serialize(t, tx3) + " 1",
+		"sequence " + seqBody(tx3.TxHash(), "A", "0200000000000000") + " 1",
+		"sequence " + seqBody(tx3.TxHash(), "R", "0300000000000000") + " 2",
+		// The transactions of a connected block come before its sequence
+		// message, and the block itself is published as the tip
+		"hashtx " + hashTx(tx1) + " 2",
+		"rawtx " + serialize(t, tx1) + " 2",
+		"hashtx " + hashTx(tx2) + " 3",
+		"rawtx " + serialize(t, tx2) + " 3",
+		"sequence " + seqBody(block.BlockHash(), "C", "") + " 3",
+		"hashblock " + hex.EncodeToString(reversed(block.BlockHash())) + " 0",
+		"rawblock " + serialize(t, block) + " 0",
+		"hashtx " + hashTx(tx1) + " 4",
+		"rawtx " + serialize(t, tx1) + " 4",
+		"hashtx " + hashTx(tx2) + " 5",
+		"rawtx " + serialize(t, tx2) + " 5",
+		"sequence " + seqBody(block.BlockHash(), "D", "") + " 4",
+	}
+	got := make([]string, len(sock.messages))
+	for i, m := range sock.messages {
+		got[i] = m.String()
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got messages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A message that fails to be sent does not use up its sequence number
+	sock.fail = true
+	n.UpdatedBlockTip(block)
+	sock.fail = false
+	sock.messages = nil
+	n.UpdatedBlockTip(block)
+	if len(sock.messages) != 2 || sock.messages[0].seq != 1 || sock.messages[1].seq != 1 {
+		t.Fatalf("got messages %v after a failed send, want hashblock and rawblock numbered 1", sock.messages)
+	}
+}
+
+func TestZMQNotifierDisabledTopics(t *testing.T) {
+	n, sock := newTestNotifier()
+	delete(n.topics, TopicRawTx)
+	delete(n.topics, TopicRawBlock)
+	delete(n.topics, TopicSequence)
+
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, 0, 0))
+	block.AddTransaction(testTx(1))
+	n.BlockConnected(block)
+	n.UpdatedBlockTip(block)
+	if len(sock.messages) != 2 || sock.messages[0].topic != TopicHashTx || sock.messages[1].topic != TopicHashBlock {
+		t.Fatalf("got messages %v, want only hashtx and hashblock", sock.messages)
+	}
+}
+
+// recorder is a Notifier that records the events it receives.
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) BlockConnected(block *wire.MsgBlock) {
+	r.events = append(r.events, "connected "+block.BlockHash().String())
+}
+
+func (r *recorder) BlockDisconnected(block *wire.MsgBlock) {
+	r.events = append(r.events, "disconnected "+block.BlockHash().String())
+}
+
+func (r *recorder) UpdatedBlockTip(block *wire.MsgBlock) {
+	r.events = append(r.events, "tip "+block.BlockHash().String())
+}
+
+func (r *recorder) TxAccepted(tx *wire.MsgTx) {
+	r.events = append(r.events, "accepted "+tx.TxHash().String())
+}
+
+func (r *recorder) TxRemoved(tx *wire.MsgTx) {
+	r.events = append(r.events, "removed "+tx.TxHash().String())
+}
+
+func TestBuffer(t *testing.T) {
+	tx := testTx(1)
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &chainhash.Hash{}, &chainhash.Hash{}, 0, 0))
+	b := NewBuffer()
+	b.TxAccepted(tx)
+	b.BlockDisconnected(block)
+	b.BlockConnected(block)
+	b.UpdatedBlockTip(block)
+	b.TxRemoved(tx)
+
+	r := &recorder{}
+	b.Flush(r)
+	b.Flush(r)
+	want := []string{
+		"accepted " + tx.TxHash().String(),
+		"disconnected " + block.BlockHash().String(),
+		"connected " + block.BlockHash().String(),
+		"tip " + block.BlockHash().String(),
+		"removed " + tx.TxHash().String(),
+	}
+	if strings.Join(r.events, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got events\n%s\nwant them once in order\n%s", strings.Join(r.events, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,77 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/chain"
+)
+
+// auxPoWConn is a connection to a peer of a merge mined chain. The AuxPoWs
+// that follow the headers in the headers and block messages it reads are
+// checked and stripped, so that the messages decode as Bitcoin ones.
+type auxPoWConn struct {
+	net.Conn
+	chain *chain.Chain
+	// invalid is called with the error of an invalid AuxPoW.
+	invalid func(err error)
+
+	// pending is what is left to read of the last message.
+	pending bytes.Buffer
+}
+
+func newAuxPoWConn(conn net.Conn, c *chain.Chain, invalid func(err error)) *auxPoWConn {
+	return &auxPoWConn{
+		Conn:    conn,
+		chain:   c,
+		invalid: invalid,
+	}
+}
+
+func (c *auxPoWConn) Read(b []byte) (int, error) {
+	if c.pending.Len() == 0 {
+		if err := c.readMessage(); err != nil {
+			return 0, err
+		}
+	}
+	return c.pending.Read(b)
+}
+
+// readMessage reads the next message into pending, without the AuxPoWs.
+func (c *auxPoWConn) readMessage() error {
+	header := make([]byte, wire.MessageHeaderSize)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return err
+	}
+	length := binary.LittleEndian.Uint32(header[16:20])
+	if length > wire.MaxMessagePayload {
+		return fmt.Errorf("message payload is too large - header indicates %d bytes, but max message payload is %d bytes", length, wire.MaxMessagePayload)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return err
+	}
+
+	// Messages with a bad checksum are left for the peer to reject
+	checksum := chainhash.DoubleHashB(payload)[:4]
+	command := string(bytes.TrimRight(header[4:16], "\x00"))
+	if bytes.Equal(checksum, header[20:24]) && (command == wire.CmdHeaders || command == wire.CmdBlock) {
+		stripped, err := c.chain.StripAuxPoW(command, payload)
+		if err != nil {
+			c.invalid(err)
+			return err
+		}
+		payload = stripped
+		binary.LittleEndian.PutUint32(header[16:20], uint32(len(payload)))
+		copy(header[20:24], chainhash.DoubleHashB(payload)[:4])
+	}
+
+	c.pending.Write(header)
+	c.pending.Write(payload)
+	return nil
+}
@@ -0,0 +1,68 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// banList keeps the hosts that sent invalid data, and saves them to disk so
+// that the bans outlive restarts.
+type banList struct {
+	mu   sync.Mutex
+	path string
+	bans map[string]time.Time
+}
+
+func loadBanList(path string) (*banList, error) {
+	b := &banList{
+		path: path,
+		bans: make(map[string]time.Time),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b.bans); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ban bans the host for the given duration.
+func (b *banList) ban(host string, duration time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bans[host] = time.Now().Add(duration)
+	return b.save()
+}
+
+// banned reports whether the host is banned, and until when.
+func (b *banList) banned(host string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until, ok := b.bans[host]
+	if !ok {
+		return time.Time{}, false
+	}
+	if time.Now().After(until) {
+		delete(b.bans, host)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+func (b *banList) save() error {
+	data, err := json.Marshal(b.bans)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(b.path, data, 0644)
+}
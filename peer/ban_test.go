@@ -0,0 +1,43 @@
+package peer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned.json")
+	bans, err := loadBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bans.ban("1.2.3.4", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := bans.ban("5.6.7.8", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bans outlive restarts, and expire
+	bans, err = loadBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	until, ok := bans.banned("1.2.3.4")
+	if !ok {
+		t.Fatal("1.2.3.4 is not banned after a restart")
+	}
+	if time.Until(until) < 59*time.Minute || time.Until(until) > time.Hour {
+		t.Fatalf("1.2.3.4 is banned until %v, want in an hour", until)
+	}
+	if _, ok := bans.banned("5.6.7.8"); ok {
+		t.Fatal("5.6.7.8 is still banned after the ban expired")
+	}
+	if _, ok := bans.bans["5.6.7.8"]; ok {
+		t.Fatal("expired ban was not removed")
+	}
+	if _, ok := bans.banned("9.9.9.9"); ok {
+		t.Fatal("9.9.9.9 was never banned")
+	}
+}
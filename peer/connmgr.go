@@ -0,0 +1,294 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/connmgr"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// minBackoff and maxBackoff bound the delay between reconnections to a
+	// configured peer, which doubles after every failed attempt.
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	// stableConnection is how long a connection has to last for the
+	// backoff to start over.
+	stableConnection = time.Minute
+
+	// banDuration is how long a peer that sent invalid data is banned.
+	banDuration = 24 * time.Hour
+
+	// discoveryInterval is how often the number of discovered peers is
+	// topped up.
+	discoveryInterval = 5 * time.Second
+
+	// dnsSeedInterval is the minimum time between two DNS seed lookups.
+	dnsSeedInterval = 5 * time.Minute
+)
+
+type Option func(*Peer)
+
+// WithDiscovery makes the peer keep target connections to peers found
+// through the DNS seeds of the network and the addresses announced by other
+// peers, on top of the configured ones. lookup resolves the DNS seeds, e.g.
+// net.LookupIP or a StubResolver.
+func WithDiscovery(target int, lookup func(host string) ([]net.IP, error)) Option {
+	return func(p *Peer) {
+		p.target = target
+		p.lookup = lookup
+	}
+}
+
+// WithDataDir sets the directory the address book (peers.json) and the
+// banned peers (banned.json) are saved in, the working directory by default.
+func WithDataDir(dir string) Option {
+	return func(p *Peer) {
+		p.dataDir = dir
+	}
+}
+
+// StubResolver resolves host names from a fixed table, so that peers can be
+// discovered without DNS.
+type StubResolver map[string][]net.IP
+
+func (r StubResolver) LookupIP(host string) ([]net.IP, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	return ips, nil
+}
+
+// keepConnected connects to a configured peer, reconnecting with an
+// exponential backoff whenever the connection fails or drops. Configured
+// peers are never banned, so a ban of their host is ignored.
+func (p *Peer) keepConnected(url string) {
+	backoff := &backoff{}
+	for {
+		start := time.Now()
+		connected := time.Duration(0)
+		pp, err := p.connect(url, false)
+		if err != nil {
+			fmt.Printf("error connecting to %s: %v\n", url, err)
+		} else {
+			pp.WaitForDisconnect()
+			connected = time.Since(start)
+		}
+
+		delay := backoff.next(connected)
+		fmt.Printf("reconnecting to %s in %v\n", url, delay)
+		time.Sleep(delay)
+	}
+}
+
+// backoff is the delay between reconnections to a configured peer, which
+// doubles after every failed attempt up to maxBackoff.
+type backoff struct {
+	delay time.Duration
+}
+
+// next returns the delay before reconnecting after a connection that lasted
+// for connected, zero if it failed. It starts over at minBackoff after a
+// stable connection.
+func (b *backoff) next(connected time.Duration) time.Duration {
+	if b.delay == 0 || connected > stableConnection {
+		b.delay = minBackoff
+	}
+	delay := b.delay
+	if b.delay *= 2; b.delay > maxBackoff {
+		b.delay = maxBackoff
+	}
+	return delay
+}
+
+// discover keeps the number of connections to discovered peers at the
+// target, seeding the address book from DNS when it runs low.
+func (p *Peer) discover() {
+	ticker := time.NewTicker(discoveryInterval)
+	defer ticker.Stop()
+
+	lastSeed := time.Time{}
+	for ; ; <-ticker.C {
+		if p.addrs.NeedMoreAddresses() && time.Since(lastSeed) > dnsSeedInterval {
+			lastSeed = time.Now()
+			p.seed()
+		}
+
+		p.peersMu.Lock()
+		missing := p.target - p.discovered
+		p.discovered += missing
+		p.peersMu.Unlock()
+		for i := 0; i < missing; i++ {
+			go p.connectDiscovered()
+		}
+	}
+}
+
+// seed adds the addresses the DNS seeds of the network resolve to to the
+// address book. The seeds are looked up in the background.
+func (p *Peer) seed() {
+	connmgr.SeedFromDNS(p.chain.Params, p.requiredServices(), p.lookup, func(addrs []*wire.NetAddressV2) {
+		// The seeds are not peers, so the first address stands in as the
+		// source
+		p.addrs.AddAddresses(addrs, addrs[0])
+	})
+}
+
+// connectDiscovered connects to an address from the address book, the slot
+// it takes is freed once the connection fails or drops.
+func (p *Peer) connectDiscovered() {
+	defer func() {
+		p.peersMu.Lock()
+		p.discovered--
+		p.peersMu.Unlock()
+	}()
+
+	url, ok := p.pickAddress()
+	if !ok {
+		return
+	}
+	pp, err := p.connect(url, true)
+	if err != nil {
+		return
+	}
+	pp.WaitForDisconnect()
+}
+
+// pickAddress picks an address from the address book that is neither
+// connected nor banned, giving up after a number of tries like btcd does.
+func (p *Peer) pickAddress() (string, bool) {
+	for tries := 0; tries < 100; tries++ {
+		ka := p.addrs.GetAddress()
+		if ka == nil {
+			return "", false
+		}
+		na := ka.NetAddress()
+		url := addrmgr.NetAddressKey(na)
+
+		p.peersMu.Lock()
+		_, connected := p.peers[url]
+		p.peersMu.Unlock()
+		if connected {
+			continue
+		}
+		if _, banned := p.bans.banned(hostOf(url)); banned {
+			continue
+		}
+		// Only retry recently tried addresses after a while
+		if tries < 30 && time.Since(ka.LastAttempt()) < 10*time.Minute {
+			continue
+		}
+		// Prefer the default port
+		if tries < 50 && strconv.Itoa(int(na.Port)) != p.chain.Params.DefaultPort {
+			continue
+		}
+
+		p.addrs.Attempt(na)
+		return url, true
+	}
+	return "", false
+}
+
+// onVerAck records a discovered peer as good, or drops it if it cannot serve
+// blocks, and asks it for more addresses while the address book is short of
+// them.
+func (p *Peer) onVerAck(pp *peer.Peer, discovered bool) {
+	if p.addrs != nil {
+		if discovered && pp.Services()&p.requiredServices() != p.requiredServices() {
+			fmt.Printf("peer %s does not serve the blocks of the chain, disconnecting\n", pp.Addr())
+			pp.Disconnect()
+			return
+		}
+		p.addrs.SetServices(pp.NA(), pp.Services())
+		p.addrs.Connected(pp.NA())
+		p.addrs.Good(pp.NA())
+		if p.addrs.NeedMoreAddresses() {
+			pp.QueueMessage(wire.NewMsgGetAddr(), nil)
+		}
+	}
+	p.sync.addPeer(pp)
+}
+
+func (p *Peer) onAddr(pp *peer.Peer, msg *wire.MsgAddr) {
+	if p.addrs == nil {
+		return
+	}
+	addrs := make([]*wire.NetAddressV2, len(msg.AddrList))
+	for i, na := range msg.AddrList {
+		addrs[i] = wire.NetAddressV2FromBytes(na.Timestamp, na.Services, na.IP, na.Port)
+	}
+	p.addrs.AddAddresses(addrs, pp.NA())
+}
+
+func (p *Peer) onAddrV2(pp *peer.Peer, msg *wire.MsgAddrV2) {
+	if p.addrs == nil {
+		return
+	}
+	p.addrs.AddAddresses(msg.AddrList, pp.NA())
+}
+
+func (p *Peer) onGetAddr(pp *peer.Peer, msg *wire.MsgGetAddr) {
+	if p.addrs == nil {
+		return
+	}
+	addrs := p.addrs.AddressCache()
+	if pp.WantsAddrV2() {
+		if _, err := pp.PushAddrV2Msg(addrs); err != nil {
+			fmt.Printf("error sending addresses to %s: %v\n", pp.Addr(), err)
+		}
+		return
+	}
+
+	legacy := make([]*wire.NetAddress, 0, len(addrs))
+	for _, na := range addrs {
+		if !na.IsTorV3() {
+			legacy = append(legacy, na.ToLegacy())
+		}
+	}
+	if _, err := pp.PushAddrMsg(legacy); err != nil {
+		fmt.Printf("error sending addresses to %s: %v\n", pp.Addr(), err)
+	}
+}
+
+// misbehaving bans a peer that sent invalid data and disconnects it.
+// Configured peers are only disconnected, banning them would stop syncing
+// from them.
+func (p *Peer) misbehaving(pp *peer.Peer, reason string) {
+	if p.configured(pp.Addr()) {
+		fmt.Printf("disconnecting configured peer %s: %s\n", pp.Addr(), reason)
+		pp.Disconnect()
+		return
+	}
+	fmt.Printf("banning peer %s: %s\n", pp.Addr(), reason)
+	if err := p.bans.ban(hostOf(pp.Addr()), banDuration); err != nil {
+		fmt.Printf("error saving the banned peers: %v\n", err)
+	}
+	pp.Disconnect()
+}
+
+// configured reports whether the address is on the host of a configured
+// peer.
+func (p *Peer) configured(addr string) bool {
+	for _, url := range p.urls {
+		if hostOf(url) == hostOf(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf returns the host of a host:port address.
+func hostOf(url string) string {
+	host, _, err := net.SplitHostPort(url)
+	if err != nil {
+		return url
+	}
+	return host
+}
@@ -0,0 +1,134 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestSeed(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	ips := []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("5.6.7.8")}
+	// Seeds that support it are asked for nodes serving witness blocks
+	resolver := StubResolver{"x9." + params.DNSSeeds[0].Host: ips}
+	p := newTestPeer(t, params, WithDiscovery(8, resolver.LookupIP), WithDataDir(t.TempDir()))
+
+	p.seed()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.addrs.NumAddresses() < len(ips) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d addresses from the seeds, want %d", p.addrs.NumAddresses(), len(ips))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	known := map[string]bool{}
+	for _, ip := range ips {
+		known[net.JoinHostPort(ip.String(), params.DefaultPort)] = true
+	}
+	if url, ok := p.pickAddress(); !ok || !known[url] {
+		t.Fatalf("picked %q from the address book, want one of %v", url, known)
+	}
+
+	if _, err := resolver.LookupIP("unknown.example.com"); err == nil {
+		t.Fatal("stub resolver resolved an unknown host")
+	}
+}
+
+func TestAddressBook(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	dir := t.TempDir()
+	p := newTestPeer(t, params, WithDiscovery(8, StubResolver{}.LookupIP), WithDataDir(dir))
+	p.addrs.Start()
+	source := wire.NetAddressV2FromBytes(time.Now(), wire.SFNodeNetwork, net.ParseIP("9.9.9.9"), 8333)
+	added := []*wire.NetAddressV2{}
+	for i := 1; i <= 10; i++ {
+		ip := net.ParseIP(fmt.Sprintf("1.2.3.%d", i))
+		added = append(added, wire.NetAddressV2FromBytes(time.Now(), wire.SFNodeNetwork|wire.SFNodeWitness, ip, 8333))
+	}
+	p.addrs.AddAddresses(added, source)
+	if err := p.addrs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The address book is saved on stop and loaded on start
+	p = newTestPeer(t, params, WithDiscovery(8, StubResolver{}.LookupIP), WithDataDir(dir))
+	p.addrs.Start()
+	defer p.addrs.Stop()
+	if p.addrs.NumAddresses() != len(added) {
+		t.Fatalf("got %d addresses after a restart, want %d", p.addrs.NumAddresses(), len(added))
+	}
+	known := map[string]bool{}
+	for _, na := range added {
+		known[addrmgr.NetAddressKey(na)] = true
+	}
+	if ka := p.addrs.GetAddress(); ka == nil || !known[addrmgr.NetAddressKey(ka.NetAddress())] {
+		t.Fatal("loaded address book does not have the saved addresses")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{}
+	want := minBackoff
+	for i := 0; i < 20; i++ {
+		if delay := b.next(0); delay != want {
+			t.Fatalf("attempt %d: got backoff %v, want %v", i, delay, want)
+		}
+		if want *= 2; want > maxBackoff {
+			want = maxBackoff
+		}
+	}
+
+	// A short connection keeps backing off, a stable one starts over
+	if delay := b.next(stableConnection / 2); delay != maxBackoff {
+		t.Fatalf("got backoff %v after a short connection, want %v", delay, maxBackoff)
+	}
+	if delay := b.next(stableConnection + time.Second); delay != minBackoff {
+		t.Fatalf("got backoff %v after a stable connection, want %v", delay, minBackoff)
+	}
+	if delay := b.next(0); delay != 2*minBackoff {
+		t.Fatalf("got backoff %v after a failure, want %v", delay, 2*minBackoff)
+	}
+}
+
+func TestMisbehavingConfiguredPeer(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	p, err := NewPeer([]string{"127.0.0.1:18444"}, &testStorage{params: params}, WithDataDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A configured peer is disconnected without being banned, so that it is
+	// reconnected
+	configured := newTestOutboundPeer(t, "127.0.0.1:18444")
+	p.misbehaving(configured, "invalid block")
+	if _, banned := p.bans.banned("127.0.0.1"); banned {
+		t.Fatal("configured peer was banned")
+	}
+	discovered := newTestOutboundPeer(t, "127.0.0.2:18444")
+	p.misbehaving(discovered, "invalid block")
+	if _, banned := p.bans.banned("127.0.0.2"); !banned {
+		t.Fatal("discovered peer was not banned")
+	}
+	for _, pp := range []*peer.Peer{configured, discovered} {
+		select {
+		case <-waitForDisconnect(pp):
+		case <-time.After(5 * time.Second):
+			t.Fatalf("peer %s was not disconnected", pp.Addr())
+		}
+	}
+}
+
+func waitForDisconnect(pp *peer.Peer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		pp.WaitForDisconnect()
+		close(done)
+	}()
+	return done
+}
@@ -3,88 +3,352 @@ package peer
 import (
 	"fmt"
 	"net"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/btcsuite/btcd/addrmgr"
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/peer"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/command"
+)
+
+const (
+	// broadcastInterval is how often the storage is checked for
+	// transactions submitted through the RPC server.
+	broadcastInterval = 5 * time.Second
+
+	// relayTimeout is how long an announced transaction is kept around for
+	// the peer to ask for it.
+	relayTimeout = 10 * time.Minute
+
+	// dialTimeout is how long connecting to a peer may take.
+	dialTimeout = 10 * time.Second
+
+	// mempoolExpiry is how long a transaction can stay unconfirmed before
+	// it is removed, bitcoind's default -mempoolexpiry.
+	mempoolExpiry = 336 * time.Hour
+
+	// expiryInterval is how often expired transactions are removed.
+	expiryInterval = time.Hour
 )
 
 type Storage interface {
 	GetBlockLocator() (blockchain.BlockLocator, error)
+	GetLatestBlockHeight() (int32, error)
+	GetLatestBlockHash() (string, error)
+	GetPreviousBlockHeight(blockhash string) (int32, error)
+	GetHeaderFromHeight(height int32) (command.BlockHeader, error)
 	PutBlock(block *wire.MsgBlock) error
 	PutTx(tx *wire.MsgTx) error
+	GetPendingBroadcasts() ([]*wire.MsgTx, error)
+	MarkBroadcast(hash string) error
+	ExpireMempool(before time.Time) error
 	Params() *chaincfg.Params
 }
 
 type Peer struct {
-	done    chan struct{}
-	peer    *peer.Peer
 	storage Storage
+	chain   *chain.Chain
+	sync    *syncManager
+
+	urls    []string
+	target  int
+	lookup  func(host string) ([]net.IP, error)
+	dataDir string
+	addrs   *addrmgr.AddrManager
+	bans    *banList
+
+	// peersMu guards peers, the connected peers by address, and discovered,
+	// the number of connections to discovered peers.
+	peersMu    sync.Mutex
+	peers      map[string]*peer.Peer
+	discovered int
+
+	// relayMu guards relayTxs, the announced transactions that are served
+	// to the peers when they ask for them.
+	relayMu  sync.Mutex
+	relayTxs map[chainhash.Hash]relayTx
 }
 
-func NewPeer(url string, str Storage) (*Peer, error) {
-	done := make(chan struct{})
+type relayTx struct {
+	tx          *wire.MsgTx
+	announcedAt time.Time
+}
+
+// NewPeer creates a peer that syncs from the nodes at the given addresses,
+// and from discovered nodes if WithDiscovery is given. The connections are
+// made by Run, with the P2P magic and protocol version of the chain of the
+// storage's params.
+func NewPeer(urls []string, str Storage, opts ...Option) (*Peer, error) {
+	syncManager, err := newSyncManager(str)
+	if err != nil {
+		return nil, err
+	}
+	p := &Peer{
+		storage:  str,
+		chain:    syncManager.chain,
+		sync:     syncManager,
+		urls:     urls,
+		dataDir:  ".",
+		peers:    make(map[string]*peer.Peer),
+		relayTxs: make(map[chainhash.Hash]relayTx),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.urls) == 0 && p.target == 0 {
+		return nil, fmt.Errorf("no peers to connect to")
+	}
+
+	if p.bans, err = loadBanList(filepath.Join(p.dataDir, "banned.json")); err != nil {
+		return nil, fmt.Errorf("failed to load the banned peers: %v", err)
+	}
+	if p.target > 0 {
+		p.addrs = addrmgr.New(p.dataDir, p.lookup)
+	}
+	p.sync.misbehaving = p.misbehaving
+	return p, nil
+}
+
+// connect connects to the peer at url, which is registered until it
+// disconnects.
+func (p *Peer) connect(url string, discovered bool) (*peer.Peer, error) {
 	peerCfg := &peer.Config{
 		UserAgentName:    "peer",  // User agent name to advertise.
 		UserAgentVersion: "1.0.0", // User agent version to advertise.
-		ChainParams:      str.Params(),
-		Services:         wire.SFNodeWitness,
+		ChainParams:      p.chain.Params,
+		ProtocolVersion:  p.chain.ProtocolVersion,
+		Services:         p.requiredServices() & wire.SFNodeWitness,
 		TrickleInterval:  time.Second * 10,
 		Listeners: peer.MessageListeners{
-			OnInv: func(p *peer.Peer, msg *wire.MsgInv) {
-				sendMsg := wire.NewMsgGetData()
-				for _, inv := range msg.InvList {
-					sendMsg.AddInvVect(inv)
-					fmt.Println("got an inv", inv.Type.String())
-				}
-				p.QueueMessage(sendMsg, done)
+			OnVerAck: func(pp *peer.Peer, msg *wire.MsgVerAck) {
+				p.onVerAck(pp, discovered)
 			},
-			OnBlock: func(p *peer.Peer, msg *wire.MsgBlock, buf []byte) {
-				if err := str.PutBlock(msg); err != nil {
-					fmt.Printf("error putting block (%s): %v\n", msg.BlockHash().String(), err)
+			OnInv:     p.onInv,
+			OnHeaders: p.sync.onHeaders,
+			OnBlock: func(pp *peer.Peer, msg *wire.MsgBlock, buf []byte) {
+				if !p.sync.onBlock(pp, msg) {
+					// Blocks are only downloaded after their headers, so
+					// catch up on the headers of an unrequested block
+					p.sync.requestHeaders(pp)
 				}
 			},
-			OnTx: func(p *peer.Peer, tx *wire.MsgTx) {
+			OnTx: func(pp *peer.Peer, tx *wire.MsgTx) {
 				fmt.Println("got a tx")
-				if err := str.PutTx(tx); err != nil {
+				if err := p.storage.PutTx(tx); err != nil {
 					fmt.Printf("error putting tx (%s): %v\n", tx.TxHash().String(), err)
 				}
 			},
+			OnNotFound: p.sync.onNotFound,
+			OnGetData:  p.onGetData,
+			OnAddr:     p.onAddr,
+			OnAddrV2:   p.onAddrV2,
+			OnGetAddr:  p.onGetAddr,
 		},
 		AllowSelfConns: true,
 	}
 
-	p, err := peer.NewOutboundPeer(peerCfg, url)
+	pp, err := peer.NewOutboundPeer(peerCfg, url)
 	if err != nil {
 		return nil, fmt.Errorf("NewOutboundPeer: error %v", err)
 	}
 
 	// Establish the connection to the peer address and mark it connected.
-	conn, err := net.Dial("tcp", p.Addr())
+	conn, err := net.DialTimeout("tcp", pp.Addr(), dialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("net.Dial: error %v", err)
 	}
-	p.AssociateConnection(conn)
+	if p.chain.AuxPoW != nil {
+		conn = newAuxPoWConn(conn, p.chain, func(err error) {
+			p.misbehaving(pp, fmt.Sprintf("invalid AuxPoW: %v", err))
+		})
+	}
+	pp.AssociateConnection(conn)
 
-	return &Peer{
-		done:    done,
-		peer:    p,
-		storage: str,
-	}, nil
+	p.peersMu.Lock()
+	p.peers[url] = pp
+	p.peersMu.Unlock()
+	go func() {
+		pp.WaitForDisconnect()
+		fmt.Printf("peer %s disconnected\n", url)
+		p.sync.removePeer(pp)
+
+		p.peersMu.Lock()
+		if p.peers[url] == pp {
+			delete(p.peers, url)
+		}
+		p.peersMu.Unlock()
+	}()
+	return pp, nil
 }
 
+// Run connects to the peers and syncs the chain from them. Configured peers
+// are reconnected when they drop, and discovered peers are replaced. It only
+// returns when a block cannot be stored, with the error.
 func (p *Peer) Run() error {
-	for {
-		locator, err := p.storage.GetBlockLocator()
+	go p.broadcast()
+	go p.expireMempool()
+	go p.sync.checkStalls()
+
+	for _, url := range p.urls {
+		go p.keepConnected(url)
+	}
+	if p.addrs != nil {
+		p.addrs.Start()
+		defer p.addrs.Stop()
+		go p.discover()
+	}
+	return p.sync.writeBlocks()
+}
+
+// onInv asks for the headers of announced blocks, and for announced
+// transactions once the chain is synced.
+func (p *Peer) onInv(pp *peer.Peer, msg *wire.MsgInv) {
+	getData := wire.NewMsgGetData()
+	newBlocks := false
+	for _, inv := range msg.InvList {
+		switch inv.Type {
+		case wire.InvTypeBlock, wire.InvTypeWitnessBlock:
+			if !p.sync.known(inv.Hash) {
+				newBlocks = true
+			}
+		case wire.InvTypeTx, wire.InvTypeWitnessTx:
+			if p.sync.current() {
+				getData.AddInvVect(wire.NewInvVect(txInvType(p.chain), &inv.Hash))
+			}
+		}
+	}
+	if newBlocks {
+		p.sync.requestHeaders(pp)
+	}
+	if len(getData.InvList) > 0 {
+		pp.QueueMessage(getData, nil)
+	}
+}
+
+// broadcast announces the transactions submitted through the RPC server to
+// the peers, which then ask for them with a getdata message. A transaction
+// stays pending until a peer asked for it.
+func (p *Peer) broadcast() {
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		p.relayMu.Lock()
+		for hash, relay := range p.relayTxs {
+			if now.Sub(relay.announcedAt) > relayTimeout {
+				delete(p.relayTxs, hash)
+			}
+		}
+		p.relayMu.Unlock()
+
+		txs, err := p.storage.GetPendingBroadcasts()
 		if err != nil {
-			return fmt.Errorf("GetBlockLocator: error %v", err)
+			fmt.Printf("error getting pending broadcasts: %v\n", err)
+			continue
+		}
+
+		for _, tx := range txs {
+			hash := tx.TxHash()
+			// Announced transactions are announced again once nobody
+			// asked for them before the relay timeout.
+			p.relayMu.Lock()
+			_, announced := p.relayTxs[hash]
+			p.relayMu.Unlock()
+			if announced {
+				continue
+			}
+
+			inv := wire.NewMsgInv()
+			if err := inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, &hash)); err != nil {
+				fmt.Printf("error announcing tx (%s): %v\n", hash.String(), err)
+				continue
+			}
+			p.peersMu.Lock()
+			for _, pp := range p.peers {
+				pp.QueueMessage(inv, nil)
+			}
+			queued := len(p.peers) > 0
+			p.peersMu.Unlock()
+			if !queued {
+				// Left pending until a peer is connected
+				continue
+			}
+
+			p.relayMu.Lock()
+			p.relayTxs[hash] = relayTx{tx: tx, announcedAt: now}
+			p.relayMu.Unlock()
+		}
+	}
+}
+
+// expireMempool removes the transactions that have been unconfirmed for too
+// long, like bitcoind does.
+func (p *Peer) expireMempool() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if err := p.storage.ExpireMempool(now.Add(-mempoolExpiry)); err != nil {
+			fmt.Printf("error expiring mempool transactions: %v\n", err)
+		}
+	}
+}
+
+func (p *Peer) onGetData(pp *peer.Peer, msg *wire.MsgGetData) {
+	notFound := wire.NewMsgNotFound()
+	for _, inv := range msg.InvList {
+		if inv.Type != wire.InvTypeTx && inv.Type != wire.InvTypeWitnessTx {
+			notFound.AddInvVect(inv)
+			continue
+		}
+
+		p.relayMu.Lock()
+		relay, ok := p.relayTxs[inv.Hash]
+		p.relayMu.Unlock()
+		if !ok {
+			notFound.AddInvVect(inv)
+			continue
 		}
-		if err := p.peer.PushGetBlocksMsg(locator, &chainhash.Hash{}); err != nil {
-			return fmt.Errorf("PushGetBlocksMsg: error %v", err)
+		pp.QueueMessage(relay.tx, nil)
+
+		// The transaction is only relayed once a peer asked for it
+		if err := p.storage.MarkBroadcast(inv.Hash.String()); err != nil {
+			fmt.Printf("error marking tx (%s) as broadcast: %v\n", inv.Hash.String(), err)
 		}
-		<-p.done
 	}
+	if len(notFound.InvList) > 0 {
+		pp.QueueMessage(notFound, nil)
+	}
+}
+
+// requiredServices returns the services a discovered peer has to offer for
+// the blocks to be downloaded from it.
+func (p *Peer) requiredServices() wire.ServiceFlag {
+	if p.chain.Segwit {
+		return wire.SFNodeNetwork | wire.SFNodeWitness
+	}
+	return wire.SFNodeNetwork
+}
+
+// blockInvType returns the inventory type blocks are requested with, with
+// their witness data on chains that have one.
+func blockInvType(c *chain.Chain) wire.InvType {
+	if c.Segwit {
+		return wire.InvTypeWitnessBlock
+	}
+	return wire.InvTypeBlock
+}
+
+// txInvType returns the inventory type transactions are requested with.
+func txInvType(c *chain.Chain) wire.InvType {
+	if c.Segwit {
+		return wire.InvTypeWitnessTx
+	}
+	return wire.InvTypeTx
 }
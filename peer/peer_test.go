@@ -0,0 +1,35 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/catalogfi/indexer/command"
+)
+
+// testStorage is a storage with only the genesis block, its other methods
+// panic.
+type testStorage struct {
+	Storage
+	params *chaincfg.Params
+}
+
+func (s *testStorage) Params() *chaincfg.Params {
+	return s.params
+}
+
+func (s *testStorage) GetLatestBlockHeight() (int32, error) {
+	return 0, nil
+}
+
+func (s *testStorage) GetPreviousBlockHeight(blockhash string) (int32, error) {
+	return 0, command.ErrNotFound
+}
+
+func newTestPeer(t *testing.T, params *chaincfg.Params, opts ...Option) *Peer {
+	p, err := NewPeer(nil, &testStorage{params: params}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,577 @@
+package peer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/chain"
+	"github.com/catalogfi/indexer/validation"
+)
+
+const (
+	// maxBlocksInFlight is the number of blocks requested from a peer at
+	// a time.
+	maxBlocksInFlight = 16
+
+	// downloadWindow is how far ahead of the last stored block blocks are
+	// requested, which bounds the number of blocks kept in memory.
+	downloadWindow = 1024
+
+	// stallTimeout is how long a peer can go without delivering any of
+	// the blocks requested from it before it is disconnected.
+	stallTimeout = 30 * time.Second
+
+	// stallCheckInterval is how often peers are checked for stalling.
+	stallCheckInterval = time.Second
+
+	// maxWriteAttempts is how many times a block is downloaded and written
+	// before syncing stops with the error of the last attempt.
+	maxWriteAttempts = 5
+)
+
+// syncManager downloads the chain headers first and then fetches the blocks
+// in parallel from all connected peers, writing them to the storage in
+// height order.
+type syncManager struct {
+	storage Storage
+	params  *chaincfg.Params
+	chain   *chain.Chain
+
+	mu    sync.Mutex
+	peers map[*peer.Peer]*syncPeer
+
+	// The header chain beyond the stored blocks, hashes[i] is the block at
+	// height baseHeight+1+i with headers[i] as its header and baseHash is its
+	// parent.
+	baseHeight int32
+	baseHash   chainhash.Hash
+	hashes     []chainhash.Hash
+	headers    []wire.BlockHeader
+	heights    map[chainhash.Hash]int32
+
+	// nextRequest is the height of the next block to request, blocks from
+	// stalled peers are requested again first through retry.
+	nextRequest int32
+	retry       []chainhash.Hash
+	requested   map[chainhash.Hash]*syncPeer
+	blocks      map[chainhash.Hash]*wire.MsgBlock
+
+	// headersSynced is set once a peer had no more headers to send.
+	headersSynced bool
+
+	// misbehaving is called with peers that send invalid data.
+	misbehaving func(p *peer.Peer, reason string)
+
+	// wake signals the writer that a block may be ready to be stored.
+	wake chan struct{}
+}
+
+type syncPeer struct {
+	peer     *peer.Peer
+	inFlight map[chainhash.Hash]time.Time
+	// lastProgress is when the peer last delivered a block, or was given
+	// blocks to download while it had none in flight.
+	lastProgress time.Time
+}
+
+func newSyncManager(str Storage) (*syncManager, error) {
+	params := str.Params()
+	s := &syncManager{
+		storage:   str,
+		params:    params,
+		chain:     chain.ForParams(params),
+		peers:     make(map[*peer.Peer]*syncPeer),
+		baseHash:  *params.GenesisHash,
+		heights:   make(map[chainhash.Hash]int32),
+		requested: make(map[chainhash.Hash]*syncPeer),
+		blocks:    make(map[chainhash.Hash]*wire.MsgBlock),
+		wake:      make(chan struct{}, 1),
+	}
+
+	height, err := str.GetLatestBlockHeight()
+	if err != nil {
+		return nil, fmt.Errorf("GetLatestBlockHeight: error %v", err)
+	}
+	if height > 0 {
+		tip, err := str.GetLatestBlockHash()
+		if err != nil {
+			return nil, fmt.Errorf("GetLatestBlockHash: error %v", err)
+		}
+		hash, err := chainhash.NewHashFromStr(tip)
+		if err != nil {
+			return nil, err
+		}
+		s.baseHeight = height
+		s.baseHash = *hash
+	}
+	s.nextRequest = s.baseHeight + 1
+	return s, nil
+}
+
+// addPeer starts syncing from the peer once the handshake is done.
+func (s *syncManager) addPeer(p *peer.Peer) {
+	s.mu.Lock()
+	s.peers[p] = &syncPeer{
+		peer:     p,
+		inFlight: make(map[chainhash.Hash]time.Time),
+	}
+	s.mu.Unlock()
+
+	s.requestHeaders(p)
+}
+
+// removePeer hands the blocks in flight from a disconnected peer to the
+// other peers.
+func (s *syncManager) removePeer(p *peer.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sp, ok := s.peers[p]
+	if !ok {
+		return
+	}
+	delete(s.peers, p)
+	s.requeue(sp)
+	s.schedule()
+}
+
+// known reports whether the block is in the header chain or in the storage.
+func (s *syncManager) known(hash chainhash.Hash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.knownHeight(hash)
+	return ok
+}
+
+// current reports whether the headers are synced and all of their blocks
+// are stored.
+func (s *syncManager) current() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headersSynced && len(s.hashes) == 0
+}
+
+// requestHeaders asks the peer for the headers following the last known
+// header.
+func (s *syncManager) requestHeaders(p *peer.Peer) {
+	locator, err := s.locator()
+	if err != nil {
+		fmt.Printf("error building the block locator: %v\n", err)
+		return
+	}
+	if err := p.PushGetHeadersMsg(locator, &chainhash.Hash{}); err != nil {
+		fmt.Printf("error requesting headers from %s: %v\n", p.Addr(), err)
+	}
+}
+
+// locator returns a block locator starting from the last known header, made
+// of the header chain followed by the stored blocks.
+func (s *syncManager) locator() (blockchain.BlockLocator, error) {
+	s.mu.Lock()
+	locator := blockchain.BlockLocator{}
+	step := 1
+	for i := len(s.hashes) - 1; i >= 0; i -= step {
+		hash := s.hashes[i]
+		locator = append(locator, &hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	s.mu.Unlock()
+
+	stored, err := s.storage.GetBlockLocator()
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		stored = blockchain.BlockLocator{s.params.GenesisHash}
+	}
+	return append(locator, stored...), nil
+}
+
+// knownHeight returns the height of a block in the header chain or in the
+// storage.
+func (s *syncManager) knownHeight(hash chainhash.Hash) (int32, bool) {
+	if height, ok := s.heights[hash]; ok {
+		return height, true
+	}
+	if hash == s.baseHash {
+		return s.baseHeight, true
+	}
+	if hash == *s.params.GenesisHash {
+		return 0, true
+	}
+	height, err := s.storage.GetPreviousBlockHeight(hash.String())
+	if err != nil {
+		return 0, false
+	}
+	return height, true
+}
+
+// onHeaders extends the header chain with the new headers. Headers forking
+// off the chain replace the blocks after the fork point if they lead to a
+// greater height, the storage then decides which branch ends up as the main
+// chain.
+func (s *syncManager) onHeaders(p *peer.Peer, msg *wire.MsgHeaders) {
+	// The peer is reported once the lock is released, reporting it saves
+	// the ban list
+	offense := ""
+	defer func() {
+		if offense != "" {
+			s.misbehaving(p, offense)
+		}
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(msg.Headers) < wire.MaxBlockHeadersPerMsg {
+		s.headersSynced = true
+	}
+
+	// Skip the headers that are known already
+	headers := msg.Headers
+	for len(headers) > 0 {
+		if _, ok := s.knownHeight(headers[0].BlockHash()); !ok {
+			break
+		}
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		// Another peer sent these first, carry on from the end of them
+		if len(msg.Headers) == wire.MaxBlockHeadersPerMsg {
+			go s.requestHeaders(p)
+		}
+		return
+	}
+
+	parentHeight, ok := s.knownHeight(headers[0].PrevBlock)
+	if !ok {
+		fmt.Printf("headers from %s do not connect to the known chain\n", p.Addr())
+		return
+	}
+
+	chain := &headerChain{sync: s, parentHeight: parentHeight}
+	if parentHeight <= s.baseHeight && parentHeight > 0 {
+		// Stored blocks off the main chain are not kept as headers, so a
+		// branch forking off one cannot be checked
+		parent, err := chain.HeaderAt(parentHeight)
+		if err != nil || parent.BlockHash() != headers[0].PrevBlock {
+			fmt.Printf("headers from %s fork off a stale block\n", p.Addr())
+			return
+		}
+	}
+
+	now := time.Now()
+	hashes := make([]chainhash.Hash, len(headers))
+	for i, header := range headers {
+		if err := validation.CheckHeader(s.chain, chain, header, parentHeight+1+int32(i), now); err != nil {
+			offense = fmt.Sprintf("invalid header: %v", err)
+			return
+		}
+		chain.branch = append(chain.branch, header)
+		hashes[i] = header.BlockHash()
+	}
+
+	tipHeight, tipHash := s.baseHeight, s.baseHash
+	if len(s.hashes) > 0 {
+		tipHeight, tipHash = tipHeight+int32(len(s.hashes)), s.hashes[len(s.hashes)-1]
+	}
+	if parentHeight+int32(len(hashes)) <= tipHeight {
+		return
+	}
+	if headers[0].PrevBlock != tipHash {
+		s.fork(headers[0].PrevBlock, parentHeight)
+	}
+	for i, hash := range hashes {
+		s.hashes = append(s.hashes, hash)
+		s.headers = append(s.headers, *headers[i])
+		s.heights[hash] = parentHeight + 1 + int32(i)
+	}
+
+	if len(msg.Headers) == wire.MaxBlockHeadersPerMsg {
+		go s.requestHeaders(p)
+	} else {
+		fmt.Println("Headers synced to height", s.baseHeight+int32(len(s.hashes)), "with", p.Addr())
+	}
+	s.schedule()
+}
+
+// fork drops the header chain after the block with the given hash.
+func (s *syncManager) fork(hash chainhash.Hash, height int32) {
+	fmt.Println("Header chain forks at height", height, hash.String())
+	if _, ok := s.heights[hash]; ok {
+		for _, dropped := range s.hashes[height-s.baseHeight:] {
+			delete(s.heights, dropped)
+		}
+		s.hashes = s.hashes[:height-s.baseHeight]
+		s.headers = s.headers[:height-s.baseHeight]
+	} else {
+		// The fork point is stored already, so the blocks are fetched again
+		// from there on
+		s.hashes = nil
+		s.headers = nil
+		s.heights = make(map[chainhash.Hash]int32)
+		s.baseHash = hash
+		s.baseHeight = height
+	}
+	if s.nextRequest > height+1 {
+		s.nextRequest = height + 1
+	}
+
+	for hash := range s.blocks {
+		if _, ok := s.heights[hash]; !ok {
+			delete(s.blocks, hash)
+		}
+	}
+	for hash, sp := range s.requested {
+		if _, ok := s.heights[hash]; !ok {
+			delete(s.requested, hash)
+			delete(sp.inFlight, hash)
+		}
+	}
+}
+
+// onBlock keeps a requested block until it can be written in order. It
+// reports whether the block was requested by the sync manager. The block is
+// checked without holding the lock, and the peer is reported after it.
+func (s *syncManager) onBlock(p *peer.Peer, block *wire.MsgBlock) bool {
+	hash := block.BlockHash()
+
+	s.mu.Lock()
+	_, ok := s.requested[hash]
+	height := s.heights[hash]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if err := validation.CheckBlock(s.chain, block, height); err != nil {
+		// The block is requested again once the peer is gone
+		s.misbehaving(p, fmt.Sprintf("invalid block %v: %v", hash, err))
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The header chain may have forked, or another peer delivered the
+	// block, while it was checked
+	sp, ok := s.requested[hash]
+	if !ok {
+		return true
+	}
+	delete(s.requested, hash)
+	delete(sp.inFlight, hash)
+	if sp.peer == p {
+		sp.lastProgress = time.Now()
+	}
+	s.blocks[hash] = block
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	s.schedule()
+	return true
+}
+
+// onNotFound requests the blocks the peer does not have from the other
+// peers.
+func (s *syncManager) onNotFound(p *peer.Peer, msg *wire.MsgNotFound) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sp, ok := s.peers[p]
+	if !ok {
+		return
+	}
+	for _, inv := range msg.InvList {
+		if _, ok := sp.inFlight[inv.Hash]; !ok {
+			continue
+		}
+		delete(sp.inFlight, inv.Hash)
+		delete(s.requested, inv.Hash)
+		s.retry = append(s.retry, inv.Hash)
+	}
+	s.schedule()
+}
+
+// schedule requests blocks from every peer with room in its in-flight
+// window.
+func (s *syncManager) schedule() {
+	now := time.Now()
+	for _, sp := range s.peers {
+		getData := wire.NewMsgGetData()
+		for len(sp.inFlight) < maxBlocksInFlight {
+			hash, ok := s.nextBlock()
+			if !ok {
+				break
+			}
+			if len(sp.inFlight) == 0 {
+				sp.lastProgress = now
+			}
+			sp.inFlight[hash] = now
+			s.requested[hash] = sp
+			getData.AddInvVect(wire.NewInvVect(blockInvType(s.chain), &hash))
+		}
+		if len(getData.InvList) > 0 {
+			sp.peer.QueueMessage(getData, nil)
+		}
+	}
+}
+
+// nextBlock returns the next block to request.
+func (s *syncManager) nextBlock() (chainhash.Hash, bool) {
+	for len(s.retry) > 0 {
+		hash := s.retry[0]
+		s.retry = s.retry[1:]
+		if _, ok := s.heights[hash]; ok {
+			if _, ok := s.requested[hash]; !ok {
+				return hash, true
+			}
+		}
+	}
+
+	for s.nextRequest <= s.baseHeight+int32(len(s.hashes)) && s.nextRequest <= s.baseHeight+downloadWindow {
+		hash := s.hashes[s.nextRequest-s.baseHeight-1]
+		s.nextRequest++
+		_, requested := s.requested[hash]
+		_, received := s.blocks[hash]
+		if !requested && !received {
+			return hash, true
+		}
+	}
+	return chainhash.Hash{}, false
+}
+
+// requeue makes the blocks in flight from the peer available to the other
+// peers, lowest height first.
+func (s *syncManager) requeue(sp *syncPeer) {
+	hashes := make([]chainhash.Hash, 0, len(sp.inFlight))
+	for hash := range sp.inFlight {
+		delete(s.requested, hash)
+		hashes = append(hashes, hash)
+	}
+	sp.inFlight = make(map[chainhash.Hash]time.Time)
+	sort.Slice(hashes, func(i, j int) bool {
+		return s.heights[hashes[i]] < s.heights[hashes[j]]
+	})
+	s.retry = append(hashes, s.retry...)
+}
+
+// checkStalls disconnects the peers that have not delivered any of their
+// blocks in flight for too long, their blocks are requested from the other
+// peers.
+func (s *syncManager) checkStalls() {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for p, sp := range s.peers {
+			if len(sp.inFlight) == 0 || now.Sub(sp.lastProgress) < stallTimeout {
+				continue
+			}
+			fmt.Printf("peer %s stalled with %d blocks in flight, disconnecting\n", p.Addr(), len(sp.inFlight))
+			delete(s.peers, p)
+			s.requeue(sp)
+			p.Disconnect()
+		}
+		s.schedule()
+		s.mu.Unlock()
+	}
+}
+
+// writeBlocks stores the downloaded blocks in height order. A block that
+// fails to be stored is downloaded again, and writeBlocks returns the error
+// once it failed maxWriteAttempts times.
+func (s *syncManager) writeBlocks() error {
+	failed, attempts := chainhash.Hash{}, 0
+	for range s.wake {
+		for {
+			s.mu.Lock()
+			if len(s.hashes) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			hash := s.hashes[0]
+			block, ok := s.blocks[hash]
+			s.mu.Unlock()
+			if !ok {
+				break
+			}
+
+			err := s.storage.PutBlock(block)
+			if err != nil {
+				if hash != failed {
+					failed, attempts = hash, 0
+				}
+				attempts++
+				if attempts == maxWriteAttempts {
+					return fmt.Errorf("error putting block (%s) after %d attempts: %v", hash.String(), attempts, err)
+				}
+			}
+
+			s.mu.Lock()
+			if err != nil {
+				// Fetch the block again, possibly from another peer
+				fmt.Printf("error putting block (%s): %v\n", hash.String(), err)
+				delete(s.blocks, hash)
+				s.retry = append(s.retry, hash)
+				s.schedule()
+				s.mu.Unlock()
+				break
+			}
+			// The header chain may have forked while the block was written
+			if len(s.hashes) > 0 && s.hashes[0] == hash {
+				delete(s.blocks, hash)
+				delete(s.heights, hash)
+				s.hashes = s.hashes[1:]
+				s.headers = s.headers[1:]
+				s.baseHash = hash
+				s.baseHeight++
+				if s.nextRequest <= s.baseHeight {
+					s.nextRequest = s.baseHeight + 1
+				}
+				s.schedule()
+			}
+			s.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// headerChain is the chain a branch of new headers builds on: the branch
+// itself after parentHeight, then the header chain and the stored blocks.
+type headerChain struct {
+	sync         *syncManager
+	parentHeight int32
+	branch       []*wire.BlockHeader
+}
+
+func (c *headerChain) HeaderAt(height int32) (*wire.BlockHeader, error) {
+	s := c.sync
+	switch {
+	case height > c.parentHeight:
+		if i := height - c.parentHeight - 1; i < int32(len(c.branch)) {
+			return c.branch[i], nil
+		}
+	case height > s.baseHeight:
+		return &s.headers[height-s.baseHeight-1], nil
+	case height == 0:
+		return &s.params.GenesisBlock.Header, nil
+	case height > 0:
+		header, err := s.storage.GetHeaderFromHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		return header.Header, nil
+	}
+	return nil, fmt.Errorf("no header at height %d", height)
+}
@@ -0,0 +1,180 @@
+package peer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/peer"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// newTestSyncManager returns a sync manager on the regtest genesis block
+// that records the peers reported as misbehaving.
+func newTestSyncManager(t *testing.T) (*syncManager, map[*peer.Peer]string) {
+	s, err := newSyncManager(&testStorage{params: &chaincfg.RegressionNetParams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := map[*peer.Peer]string{}
+	s.misbehaving = func(p *peer.Peer, reason string) {
+		// Reporting a peer saves the ban list, which must not block syncing
+		if !s.mu.TryLock() {
+			t.Errorf("peer reported with the lock held: %s", reason)
+		} else {
+			s.mu.Unlock()
+		}
+		reported[p] = reason
+	}
+	return s, reported
+}
+
+func newTestOutboundPeer(t *testing.T, addr string) *peer.Peer {
+	p, err := peer.NewOutboundPeer(&peer.Config{ChainParams: &chaincfg.RegressionNetParams}, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// nextHeader returns a mined regtest header on top of prev.
+func nextHeader(prev *wire.BlockHeader) *wire.BlockHeader {
+	prevHash := prev.BlockHash()
+	header := wire.NewBlockHeader(4, &prevHash, &chainhash.Hash{}, chaincfg.RegressionNetParams.PowLimitBits, 0)
+	header.Timestamp = prev.Timestamp.Add(10 * time.Minute)
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			return header
+		}
+		header.Nonce++
+	}
+}
+
+func TestInvalidHeaderReportsPeer(t *testing.T) {
+	s, reported := newTestSyncManager(t)
+	honest := newTestOutboundPeer(t, "127.0.0.1:18444")
+	offending := newTestOutboundPeer(t, "127.0.0.2:18444")
+
+	first := nextHeader(&chaincfg.RegressionNetParams.GenesisBlock.Header)
+	msg := wire.NewMsgHeaders()
+	msg.AddBlockHeader(first)
+	s.onHeaders(honest, msg)
+	if len(reported) != 0 {
+		t.Fatalf("valid header reported peers %v", reported)
+	}
+	if height, ok := s.knownHeight(first.BlockHash()); !ok || height != 1 {
+		t.Fatalf("got height %d (%v) for the valid header, want 1", height, ok)
+	}
+
+	invalid := nextHeader(first)
+	invalid.Bits = 0x1d00ffff
+	msg = wire.NewMsgHeaders()
+	msg.AddBlockHeader(invalid)
+	s.onHeaders(offending, msg)
+	if reason, ok := reported[offending]; !ok || !strings.Contains(reason, "invalid header") {
+		t.Fatalf("offending peer was not reported, got %v", reported)
+	}
+	if _, ok := reported[honest]; ok {
+		t.Fatal("honest peer was reported")
+	}
+	if _, ok := s.knownHeight(invalid.BlockHash()); ok {
+		t.Fatal("invalid header was added to the header chain")
+	}
+}
+
+func TestInvalidBlockReportsPeer(t *testing.T) {
+	s, reported := newTestSyncManager(t)
+	offending := newTestOutboundPeer(t, "127.0.0.2:18444")
+
+	// A block without transactions cannot match its header
+	header := nextHeader(&chaincfg.RegressionNetParams.GenesisBlock.Header)
+	block := wire.NewMsgBlock(header)
+	hash := block.BlockHash()
+	sp := &syncPeer{peer: offending, inFlight: map[chainhash.Hash]time.Time{hash: time.Now()}}
+	s.requested[hash] = sp
+	s.heights[hash] = 1
+
+	if !s.onBlock(offending, block) {
+		t.Fatal("requested block was reported as unrequested")
+	}
+	if reason, ok := reported[offending]; !ok || !strings.Contains(reason, "invalid block") {
+		t.Fatalf("offending peer was not reported, got %v", reported)
+	}
+	if _, ok := s.blocks[hash]; ok {
+		t.Fatal("invalid block was kept to be written")
+	}
+	if _, ok := s.requested[hash]; !ok {
+		t.Fatal("invalid block is no longer requested")
+	}
+}
+
+// failingStorage fails to store any block.
+type failingStorage struct {
+	testStorage
+}
+
+func (s *failingStorage) PutBlock(block *wire.MsgBlock) error {
+	return errors.New("disk full")
+}
+
+func TestWriteBlocksGivesUp(t *testing.T) {
+	s, err := newSyncManager(&failingStorage{testStorage{params: &chaincfg.RegressionNetParams}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := nextHeader(&chaincfg.RegressionNetParams.GenesisBlock.Header)
+	block := wire.NewMsgBlock(header)
+	hash := block.BlockHash()
+	s.hashes = []chainhash.Hash{hash}
+	s.headers = []wire.BlockHeader{*header}
+	s.heights[hash] = 1
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.writeBlocks()
+	}()
+	// Every failed attempt drops the block to download it again
+	for i := 1; i < maxWriteAttempts; i++ {
+		s.mu.Lock()
+		s.blocks[hash] = block
+		s.mu.Unlock()
+		s.wake <- struct{}{}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			s.mu.Lock()
+			retried := len(s.retry)
+			s.mu.Unlock()
+			if retried == i {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("block was not dropped after attempt %d", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		select {
+		case err := <-errs:
+			t.Fatalf("gave up after %d attempts: %v", i, err)
+		default:
+		}
+	}
+
+	s.mu.Lock()
+	s.blocks[hash] = block
+	s.mu.Unlock()
+	s.wake <- struct{}{}
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Fatalf("got error %v, want the error of the storage", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("still writing after %d attempts", maxWriteAttempts)
+	}
+}
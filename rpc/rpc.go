@@ -1,6 +1,9 @@
 package rpc
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/catalogfi/indexer/command"
@@ -19,7 +22,7 @@ type rpc struct {
 
 type Request struct {
 	JSONRPC string        `json:"jsonrpc"`
-	ID      string        `json:"id"`
+	ID      interface{}   `json:"id"`
 	Method  string        `json:"method"`
 	Params  []interface{} `json:"params"`
 }
@@ -27,7 +30,7 @@ type Request struct {
 type Response struct {
 	Result interface{} `json:"result"`
 	Error  interface{} `json:"error"`
-	ID     string      `json:"id"`
+	ID     interface{} `json:"id"`
 }
 
 type ErrResponse struct {
@@ -47,28 +50,173 @@ func (r *rpc) AddCommand(cmd command.Command) {
 }
 
 func (r *rpc) HandleJSONRPC(ctx *gin.Context) {
+	body, err := ctx.GetRawData()
+	if err != nil {
+		writeError(ctx, nil, command.NewError(command.ErrRPCInvalidRequest, err.Error()))
+		return
+	}
+
+	// A JSON array is a batch of requests, answered with an array of
+	// responses in the same order.
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		batch := []json.RawMessage{}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeError(ctx, nil, command.NewError(command.ErrRPCParse, "Parse error"))
+			return
+		}
+		if len(batch) == 0 {
+			writeError(ctx, nil, command.NewError(command.ErrRPCInvalidRequest, "Empty batch request"))
+			return
+		}
+
+		resps := make([]Response, 0, len(batch))
+		for _, raw := range batch {
+			req := Request{}
+			if err := json.Unmarshal(raw, &req); err != nil {
+				resps = append(resps, errorResponse(nil, command.NewError(command.ErrRPCInvalidRequest, "Invalid Request object")))
+				continue
+			}
+			resp := r.execute(req)
+			if !isNotification(raw, req) {
+				resps = append(resps, resp)
+			}
+		}
+		if len(resps) == 0 {
+			ctx.Status(http.StatusNoContent)
+			return
+		}
+		ctx.JSON(http.StatusOK, resps)
+		return
+	}
+
 	req := Request{}
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, Response{Result: nil, Error: ErrResponse{-1, err.Error()}, ID: req.ID})
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(ctx, nil, command.NewError(command.ErrRPCParse, "Parse error"))
 		return
 	}
 
-	resp, err := r.commands[req.Method].Query(r.storage, req.Params)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, Response{Result: nil, Error: ErrResponse{-1, err.Error()}, ID: req.ID})
+	resp := r.execute(req)
+	if isNotification(body, req) {
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+	if resp.Error != nil {
+		ctx.JSON(httpStatus(resp.Error.(ErrResponse).Code), resp)
 		return
 	}
-	ctx.JSON(http.StatusOK, Response{Result: resp, Error: nil, ID: req.ID})
+	ctx.JSON(http.StatusOK, resp)
+}
+
+func (r *rpc) execute(req Request) Response {
+	if req.Method == "" {
+		return errorResponse(req.ID, command.NewError(command.ErrRPCInvalidRequest, "Method must be a string"))
+	}
+
+	cmd, ok := r.commands[req.Method]
+	if !ok {
+		return errorResponse(req.ID, command.NewError(command.ErrRPCMethodNotFound, "Method not found"))
+	}
+
+	resp, err := cmd.Query(r.storage, req.Params)
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	return Response{Result: resp, Error: nil, ID: req.ID}
+}
+
+// isNotification reports whether a request is a JSON-RPC 2.0 notification,
+// which has no id member and is not answered. Like bitcoind, requests of
+// earlier versions are always answered, with a null id if they have none.
+func isNotification(raw json.RawMessage, req Request) bool {
+	if req.JSONRPC != "2.0" {
+		return false
+	}
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return false
+	}
+	_, ok := members["id"]
+	return !ok
+}
+
+func errorResponse(id interface{}, err error) Response {
+	rpcErr := command.ToError(err)
+	return Response{Result: nil, Error: ErrResponse{rpcErr.Code, rpcErr.Message}, ID: id}
+}
+
+func writeError(ctx *gin.Context, id interface{}, err *command.Error) {
+	ctx.JSON(httpStatus(err.Code), errorResponse(id, err))
+}
+
+// httpStatus returns the HTTP status bitcoind uses to reply to a single
+// (non-batched) request that failed with the given error code.
+func httpStatus(code int) int {
+	switch code {
+	case command.ErrRPCInvalidRequest:
+		return http.StatusBadRequest
+	case command.ErrRPCMethodNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Commands returns the commands of the default RPC server.
+func Commands() []command.Command {
+	return []command.Command{
+		command.GetBestBlockHash(),
+		command.GetBlock(),
+		command.GetBlockCount(),
+		command.GetBlockHash(),
+		command.GetBlockHeader(),
+		command.GetRawTransaction(),
+		command.ListUnspent(),
+		command.GetAddressTxIDs(),
+		command.GetAddressDeltas(),
+		command.GetAddressBalance(),
+		command.SendRawTransaction(),
+		command.GetRawMempool(),
+		command.GetMempoolEntry(),
+		command.GetMempoolAncestors(),
+		command.GetMempoolDescendants(),
+		command.GetMempoolInfo(),
+		command.GetTxOut(),
+		command.GetTxOutSetInfo(),
+		command.EstimateSmartFee(),
+		command.GetBlockStats(),
+		command.GetChainTxStats(),
+		command.GetTxOutProof(),
+		command.VerifyTxOutProof(),
+		command.GetBlockFilter(),
+	}
 }
 
 func Default(str command.Storage) RPC {
 	rpc := New(str)
-	rpc.AddCommand(command.GetBestBlockHash())
-	rpc.AddCommand(command.GetBlock())
-	rpc.AddCommand(command.GetBlockCount())
-	rpc.AddCommand(command.GetBlockHash())
-	rpc.AddCommand(command.GetBlockHeader())	
-	rpc.AddCommand(command.GetRawTransaction())
-	rpc.AddCommand(command.ListUnspent())
+	for _, cmd := range Commands() {
+		rpc.AddCommand(cmd)
+	}
 	return rpc
 }
+
+// Enabled returns an RPC server with the default commands of the given
+// names, or all of them if no names are given.
+func Enabled(str command.Storage, names []string) (RPC, error) {
+	if len(names) == 0 {
+		return Default(str), nil
+	}
+	commands := map[string]command.Command{}
+	for _, cmd := range Commands() {
+		commands[cmd.Name()] = cmd
+	}
+	rpc := New(str)
+	for _, name := range names {
+		cmd, ok := commands[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown RPC method %q", name)
+		}
+		rpc.AddCommand(cmd)
+	}
+	return rpc, nil
+}
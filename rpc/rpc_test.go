@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/catalogfi/indexer/command"
+	"github.com/gin-gonic/gin"
+)
+
+// echo returns its params, or fails with its first param as the error
+// message if it is called as fail.
+type echo struct {
+	name string
+}
+
+func (e echo) Name() string {
+	return e.name
+}
+
+func (e echo) Query(str command.Storage, params []interface{}) (interface{}, error) {
+	if e.name == "fail" {
+		return nil, command.NewError(command.ErrRPCInvalidParameter, "%v", params[0])
+	}
+	return params, nil
+}
+
+func serve(t *testing.T, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := New(nil)
+	r.AddCommand(echo{"echo"})
+	r.AddCommand(echo{"fail"})
+	router := gin.New()
+	router.POST("/", r.HandleJSONRPC)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return w
+}
+
+// response is a Response as a client decodes it.
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *ErrResponse    `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+func TestHandleJSONRPC(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		// want is the id and the result or error code of each response, a
+		// single one unless the body is a batch
+		want []string
+	}{
+		{
+			name:       "request",
+			body:       `{"jsonrpc":"1.0","id":1,"method":"echo","params":[2]}`,
+			wantStatus: http.StatusOK,
+			want:       []string{"1 [2]"},
+		},
+		{
+			name:       "failing request",
+			body:       `{"jsonrpc":"1.0","id":"a","method":"fail","params":["bad"]}`,
+			wantStatus: http.StatusInternalServerError,
+			want:       []string{`"a" -8 bad`},
+		},
+		{
+			name:       "unknown method",
+			body:       `{"id":1,"method":"nope"}`,
+			wantStatus: http.StatusNotFound,
+			want:       []string{"1 -32601 Method not found"},
+		},
+		{
+			name:       "missing method",
+			body:       `{"id":1}`,
+			wantStatus: http.StatusBadRequest,
+			want:       []string{"1 -32600 Method must be a string"},
+		},
+		{
+			name:       "empty batch",
+			body:       `[]`,
+			wantStatus: http.StatusBadRequest,
+			want:       []string{"null -32600 Empty batch request"},
+		},
+		{
+			name:       "mixed batch",
+			body:       `[{"id":1,"method":"echo","params":[1]}, {"id":2,"method":"fail","params":["bad"]}, 3, {"id":4,"method":"nope"}, {"id":5,"method":"echo","params":[5]}]`,
+			wantStatus: http.StatusOK,
+			want:       []string{"1 [1]", "2 -8 bad", "null -32600 Invalid Request object", "4 -32601 Method not found", "5 [5]"},
+		},
+		{
+			name:       "batch with notifications",
+			body:       `[{"jsonrpc":"2.0","method":"echo","params":[1]}, {"jsonrpc":"2.0","id":null,"method":"echo","params":[2]}, {"method":"echo","params":[3]}]`,
+			wantStatus: http.StatusOK,
+			want:       []string{"null [2]", "null [3]"},
+		},
+		{
+			name:       "batch of notifications",
+			body:       `[{"jsonrpc":"2.0","method":"echo","params":[1]}, {"jsonrpc":"2.0","method":"fail","params":["bad"]}]`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "notification",
+			body:       `{"jsonrpc":"2.0","method":"echo","params":[1]}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "request without an id",
+			body:       `{"jsonrpc":"1.0","method":"echo","params":[1]}`,
+			wantStatus: http.StatusOK,
+			want:       []string{"null [1]"},
+		},
+		{
+			name:       "number",
+			body:       `42`,
+			wantStatus: http.StatusInternalServerError,
+			want:       []string{"null -32700 Parse error"},
+		},
+		{
+			name:       "string",
+			body:       `"getblockcount"`,
+			wantStatus: http.StatusInternalServerError,
+			want:       []string{"null -32700 Parse error"},
+		},
+		{
+			name:       "malformed batch",
+			body:       `[{"id":1,"method":"echo"}`,
+			wantStatus: http.StatusInternalServerError,
+			want:       []string{"null -32700 Parse error"},
+		},
+		{
+			name:       "empty body",
+			body:       ``,
+			wantStatus: http.StatusInternalServerError,
+			want:       []string{"null -32700 Parse error"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(t, test.body)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, test.wantStatus)
+			}
+			if test.want == nil {
+				if w.Body.Len() != 0 {
+					t.Fatalf("got body %s, want none", w.Body)
+				}
+				return
+			}
+
+			resps := []response{}
+			if strings.HasPrefix(strings.TrimSpace(test.body), "[") && w.Code == http.StatusOK {
+				if err := json.Unmarshal(w.Body.Bytes(), &resps); err != nil {
+					t.Fatalf("got body %s: %v", w.Body, err)
+				}
+			} else {
+				resp := response{}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("got body %s: %v", w.Body, err)
+				}
+				resps = append(resps, resp)
+			}
+			got := make([]string, len(resps))
+			for i, resp := range resps {
+				if resp.Error != nil {
+					if string(resp.Result) != "null" {
+						t.Fatalf("got result %s along with error %+v", resp.Result, resp.Error)
+					}
+					got[i] = strings.TrimSpace(strings.Join([]string{string(resp.ID), strconv.Itoa(resp.Error.Code), resp.Error.Message}, " "))
+					continue
+				}
+				got[i] = string(resp.ID) + " " + string(resp.Result)
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Fatalf("got responses\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"math"
+
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+	"gorm.io/gorm"
+)
+
+// addressFundings selects every output paying to one of the addresses that
+// was created in a main chain block within the height range.
+func (s *storage) addressFundings(addresses []string, options command.AddressQueryOptions) *gorm.DB {
+	return s.db.Model(&model.OutPoint{}).
+		Select("out_points.spender AS address, transactions.hash AS tx_hash, out_points.funding_tx_index AS idx, 1 AS funding, transactions.block_index AS block_index, blocks.height AS height, out_points.value AS value").
+		Joins("JOIN transactions ON transactions.id = out_points.funding_tx_id").
+		Joins("JOIN blocks ON blocks.id = transactions.block_id").
+		Where("out_points.spender IN ? AND blocks.is_orphan = ? AND blocks.height >= ? AND blocks.height <= ?", addresses, false, options.StartHeight, options.EndHeight)
+}
+
+// addressSpends selects every output paying to one of the addresses that
+// was spent in a main chain block within the height range.
+func (s *storage) addressSpends(addresses []string, options command.AddressQueryOptions) *gorm.DB {
+	return s.db.Model(&model.OutPoint{}).
+		Select("out_points.spender AS address, transactions.hash AS tx_hash, out_points.spending_tx_index AS idx, 0 AS funding, transactions.block_index AS block_index, blocks.height AS height, -out_points.value AS value").
+		Joins("JOIN transactions ON transactions.id = out_points.spending_tx_id").
+		Joins("JOIN blocks ON blocks.id = transactions.block_id").
+		Where("out_points.spender IN ? AND blocks.is_orphan = ? AND blocks.height >= ? AND blocks.height <= ?", addresses, false, options.StartHeight, options.EndHeight)
+}
+
+// GetAddressDeltas returns the entries of the history of the addresses in
+// the main chain, ordered by position in the chain and value. Entries of a
+// transaction with the same value are ordered by address, spends first and
+// then by index, so that pages do not overlap.
+func (s *storage) GetAddressDeltas(addresses []string, options command.AddressQueryOptions) ([]command.AddressDelta, error) {
+	rows := []struct {
+		Address    string
+		TxHash     model.Hash
+		Idx        uint32
+		BlockIndex uint32
+		Height     int32
+		Value      int64
+	}{}
+	if resp := s.db.Table("(? UNION ALL ?) AS deltas", s.addressFundings(addresses, options), s.addressSpends(addresses, options)).
+		Order("height, block_index, value, address, funding, idx").
+		Offset(options.Offset).
+		Limit(options.Limit).
+		Find(&rows); resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	deltas := make([]command.AddressDelta, len(rows))
+	for i, row := range rows {
+		deltas[i] = command.AddressDelta{
+			Address:    row.Address,
+			TxHash:     string(row.TxHash),
+			Index:      row.Idx,
+			BlockIndex: row.BlockIndex,
+			Height:     row.Height,
+			Value:      row.Value,
+		}
+	}
+	return deltas, nil
+}
+
+func (s *storage) GetAddressTxIDs(addresses []string, options command.AddressQueryOptions) ([]string, error) {
+	txids := []model.Hash{}
+	if resp := s.db.Table("(? UNION ALL ?) AS deltas", s.addressFundings(addresses, options), s.addressSpends(addresses, options)).
+		Select("tx_hash").
+		Group("tx_hash, height, block_index").
+		Order("height, block_index, tx_hash").
+		Offset(options.Offset).
+		Limit(options.Limit).
+		Pluck("tx_hash", &txids); resp.Error != nil {
+		return nil, resp.Error
+	}
+	return model.Strings(txids), nil
+}
+
+// GetAddressBalance returns the confirmed balance of the addresses and the
+// total amount they have received.
+func (s *storage) GetAddressBalance(addresses []string) (int64, int64, error) {
+	options := command.AddressQueryOptions{
+		StartHeight: 0,
+		EndHeight:   math.MaxInt32,
+	}
+
+	var received, spent int64
+	if resp := s.db.Table("(?) AS fundings", s.addressFundings(addresses, options)).Select("COALESCE(SUM(value), 0)").Scan(&received); resp.Error != nil {
+		return 0, 0, resp.Error
+	}
+	if resp := s.db.Table("(?) AS spends", s.addressSpends(addresses, options)).Select("COALESCE(-SUM(value), 0)").Scan(&spent); resp.Error != nil {
+		return 0, 0, resp.Error
+	}
+	return received - spent, received, nil
+}
@@ -0,0 +1,148 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/store"
+	"github.com/catalogfi/indexer/store/kv"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+const (
+	benchBlocks = 100
+	benchTxs    = 200
+)
+
+var params = &chaincfg.RegressionNetParams
+
+// BenchmarkPutBlock measures block ingestion throughput by storing a
+// synthetic chain into an empty in-memory database of each backend, so that
+// regressions in the write path are visible.
+func BenchmarkPutBlock(b *testing.B) {
+	blocks, err := fixtureChain(benchBlocks, benchTxs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	txs := 0
+	for _, block := range blocks {
+		txs += len(block.Transactions)
+	}
+
+	backends := []struct {
+		name       string
+		newStorage func(b *testing.B, i int) store.Storage
+	}{
+		{"sql", func(b *testing.B, i int) store.Storage { return store.NewTestStorage(b) }},
+		{"kv", newKVStorage},
+	}
+	for _, backend := range backends {
+		b.Run(backend.name, func(b *testing.B) {
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				str := backend.newStorage(b, i)
+				b.StartTimer()
+				start := time.Now()
+				for _, block := range blocks {
+					if err := str.PutBlock(block); err != nil {
+						b.Fatal(err)
+					}
+				}
+				elapsed += time.Since(start)
+			}
+			b.ReportMetric(float64(b.N*len(blocks))/elapsed.Seconds(), "blocks/s")
+			b.ReportMetric(float64(b.N*txs)/elapsed.Seconds(), "txs/s")
+		})
+	}
+}
+
+func newKVStorage(b *testing.B, i int) store.Storage {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return kv.NewStorage(params, db)
+}
+
+// fixtureChain builds a chain on top of the genesis block. The coinbase of
+// every block has an output for each of its transactions, and each
+// transaction spends the matching output of the previous block's coinbase
+// as well as the change of the previous block's transaction, so the blocks
+// exercise both new and spent outpoints.
+func fixtureChain(numBlocks, numTxs int) ([]*wire.MsgBlock, error) {
+	pkScripts := make([][]byte, numTxs+1)
+	for i := range pkScripts {
+		var hash [20]byte
+		hash[0], hash[1] = byte(i), byte(i>>8)
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(hash[:], params)
+		if err != nil {
+			return nil, err
+		}
+		if pkScripts[i], err = txscript.PayToAddrScript(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	blocks := make([]*wire.MsgBlock, 0, numBlocks)
+	prevHash := params.GenesisBlock.BlockHash()
+	var prev *wire.MsgBlock
+	for height := 1; height <= numBlocks; height++ {
+		coinbase := wire.NewMsgTx(wire.TxVersion)
+		coinbase.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+			SignatureScript:  []byte{0x03, byte(height), byte(height >> 8), byte(height >> 16)},
+			Sequence:         wire.MaxTxInSequenceNum,
+		})
+		for i := 0; i < numTxs+1; i++ {
+			coinbase.AddTxOut(wire.NewTxOut(100000, pkScripts[i]))
+		}
+		txs := []*wire.MsgTx{coinbase}
+
+		if prev != nil {
+			for i := 0; i < numTxs; i++ {
+				tx := wire.NewMsgTx(wire.TxVersion)
+				tx.AddTxIn(&wire.TxIn{
+					PreviousOutPoint: wire.OutPoint{Hash: prev.Transactions[0].TxHash(), Index: uint32(i)},
+					Witness:          wire.TxWitness{make([]byte, 72), make([]byte, 33)},
+					Sequence:         wire.MaxTxInSequenceNum,
+				})
+				if len(prev.Transactions) > i+1 {
+					tx.AddTxIn(&wire.TxIn{
+						PreviousOutPoint: wire.OutPoint{Hash: prev.Transactions[i+1].TxHash(), Index: 1},
+						Witness:          wire.TxWitness{make([]byte, 72), make([]byte, 33)},
+						Sequence:         wire.MaxTxInSequenceNum,
+					})
+				}
+				tx.AddTxOut(wire.NewTxOut(50000, pkScripts[i+1]))
+				tx.AddTxOut(wire.NewTxOut(40000, pkScripts[i]))
+				txs = append(txs, tx)
+			}
+		}
+
+		utxs := make([]*btcutil.Tx, len(txs))
+		for i, tx := range txs {
+			utxs[i] = btcutil.NewTx(tx)
+		}
+		merkles := blockchain.BuildMerkleTreeStore(utxs, false)
+		block := wire.NewMsgBlock(wire.NewBlockHeader(1, &prevHash, merkles[len(merkles)-1], params.PowLimitBits, uint32(height)))
+		block.Header.Timestamp = params.GenesisBlock.Header.Timestamp.Add(time.Duration(height) * 10 * time.Minute)
+		for _, tx := range txs {
+			if err := block.AddTransaction(tx); err != nil {
+				return nil, err
+			}
+		}
+
+		blocks = append(blocks, block)
+		prevHash = block.BlockHash()
+		prev = block
+	}
+	return blocks, nil
+}
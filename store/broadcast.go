@@ -0,0 +1,63 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/model"
+)
+
+func (s *storage) GetOutPoint(hash string, index uint32) (model.OutPoint, error) {
+	op := model.OutPoint{}
+	if res := s.db.First(&op, "funding_tx_hash = ? AND funding_tx_index = ? AND funding_tx_id IS NOT NULL", model.Hash(hash), index); res.Error != nil {
+		return model.OutPoint{}, queryError(res.Error)
+	}
+	return op, nil
+}
+
+// SubmitTx stores the transaction as unconfirmed and queues it to be relayed
+// by the peer.
+func (s *storage) SubmitTx(tx *wire.MsgTx) error {
+	buf := new(bytes.Buffer)
+	if err := tx.Serialize(buf); err != nil {
+		return err
+	}
+
+	return s.transaction(func(s *storage) error {
+		created, err := s.putTx(tx)
+		if err != nil {
+			return err
+		}
+		if created {
+			s.notifier.TxAccepted(tx)
+		}
+
+		// Submitting a transaction again relays it again. The raw transaction
+		// goes in Attrs since gorm reads a []byte condition as an IN list
+		return s.db.Where(model.BroadcastTx{Hash: model.Hash(tx.TxHash().String())}).
+			Attrs(model.BroadcastTx{Raw: buf.Bytes()}).
+			Assign(map[string]interface{}{"relayed": false}).
+			FirstOrCreate(&model.BroadcastTx{}).Error
+	})
+}
+
+func (s *storage) GetPendingBroadcasts() ([]*wire.MsgTx, error) {
+	broadcasts := []model.BroadcastTx{}
+	if res := s.db.Order("id").Find(&broadcasts, "relayed = ?", false); res.Error != nil {
+		return nil, res.Error
+	}
+
+	txs := make([]*wire.MsgTx, 0, len(broadcasts))
+	for _, broadcast := range broadcasts {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		if err := tx.Deserialize(bytes.NewReader(broadcast.Raw)); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+func (s *storage) MarkBroadcast(hash string) error {
+	return s.db.Model(&model.BroadcastTx{}).Where("hash = ?", model.Hash(hash)).Update("relayed", true).Error
+}
@@ -1,9 +1,8 @@
 package store
 
 import (
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -13,9 +12,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// queryError maps a missing record to command.ErrNotFound, so that commands
+// can tell it apart from other database errors.
+func queryError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return command.ErrNotFound
+	}
+	return err
+}
+
 func (s *storage) GetPreviousBlockHeight(blockhash string) (int32, error) {
 	block := model.Block{}
-	if res := s.db.First(&block, "hash = ?", blockhash); res.Error != nil {
+	if res := s.db.First(&block, "hash = ?", model.Hash(blockhash)); res.Error != nil {
 		return 0, res.Error
 	}
 	return block.Height, nil
@@ -23,7 +31,7 @@ func (s *storage) GetPreviousBlockHeight(blockhash string) (int32, error) {
 
 func (s *storage) GetLatestBlockHeight() (int32, error) {
 	block := &model.Block{}
-	if resp := s.db.Order("height desc").First(block); resp.Error != nil {
+	if resp := s.db.Order("height desc").First(block, "is_orphan = ?", false); resp.Error != nil {
 		if resp.Error == gorm.ErrRecordNotFound {
 			return -1, nil
 		}
@@ -34,18 +42,18 @@ func (s *storage) GetLatestBlockHeight() (int32, error) {
 
 func (s *storage) GetBlockHash(height int32) (string, error) {
 	block := &model.Block{}
-	if resp := s.db.First(block, "height = ?", height); resp.Error != nil {
-		return "", resp.Error
+	if resp := s.db.First(block, "height = ? AND is_orphan = ?", height, false); resp.Error != nil {
+		return "", queryError(resp.Error)
 	}
-	return block.Hash, nil
+	return string(block.Hash), nil
 }
 
 func (s *storage) GetLatestBlockHash() (string, error) {
 	block := &model.Block{}
-	if resp := s.db.Order("height desc").First(block); resp.Error != nil {
-		return "", resp.Error
+	if resp := s.db.Order("height desc").First(block, "is_orphan = ?", false); resp.Error != nil {
+		return "", queryError(resp.Error)
 	}
-	return block.Hash, nil
+	return string(block.Hash), nil
 }
 
 func (s *storage) GetBlockCount() (int32, error) {
@@ -54,16 +62,27 @@ func (s *storage) GetBlockCount() (int32, error) {
 
 func (s *storage) GetBlockFromHash(blockHash string) (*btcutil.Block, error) {
 	block := &model.Block{}
-	if resp := s.db.First(block, "hash = ?", blockHash); resp.Error != nil {
-		return nil, resp.Error
+	if resp := s.db.First(block, "hash = ?", model.Hash(blockHash)); resp.Error != nil {
+		return nil, queryError(resp.Error)
+	}
+
+	if len(block.Raw) > 0 {
+		// The block is not on the main chain, so its transactions are not
+		// attached to it
+		b, err := btcutil.NewBlockFromBytes(block.Raw)
+		if err != nil {
+			return nil, err
+		}
+		b.SetHeight(block.Height)
+		return b, nil
 	}
 
-	prevHash, err := chainhash.NewHashFromStr(block.PreviousBlock)
+	prevHash, err := chainhash.NewHashFromStr(string(block.PreviousBlock))
 	if err != nil {
 		return nil, err
 	}
 
-	merkleRootHash, err := chainhash.NewHashFromStr(block.MerkleRoot)
+	merkleRootHash, err := chainhash.NewHashFromStr(string(block.MerkleRoot))
 	if err != nil {
 		return nil, err
 	}
@@ -74,13 +93,20 @@ func (s *storage) GetBlockFromHash(blockHash string) (*btcutil.Block, error) {
 	msgBlock := wire.NewMsgBlock(blockHeader)
 
 	txs := []model.Transaction{}
-	if resp := s.db.Order("block_index").Find(&txs, "block_hash = ?", blockHash); resp.Error != nil {
+	if resp := s.db.Order("block_index").Find(&txs, "block_hash = ?", model.Hash(blockHash)); resp.Error != nil {
 		return nil, resp.Error
 	}
+	coinbase, err := s.duplicateCoinbase(block)
+	if err != nil {
+		return nil, err
+	}
+	if coinbase != nil {
+		txs = append([]model.Transaction{*coinbase}, txs...)
+	}
 	for _, transaction := range txs {
 		tx := wire.NewMsgTx(transaction.Version)
 		tx.LockTime = transaction.LockTime
-		if err := s.addInputsAndOutputs(transaction.Hash, tx); err != nil {
+		if err := s.addInputsAndOutputs(string(transaction.Hash), tx); err != nil {
 			return nil, err
 		}
 		if err := msgBlock.AddTransaction(tx); err != nil {
@@ -95,14 +121,14 @@ func (s *storage) GetBlockFromHash(blockHash string) (*btcutil.Block, error) {
 
 func (s *storage) GetHeaderFromHash(blockHash string) (command.BlockHeader, error) {
 	block := &model.Block{}
-	if resp := s.db.First(block, "hash = ?", blockHash); resp.Error != nil {
-		return command.BlockHeader{}, resp.Error
+	if resp := s.db.First(block, "hash = ?", model.Hash(blockHash)); resp.Error != nil {
+		return command.BlockHeader{}, queryError(resp.Error)
 	}
-	prevHash, err := chainhash.NewHashFromStr(block.PreviousBlock)
+	prevHash, err := chainhash.NewHashFromStr(string(block.PreviousBlock))
 	if err != nil {
 		return command.BlockHeader{}, err
 	}
-	merkleRootHash, err := chainhash.NewHashFromStr(block.MerkleRoot)
+	merkleRootHash, err := chainhash.NewHashFromStr(string(block.MerkleRoot))
 	if err != nil {
 		return command.BlockHeader{}, err
 	}
@@ -123,14 +149,14 @@ func (s *storage) GetHeaderFromHash(blockHash string) (command.BlockHeader, erro
 
 func (s *storage) GetHeaderFromHeight(height int32) (command.BlockHeader, error) {
 	block := &model.Block{}
-	if resp := s.db.First(block, "height = ?", height); resp.Error != nil {
-		return command.BlockHeader{}, resp.Error
+	if resp := s.db.First(block, "height = ? AND is_orphan = ?", height, false); resp.Error != nil {
+		return command.BlockHeader{}, queryError(resp.Error)
 	}
-	prevHash, err := chainhash.NewHashFromStr(block.PreviousBlock)
+	prevHash, err := chainhash.NewHashFromStr(string(block.PreviousBlock))
 	if err != nil {
 		return command.BlockHeader{}, err
 	}
-	merkleRootHash, err := chainhash.NewHashFromStr(block.MerkleRoot)
+	merkleRootHash, err := chainhash.NewHashFromStr(string(block.MerkleRoot))
 	if err != nil {
 		return command.BlockHeader{}, err
 	}
@@ -152,52 +178,38 @@ func (s *storage) GetHeaderFromHeight(height int32) (command.BlockHeader, error)
 func (s *storage) addInputsAndOutputs(txHash string, tx *wire.MsgTx) error {
 	txIns := []model.OutPoint{}
 	txOuts := []model.OutPoint{}
-	if res := s.db.Order("spending_tx_index").Find(&txIns, "spending_tx_hash = ?", txHash); res.Error != nil {
+	if res := s.db.Order("spending_tx_index").Find(&txIns, "spending_tx_hash = ?", model.Hash(txHash)); res.Error != nil {
 		return res.Error
 	}
+	if res := s.db.Order("funding_tx_index").Find(&txOuts, "funding_tx_hash = ?", model.Hash(txHash)); res.Error != nil {
+		return res.Error
+	}
+	return addInputsAndOutputs(tx, txIns, txOuts)
+}
+
+// addInputsAndOutputs adds the inputs and outputs of a transaction from its
+// spent and created outpoints, in the order of the inputs and outputs.
+func addInputsAndOutputs(tx *wire.MsgTx, txIns, txOuts []model.OutPoint) error {
 	for _, txIn := range txIns {
-		opHash, err := chainhash.NewHashFromStr(txIn.FundingTxHash)
+		opHash, err := chainhash.NewHashFromStr(string(txIn.FundingTxHash))
 		if err != nil {
 			return fmt.Errorf("invalid op hash: %v", err)
 		}
 
-		signatureScript, err := hex.DecodeString(txIn.SignatureScript)
-		if err != nil {
-			return fmt.Errorf("failed to decode sig script: %v", err)
-		}
-
-		witness := strings.Split(txIn.Witness, ",")
-		witnessBytes := make([][]byte, len(witness))
-		for i := range witness {
-			witness, err := hex.DecodeString(witness[i])
-			if err != nil {
-				return err
-			}
-			witnessBytes[i] = make([]byte, 32)
-			copy(witnessBytes[i], witness)
-		}
-
-		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(opHash, txIn.FundingTxIndex), signatureScript, witnessBytes))
-	}
-
-	if res := s.db.Order("funding_tx_index").Find(&txOuts, "funding_tx_hash = ?", txHash); res.Error != nil {
-		return res.Error
+		in := wire.NewTxIn(wire.NewOutPoint(opHash, txIn.FundingTxIndex), txIn.SignatureScript, wire.TxWitness(txIn.Witness))
+		in.Sequence = txIn.Sequence
+		tx.AddTxIn(in)
 	}
 	for _, txOut := range txOuts {
-		pkScript, err := hex.DecodeString(txOut.PkScript)
-		if err != nil {
-			return fmt.Errorf("failed to decode pkScript: %v", err)
-		}
-
-		tx.AddTxOut(wire.NewTxOut(txOut.Value, pkScript))
+		tx.AddTxOut(wire.NewTxOut(txOut.Value, txOut.PkScript))
 	}
 	return nil
 }
 
 func (s *storage) GetTransaction(txHash string) (command.Transaction, error) {
 	transaction := model.Transaction{}
-	if res := s.db.Joins("Block").First(&transaction, "transactions.hash = ?", txHash); res.Error != nil {
-		return command.Transaction{}, res.Error
+	if res := s.db.Preload("Block").First(&transaction, "hash = ?", model.Hash(txHash)); res.Error != nil {
+		return command.Transaction{}, queryError(res.Error)
 	}
 	tx := wire.NewMsgTx(transaction.Version)
 	tx.LockTime = transaction.LockTime
@@ -205,30 +217,33 @@ func (s *storage) GetTransaction(txHash string) (command.Transaction, error) {
 		return command.Transaction{}, err
 	}
 
-	if transaction.BlockHash == "" {
+	if transaction.Block == nil {
 		return command.Transaction{
 			Tx: tx,
 		}, nil
 	}
-	block, err := s.GetBlockFromHash(transaction.BlockHash)
-	if err != nil {
-		return command.Transaction{}, nil
-	}
-
 	return command.Transaction{
 		Tx:        tx,
-		BlockHash: transaction.BlockHash,
-		Height:    block.Height(),
-		BlockTime: block.MsgBlock().Header.Timestamp.Unix(),
+		BlockHash: string(transaction.Block.Hash),
+		Height:    transaction.Block.Height,
+		BlockTime: transaction.Block.Timestamp.Unix(),
 	}, nil
 }
 
-func (storage *storage) ListUnspent(startBlock, endBlock int, addresses []string, includeUnsafe bool, options command.ListUnspentQueryOptions) ([]model.OutPoint, error) {
-	outpoints := []model.OutPoint{}
+// ListUnspent returns the outputs paying to the addresses that are unspent
+// and were confirmed in the height range, excluding the ones of unsafe
+// transactions unless includeUnsafe is set.
+func (s *storage) ListUnspent(startBlock, endBlock int, addresses []string, includeUnsafe bool, options command.ListUnspentQueryOptions) ([]model.OutPoint, error) {
+	funding := s.db.Where("block_id IS NOT NULL")
 	if !includeUnsafe {
-		resp := storage.db.Joins("FundingTx.Block", "height >= ? AND height <= ?", startBlock, endBlock).Joins("FundingTx", "safe = ?", true).Limit(int(options.MaximumCount)).Find(&outpoints, "spender IN ? AND value >= ? AND value <= ?", addresses, options.MinimumAmount, options.MaximumAmount)
-		return outpoints, resp.Error
+		funding = funding.Where("safe = ?", true)
 	}
-	resp := storage.db.Joins("FundingTx.Block", "height >= ? AND height <= ?", startBlock, endBlock).Joins("FundingTx").Limit(int(options.MaximumCount)).Find(&outpoints, "spender IN ? AND value >= ? AND value <= ?", addresses, options.MinimumAmount, options.MaximumAmount)
+	outpoints := []model.OutPoint{}
+	resp := s.db.InnerJoins("FundingTx", funding).
+		InnerJoins("FundingTx.Block", s.db.Where("is_orphan = ? AND height >= ? AND height <= ?", false, startBlock, endBlock)).
+		Where("out_points.spender IN ? AND out_points.spending_tx_id IS NULL AND out_points.value >= ? AND out_points.value <= ?", addresses, options.MinimumAmount, options.MaximumAmount).
+		Order("out_points.id").
+		Limit(int(options.MaximumCount)).
+		Find(&outpoints)
 	return outpoints, resp.Error
 }
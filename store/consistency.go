@@ -0,0 +1,202 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/model"
+	"gorm.io/gorm"
+)
+
+const consistencyBatchSize = 1000
+
+// duplicateCoinbases are the coinbase transactions that a later block
+// repeated before BIP30 disallowed it, by network and by the hash of the
+// earlier block. A transaction is stored once, with the last block that
+// confirmed it, so the earlier blocks are stored without their coinbase.
+var duplicateCoinbases = map[wire.BitcoinNet]map[string]string{
+	wire.MainNet: {
+		// Blocks 91722 and 91812, repeated by blocks 91880 and 91842
+		"00000000000271a2dc26e7667f8419f2e15416dc6955e5a6c6cdf3f2574dd08e": "e3bf3d07d4b0375638d5f1db5255fe07ba2c4cb067cd81b84ee974b6585fb468",
+		"00000000000af0aed4792b1acee3d966af36cf5def14935db8de83d6f9306f2f": "d5d27987d2a3dfc724e359870c6644b40e497bdc0589a033220fe15429d88599",
+	},
+}
+
+// CheckConsistency verifies the blocks that are not marked complete, which
+// are the ones written before blocks were stored atomically. A block is
+// complete when the merkle root of its transactions, rebuilt from their
+// stored inputs and outputs, matches its header, with the coinbase of a later
+// block for the mainnet blocks in duplicateCoinbases. Only the tip can be left
+// partially written by an interrupted write, so a partial tip is removed
+// for the peer to download it again. Partial blocks below the tip are
+// logged and reported as an error, leaving the chain untouched.
+func (s *storage) CheckConsistency() error {
+	partial := []model.Block{}
+	after := int32(0)
+	for {
+		blocks := []model.Block{}
+		if resp := s.db.Where("complete = ? AND is_orphan = ? AND height > ?", false, false, after).
+			Order("height").Limit(consistencyBatchSize).Find(&blocks); resp.Error != nil {
+			return resp.Error
+		}
+		if len(blocks) == 0 {
+			break
+		}
+
+		ids := make([]uint, len(blocks))
+		for i, block := range blocks {
+			ids[i] = block.ID
+		}
+		txs, err := s.blockTxs(ids)
+		if err != nil {
+			return err
+		}
+
+		complete := []uint{}
+		for _, block := range blocks {
+			coinbase, err := s.duplicateCoinbase(&block)
+			if err != nil {
+				return err
+			}
+			if coinbase != nil {
+				tx := wire.NewMsgTx(coinbase.Version)
+				tx.LockTime = coinbase.LockTime
+				if err := s.addInputsAndOutputs(string(coinbase.Hash), tx); err != nil {
+					return err
+				}
+				txs[block.ID] = append([]*btcutil.Tx{btcutil.NewTx(tx)}, txs[block.ID]...)
+			}
+			if len(txs[block.ID]) == 0 || merkleRoot(txs[block.ID]).String() != string(block.MerkleRoot) {
+				partial = append(partial, block)
+				continue
+			}
+			complete = append(complete, block.ID)
+		}
+		if err := s.updateComplete(complete); err != nil {
+			return err
+		}
+		after = blocks[len(blocks)-1].Height
+		fmt.Println("Verified blocks up to height", after)
+	}
+	if len(partial) == 0 {
+		return nil
+	}
+
+	tip, err := s.GetLatestBlockHeight()
+	if err != nil {
+		return err
+	}
+	if len(partial) == 1 && partial[0].Height == tip {
+		fmt.Println("Block", tip, partial[0].Hash, "was partially written, removing it")
+		return s.transaction(func(s *storage) error {
+			return s.truncate(tip)
+		})
+	}
+	for _, block := range partial {
+		fmt.Println("Block", block.Height, block.Hash, "does not match its merkle root")
+	}
+	return fmt.Errorf("%d partially written blocks below the tip, from height %d", len(partial), partial[0].Height)
+}
+
+// duplicateCoinbase returns the coinbase transaction of the block if a later
+// block repeated it and it is stored with that block, and nil otherwise.
+func (s *storage) duplicateCoinbase(block *model.Block) (*model.Transaction, error) {
+	hash, ok := duplicateCoinbases[s.params.Net][string(block.Hash)]
+	if !ok {
+		return nil, nil
+	}
+	coinbase := &model.Transaction{}
+	if resp := s.db.First(coinbase, "hash = ?", model.Hash(hash)); errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if coinbase.BlockID != nil && *coinbase.BlockID == block.ID {
+		return nil, nil
+	}
+	return coinbase, nil
+}
+
+func (s *storage) updateComplete(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.Model(&model.Block{}).Where("id IN ?", ids).Update("complete", true).Error
+}
+
+// truncate removes the main chain blocks from the given height upwards
+// together with their transactions and fee stats, and restores the outputs
+// they spent.
+func (s *storage) truncate(height int32) error {
+	blockIDs := s.db.Model(&model.Block{}).Select("id").Where("height >= ? AND is_orphan = ?", height, false)
+	txIDs := s.db.Model(&model.Transaction{}).Select("id").Where("block_id IN (?)", blockIDs)
+
+	// Outputs created by the removed transactions, and the placeholder
+	// outputs of their coinbase inputs
+	if resp := s.db.Unscoped().Where("funding_tx_id IN (?) OR (funding_tx_id IS NULL AND spending_tx_id IN (?))", txIDs, txIDs).
+		Delete(&model.OutPoint{}); resp.Error != nil {
+		return resp.Error
+	}
+	if resp := s.db.Model(&model.OutPoint{}).Where("spending_tx_id IN (?)", txIDs).Updates(unspent()); resp.Error != nil {
+		return resp.Error
+	}
+	if resp := s.db.Unscoped().Where("id IN (?)", txIDs).Delete(&model.Transaction{}); resp.Error != nil {
+		return resp.Error
+	}
+	if resp := s.db.Unscoped().Where("block_id IN (?)", blockIDs).Delete(&model.FeeStat{}); resp.Error != nil {
+		return resp.Error
+	}
+	return s.db.Unscoped().Where("height >= ? AND is_orphan = ?", height, false).Delete(&model.Block{}).Error
+}
+
+// blockTxs rebuilds the transactions of the given blocks from their stored
+// inputs and outputs, by block ID and in the order of the blocks.
+func (s *storage) blockTxs(blockIDs []uint) (map[uint][]*btcutil.Tx, error) {
+	transactions := []model.Transaction{}
+	if resp := s.db.Where("block_id IN ?", blockIDs).Order("block_id, block_index").Find(&transactions); resp.Error != nil {
+		return nil, resp.Error
+	}
+	txIDs := make([]uint, len(transactions))
+	for i, transaction := range transactions {
+		txIDs[i] = transaction.ID
+	}
+	inputs := []model.OutPoint{}
+	outputs := []model.OutPoint{}
+	if len(txIDs) > 0 {
+		if resp := s.db.Where("spending_tx_id IN ?", txIDs).Order("spending_tx_index").Find(&inputs); resp.Error != nil {
+			return nil, resp.Error
+		}
+		if resp := s.db.Where("funding_tx_id IN ?", txIDs).Order("funding_tx_index").Find(&outputs); resp.Error != nil {
+			return nil, resp.Error
+		}
+	}
+	txIns := map[uint][]model.OutPoint{}
+	for _, input := range inputs {
+		txIns[*input.SpendingTxID] = append(txIns[*input.SpendingTxID], input)
+	}
+	txOuts := map[uint][]model.OutPoint{}
+	for _, output := range outputs {
+		txOuts[*output.FundingTxID] = append(txOuts[*output.FundingTxID], output)
+	}
+
+	txs := map[uint][]*btcutil.Tx{}
+	for _, transaction := range transactions {
+		tx := wire.NewMsgTx(transaction.Version)
+		tx.LockTime = transaction.LockTime
+		if err := addInputsAndOutputs(tx, txIns[transaction.ID], txOuts[transaction.ID]); err != nil {
+			return nil, err
+		}
+		txs[*transaction.BlockID] = append(txs[*transaction.BlockID], btcutil.NewTx(tx))
+	}
+	return txs, nil
+}
+
+// merkleRoot computes the merkle root of the given transactions.
+func merkleRoot(txs []*btcutil.Tx) chainhash.Hash {
+	merkles := blockchain.BuildMerkleTreeStore(txs, false)
+	return *merkles[len(merkles)-1]
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/model"
+	"github.com/catalogfi/indexer/store/storetest"
+)
+
+// partialChain stores three blocks, a fourth one confirming a transaction
+// seen in the mempool and n more blocks, checks that they are consistent,
+// and then makes the fourth block lose the outputs of the transaction as
+// blocks written before they were stored atomically can. It returns the
+// first and the fourth block and the transaction.
+func partialChain(t *testing.T, s *storage, n int) (*wire.MsgBlock, *wire.MsgBlock, *wire.MsgTx) {
+	t.Helper()
+	blocks := storetest.PutChain(t, s, storetest.Params.GenesisBlock.BlockHash(), 1, 3, 0)
+	spend := storetest.SpendTx(blocks[0].Transactions[0], 0, 49e8, storetest.Address(2), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(spend); err != nil {
+		t.Fatal(err)
+	}
+	partial := storetest.NewBlock(blocks[2].BlockHash(), 4*600, storetest.CoinbaseTx(4, 0, storetest.Address(1)), spend)
+	if err := s.PutBlock(partial); err != nil {
+		t.Fatal(err)
+	}
+	storetest.PutChain(t, s, partial.BlockHash(), 5, n, 0)
+
+	// Complete blocks are left alone
+	if err := s.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	if height, err := s.GetLatestBlockHeight(); err != nil || height != int32(4+n) {
+		t.Fatalf("got height %d (%v), want %d", height, err, 4+n)
+	}
+
+	if err := s.db.Model(&model.Block{}).Where("height > 0").Update("complete", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Unscoped().Delete(&model.OutPoint{}, "funding_tx_hash = ?", model.Hash(spend.TxHash().String())).Error; err != nil {
+		t.Fatal(err)
+	}
+	return blocks[0], partial, spend
+}
+
+func assertHeights(t *testing.T, s *storage, complete int64, want ...int32) {
+	t.Helper()
+	heights := []int32{}
+	if err := s.db.Model(&model.Block{}).Order("height").Pluck("height", &heights).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(heights) != len(want) || heights[len(heights)-1] != want[len(want)-1] {
+		t.Fatalf("got blocks at heights %v, want %v", heights, want)
+	}
+	count := int64(0)
+	if err := s.db.Model(&model.Block{}).Where("complete = ? AND height > 0", true).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != complete {
+		t.Fatalf("got %d complete blocks, want %d", count, complete)
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	t.Run("below the tip", func(t *testing.T) {
+		s := newTestStorage(t)
+		_, partial, _ := partialChain(t, s, 2)
+
+		// A partial block below the tip is reported without removing
+		// anything, the other blocks are verified
+		if err := s.CheckConsistency(); err == nil {
+			t.Fatal("got no error for a partial block below the tip")
+		}
+		assertHeights(t, s, 5, 0, 1, 2, 3, 4, 5, 6)
+		block := model.Block{}
+		if err := s.db.First(&block, "hash = ?", model.Hash(partial.BlockHash().String())).Error; err != nil || block.Complete {
+			t.Fatalf("got partial block complete %v (%v), want it incomplete", block.Complete, err)
+		}
+	})
+
+	t.Run("tip", func(t *testing.T) {
+		s := newTestStorage(t)
+		first, partial, spend := partialChain(t, s, 0)
+		stats := int64(0)
+		if err := s.db.Model(&model.FeeStat{}).Count(&stats).Error; err != nil || stats == 0 {
+			t.Fatalf("got %d fee stats (%v), want the ones of the partial block", stats, err)
+		}
+
+		// A partial tip is removed with its transactions and fee stats
+		if err := s.CheckConsistency(); err != nil {
+			t.Fatal(err)
+		}
+		assertHeights(t, s, 3, 0, 1, 2, 3)
+		if _, err := s.GetTransaction(spend.TxHash().String()); err == nil {
+			t.Fatal("transaction of the removed block is still stored")
+		}
+		if err := s.db.Unscoped().Model(&model.FeeStat{}).Count(&stats).Error; err != nil || stats != 0 {
+			t.Fatalf("got %d fee stats (%v) of the removed block, want none", stats, err)
+		}
+		op, err := s.GetOutPoint(first.Transactions[0].TxHash().String(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op.SpendingTxID != nil {
+			t.Fatal("output spent by the removed block is still spent")
+		}
+
+		// The removed block can be downloaded again
+		if err := s.PutBlock(partial); err != nil {
+			t.Fatal(err)
+		}
+		op, err = s.GetOutPoint(first.Transactions[0].TxHash().String(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op.SpendingTxID == nil {
+			t.Fatal("output is not spent after the block was stored again")
+		}
+	})
+}
+
+func TestCheckConsistencyDuplicateCoinbase(t *testing.T) {
+	s := newTestStorage(t)
+	blocks := storetest.PutChain(t, s, storetest.Params.GenesisBlock.BlockHash(), 1, 1, 0)
+	coinbase := blocks[0].Transactions[0]
+	repeat := storetest.NewBlock(blocks[0].BlockHash(), 2*600, coinbase)
+	if err := s.PutBlock(repeat); err != nil {
+		t.Fatal(err)
+	}
+	storetest.PutChain(t, s, repeat.BlockHash(), 3, 1, 0)
+	if err := s.db.Model(&model.Block{}).Where("height > 0").Update("complete", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The coinbase is stored with the block repeating it, so the first block
+	// has no transactions unless it is a known exception
+	if err := s.CheckConsistency(); err == nil {
+		t.Fatal("got no error for a block without its coinbase")
+	}
+	duplicateCoinbases[storetest.Params.Net] = map[string]string{blocks[0].BlockHash().String(): coinbase.TxHash().String()}
+	t.Cleanup(func() { delete(duplicateCoinbases, storetest.Params.Net) })
+	if err := s.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	assertHeights(t, s, 3, 0, 1, 2, 3)
+
+	for _, want := range []*wire.MsgBlock{blocks[0], repeat} {
+		block, err := s.GetBlockFromHash(want.BlockHash().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txs := block.Transactions(); len(txs) != 1 || txs[0].Hash().String() != coinbase.TxHash().String() {
+			t.Fatalf("got transactions %v of block %s, want the coinbase", txs, want.BlockHash())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"github.com/catalogfi/indexer/electrum"
+	"github.com/catalogfi/indexer/model"
+)
+
+func (s *storage) GetScriptHashOutputs(scriptHash string) ([]electrum.Output, error) {
+	rows := []struct {
+		TxHash     model.Hash
+		Index      uint32
+		Value      int64
+		Height     int32
+		BlockIndex uint32
+
+		SpendingTxHash     model.Hash
+		SpendingHeight     int32
+		SpendingBlockIndex uint32
+	}{}
+	if resp := s.db.Model(&model.OutPoint{}).
+		Select(`out_points.funding_tx_hash AS tx_hash, out_points.funding_tx_index AS "index", out_points.value AS value,
+			COALESCE(funding_blocks.height, 0) AS height, funding_txs.block_index AS block_index,
+			out_points.spending_tx_hash AS spending_tx_hash, COALESCE(spending_blocks.height, 0) AS spending_height,
+			COALESCE(spending_txs.block_index, 0) AS spending_block_index`).
+		Joins("JOIN transactions AS funding_txs ON funding_txs.id = out_points.funding_tx_id").
+		Joins("LEFT JOIN blocks AS funding_blocks ON funding_blocks.id = funding_txs.block_id AND funding_blocks.is_orphan = ?", false).
+		Joins("LEFT JOIN transactions AS spending_txs ON spending_txs.id = out_points.spending_tx_id").
+		Joins("LEFT JOIN blocks AS spending_blocks ON spending_blocks.id = spending_txs.block_id AND spending_blocks.is_orphan = ?", false).
+		Where("out_points.script_hash = ?", model.Hash(scriptHash)).
+		Order("out_points.id").
+		Scan(&rows); resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	outputs := make([]electrum.Output, len(rows))
+	for i, row := range rows {
+		outputs[i] = electrum.Output{
+			TxHash:             string(row.TxHash),
+			Index:              row.Index,
+			Value:              row.Value,
+			Height:             row.Height,
+			BlockIndex:         row.BlockIndex,
+			SpendingTxHash:     string(row.SpendingTxHash),
+			SpendingHeight:     row.SpendingHeight,
+			SpendingBlockIndex: row.SpendingBlockIndex,
+		}
+	}
+	return outputs, nil
+}
+
+func (s *storage) GetBlockTxIDs(blockHash string) ([]string, error) {
+	txids := []model.Hash{}
+	resp := s.db.Model(&model.Transaction{}).Where("block_hash = ?", model.Hash(blockHash)).Order("block_index").Pluck("hash", &txids)
+	return model.Strings(txids), resp.Error
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"math"
+
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/esplora"
+	"github.com/catalogfi/indexer/model"
+)
+
+func (s *storage) GetTxStatus(txHash string) (esplora.TxStatus, error) {
+	transaction := model.Transaction{}
+	if res := s.db.Preload("Block").First(&transaction, "hash = ?", model.Hash(txHash)); res.Error != nil {
+		return esplora.TxStatus{}, queryError(res.Error)
+	}
+	return txStatus(transaction), nil
+}
+
+func txStatus(transaction model.Transaction) esplora.TxStatus {
+	if transaction.Block == nil {
+		return esplora.TxStatus{Confirmed: false}
+	}
+	return esplora.TxStatus{
+		Confirmed:   true,
+		BlockHeight: transaction.Block.Height,
+		BlockHash:   string(transaction.Block.Hash),
+		BlockTime:   transaction.Block.Timestamp.Unix(),
+	}
+}
+
+// transactions returns the transactions with their blocks by hash, or
+// command.ErrNotFound if one of them is missing.
+func (s *storage) transactions(txHashes []string) (map[model.Hash]model.Transaction, error) {
+	transactions := make(map[model.Hash]model.Transaction, len(txHashes))
+	if len(txHashes) == 0 {
+		return transactions, nil
+	}
+	found := []model.Transaction{}
+	if res := s.db.Preload("Block").Find(&found, "hash IN ?", model.Hashes(txHashes)); res.Error != nil {
+		return nil, res.Error
+	}
+	for _, transaction := range found {
+		transactions[transaction.Hash] = transaction
+	}
+	for _, txHash := range txHashes {
+		if _, ok := transactions[model.Hash(txHash)]; !ok {
+			return nil, command.ErrNotFound
+		}
+	}
+	return transactions, nil
+}
+
+func (s *storage) GetTxStatuses(txHashes []string) ([]esplora.TxStatus, error) {
+	transactions, err := s.transactions(txHashes)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]esplora.TxStatus, len(txHashes))
+	for i, txHash := range txHashes {
+		statuses[i] = txStatus(transactions[model.Hash(txHash)])
+	}
+	return statuses, nil
+}
+
+// GetTxDetails loads the transactions, their inputs and their outputs with
+// three queries whatever the number of transactions.
+func (s *storage) GetTxDetails(txHashes []string) ([]esplora.TxDetails, error) {
+	transactions, err := s.transactions(txHashes)
+	if err != nil {
+		return nil, err
+	}
+	inputs := []model.OutPoint{}
+	outputs := []model.OutPoint{}
+	if len(txHashes) > 0 {
+		if res := s.db.Order("spending_tx_index").Find(&inputs, "spending_tx_hash IN ?", model.Hashes(txHashes)); res.Error != nil {
+			return nil, res.Error
+		}
+		if res := s.db.Order("funding_tx_index").Find(&outputs, "funding_tx_hash IN ?", model.Hashes(txHashes)); res.Error != nil {
+			return nil, res.Error
+		}
+	}
+	txIns := map[model.Hash][]model.OutPoint{}
+	for _, input := range inputs {
+		txIns[input.SpendingTxHash] = append(txIns[input.SpendingTxHash], input)
+	}
+	txOuts := map[model.Hash][]model.OutPoint{}
+	for _, output := range outputs {
+		txOuts[output.FundingTxHash] = append(txOuts[output.FundingTxHash], output)
+	}
+
+	details := make([]esplora.TxDetails, len(txHashes))
+	for i, txHash := range txHashes {
+		transaction := transactions[model.Hash(txHash)]
+		tx := wire.NewMsgTx(transaction.Version)
+		tx.LockTime = transaction.LockTime
+		if err := addInputsAndOutputs(tx, txIns[transaction.Hash], txOuts[transaction.Hash]); err != nil {
+			return nil, err
+		}
+		details[i] = esplora.TxDetails{
+			Tx:     tx,
+			Inputs: txIns[transaction.Hash],
+			Status: txStatus(transaction),
+		}
+	}
+	return details, nil
+}
+
+// GetTxOutPoints returns the outpoints spent by the transaction and the
+// outpoints created by it, both in the order of the transaction's inputs and
+// outputs.
+func (s *storage) GetTxOutPoints(txHash string) ([]model.OutPoint, []model.OutPoint, error) {
+	inputs := []model.OutPoint{}
+	if res := s.db.Order("spending_tx_index").Find(&inputs, "spending_tx_hash = ?", model.Hash(txHash)); res.Error != nil {
+		return nil, nil, res.Error
+	}
+	outputs := []model.OutPoint{}
+	if res := s.db.Order("funding_tx_index").Find(&outputs, "funding_tx_hash = ?", model.Hash(txHash)); res.Error != nil {
+		return nil, nil, res.Error
+	}
+	return inputs, outputs, nil
+}
+
+// GetAddressChainTxIDs returns the confirmed transactions of the address,
+// newest first, starting after lastSeenTxID if it is not empty.
+func (s *storage) GetAddressChainTxIDs(address, lastSeenTxID string, limit int) ([]string, error) {
+	options := command.AddressQueryOptions{
+		StartHeight: 0,
+		EndHeight:   math.MaxInt32,
+	}
+	query := s.db.Table("(? UNION ALL ?) AS deltas", s.addressFundings([]string{address}, options), s.addressSpends([]string{address}, options))
+
+	if lastSeenTxID != "" {
+		status, err := s.GetTxStatus(lastSeenTxID)
+		if err != nil {
+			return nil, err
+		}
+		transaction := model.Transaction{}
+		if res := s.db.First(&transaction, "hash = ?", model.Hash(lastSeenTxID)); res.Error != nil {
+			return nil, res.Error
+		}
+		query = query.Where("height < ? OR (height = ? AND block_index < ?)", status.BlockHeight, status.BlockHeight, transaction.BlockIndex)
+	}
+
+	txids := []model.Hash{}
+	if res := query.Select("tx_hash").
+		Group("tx_hash, height, block_index").
+		Order("height DESC, block_index DESC").
+		Limit(limit).
+		Pluck("tx_hash", &txids); res.Error != nil {
+		return nil, res.Error
+	}
+	return model.Strings(txids), nil
+}
+
+// GetAddressMempoolTxIDs returns the unconfirmed transactions funding or
+// spending the address, newest first.
+func (s *storage) GetAddressMempoolTxIDs(address string, limit int) ([]string, error) {
+	fundings := s.db.Model(&model.OutPoint{}).
+		Select("transactions.id AS id, transactions.hash AS tx_hash").
+		Joins("JOIN transactions ON transactions.id = out_points.funding_tx_id").
+		Where("out_points.spender = ? AND transactions.block_id IS NULL", address)
+	spends := s.db.Model(&model.OutPoint{}).
+		Select("transactions.id AS id, transactions.hash AS tx_hash").
+		Joins("JOIN transactions ON transactions.id = out_points.spending_tx_id").
+		Where("out_points.spender = ? AND transactions.block_id IS NULL", address)
+
+	txids := []model.Hash{}
+	if res := s.db.Table("(? UNION ?) AS txs", fundings, spends).
+		Select("tx_hash").
+		Order("id DESC").
+		Limit(limit).
+		Pluck("tx_hash", &txids); res.Error != nil {
+		return nil, res.Error
+	}
+	return model.Strings(txids), nil
+}
+
+// GetAddressUnspent returns the outputs paying to the address that are not
+// spent by a confirmed or an unconfirmed transaction.
+func (s *storage) GetAddressUnspent(address string) ([]model.OutPoint, error) {
+	outpoints := []model.OutPoint{}
+	res := s.db.Order("id").Find(&outpoints, "spender = ? AND spending_tx_id IS NULL", address)
+	return outpoints, res.Error
+}
@@ -0,0 +1,9 @@
+package store
+
+import "testing"
+
+// NewTestStorage exposes newTestStorage to the external tests of the
+// package.
+func NewTestStorage(t testing.TB, opts ...Option) Storage {
+	return newTestStorage(t, opts...)
+}
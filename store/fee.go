@@ -0,0 +1,92 @@
+package store
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+// putFeeStats counts the transactions the block confirms that were seen in
+// the mempool, by fee rate bucket and by the number of blocks they waited
+// for.
+func (s *storage) putFeeStats(block *model.Block, confirmed []model.Transaction) error {
+	type key struct {
+		bucket int
+		blocks int32
+	}
+	stats := map[key]*model.FeeStat{}
+	order := []key{}
+	for _, transaction := range confirmed {
+		if transaction.FirstSeenHeight <= 0 || transaction.FirstSeenHeight >= block.Height || transaction.Weight == 0 {
+			continue
+		}
+		feeRate := float64(transaction.Fee) * 4 / float64(transaction.Weight)
+		k := key{command.FeeRateBucket(feeRate), block.Height - transaction.FirstSeenHeight}
+		stat, ok := stats[k]
+		if !ok {
+			stat = &model.FeeStat{BlockID: block.ID, Bucket: k.bucket, Blocks: k.blocks}
+			stats[k] = stat
+			order = append(order, k)
+		}
+		stat.Count++
+		stat.FeeRate += feeRate
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+
+	feeStats := make([]model.FeeStat, len(order))
+	for i, k := range order {
+		feeStats[i] = *stats[k]
+	}
+	return s.db.CreateInBatches(&feeStats, insertBatchSize).Error
+}
+
+// GetFeeStats returns the transactions confirmed in the last blocks of the
+// main chain by fee rate bucket and block, counting the ones confirmed
+// within the target, along with the transactions in the mempool that waited
+// for longer than the target.
+func (s *storage) GetFeeStats(target, blocks int32) ([]command.FeeStats, error) {
+	tip, err := s.GetLatestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []struct {
+		Bucket    int
+		Height    int32
+		Confirmed int64
+		Total     int64
+		FeeRate   float64
+	}{}
+	if resp := s.db.Model(&model.FeeStat{}).
+		Select("fee_stats.bucket, blocks.height, SUM(CASE WHEN fee_stats.blocks <= ? THEN fee_stats.count ELSE 0 END) AS confirmed, SUM(fee_stats.count) AS total, SUM(fee_stats.fee_rate) AS fee_rate", target).
+		Joins("JOIN blocks ON blocks.id = fee_stats.block_id AND blocks.is_orphan = ? AND blocks.deleted_at IS NULL", false).
+		Where("blocks.height > ?", tip-blocks).
+		Group("fee_stats.bucket, blocks.height").Scan(&rows); resp.Error != nil {
+		return nil, resp.Error
+	}
+	stats := make([]command.FeeStats, 0, len(rows))
+	for _, row := range rows {
+		stats = append(stats, command.FeeStats{
+			Bucket:    row.Bucket,
+			Age:       tip - row.Height,
+			Confirmed: row.Confirmed,
+			Total:     row.Total,
+			FeeRate:   row.FeeRate,
+		})
+	}
+
+	waiting := []model.Transaction{}
+	if resp := s.db.Select("fee", "weight").Find(&waiting, "block_id IS NULL AND hash <> ? AND weight > 0 AND first_seen_height > 0 AND first_seen_height <= ?", model.Hash(chainhash.Hash{}.String()), tip-target); resp.Error != nil {
+		return nil, resp.Error
+	}
+	unconfirmed := map[int]int64{}
+	for _, transaction := range waiting {
+		unconfirmed[command.FeeRateBucket(float64(transaction.Fee)*4/float64(transaction.Weight))]++
+	}
+	for bucket, count := range unconfirmed {
+		stats = append(stats, command.FeeStats{Bucket: bucket, Age: -1, Total: count})
+	}
+	return stats, nil
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/blockfilter"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+	"gorm.io/gorm"
+)
+
+// putBlockFilter stores the basic filter of a block connected to the main
+// chain, unless it is stored already. The filter is skipped if the filter of
+// the previous block is missing, which is the case for blocks stored before
+// filters were until BackfillBlockFilters builds them.
+func (s *storage) putBlockFilter(block *wire.MsgBlock) error {
+	blockHash := model.Hash(block.BlockHash().String())
+	if resp := s.db.First(&model.BlockFilter{}, "block_hash = ?", blockHash); resp.Error == nil {
+		return nil
+	} else if !errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+		return resp.Error
+	}
+
+	prevHeader := chainhash.Hash{}
+	if block.Header.PrevBlock != (chainhash.Hash{}) {
+		prevFilter := model.BlockFilter{}
+		if resp := s.db.First(&prevFilter, "block_hash = ?", model.Hash(block.Header.PrevBlock.String())); resp.Error != nil {
+			if errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+				fmt.Println("Block filter of", blockHash, "not stored: the filter of the previous block is missing")
+				return nil
+			}
+			return resp.Error
+		}
+		header, err := chainhash.NewHashFromStr(string(prevFilter.Header))
+		if err != nil {
+			return err
+		}
+		prevHeader = *header
+	}
+
+	prevOutScripts, err := s.prevOutScripts(block)
+	if err != nil {
+		return err
+	}
+	if prevOutScripts == nil {
+		fmt.Println("Block filter of", blockHash, "not stored: spent outputs are missing")
+		return nil
+	}
+
+	filter, header, err := blockfilter.BuildBasic(block, prevOutScripts, prevHeader)
+	if err != nil {
+		return err
+	}
+	return s.db.Create(&model.BlockFilter{
+		BlockHash: blockHash,
+		Filter:    filter,
+		Header:    model.Hash(header.String()),
+	}).Error
+}
+
+// BackfillBlockFilters builds the filters missing from the main chain, from
+// the block after the last one with a filter up to the tip. Blocks stored
+// before filters were have none, and neither have the blocks connected after
+// them since the header of a filter commits to the previous one.
+func (s *storage) BackfillBlockFilters() error {
+	tip, err := s.tip()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	start := int32(0)
+	last := model.Block{}
+	if resp := s.db.Joins("JOIN block_filters ON block_filters.block_hash = blocks.hash AND block_filters.deleted_at IS NULL").
+		Order("blocks.height desc").First(&last, "blocks.is_orphan = ?", false); resp.Error == nil {
+		start = last.Height + 1
+	} else if !errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+		return resp.Error
+	}
+	if start > tip.Height {
+		return nil
+	}
+
+	fmt.Println("Building the block filters from height", start, "to", tip.Height)
+	for height := start; height <= tip.Height; height++ {
+		block := s.params.GenesisBlock
+		if height > 0 {
+			hash, err := s.GetBlockHash(height)
+			if err != nil {
+				return err
+			}
+			b, err := s.GetBlockFromHash(hash)
+			if err != nil {
+				return err
+			}
+			block = b.MsgBlock()
+		}
+		if err := s.putBlockFilter(block); err != nil {
+			return err
+		}
+		// putBlockFilter reported why the filter could not be built, and the
+		// following ones cannot be either
+		if _, err := s.GetBlockFilter(block.BlockHash().String()); errors.Is(err, command.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prevOutScripts returns the scripts of the outputs spent by the inputs of
+// a block, or nil if some of them are not stored. Outputs are looked up by
+// the hashes of their transactions, which the funding index covers.
+func (s *storage) prevOutScripts(block *wire.MsgBlock) ([][]byte, error) {
+	scripts := map[wire.OutPoint][]byte{}
+	for _, tx := range block.Transactions {
+		hash := tx.TxHash()
+		for i, txOut := range tx.TxOut {
+			scripts[*wire.NewOutPoint(&hash, uint32(i))] = txOut.PkScript
+		}
+	}
+
+	missing := []model.Hash{}
+	seen := map[chainhash.Hash]bool{}
+	for _, tx := range block.Transactions {
+		if blockchain.IsCoinBaseTx(tx) {
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			if _, ok := scripts[txIn.PreviousOutPoint]; !ok && !seen[txIn.PreviousOutPoint.Hash] {
+				seen[txIn.PreviousOutPoint.Hash] = true
+				missing = append(missing, model.Hash(txIn.PreviousOutPoint.Hash.String()))
+			}
+		}
+	}
+	for start := 0; start < len(missing); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		// Deleted outpoints are skipped here rather than in the query, or
+		// sqlite picks the index on deleted_at over the funding one
+		outPoints := []model.OutPoint{}
+		if resp := s.db.Unscoped().Select("funding_tx_hash", "funding_tx_index", "pk_script", "deleted_at").
+			Where("funding_tx_hash IN ?", missing[start:end]).Find(&outPoints); resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, op := range outPoints {
+			if op.DeletedAt.Valid {
+				continue
+			}
+			hash, err := chainhash.NewHashFromStr(string(op.FundingTxHash))
+			if err != nil {
+				return nil, err
+			}
+			scripts[*wire.NewOutPoint(hash, op.FundingTxIndex)] = op.PkScript
+		}
+	}
+
+	prevOutScripts := [][]byte{}
+	for _, tx := range block.Transactions {
+		if blockchain.IsCoinBaseTx(tx) {
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			script, ok := scripts[txIn.PreviousOutPoint]
+			if !ok {
+				return nil, nil
+			}
+			prevOutScripts = append(prevOutScripts, script)
+		}
+	}
+	return prevOutScripts, nil
+}
+
+// GetBlockFilter returns the basic filter of a block and its header.
+func (s *storage) GetBlockFilter(blockHash string) (command.BlockFilter, error) {
+	filter := model.BlockFilter{}
+	if resp := s.db.First(&filter, "block_hash = ?", model.Hash(blockHash)); resp.Error != nil {
+		return command.BlockFilter{}, queryError(resp.Error)
+	}
+	return command.BlockFilter{
+		Filter: hex.EncodeToString(filter.Filter),
+		Header: string(filter.Header),
+	}, nil
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+	"github.com/catalogfi/indexer/store/storetest"
+)
+
+func TestBackfillBlockFilters(t *testing.T) {
+	s := newTestStorage(t)
+	blocks := storetest.PutChain(t, s, storetest.Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	spend := storetest.SpendTx(blocks[0].Transactions[0], 0, 49e8, storetest.Address(2), wire.MaxTxInSequenceNum)
+	block := storetest.NewBlock(blocks[1].BlockHash(), 3*600, storetest.CoinbaseTx(3, 0, storetest.Address(1)), spend)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	storetest.PutChain(t, s, block.BlockHash(), 4, 2, 0)
+
+	hashes := []model.Hash{}
+	want := []command.BlockFilter{}
+	for height := int32(0); height <= 5; height++ {
+		hash, err := s.GetBlockHash(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := s.GetBlockFilter(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, model.Hash(hash))
+		want = append(want, filter)
+	}
+
+	// Blocks stored before filters were have none from some height on
+	for _, from := range []int{3, 0} {
+		if err := s.db.Unscoped().Delete(&model.BlockFilter{}, "block_hash IN ?", hashes[from:]).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := s.BackfillBlockFilters(); err != nil {
+			t.Fatal(err)
+		}
+		for height, hash := range hashes {
+			if got, err := s.GetBlockFilter(string(hash)); err != nil || got != want[height] {
+				t.Fatalf("filters missing from height %d: got filter %+v (%v) at height %d, want %+v", from, got, err, height, want[height])
+			}
+		}
+	}
+
+	// Nothing is left to build
+	if err := s.BackfillBlockFilters(); err != nil {
+		t.Fatal(err)
+	}
+}
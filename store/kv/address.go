@@ -0,0 +1,226 @@
+package kv
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// addressEntry is an output of a transaction paying to an address, or an
+// input spending one, valued negatively.
+type addressEntry struct {
+	address   string
+	direction byte
+	n         uint32
+	value     int64
+}
+
+// addressEntries returns the outputs of the transaction that pay to an
+// address and the inputs that spend one, whose outputs have to be stored.
+func (s *storage) addressEntries(tx *wire.MsgTx) ([]addressEntry, error) {
+	entries := []addressEntry{}
+	if !blockchain.IsCoinBaseTx(tx) {
+		for j, txIn := range tx.TxIn {
+			prevOut, err := s.getOutput(txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index)
+			if err != nil {
+				return nil, err
+			}
+			if prevOut.Spender != "" {
+				entries = append(entries, addressEntry{prevOut.Spender, directionSpending, uint32(j), -prevOut.Value})
+			}
+		}
+	}
+	for j, txOut := range tx.TxOut {
+		spender, _, err := store.Spender(txOut.PkScript, s.params)
+		if err != nil {
+			return nil, err
+		}
+		if spender != "" {
+			entries = append(entries, addressEntry{spender, directionFunding, uint32(j), txOut.Value})
+		}
+	}
+	return entries, nil
+}
+
+// indexTx adds the transaction to the history of the addresses it funds or
+// spends from, or removes it if add is not set. Confirmed transactions are
+// indexed by height and unconfirmed ones by sequence number.
+func (s *storage) indexTx(hash string, tx *wire.MsgTx, transaction txRecord, add bool) error {
+	entries, err := s.addressEntries(tx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var k, value []byte
+		if transaction.BlockHash != "" {
+			k = key(prefixAddress, addressBytes(e.address), uint32Bytes(uint32(transaction.Height)), uint32Bytes(transaction.BlockIndex),
+				hashBytes(hash), []byte{e.direction}, uint32Bytes(e.n))
+			value = uint64Bytes(uint64(e.value))
+		} else {
+			k = key(prefixAddressPool, addressBytes(e.address), uint64Bytes(transaction.Seq), hashBytes(hash))
+			value = []byte{}
+		}
+		if add {
+			s.put(k, value)
+		} else {
+			s.delete(k)
+		}
+	}
+	return nil
+}
+
+// addressKeyFields returns the fields of a key that follow the address.
+func addressKeyFields(k []byte) []byte {
+	return k[2+int(k[1]):]
+}
+
+// unspentOutPoint returns the outpoint of an unspent output key.
+func unspentOutPoint(k []byte) (string, uint32) {
+	fields := addressKeyFields(k)
+	return hex.EncodeToString(fields[8:40]), binary.BigEndian.Uint32(fields[40:44])
+}
+
+// addressDelta decodes an entry of the history of an address.
+func addressDelta(address string, k, value []byte) command.AddressDelta {
+	fields := addressKeyFields(k)
+	return command.AddressDelta{
+		Address:    address,
+		Height:     int32(binary.BigEndian.Uint32(fields[0:4])),
+		BlockIndex: binary.BigEndian.Uint32(fields[4:8]),
+		TxHash:     hex.EncodeToString(fields[8:40]),
+		Index:      binary.BigEndian.Uint32(fields[41:45]),
+		Value:      int64(binary.BigEndian.Uint64(value)),
+	}
+}
+
+// addressHistory returns the entries of the history of the addresses in the
+// main chain within the height range, ordered by height, position in the
+// block and value. Entries of a transaction with the same value are ordered
+// by address, spends first and then by index, as in the SQL storage.
+func (s *storage) addressHistory(addresses []string, options command.AddressQueryOptions) ([]command.AddressDelta, error) {
+	deltas := []command.AddressDelta{}
+	if options.EndHeight < 0 || options.StartHeight > options.EndHeight {
+		return deltas, nil
+	}
+	start := options.StartHeight
+	if start < 0 {
+		start = 0
+	}
+	directions := []byte{}
+	for _, address := range addresses {
+		r := &util.Range{
+			Start: key(prefixAddress, addressBytes(address), uint32Bytes(uint32(start))),
+			Limit: key(prefixAddress, addressBytes(address), uint32Bytes(uint32(options.EndHeight)+1)),
+		}
+		if err := s.iterate(r, false, func(k, value []byte) (bool, error) {
+			deltas = append(deltas, addressDelta(address, k, value))
+			directions = append(directions, addressKeyFields(k)[40])
+			return true, nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(&history{deltas, directions})
+	return deltas, nil
+}
+
+// history sorts the entries of the history of addresses along with their
+// directions.
+type history struct {
+	deltas     []command.AddressDelta
+	directions []byte
+}
+
+func (h *history) Len() int {
+	return len(h.deltas)
+}
+
+func (h *history) Less(i, j int) bool {
+	a, b := h.deltas[i], h.deltas[j]
+	if a.Height != b.Height {
+		return a.Height < b.Height
+	}
+	if a.BlockIndex != b.BlockIndex {
+		return a.BlockIndex < b.BlockIndex
+	}
+	if a.Value != b.Value {
+		return a.Value < b.Value
+	}
+	if a.Address != b.Address {
+		return a.Address < b.Address
+	}
+	if h.directions[i] != h.directions[j] {
+		return h.directions[i] == directionSpending
+	}
+	return a.Index < b.Index
+}
+
+func (h *history) Swap(i, j int) {
+	h.deltas[i], h.deltas[j] = h.deltas[j], h.deltas[i]
+	h.directions[i], h.directions[j] = h.directions[j], h.directions[i]
+}
+
+// page returns the bounds of the rows left by the offset and limit, a
+// negative limit meaning no limit.
+func page(n, offset, limit int) (int, int) {
+	start := offset
+	if start > n {
+		start = n
+	}
+	end := n
+	if limit >= 0 && start+limit < end {
+		end = start + limit
+	}
+	return start, end
+}
+
+func (s *storage) GetAddressDeltas(addresses []string, options command.AddressQueryOptions) ([]command.AddressDelta, error) {
+	deltas, err := s.addressHistory(addresses, options)
+	if err != nil {
+		return nil, err
+	}
+	start, end := page(len(deltas), options.Offset, options.Limit)
+	return deltas[start:end], nil
+}
+
+func (s *storage) GetAddressTxIDs(addresses []string, options command.AddressQueryOptions) ([]string, error) {
+	deltas, err := s.addressHistory(addresses, options)
+	if err != nil {
+		return nil, err
+	}
+	// Entries of a transaction are next to each other once sorted by
+	// position in the chain
+	txids := []string{}
+	for i, delta := range deltas {
+		if i == 0 || delta.TxHash != deltas[i-1].TxHash {
+			txids = append(txids, delta.TxHash)
+		}
+	}
+	start, end := page(len(txids), options.Offset, options.Limit)
+	return txids[start:end], nil
+}
+
+// GetAddressBalance returns the confirmed balance of the addresses and the
+// total amount they have received.
+func (s *storage) GetAddressBalance(addresses []string) (int64, int64, error) {
+	var received, spent int64
+	for _, address := range addresses {
+		if err := s.iterate(prefix(prefixAddress, addressBytes(address)), false, func(_, value []byte) (bool, error) {
+			if v := int64(binary.BigEndian.Uint64(value)); v > 0 {
+				received += v
+			} else {
+				spent -= v
+			}
+			return true, nil
+		}); err != nil {
+			return 0, 0, err
+		}
+	}
+	return received - spent, received, nil
+}
@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+func (s *storage) GetOutPoint(hash string, index uint32) (model.OutPoint, error) {
+	output, err := s.getOutput(hash, index)
+	if err != nil {
+		return model.OutPoint{}, err
+	}
+	return outPoint(hash, index, output), nil
+}
+
+// SubmitTx stores the transaction as unconfirmed and queues it to be relayed
+// by the peer.
+func (s *storage) SubmitTx(tx *wire.MsgTx) error {
+	raw, err := serialize(tx)
+	if err != nil {
+		return err
+	}
+
+	return s.transaction(func(s *storage) error {
+		created, err := s.putTx(tx)
+		if err != nil {
+			return err
+		}
+		if created {
+			s.notifier.TxAccepted(tx)
+		}
+
+		// Submitting a transaction again relays it again
+		k := key(prefixBroadcast, hashBytes(tx.TxHash().String()))
+		broadcast := broadcastRecord{}
+		if err := s.getRecord(k, &broadcast); errors.Is(err, command.ErrNotFound) {
+			if broadcast.Seq, err = s.nextSequence(); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		broadcast.Raw = raw
+		broadcast.Relayed = false
+		return s.putRecord(k, broadcast)
+	})
+}
+
+func (s *storage) GetPendingBroadcasts() ([]*wire.MsgTx, error) {
+	broadcasts := []broadcastRecord{}
+	if err := s.iterate(prefix(prefixBroadcast), false, func(_, value []byte) (bool, error) {
+		broadcast := broadcastRecord{}
+		if err := json.Unmarshal(value, &broadcast); err != nil {
+			return false, err
+		}
+		if !broadcast.Relayed {
+			broadcasts = append(broadcasts, broadcast)
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(broadcasts, func(i, j int) bool { return broadcasts[i].Seq < broadcasts[j].Seq })
+
+	txs := make([]*wire.MsgTx, 0, len(broadcasts))
+	for _, broadcast := range broadcasts {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		if err := tx.Deserialize(bytes.NewReader(broadcast.Raw)); err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+func (s *storage) MarkBroadcast(hash string) error {
+	if hashBytes(hash) == nil {
+		return nil
+	}
+	return s.transaction(func(s *storage) error {
+		k := key(prefixBroadcast, hashBytes(hash))
+		broadcast := broadcastRecord{}
+		if err := s.getRecord(k, &broadcast); errors.Is(err, command.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		broadcast.Relayed = true
+		return s.putRecord(k, broadcast)
+	})
+}
@@ -0,0 +1,269 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+// getBlock returns the block with the given hash.
+func (s *storage) getBlock(hash string) (blockRecord, error) {
+	record := blockRecord{}
+	if hashBytes(hash) == nil {
+		return record, command.ErrNotFound
+	}
+	err := s.getRecord(key(prefixBlock, hashBytes(hash)), &record)
+	return record, err
+}
+
+// getTx returns the transaction with the given hash.
+func (s *storage) getTx(hash string) (txRecord, error) {
+	record := txRecord{}
+	if hashBytes(hash) == nil {
+		return record, command.ErrNotFound
+	}
+	err := s.getRecord(key(prefixTx, hashBytes(hash)), &record)
+	return record, err
+}
+
+// getOutput returns the output at index of the transaction with the given
+// hash.
+func (s *storage) getOutput(hash string, index uint32) (outputRecord, error) {
+	record := outputRecord{}
+	if hashBytes(hash) == nil {
+		return record, command.ErrNotFound
+	}
+	err := s.getRecord(outputKey(hash, index), &record)
+	return record, err
+}
+
+func outputKey(hash string, index uint32) []byte {
+	return key(prefixOutput, hashBytes(hash), uint32Bytes(index))
+}
+
+// outPoint returns the output as a model.OutPoint.
+func outPoint(hash string, index uint32, record outputRecord) model.OutPoint {
+	return model.OutPoint{
+		SpendingTxHash:  model.Hash(record.SpendingTxHash),
+		SpendingTxIndex: record.SpendingTxIndex,
+		FundingTxHash:   model.Hash(hash),
+		FundingTxIndex:  index,
+		PkScript:        record.PkScript,
+		Value:           record.Value,
+		Spender:         record.Spender,
+		Type:            record.Type,
+	}
+}
+
+// mainChainHash returns the hash of the main chain block at height.
+func (s *storage) mainChainHash(height int32) (string, error) {
+	if height < 0 {
+		return "", command.ErrNotFound
+	}
+	value, err := s.get(key(prefixHeight, uint32Bytes(uint32(height))))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(value), nil
+}
+
+// tip returns the hash and the last block of the main chain.
+func (s *storage) tip() (string, blockRecord, error) {
+	value, err := s.get([]byte{prefixTip})
+	if err != nil {
+		return "", blockRecord{}, err
+	}
+	hash := hex.EncodeToString(value)
+	block, err := s.getBlock(hash)
+	return hash, block, err
+}
+
+func (s *storage) GetPreviousBlockHeight(blockhash string) (int32, error) {
+	block, err := s.getBlock(blockhash)
+	if err != nil {
+		return 0, err
+	}
+	return block.Height, nil
+}
+
+func (s *storage) GetLatestBlockHeight() (int32, error) {
+	_, block, err := s.tip()
+	if errors.Is(err, command.ErrNotFound) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return block.Height, nil
+}
+
+func (s *storage) GetBlockHash(height int32) (string, error) {
+	return s.mainChainHash(height)
+}
+
+func (s *storage) GetLatestBlockHash() (string, error) {
+	hash, _, err := s.tip()
+	return hash, err
+}
+
+func (s *storage) GetBlockFromHash(blockHash string) (*btcutil.Block, error) {
+	block, err := s.getBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Raw != nil {
+		// The block is not on the main chain, so its transactions are not
+		// attached to it
+		b, err := btcutil.NewBlockFromBytes(block.Raw)
+		if err != nil {
+			return nil, err
+		}
+		b.SetHeight(block.Height)
+		return b, nil
+	}
+
+	header, err := block.header()
+	if err != nil {
+		return nil, err
+	}
+	msgBlock := wire.NewMsgBlock(&header)
+	for _, txid := range block.TxIDs {
+		transaction, err := s.getTx(txid)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := transaction.tx()
+		if err != nil {
+			return nil, err
+		}
+		if err := msgBlock.AddTransaction(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	b := btcutil.NewBlock(msgBlock)
+	b.SetHeight(block.Height)
+	return b, nil
+}
+
+func (s *storage) GetHeaderFromHash(blockHash string) (command.BlockHeader, error) {
+	block, err := s.getBlock(blockHash)
+	if err != nil {
+		return command.BlockHeader{}, err
+	}
+	header, err := block.header()
+	if err != nil {
+		return command.BlockHeader{}, err
+	}
+	return command.BlockHeader{
+		Header: &header,
+		Height: block.Height,
+		NumTxs: int64(len(block.TxIDs)),
+	}, nil
+}
+
+func (s *storage) GetHeaderFromHeight(height int32) (command.BlockHeader, error) {
+	hash, err := s.mainChainHash(height)
+	if err != nil {
+		return command.BlockHeader{}, err
+	}
+	return s.GetHeaderFromHash(hash)
+}
+
+func (s *storage) GetTransaction(txHash string) (command.Transaction, error) {
+	transaction, err := s.getTx(txHash)
+	if err != nil {
+		return command.Transaction{}, err
+	}
+	tx, err := transaction.tx()
+	if err != nil {
+		return command.Transaction{}, err
+	}
+	if transaction.BlockHash == "" {
+		return command.Transaction{
+			Tx: tx,
+		}, nil
+	}
+
+	block, err := s.getBlock(transaction.BlockHash)
+	if err != nil {
+		return command.Transaction{}, err
+	}
+	header, err := block.header()
+	if err != nil {
+		return command.Transaction{}, err
+	}
+	return command.Transaction{
+		Tx:        tx,
+		BlockHash: transaction.BlockHash,
+		Height:    block.Height,
+		BlockTime: header.Timestamp.Unix(),
+	}, nil
+}
+
+// ListUnspent returns the outputs paying to the addresses that are unspent
+// and were confirmed in the height range, excluding the ones of unsafe
+// transactions unless includeUnsafe is set.
+func (s *storage) ListUnspent(startBlock, endBlock int, addresses []string, includeUnsafe bool, options command.ListUnspentQueryOptions) ([]model.OutPoint, error) {
+	outpoints := []model.OutPoint{}
+	for _, address := range addresses {
+		if len(outpoints) >= int(options.MaximumCount) {
+			break
+		}
+		err := s.iterate(prefix(prefixUnspent, addressBytes(address)), false, func(k, _ []byte) (bool, error) {
+			hash, index := unspentOutPoint(k)
+			output, err := s.getOutput(hash, index)
+			if err != nil {
+				return false, err
+			}
+			if output.Value < options.MinimumAmount || output.Value > options.MaximumAmount {
+				return true, nil
+			}
+			funding, err := s.getTx(hash)
+			if err != nil {
+				return false, err
+			}
+			if funding.BlockHash == "" || int(funding.Height) < startBlock || int(funding.Height) > endBlock {
+				return true, nil
+			}
+			if !includeUnsafe && !funding.Safe {
+				return true, nil
+			}
+			outpoints = append(outpoints, outPoint(hash, index, output))
+			return len(outpoints) < int(options.MaximumCount), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return outpoints, nil
+}
+
+// txInOutPoint returns an input as the model.OutPoint it spends, for inputs
+// that spend no stored output.
+func txInOutPoint(hash string, index uint32, txIn *wire.TxIn) model.OutPoint {
+	return model.OutPoint{
+		SpendingTxHash:  model.Hash(hash),
+		SpendingTxIndex: index,
+		Sequence:        txIn.Sequence,
+		SignatureScript: txIn.SignatureScript,
+		Witness:         model.Witness(txIn.Witness),
+		FundingTxHash:   model.Hash(txIn.PreviousOutPoint.Hash.String()),
+		FundingTxIndex:  txIn.PreviousOutPoint.Index,
+	}
+}
+
+// serialize returns the serialization of a transaction.
+func serialize(tx *wire.MsgTx) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := tx.Serialize(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
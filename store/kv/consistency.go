@@ -0,0 +1,7 @@
+package kv
+
+// CheckConsistency has nothing to check: blocks are written in a single
+// batch, which LevelDB applies entirely or not at all.
+func (s *storage) CheckConsistency() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package kv
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/catalogfi/indexer/electrum"
+)
+
+func (s *storage) GetScriptHashOutputs(scriptHash string) ([]electrum.Output, error) {
+	outputs := []electrum.Output{}
+	if hashBytes(scriptHash) == nil {
+		return outputs, nil
+	}
+	err := s.iterate(prefix(prefixScriptHash, hashBytes(scriptHash)), false, func(k, _ []byte) (bool, error) {
+		hash := hex.EncodeToString(k[41:73])
+		index := binary.BigEndian.Uint32(k[73:77])
+		output, err := s.getOutput(hash, index)
+		if err != nil {
+			return false, err
+		}
+		funding, err := s.getTx(hash)
+		if err != nil {
+			return false, err
+		}
+		o := electrum.Output{
+			TxHash:         hash,
+			Index:          index,
+			Value:          output.Value,
+			Height:         funding.Height,
+			BlockIndex:     funding.BlockIndex,
+			SpendingTxHash: output.SpendingTxHash,
+		}
+		if output.SpendingTxHash != "" {
+			spending, err := s.getTx(output.SpendingTxHash)
+			if err != nil {
+				return false, err
+			}
+			o.SpendingHeight = spending.Height
+			o.SpendingBlockIndex = spending.BlockIndex
+		}
+		outputs = append(outputs, o)
+		return true, nil
+	})
+	return outputs, err
+}
+
+func (s *storage) GetBlockTxIDs(blockHash string) ([]string, error) {
+	return s.blockTxs(blockHash)
+}
@@ -0,0 +1,183 @@
+package kv
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/esplora"
+	"github.com/catalogfi/indexer/model"
+)
+
+func (s *storage) GetTxStatus(txHash string) (esplora.TxStatus, error) {
+	transaction, err := s.getTx(txHash)
+	if err != nil {
+		return esplora.TxStatus{}, err
+	}
+	if transaction.BlockHash == "" {
+		return esplora.TxStatus{Confirmed: false}, nil
+	}
+
+	block, err := s.getBlock(transaction.BlockHash)
+	if err != nil {
+		return esplora.TxStatus{}, err
+	}
+	header, err := block.header()
+	if err != nil {
+		return esplora.TxStatus{}, err
+	}
+	return esplora.TxStatus{
+		Confirmed:   true,
+		BlockHeight: block.Height,
+		BlockHash:   transaction.BlockHash,
+		BlockTime:   header.Timestamp.Unix(),
+	}, nil
+}
+
+// GetTxOutPoints returns the outpoints spent by the transaction and the
+// outpoints created by it, both in the order of the transaction's inputs and
+// outputs.
+func (s *storage) GetTxOutPoints(txHash string) ([]model.OutPoint, []model.OutPoint, error) {
+	inputs := []model.OutPoint{}
+	outputs := []model.OutPoint{}
+	transaction, err := s.getTx(txHash)
+	if errors.Is(err, command.ErrNotFound) {
+		return inputs, outputs, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	tx, err := transaction.tx()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coinbase := blockchain.IsCoinBaseTx(tx)
+	for i, txIn := range tx.TxIn {
+		input := txInOutPoint(txHash, uint32(i), txIn)
+		if !coinbase {
+			output, err := s.getOutput(string(input.FundingTxHash), input.FundingTxIndex)
+			if err != nil {
+				return nil, nil, err
+			}
+			input.PkScript = output.PkScript
+			input.Value = output.Value
+			input.Spender = output.Spender
+			input.Type = output.Type
+		}
+		inputs = append(inputs, input)
+	}
+	for i := range tx.TxOut {
+		op, err := s.GetOutPoint(txHash, uint32(i))
+		if err != nil {
+			return nil, nil, err
+		}
+		outputs = append(outputs, op)
+	}
+	return inputs, outputs, nil
+}
+
+// GetAddressChainTxIDs returns the confirmed transactions of the address,
+// newest first, starting after lastSeenTxID if it is not empty.
+func (s *storage) GetAddressChainTxIDs(address, lastSeenTxID string, limit int) ([]string, error) {
+	r := prefix(prefixAddress, addressBytes(address))
+	if lastSeenTxID != "" {
+		status, err := s.GetTxStatus(lastSeenTxID)
+		if err != nil {
+			return nil, err
+		}
+		transaction, err := s.getTx(lastSeenTxID)
+		if err != nil {
+			return nil, err
+		}
+		if !status.Confirmed {
+			return []string{}, nil
+		}
+		r.Limit = key(prefixAddress, addressBytes(address), uint32Bytes(uint32(status.BlockHeight)), uint32Bytes(transaction.BlockIndex))
+	}
+
+	txids := []string{}
+	err := s.iterate(r, true, func(k, _ []byte) (bool, error) {
+		txid := hex.EncodeToString(addressKeyFields(k)[8:40])
+		if len(txids) == 0 || txids[len(txids)-1] != txid {
+			if len(txids) == limit {
+				return false, nil
+			}
+			txids = append(txids, txid)
+		}
+		return true, nil
+	})
+	return txids, err
+}
+
+// GetAddressMempoolTxIDs returns the unconfirmed transactions funding or
+// spending the address, newest first.
+func (s *storage) GetAddressMempoolTxIDs(address string, limit int) ([]string, error) {
+	txids := []string{}
+	err := s.iterate(prefix(prefixAddressPool, addressBytes(address)), true, func(k, _ []byte) (bool, error) {
+		txid := hex.EncodeToString(addressKeyFields(k)[8:40])
+		if len(txids) == 0 || txids[len(txids)-1] != txid {
+			if len(txids) == limit {
+				return false, nil
+			}
+			txids = append(txids, txid)
+		}
+		return true, nil
+	})
+	return txids, err
+}
+
+// GetAddressUnspent returns the outputs paying to the address that are not
+// spent by a confirmed or an unconfirmed transaction.
+func (s *storage) GetAddressUnspent(address string) ([]model.OutPoint, error) {
+	outpoints := []model.OutPoint{}
+	err := s.iterate(prefix(prefixUnspent, addressBytes(address)), false, func(k, _ []byte) (bool, error) {
+		hash, index := unspentOutPoint(k)
+		output, err := s.getOutput(hash, index)
+		if err != nil {
+			return false, err
+		}
+		outpoints = append(outpoints, outPoint(hash, index, output))
+		return true, nil
+	})
+	return outpoints, err
+}
+
+// GetTxStatuses looks the transactions up one by one, which are point reads
+// in LevelDB.
+func (s *storage) GetTxStatuses(txHashes []string) ([]esplora.TxStatus, error) {
+	statuses := make([]esplora.TxStatus, len(txHashes))
+	for i, txHash := range txHashes {
+		status, err := s.GetTxStatus(txHash)
+		if err != nil {
+			return nil, err
+		}
+		statuses[i] = status
+	}
+	return statuses, nil
+}
+
+func (s *storage) GetTxDetails(txHashes []string) ([]esplora.TxDetails, error) {
+	details := make([]esplora.TxDetails, len(txHashes))
+	for i, txHash := range txHashes {
+		transaction, err := s.GetTransaction(txHash)
+		if err != nil {
+			return nil, err
+		}
+		inputs, _, err := s.GetTxOutPoints(txHash)
+		if err != nil {
+			return nil, err
+		}
+		status, err := s.GetTxStatus(txHash)
+		if err != nil {
+			return nil, err
+		}
+		details[i] = esplora.TxDetails{
+			Tx:     transaction.Tx,
+			Inputs: inputs,
+			Status: status,
+		}
+	}
+	return details, nil
+}
@@ -0,0 +1,106 @@
+package kv
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/catalogfi/indexer/command"
+)
+
+// putFeeStats counts the transactions the block confirms that were seen in
+// the mempool, by fee rate bucket and by the number of blocks they waited
+// for.
+func (s *storage) putFeeStats(block *confirmation, confirmed []txRecord) error {
+	type statKey struct {
+		bucket int
+		blocks int32
+	}
+	stats := map[statKey]*feeStatRecord{}
+	order := []statKey{}
+	for _, transaction := range confirmed {
+		if transaction.FirstSeenHeight <= 0 || transaction.FirstSeenHeight >= block.height || transaction.Weight == 0 {
+			continue
+		}
+		feeRate := float64(transaction.Fee) * 4 / float64(transaction.Weight)
+		k := statKey{command.FeeRateBucket(feeRate), block.height - transaction.FirstSeenHeight}
+		stat, ok := stats[k]
+		if !ok {
+			stat = &feeStatRecord{Bucket: k.bucket, Blocks: k.blocks}
+			stats[k] = stat
+			order = append(order, k)
+		}
+		stat.Count++
+		stat.FeeRate += feeRate
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+
+	feeStats := make([]feeStatRecord, len(order))
+	for i, k := range order {
+		feeStats[i] = *stats[k]
+	}
+	return s.putRecord(key(prefixFeeStats, hashBytes(block.hash)), feeStats)
+}
+
+// GetFeeStats returns the transactions confirmed in the last blocks of the
+// main chain by fee rate bucket and block, counting the ones confirmed
+// within the target, along with the transactions in the mempool that waited
+// for longer than the target.
+func (s *storage) GetFeeStats(target, blocks int32) ([]command.FeeStats, error) {
+	tip, err := s.GetLatestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []command.FeeStats{}
+	for height := tip; height > tip-blocks && height >= 0; height-- {
+		hash, err := s.mainChainHash(height)
+		if err != nil {
+			return nil, err
+		}
+		feeStats := []feeStatRecord{}
+		if err := s.getRecord(key(prefixFeeStats, hashBytes(hash)), &feeStats); errors.Is(err, command.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		byBucket := map[int]*command.FeeStats{}
+		order := []int{}
+		for _, stat := range feeStats {
+			row, ok := byBucket[stat.Bucket]
+			if !ok {
+				row = &command.FeeStats{Bucket: stat.Bucket, Age: tip - height}
+				byBucket[stat.Bucket] = row
+				order = append(order, stat.Bucket)
+			}
+			if stat.Blocks <= target {
+				row.Confirmed += stat.Count
+			}
+			row.Total += stat.Count
+			row.FeeRate += stat.FeeRate
+		}
+		for _, bucket := range order {
+			stats = append(stats, *byBucket[bucket])
+		}
+	}
+
+	unconfirmed := map[int]int64{}
+	if err := s.iterate(prefix(prefixMempool), false, func(_, value []byte) (bool, error) {
+		transaction, err := s.getTx(hex.EncodeToString(value))
+		if err != nil {
+			return false, err
+		}
+		if transaction.Weight > 0 && transaction.FirstSeenHeight > 0 && transaction.FirstSeenHeight <= tip-target {
+			unconfirmed[command.FeeRateBucket(float64(transaction.Fee)*4/float64(transaction.Weight))]++
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	for bucket, count := range unconfirmed {
+		stats = append(stats, command.FeeStats{Bucket: bucket, Age: -1, Total: count})
+	}
+	return stats, nil
+}
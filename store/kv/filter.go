@@ -0,0 +1,139 @@
+package kv
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/blockfilter"
+	"github.com/catalogfi/indexer/command"
+)
+
+// putBlockFilter stores the basic filter of a block connected to the main
+// chain, unless it is stored already. Its transactions have to be stored, so
+// that the outputs they spend can be looked up.
+func (s *storage) putBlockFilter(block *wire.MsgBlock) error {
+	blockHash := block.BlockHash().String()
+	if ok, err := s.has(key(prefixFilter, hashBytes(blockHash))); err != nil || ok {
+		return err
+	}
+
+	prevHeader := chainhash.Hash{}
+	if block.Header.PrevBlock != (chainhash.Hash{}) {
+		prevFilter := filterRecord{}
+		if err := s.getRecord(key(prefixFilter, hashBytes(block.Header.PrevBlock.String())), &prevFilter); err != nil {
+			if errors.Is(err, command.ErrNotFound) {
+				fmt.Println("Block filter of", blockHash, "not stored: the filter of the previous block is missing")
+				return nil
+			}
+			return err
+		}
+		header, err := chainhash.NewHashFromStr(prevFilter.Header)
+		if err != nil {
+			return err
+		}
+		prevHeader = *header
+	}
+
+	prevOutScripts := [][]byte{}
+	for _, tx := range block.Transactions {
+		if blockchain.IsCoinBaseTx(tx) {
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			output, err := s.getOutput(txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index)
+			if errors.Is(err, command.ErrNotFound) {
+				fmt.Println("Block filter of", blockHash, "not stored: spent outputs are missing")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			prevOutScripts = append(prevOutScripts, output.PkScript)
+		}
+	}
+
+	filter, header, err := blockfilter.BuildBasic(block, prevOutScripts, prevHeader)
+	if err != nil {
+		return err
+	}
+	return s.putRecord(key(prefixFilter, hashBytes(blockHash)), filterRecord{
+		Filter: filter,
+		Header: header.String(),
+	})
+}
+
+// BackfillBlockFilters builds the filters missing from the main chain, from
+// the block after the last one with a filter up to the tip.
+func (s *storage) BackfillBlockFilters() error {
+	tipHeight, err := s.GetLatestBlockHeight()
+	if err != nil {
+		return err
+	}
+	start := tipHeight + 1
+	for ; start > 0; start-- {
+		hash, err := s.mainChainHash(start - 1)
+		if err != nil {
+			return err
+		}
+		if ok, err := s.has(key(prefixFilter, hashBytes(hash))); err != nil {
+			return err
+		} else if ok {
+			break
+		}
+	}
+	if start > tipHeight {
+		return nil
+	}
+
+	fmt.Println("Building the block filters from height", start, "to", tipHeight)
+	for height := start; height <= tipHeight; height++ {
+		block := s.params.GenesisBlock
+		if height > 0 {
+			hash, err := s.mainChainHash(height)
+			if err != nil {
+				return err
+			}
+			b, err := s.GetBlockFromHash(hash)
+			if err != nil {
+				return err
+			}
+			block = b.MsgBlock()
+		}
+		stored := false
+		if err := s.transaction(func(s *storage) error {
+			if err := s.putBlockFilter(block); err != nil {
+				return err
+			}
+			var err error
+			stored, err = s.has(key(prefixFilter, hashBytes(block.BlockHash().String())))
+			return err
+		}); err != nil {
+			return err
+		}
+		// putBlockFilter reported why the filter could not be built, and the
+		// following ones cannot be either
+		if !stored {
+			return nil
+		}
+	}
+	return nil
+}
+
+// GetBlockFilter returns the basic filter of a block and its header.
+func (s *storage) GetBlockFilter(blockHash string) (command.BlockFilter, error) {
+	filter := filterRecord{}
+	if hashBytes(blockHash) == nil {
+		return command.BlockFilter{}, command.ErrNotFound
+	}
+	if err := s.getRecord(key(prefixFilter, hashBytes(blockHash)), &filter); err != nil {
+		return command.BlockFilter{}, err
+	}
+	return command.BlockFilter{
+		Filter: hex.EncodeToString(filter.Filter),
+		Header: filter.Header,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store/storetest"
+	"github.com/syndtr/goleveldb/leveldb"
+	leveldbstorage "github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestBackfillBlockFilters(t *testing.T) {
+	db, err := leveldb.Open(leveldbstorage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := NewStorage(storetest.Params, db).(*storage)
+	blocks := storetest.PutChain(t, s, storetest.Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	spend := storetest.SpendTx(blocks[0].Transactions[0], 0, 49e8, storetest.Address(2), wire.MaxTxInSequenceNum)
+	block := storetest.NewBlock(blocks[1].BlockHash(), 3*600, storetest.CoinbaseTx(3, 0, storetest.Address(1)), spend)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	storetest.PutChain(t, s, block.BlockHash(), 4, 2, 0)
+
+	hashes := []string{}
+	want := []command.BlockFilter{}
+	for height := int32(0); height <= 5; height++ {
+		hash, err := s.GetBlockHash(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := s.GetBlockFilter(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+		want = append(want, filter)
+	}
+
+	// Blocks stored before filters were have none from some height on
+	for _, from := range []int{3, 0} {
+		for _, hash := range hashes[from:] {
+			if err := db.Delete(key(prefixFilter, hashBytes(hash)), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.BackfillBlockFilters(); err != nil {
+			t.Fatal(err)
+		}
+		for height, hash := range hashes {
+			if got, err := s.GetBlockFilter(hash); err != nil || got != want[height] {
+				t.Fatalf("filters missing from height %d: got filter %+v (%v) at height %d, want %+v", from, got, err, height, want[height])
+			}
+		}
+	}
+
+	// Nothing is left to build
+	if err := s.BackfillBlockFilters(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,451 @@
+// Package kv implements the storage on an embedded LevelDB database, for
+// deployments that do not want to run a SQL server. Every record is stored
+// under a key that starts with a one byte prefix, followed by the fields the
+// record is looked up or ordered by:
+//
+//	'T'                                   hash of the tip of the main chain
+//	'n'                                   last sequence number
+//	'b' block hash                        block
+//	'h' height                            hash of the main chain block
+//	't' txid                              transaction
+//	'o' txid vout                         output
+//	'a' address height index txid dir n   value received or spent by an
+//	                                      address in the main chain
+//	'u' address seq txid                  unconfirmed transaction of an address
+//	'U' address seq txid vout             unspent output of an address
+//	's' script hash seq txid vout         output paying to an electrum script
+//	                                      hash
+//	'p' seq                               txid of an unconfirmed transaction
+//	'e' txid seq                          evicted transaction
+//	'c' evicting txid seq                 txid of the transaction it evicted
+//	'r' txid                              transaction to broadcast
+//	'f' block hash                        fee statistics of a block
+//	'F' block hash                        filter of a block
+//
+// Hashes are stored in the byte order of their hex encoding, and numbers in
+// big endian, so that keys sort like the SQL storage orders its rows.
+// Sequence numbers are given to transactions as they are stored, and order
+// them like the ids of the SQL storage.
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/notify"
+	"github.com/catalogfi/indexer/store"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	prefixTip          = 'T'
+	prefixSequence     = 'n'
+	prefixBlock        = 'b'
+	prefixHeight       = 'h'
+	prefixTx           = 't'
+	prefixOutput       = 'o'
+	prefixAddress      = 'a'
+	prefixAddressPool  = 'u'
+	prefixUnspent      = 'U'
+	prefixScriptHash   = 's'
+	prefixMempool      = 'p'
+	prefixEvicted      = 'e'
+	prefixConflict     = 'c'
+	prefixBroadcast    = 'r'
+	prefixFeeStats     = 'f'
+	prefixFilter       = 'F'
+	directionFunding   = 0
+	directionSpending  = 1
+	zeroHash           = "0000000000000000000000000000000000000000000000000000000000000000"
+	coinbaseInputIndex = 0xffffffff
+)
+
+// reader is implemented by the database and by its snapshots.
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+type storage struct {
+	params   *chaincfg.Params
+	ldb      *leveldb.DB
+	db       reader
+	notifier notify.Notifier
+
+	// mu serializes the writes, which are buffered in writes until they
+	// are committed in a single batch. Buffered values start with
+	// writePut, or are writeDelete for deleted keys.
+	mu     *sync.Mutex
+	writes *memdb.DB
+}
+
+const (
+	writeDelete = 0
+	writePut    = 1
+)
+
+type Option func(*storage)
+
+// WithNotifier makes the storage report connected and disconnected blocks
+// and newly stored unconfirmed transactions to the given notifier.
+func WithNotifier(notifier notify.Notifier) Option {
+	return func(s *storage) {
+		s.notifier = notifier
+	}
+}
+
+// NewStorage returns a storage on the LevelDB database.
+func NewStorage(params *chaincfg.Params, db *leveldb.DB, opts ...Option) store.Storage {
+	s := &storage{
+		params:   params,
+		ldb:      db,
+		db:       db,
+		notifier: notify.Nop(),
+		mu:       new(sync.Mutex),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *storage) Params() *chaincfg.Params {
+	return s.params
+}
+
+// transaction runs fn against a storage whose writes are buffered, and
+// commits them in a single batch if fn succeeds. Notifications made by fn
+// are only sent once the batch is committed.
+func (s *storage) transaction(fn func(s *storage) error) error {
+	if s.writes != nil {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buffer := notify.NewBuffer()
+	tx := &storage{
+		params:   s.params,
+		ldb:      s.ldb,
+		db:       s.ldb,
+		notifier: buffer,
+		mu:       s.mu,
+		writes:   memdb.New(comparer.DefaultComparer, 0),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	it := tx.writes.NewIterator(nil)
+	for it.Next() {
+		if it.Value()[0] == writeDelete {
+			batch.Delete(it.Key())
+		} else {
+			batch.Put(it.Key(), it.Value()[1:])
+		}
+	}
+	it.Release()
+	if err := s.ldb.Write(batch, nil); err != nil {
+		return err
+	}
+	buffer.Flush(s.notifier)
+	return nil
+}
+
+// snapshot runs fn against a storage reading a consistent view of the
+// database.
+func (s *storage) snapshot(fn func(s *storage) error) error {
+	snap, err := s.ldb.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	return fn(&storage{
+		params:   s.params,
+		ldb:      s.ldb,
+		db:       snap,
+		notifier: s.notifier,
+		mu:       s.mu,
+	})
+}
+
+// get returns the value of a key, or command.ErrNotFound.
+func (s *storage) get(key []byte) ([]byte, error) {
+	if s.writes != nil {
+		if w, err := s.writes.Get(key); err == nil {
+			if w[0] == writeDelete {
+				return nil, command.ErrNotFound
+			}
+			return w[1:], nil
+		}
+	}
+	value, err := s.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, command.ErrNotFound
+	}
+	return value, err
+}
+
+func (s *storage) has(key []byte) (bool, error) {
+	_, err := s.get(key)
+	if errors.Is(err, command.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *storage) put(key, value []byte) {
+	s.writes.Put(key, append([]byte{writePut}, value...))
+}
+
+func (s *storage) delete(key []byte) {
+	s.writes.Put(key, []byte{writeDelete})
+}
+
+// getRecord decodes the record stored under a key.
+func (s *storage) getRecord(key []byte, record interface{}) error {
+	value, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(value, record)
+}
+
+func (s *storage) putRecord(key []byte, record interface{}) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.put(key, value)
+	return nil
+}
+
+// iterate calls fn with the keys in the range and their values in order, or
+// in reverse order if reverse is set, until fn returns false. The slices
+// passed to fn are only valid until it returns. Writes that are not
+// committed yet are merged in.
+func (s *storage) iterate(r *util.Range, reverse bool, fn func(key, value []byte) (bool, error)) error {
+	if s.writes != nil && s.writes.Len() > 0 {
+		return s.iterateWrites(r, reverse, fn)
+	}
+
+	it := s.db.NewIterator(r, nil)
+	defer it.Release()
+	ok, next := it.First(), it.Next
+	if reverse {
+		ok, next = it.Last(), it.Prev
+	}
+	for ; ok; ok = next() {
+		more, err := fn(it.Key(), it.Value())
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return it.Error()
+}
+
+// iterateWrites is iterate for a storage with uncommitted writes, which
+// shadow the stored values of their keys.
+func (s *storage) iterateWrites(r *util.Range, reverse bool, fn func(key, value []byte) (bool, error)) error {
+	type entry struct {
+		key, value []byte
+	}
+	entries := []entry{}
+	writes := []entry{}
+	wit := s.writes.NewIterator(r)
+	for wit.Next() {
+		writes = append(writes, entry{append([]byte{}, wit.Key()...), append([]byte{}, wit.Value()...)})
+	}
+	wit.Release()
+
+	it := s.db.NewIterator(r, nil)
+	i := 0
+	for it.Next() {
+		for ; i < len(writes) && bytes.Compare(writes[i].key, it.Key()) < 0; i++ {
+			if writes[i].value[0] == writePut {
+				entries = append(entries, entry{writes[i].key, writes[i].value[1:]})
+			}
+		}
+		if i < len(writes) && bytes.Equal(writes[i].key, it.Key()) {
+			continue
+		}
+		entries = append(entries, entry{append([]byte{}, it.Key()...), append([]byte{}, it.Value()...)})
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for ; i < len(writes); i++ {
+		if writes[i].value[0] == writePut {
+			entries = append(entries, entry{writes[i].key, writes[i].value[1:]})
+		}
+	}
+
+	for j := range entries {
+		e := entries[j]
+		if reverse {
+			e = entries[len(entries)-1-j]
+		}
+		more, err := fn(e.key, e.value)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextSequence returns the next sequence number.
+func (s *storage) nextSequence() (uint64, error) {
+	seq := uint64(0)
+	value, err := s.get([]byte{prefixSequence})
+	if err != nil && !errors.Is(err, command.ErrNotFound) {
+		return 0, err
+	}
+	if err == nil {
+		seq = binary.BigEndian.Uint64(value)
+	}
+	seq++
+	s.put([]byte{prefixSequence}, uint64Bytes(seq))
+	return seq, nil
+}
+
+// prefix returns the range of the keys starting with the prefix and fields.
+func prefix(p byte, fields ...[]byte) *util.Range {
+	return util.BytesPrefix(key(p, fields...))
+}
+
+// key concatenates a prefix and the fields of a key.
+func key(prefix byte, fields ...[]byte) []byte {
+	k := []byte{prefix}
+	for _, field := range fields {
+		k = append(k, field...)
+	}
+	return k
+}
+
+// hashBytes returns the bytes of a hex encoded hash, in the order of its
+// encoding. Strings that are not hashes return nil, which no key matches.
+func hashBytes(hash string) []byte {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != 32 {
+		return nil
+	}
+	return b
+}
+
+// addressBytes returns an address prefixed with its length, so that no
+// address is the prefix of another one in keys.
+func addressBytes(address string) []byte {
+	return append([]byte{byte(len(address))}, address...)
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// blockRecord is a block, stored with its transactions while it is on a
+// side branch.
+type blockRecord struct {
+	Height    int32
+	Header    []byte
+	MainChain bool
+	// TxIDs are the transactions of a main chain block, and ChainTxs the
+	// number of transactions in the main chain up to it.
+	TxIDs    []string
+	ChainTxs int64
+	Raw      []byte
+}
+
+func (b *blockRecord) header() (wire.BlockHeader, error) {
+	header := wire.BlockHeader{}
+	err := header.Deserialize(bytes.NewReader(b.Header))
+	return header, err
+}
+
+// txRecord is a transaction, confirmed if BlockHash is set.
+type txRecord struct {
+	Raw        []byte
+	Seq        uint64
+	BlockHash  string
+	BlockIndex uint32
+	Height     int32
+	// Safe, Fee, Size, Weight, FirstSeen and FirstSeenHeight are the fields
+	// of model.Transaction.
+	Safe            bool
+	Fee             int64
+	Size            int
+	Weight          int
+	FirstSeen       time.Time
+	FirstSeenHeight int32
+}
+
+func (t *txRecord) tx() (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	err := tx.Deserialize(bytes.NewReader(t.Raw))
+	return tx, err
+}
+
+// outputRecord is an output along with the input spending it, if any.
+type outputRecord struct {
+	Value    int64
+	PkScript []byte
+	Spender  string
+	Type     string
+	// Seq is the sequence number of the transaction creating the output.
+	Seq             uint64
+	SpendingTxHash  string
+	SpendingTxIndex uint32
+}
+
+// evictedRecord is a transaction evicted from the mempool.
+type evictedRecord struct {
+	Raw       []byte
+	Reason    string
+	EvictedBy string
+}
+
+// broadcastRecord is a transaction submitted through the RPC server.
+type broadcastRecord struct {
+	Raw     []byte
+	Seq     uint64
+	Relayed bool
+}
+
+// feeStatRecord is a model.FeeStat of a block.
+type feeStatRecord struct {
+	Bucket  int
+	Blocks  int32
+	Count   int64
+	FeeRate float64
+}
+
+// filterRecord is the basic filter of a block and its header.
+type filterRecord struct {
+	Filter []byte
+	Header string
+}
@@ -0,0 +1,22 @@
+package kv_test
+
+import (
+	"testing"
+
+	"github.com/catalogfi/indexer/notify"
+	"github.com/catalogfi/indexer/store/kv"
+	"github.com/catalogfi/indexer/store/storetest"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, notifier notify.Notifier) storetest.Storage {
+		db, err := leveldb.Open(storage.NewMemStorage(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return kv.NewStorage(storetest.Params, db, kv.WithNotifier(notifier))
+	})
+}
@@ -0,0 +1,234 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store"
+)
+
+// GetMempool returns the unconfirmed transactions in the order they were
+// stored.
+func (s *storage) GetMempool() ([]command.MempoolEntry, error) {
+	hashes := []string{}
+	if err := s.iterate(prefix(prefixMempool), false, func(_, value []byte) (bool, error) {
+		hashes = append(hashes, hex.EncodeToString(value))
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return s.GetMempoolEntries(hashes)
+}
+
+// GetMempoolEntries returns the transactions with the given hashes that are
+// unconfirmed, the others are left out.
+func (s *storage) GetMempoolEntries(hashes []string) ([]command.MempoolEntry, error) {
+	type unconfirmed struct {
+		hash        string
+		transaction txRecord
+	}
+	transactions := []unconfirmed{}
+	seen := map[string]bool{}
+	for _, hash := range hashes {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		transaction, err := s.getTx(hash)
+		if errors.Is(err, command.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if transaction.BlockHash == "" {
+			transactions = append(transactions, unconfirmed{hash, transaction})
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].transaction.Seq < transactions[j].transaction.Seq })
+
+	entries := make([]command.MempoolEntry, len(transactions))
+	for i, t := range transactions {
+		entry, err := s.mempoolEntry(t.hash, t.transaction)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// mempoolEntry looks up the unconfirmed parents and children of an
+// unconfirmed transaction and whether it is waiting to be relayed.
+func (s *storage) mempoolEntry(hash string, transaction txRecord) (command.MempoolEntry, error) {
+	tx, err := transaction.tx()
+	if err != nil {
+		return command.MempoolEntry{}, err
+	}
+	entry := command.MempoolEntry{
+		Hash:        hash,
+		WitnessHash: tx.WitnessHash().String(),
+		Size:        transaction.Size,
+		Weight:      transaction.Weight,
+		Fee:         transaction.Fee,
+		Time:        transaction.FirstSeen,
+		Height:      transaction.FirstSeenHeight,
+		Signals:     store.SignalsReplacement(tx),
+	}
+
+	parents := map[string]bool{}
+	for _, txIn := range tx.TxIn {
+		parent := txIn.PreviousOutPoint.Hash.String()
+		if parents[parent] {
+			continue
+		}
+		parents[parent] = true
+		transaction, err := s.getTx(parent)
+		if errors.Is(err, command.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return command.MempoolEntry{}, err
+		}
+		if transaction.BlockHash == "" {
+			entry.Depends = append(entry.Depends, parent)
+		}
+	}
+
+	children := map[string]bool{}
+	for j := range tx.TxOut {
+		output, err := s.getOutput(hash, uint32(j))
+		if err != nil {
+			return command.MempoolEntry{}, err
+		}
+		if output.SpendingTxHash != "" && !children[output.SpendingTxHash] {
+			children[output.SpendingTxHash] = true
+			entry.SpentBy = append(entry.SpentBy, output.SpendingTxHash)
+		}
+	}
+
+	broadcast := broadcastRecord{}
+	if err := s.getRecord(key(prefixBroadcast, hashBytes(hash)), &broadcast); err == nil {
+		entry.Unbroadcast = !broadcast.Relayed
+	} else if !errors.Is(err, command.ErrNotFound) {
+		return command.MempoolEntry{}, err
+	}
+	return entry, nil
+}
+
+// ExpireMempool removes the unconfirmed transactions that entered the
+// mempool before the given time, along with their descendants.
+func (s *storage) ExpireMempool(before time.Time) error {
+	return s.transaction(func(s *storage) error {
+		hashes := []string{}
+		if err := s.iterate(prefix(prefixMempool), false, func(_, value []byte) (bool, error) {
+			hashes = append(hashes, hex.EncodeToString(value))
+			return true, nil
+		}); err != nil {
+			return err
+		}
+
+		expired := 0
+		for _, hash := range hashes {
+			transaction, err := s.getTx(hash)
+			if errors.Is(err, command.ErrNotFound) {
+				// Already removed as the descendant of another transaction
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !transaction.FirstSeen.Before(before) {
+				continue
+			}
+			if err := s.removeTx(hash, store.EvictedExpired, ""); err != nil {
+				return err
+			}
+			expired++
+		}
+		if expired > 0 {
+			fmt.Println("Expired", expired, "transactions from the mempool")
+		}
+		return nil
+	})
+}
+
+// GetEvictedTransaction returns a transaction that was evicted from the
+// mempool.
+func (s *storage) GetEvictedTransaction(hash string) (command.Transaction, error) {
+	if hashBytes(hash) == nil {
+		return command.Transaction{}, command.ErrNotFound
+	}
+	evicted := evictedRecord{}
+	found := false
+	if err := s.iterate(prefix(prefixEvicted, hashBytes(hash)), true, func(_, value []byte) (bool, error) {
+		found = true
+		return false, json.Unmarshal(value, &evicted)
+	}); err != nil {
+		return command.Transaction{}, err
+	}
+	if !found {
+		return command.Transaction{}, command.ErrNotFound
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(evicted.Raw)); err != nil {
+		return command.Transaction{}, err
+	}
+
+	transaction := command.Transaction{Tx: tx}
+	if evicted.Reason == store.EvictedReplaced {
+		transaction.ReplacedBy = evicted.EvictedBy
+	}
+	return transaction, nil
+}
+
+// GetWalletConflicts returns the transactions spending the same outputs as
+// the transaction that it evicted from the mempool or that evicted it.
+func (s *storage) GetWalletConflicts(hash string) ([]string, error) {
+	type conflict struct {
+		seq  uint64
+		hash string
+	}
+	if hashBytes(hash) == nil {
+		return []string{}, nil
+	}
+	conflicts := []conflict{}
+	if err := s.iterate(prefix(prefixConflict, hashBytes(hash)), false, func(k, value []byte) (bool, error) {
+		conflicts = append(conflicts, conflict{sequence(k), hex.EncodeToString(value)})
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := s.iterate(prefix(prefixEvicted, hashBytes(hash)), false, func(k, value []byte) (bool, error) {
+		evicted := evictedRecord{}
+		if err := json.Unmarshal(value, &evicted); err != nil {
+			return false, err
+		}
+		if evicted.EvictedBy != "" {
+			conflicts = append(conflicts, conflict{sequence(k), evicted.EvictedBy})
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].seq < conflicts[j].seq })
+
+	hashes := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		hashes[i] = c.hash
+	}
+	return hashes, nil
+}
+
+// sequence returns the sequence number at the end of a key.
+func sequence(k []byte) uint64 {
+	return binary.BigEndian.Uint64(k[len(k)-8:])
+}
@@ -0,0 +1,344 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store"
+)
+
+func (s *storage) GetBlockLocator() (blockchain.BlockLocator, error) {
+	height, err := s.GetLatestBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := []*chainhash.Hash{}
+	for _, h := range store.LocatorHeights(height) {
+		blockHash, err := s.mainChainHash(h)
+		if errors.Is(err, command.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hash, err := chainhash.NewHashFromStr(blockHash)
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func (s *storage) PutTx(tx *wire.MsgTx) error {
+	return s.transaction(func(s *storage) error {
+		created, err := s.putTx(tx)
+		if err != nil {
+			return err
+		}
+		if created {
+			s.notifier.TxAccepted(tx)
+		}
+		return nil
+	})
+}
+
+// putTx stores an unconfirmed transaction, replacing the unconfirmed
+// transactions it conflicts with if the BIP125 rules allow it, and reports
+// whether the transaction was not known before.
+func (s *storage) putTx(tx *wire.MsgTx) (bool, error) {
+	replacement, err := s.replaceConflicts(tx)
+	if err != nil {
+		return false, err
+	}
+	created, err := s.putTxs([]*wire.MsgTx{tx}, nil)
+	if err != nil || len(created) == 0 {
+		return false, err
+	}
+	return true, s.setSafe(tx, replacement)
+}
+
+// confirmation is the block a transaction is confirmed in.
+type confirmation struct {
+	hash   string
+	height int32
+}
+
+// putTxs stores the transactions and their outputs, confirming them in
+// block if it is not nil. Transactions that are already known are only moved
+// into the block. It returns the transactions that were not known before.
+func (s *storage) putTxs(txs []*wire.MsgTx, block *confirmation) ([]*wire.MsgTx, error) {
+	// Unconfirmed transactions enter the mempool at the current height
+	firstSeen, firstSeenHeight := time.Time{}, int32(0)
+	if block == nil {
+		height, err := s.GetLatestBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		firstSeen, firstSeenHeight = time.Now(), height
+	}
+
+	created := []*wire.MsgTx{}
+	confirmed := []txRecord{}
+	for i, tx := range txs {
+		hash := tx.TxHash().String()
+		transaction, err := s.getTx(hash)
+		if err == nil {
+			if block != nil {
+				confirmed = append(confirmed, transaction)
+				if err := s.confirmTx(hash, tx, transaction, block, uint32(i)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		} else if !errors.Is(err, command.ErrNotFound) {
+			return nil, err
+		}
+
+		raw, err := serialize(tx)
+		if err != nil {
+			return nil, err
+		}
+		seq, err := s.nextSequence()
+		if err != nil {
+			return nil, err
+		}
+		transaction = txRecord{
+			Raw:  raw,
+			Seq:  seq,
+			Safe: block != nil,
+
+			Size:            tx.SerializeSize(),
+			Weight:          3*tx.SerializeSizeStripped() + tx.SerializeSize(),
+			FirstSeen:       firstSeen,
+			FirstSeenHeight: firstSeenHeight,
+		}
+		if block != nil {
+			transaction.BlockHash = block.hash
+			transaction.BlockIndex = uint32(i)
+			transaction.Height = block.height
+		}
+		if err := s.removeEvicted(hash); err != nil {
+			return nil, err
+		}
+
+		// Outputs created earlier in the same block are stored by now, so
+		// they can be spent like any other.
+		if !blockchain.IsCoinBaseTx(tx) {
+			for j, txIn := range tx.TxIn {
+				value, err := s.spend(hash, uint32(j), txIn, block)
+				if err != nil {
+					return nil, err
+				}
+				transaction.Fee += value
+			}
+			for _, txOut := range tx.TxOut {
+				transaction.Fee -= txOut.Value
+			}
+		}
+		for j, txOut := range tx.TxOut {
+			if err := s.putOutput(hash, uint32(j), seq, txOut); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := s.putRecord(key(prefixTx, hashBytes(hash)), transaction); err != nil {
+			return nil, err
+		}
+		if block == nil {
+			s.put(key(prefixMempool, uint64Bytes(seq)), hashBytes(hash))
+		}
+		if err := s.indexTx(hash, tx, transaction, true); err != nil {
+			return nil, err
+		}
+		created = append(created, tx)
+	}
+	if block != nil {
+		if err := s.putFeeStats(block, confirmed); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// confirmTx moves a known unconfirmed transaction into the block.
+func (s *storage) confirmTx(hash string, tx *wire.MsgTx, transaction txRecord, block *confirmation, index uint32) error {
+	if err := s.indexTx(hash, tx, transaction, false); err != nil {
+		return err
+	}
+	if transaction.BlockHash == "" {
+		s.delete(key(prefixMempool, uint64Bytes(transaction.Seq)))
+	}
+	transaction.BlockHash = block.hash
+	transaction.BlockIndex = index
+	transaction.Height = block.height
+	transaction.Safe = true
+	if err := s.putRecord(key(prefixTx, hashBytes(hash)), transaction); err != nil {
+		return err
+	}
+	return s.indexTx(hash, tx, transaction, true)
+}
+
+// putOutput stores a new, unspent output.
+func (s *storage) putOutput(hash string, index uint32, seq uint64, txOut *wire.TxOut) error {
+	spender, class, err := store.Spender(txOut.PkScript, s.params)
+	if err != nil {
+		return err
+	}
+	if err := s.putRecord(outputKey(hash, index), outputRecord{
+		Value:    txOut.Value,
+		PkScript: txOut.PkScript,
+		Spender:  spender,
+		Type:     class,
+		Seq:      seq,
+	}); err != nil {
+		return err
+	}
+	if spender != "" {
+		s.put(unspentKey(spender, seq, hash, index), []byte{})
+	}
+	s.put(scriptHashKey(txOut.PkScript, seq, hash, index), []byte{})
+	return nil
+}
+
+// spend marks the output spent by the input at index of the transaction as
+// spent, and returns its value. Unconfirmed transactions spending the same
+// output as a transaction of block are removed, since they can no longer be
+// confirmed. The output has to exist.
+func (s *storage) spend(hash string, index uint32, txIn *wire.TxIn, block *confirmation) (int64, error) {
+	fundingHash := txIn.PreviousOutPoint.Hash.String()
+	output, err := s.getOutput(fundingHash, txIn.PreviousOutPoint.Index)
+	if errors.Is(err, command.ErrNotFound) {
+		return 0, fmt.Errorf("failed to find the outpoint %v spent by transaction %v", txIn.PreviousOutPoint, hash)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if block != nil && output.SpendingTxHash != "" && output.SpendingTxHash != hash {
+		conflict, err := s.getTx(output.SpendingTxHash)
+		if err != nil {
+			return 0, err
+		}
+		if conflict.BlockHash != "" {
+			return 0, fmt.Errorf("outputs spent by transaction %v in block %v are spent again", output.SpendingTxHash, conflict.BlockHash)
+		}
+		if err := s.removeTx(output.SpendingTxHash, store.EvictedConflict, hash); err != nil {
+			return 0, err
+		}
+		if output, err = s.getOutput(fundingHash, txIn.PreviousOutPoint.Index); err != nil {
+			return 0, err
+		}
+	}
+
+	if output.SpendingTxHash == "" && output.Spender != "" {
+		s.delete(unspentKey(output.Spender, output.Seq, fundingHash, txIn.PreviousOutPoint.Index))
+	}
+	output.SpendingTxHash = hash
+	output.SpendingTxIndex = index
+	return output.Value, s.putRecord(outputKey(fundingHash, txIn.PreviousOutPoint.Index), output)
+}
+
+func unspentKey(address string, seq uint64, hash string, index uint32) []byte {
+	return key(prefixUnspent, addressBytes(address), uint64Bytes(seq), hashBytes(hash), uint32Bytes(index))
+}
+
+func scriptHashKey(pkScript []byte, seq uint64, hash string, index uint32) []byte {
+	return key(prefixScriptHash, hashBytes(store.ScriptHash(pkScript)), uint64Bytes(seq), hashBytes(hash), uint32Bytes(index))
+}
+
+// PutBlock stores the block and its transactions in a single batch, so that
+// a failure part way through leaves no trace of it.
+func (s *storage) PutBlock(block *wire.MsgBlock) error {
+	return s.transaction(func(s *storage) error {
+		return s.putBlock(block)
+	})
+}
+
+func (s *storage) putBlock(block *wire.MsgBlock) error {
+	blockHash := block.BlockHash().String()
+	if ok, err := s.has(key(prefixBlock, hashBytes(blockHash))); err != nil || ok {
+		// The block is already known
+		return err
+	}
+
+	if block.Header.PrevBlock == *s.params.GenesisHash {
+		if err := s.putGenesisBlock(); err != nil {
+			return err
+		}
+	}
+
+	previousBlock, err := s.getBlock(block.Header.PrevBlock.String())
+	if err != nil {
+		return err
+	}
+	tipHash, _, err := s.tip()
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := block.Header.Serialize(buf); err != nil {
+		return err
+	}
+	record := blockRecord{
+		Height: previousBlock.Height + 1,
+		Header: buf.Bytes(),
+	}
+	if block.Header.PrevBlock.String() == tipHash {
+		if err := s.connectBlock(blockHash, record, block); err != nil {
+			return err
+		}
+		s.notifier.UpdatedBlockTip(block)
+		return nil
+	}
+
+	// The block is on a side branch, it is kept as is so that the branch can
+	// be connected once it has more work than the main chain.
+	buf = new(bytes.Buffer)
+	if err := block.Serialize(buf); err != nil {
+		return err
+	}
+	record.Raw = buf.Bytes()
+	if err := s.putRecord(key(prefixBlock, hashBytes(blockHash)), record); err != nil {
+		return err
+	}
+	fmt.Println("Block", record.Height, "has been added to a side branch", blockHash)
+	return s.reorganize(blockHash, record)
+}
+
+// putGenesisBlock stores the genesis block, whose transaction is not
+// spendable and so is not stored, unless it is stored already.
+func (s *storage) putGenesisBlock() error {
+	genesisBlock := btcutil.NewBlock(s.params.GenesisBlock)
+	blockHash := genesisBlock.Hash().String()
+	if ok, err := s.has(key(prefixBlock, hashBytes(blockHash))); err != nil || ok {
+		return err
+	}
+
+	if err := s.putBlockFilter(genesisBlock.MsgBlock()); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := genesisBlock.MsgBlock().Header.Serialize(buf); err != nil {
+		return err
+	}
+	if err := s.putRecord(key(prefixBlock, hashBytes(blockHash)), blockRecord{
+		Height:    0,
+		Header:    buf.Bytes(),
+		MainChain: true,
+	}); err != nil {
+		return err
+	}
+	s.put(key(prefixHeight, uint32Bytes(0)), hashBytes(blockHash))
+	s.put([]byte{prefixTip}, hashBytes(blockHash))
+	return nil
+}
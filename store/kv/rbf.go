@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store"
+)
+
+// replaceConflicts evicts the unconfirmed transactions spending the same
+// outputs as tx if tx can replace them under BIP125, and reports whether
+// there were any. Spending outputs that are spent by a confirmed transaction
+// is an error.
+func (s *storage) replaceConflicts(tx *wire.MsgTx) (bool, error) {
+	if blockchain.IsCoinBaseTx(tx) {
+		return false, nil
+	}
+
+	hash := tx.TxHash().String()
+	conflicts := []string{}
+	seen := map[string]bool{}
+	fee := int64(0)
+	for _, txIn := range tx.TxIn {
+		output, err := s.getOutput(txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index)
+		if errors.Is(err, command.ErrNotFound) {
+			// Inputs that are not found make the transaction fail to be
+			// stored when its outputs are spent
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		fee += output.Value
+		if output.SpendingTxHash != "" && output.SpendingTxHash != hash && !seen[output.SpendingTxHash] {
+			seen[output.SpendingTxHash] = true
+			conflicts = append(conflicts, output.SpendingTxHash)
+		}
+	}
+	if len(conflicts) == 0 {
+		return false, nil
+	}
+
+	for _, conflict := range conflicts {
+		transaction, err := s.getTx(conflict)
+		if err != nil {
+			return false, err
+		}
+		if transaction.BlockHash != "" {
+			return false, command.NewError(command.ErrRPCVerify, "bad-txns-inputs-missingorspent")
+		}
+	}
+	originals, err := s.GetMempoolEntries(conflicts)
+	if err != nil {
+		return false, err
+	}
+	for _, txOut := range tx.TxOut {
+		fee -= txOut.Value
+	}
+	if err := store.CheckReplacement(s, tx, fee, originals); err != nil {
+		return false, err
+	}
+	for _, conflict := range conflicts {
+		if err := s.removeTx(conflict, store.EvictedReplaced, hash); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// setSafe marks a newly stored unconfirmed transaction as safe unless it
+// signals replaceability, replaced other transactions or has unsafe
+// unconfirmed parents.
+func (s *storage) setSafe(tx *wire.MsgTx, replacement bool) error {
+	if replacement || store.SignalsReplacement(tx) {
+		return nil
+	}
+	for _, txIn := range tx.TxIn {
+		parent, err := s.getTx(txIn.PreviousOutPoint.Hash.String())
+		if errors.Is(err, command.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if parent.BlockHash == "" && !parent.Safe {
+			return nil
+		}
+	}
+
+	hash := tx.TxHash().String()
+	transaction, err := s.getTx(hash)
+	if err != nil {
+		return err
+	}
+	transaction.Safe = true
+	return s.putRecord(key(prefixTx, hashBytes(hash)), transaction)
+}
+
+// markUnsafe marks the transactions and their unconfirmed descendants as
+// unsafe.
+func (s *storage) markUnsafe(hashes []string) error {
+	for len(hashes) > 0 {
+		children := []string{}
+		for _, hash := range hashes {
+			transaction, err := s.getTx(hash)
+			if err != nil {
+				return err
+			}
+			transaction.Safe = false
+			if err := s.putRecord(key(prefixTx, hashBytes(hash)), transaction); err != nil {
+				return err
+			}
+
+			tx, err := transaction.tx()
+			if err != nil {
+				return err
+			}
+			for j := range tx.TxOut {
+				output, err := s.getOutput(hash, uint32(j))
+				if err != nil {
+					return err
+				}
+				if output.SpendingTxHash == "" {
+					continue
+				}
+				child, err := s.getTx(output.SpendingTxHash)
+				if err != nil {
+					return err
+				}
+				if child.BlockHash == "" && child.Safe {
+					children = append(children, output.SpendingTxHash)
+				}
+			}
+		}
+		hashes = children
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store"
+)
+
+// connectBlock appends the block to the main chain and confirms its
+// transactions.
+func (s *storage) connectBlock(hash string, record blockRecord, block *wire.MsgBlock) error {
+	previous, err := s.getBlock(block.Header.PrevBlock.String())
+	if err != nil {
+		return err
+	}
+	record.MainChain = true
+	record.Raw = nil
+	record.TxIDs = make([]string, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		record.TxIDs[i] = tx.TxHash().String()
+	}
+	record.ChainTxs = previous.ChainTxs + int64(len(block.Transactions))
+	if err := s.putRecord(key(prefixBlock, hashBytes(hash)), record); err != nil {
+		return err
+	}
+	s.put(key(prefixHeight, uint32Bytes(uint32(record.Height))), hashBytes(hash))
+	s.put([]byte{prefixTip}, hashBytes(hash))
+
+	if _, err := s.putTxs(block.Transactions, &confirmation{hash, record.Height}); err != nil {
+		return err
+	}
+	if err := s.putBlockFilter(block); err != nil {
+		return err
+	}
+	fmt.Println("Block", record.Height, "has been added to the database", hash)
+
+	s.notifier.BlockConnected(block)
+	return nil
+}
+
+// disconnectBlock removes the last block of the main chain. Its coinbase
+// transaction is deleted, and the other transactions become unconfirmed.
+func (s *storage) disconnectBlock(hash string, record blockRecord) error {
+	block, err := s.GetBlockFromHash(hash)
+	if err != nil {
+		return err
+	}
+	raw, err := block.Bytes()
+	if err != nil {
+		return err
+	}
+
+	for i, txid := range record.TxIDs {
+		if i == 0 {
+			if err := s.removeTx(txid, store.EvictedReorg, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		transaction, err := s.getTx(txid)
+		if errors.Is(err, command.ErrNotFound) {
+			// Removed as the descendant of the coinbase transaction
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		tx, err := transaction.tx()
+		if err != nil {
+			return err
+		}
+		if err := s.indexTx(txid, tx, transaction, false); err != nil {
+			return err
+		}
+		transaction.BlockHash = ""
+		transaction.BlockIndex = 0
+		transaction.Height = 0
+		transaction.FirstSeen = time.Now()
+		transaction.FirstSeenHeight = record.Height - 1
+		if err := s.putRecord(key(prefixTx, hashBytes(txid)), transaction); err != nil {
+			return err
+		}
+		s.put(key(prefixMempool, uint64Bytes(transaction.Seq)), hashBytes(txid))
+		if err := s.indexTx(txid, tx, transaction, true); err != nil {
+			return err
+		}
+	}
+	if err := s.markUnsafeTxs(block.MsgBlock()); err != nil {
+		return err
+	}
+	s.delete(key(prefixFeeStats, hashBytes(hash)))
+
+	record.MainChain = false
+	record.TxIDs = nil
+	record.ChainTxs = 0
+	record.Raw = raw
+	if err := s.putRecord(key(prefixBlock, hashBytes(hash)), record); err != nil {
+		return err
+	}
+	s.delete(key(prefixHeight, uint32Bytes(uint32(record.Height))))
+	s.put([]byte{prefixTip}, hashBytes(block.MsgBlock().Header.PrevBlock.String()))
+	fmt.Println("Block", record.Height, "has been disconnected", hash)
+
+	s.notifier.BlockDisconnected(block.MsgBlock())
+	return nil
+}
+
+// reorganize makes the side branch ending at sideTip the main chain if it
+// has more work than the blocks of the main chain after the fork point.
+func (s *storage) reorganize(sideTipHash string, sideTip blockRecord) error {
+	type branchBlock struct {
+		hash   string
+		record blockRecord
+		header wire.BlockHeader
+	}
+	header, err := sideTip.header()
+	if err != nil {
+		return err
+	}
+	branch := []branchBlock{{sideTipHash, sideTip, header}}
+	branchWork := blockchain.CalcWork(header.Bits)
+	fork := blockRecord{}
+	for {
+		hash := branch[len(branch)-1].header.PrevBlock.String()
+		block, err := s.getBlock(hash)
+		if err != nil {
+			return err
+		}
+		if block.MainChain {
+			fork = block
+			break
+		}
+		header, err := block.header()
+		if err != nil {
+			return err
+		}
+		branch = append(branch, branchBlock{hash, block, header})
+		branchWork.Add(branchWork, blockchain.CalcWork(header.Bits))
+	}
+
+	tipHash, tip, err := s.tip()
+	if err != nil {
+		return err
+	}
+	mainChain := []branchBlock{}
+	mainWork := new(big.Int)
+	for hash, block := tipHash, tip; block.Height > fork.Height; {
+		header, err := block.header()
+		if err != nil {
+			return err
+		}
+		mainChain = append(mainChain, branchBlock{hash, block, header})
+		mainWork.Add(mainWork, blockchain.CalcWork(header.Bits))
+
+		hash = header.PrevBlock.String()
+		if block, err = s.getBlock(hash); err != nil {
+			return err
+		}
+	}
+	// On equal work the block seen first stays on the main chain
+	if branchWork.Cmp(mainWork) <= 0 {
+		return nil
+	}
+
+	fmt.Println("Reorganizing from height", fork.Height, ":", len(mainChain), "blocks disconnected and", len(branch), "blocks connected")
+	for _, block := range mainChain {
+		if err := s.disconnectBlock(block.hash, block.record); err != nil {
+			return err
+		}
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		block, err := btcutil.NewBlockFromBytes(branch[i].record.Raw)
+		if err != nil {
+			return err
+		}
+		if err := s.connectBlock(branch[i].hash, branch[i].record, block.MsgBlock()); err != nil {
+			return err
+		}
+		// The side tip is the new tip of the main chain
+		if i == 0 {
+			s.notifier.UpdatedBlockTip(block.MsgBlock())
+		}
+	}
+	return nil
+}
+
+// removeTx deletes the transaction along with the unconfirmed transactions
+// spending its outputs, and marks the outputs it spent as unspent. Removed
+// unconfirmed transactions are kept as evicted for the given reason, by
+// evictedBy if they conflict with it.
+func (s *storage) removeTx(hash, reason, evictedBy string) error {
+	transaction, err := s.getTx(hash)
+	if errors.Is(err, command.ErrNotFound) {
+		// Already removed as the descendant of another transaction
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tx, err := transaction.tx()
+	if err != nil {
+		return err
+	}
+
+	for j := range tx.TxOut {
+		output, err := s.getOutput(hash, uint32(j))
+		if err != nil {
+			return err
+		}
+		if output.SpendingTxHash != "" {
+			if err := s.removeTx(output.SpendingTxHash, reason, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.indexTx(hash, tx, transaction, false); err != nil {
+		return err
+	}
+	if transaction.BlockHash == "" {
+		seq, err := s.nextSequence()
+		if err != nil {
+			return err
+		}
+		if err := s.putRecord(key(prefixEvicted, hashBytes(hash), uint64Bytes(seq)), evictedRecord{
+			Raw:       transaction.Raw,
+			Reason:    reason,
+			EvictedBy: evictedBy,
+		}); err != nil {
+			return err
+		}
+		if evictedBy != "" {
+			s.put(key(prefixConflict, hashBytes(evictedBy), uint64Bytes(seq)), hashBytes(hash))
+		}
+		s.delete(key(prefixMempool, uint64Bytes(transaction.Seq)))
+	}
+
+	// The outputs are unspent now that the descendants are removed
+	for j, txOut := range tx.TxOut {
+		output, err := s.getOutput(hash, uint32(j))
+		if err != nil {
+			return err
+		}
+		if output.Spender != "" {
+			s.delete(unspentKey(output.Spender, output.Seq, hash, uint32(j)))
+		}
+		s.delete(scriptHashKey(txOut.PkScript, output.Seq, hash, uint32(j)))
+		s.delete(outputKey(hash, uint32(j)))
+	}
+	if !blockchain.IsCoinBaseTx(tx) {
+		for _, txIn := range tx.TxIn {
+			fundingHash := txIn.PreviousOutPoint.Hash.String()
+			output, err := s.getOutput(fundingHash, txIn.PreviousOutPoint.Index)
+			if err != nil {
+				return err
+			}
+			if output.SpendingTxHash != hash {
+				continue
+			}
+			output.SpendingTxHash = ""
+			output.SpendingTxIndex = 0
+			if err := s.putRecord(outputKey(fundingHash, txIn.PreviousOutPoint.Index), output); err != nil {
+				return err
+			}
+			if output.Spender != "" {
+				s.put(unspentKey(output.Spender, output.Seq, fundingHash, txIn.PreviousOutPoint.Index), []byte{})
+			}
+		}
+	}
+	s.delete(key(prefixTx, hashBytes(hash)))
+
+	if transaction.BlockHash == "" {
+		s.notifier.TxRemoved(tx)
+	}
+	return nil
+}
+
+// removeEvicted forgets that a transaction that is stored again was
+// evicted.
+func (s *storage) removeEvicted(hash string) error {
+	keys := [][]byte{}
+	if err := s.iterate(prefix(prefixEvicted, hashBytes(hash)), false, func(k, value []byte) (bool, error) {
+		evicted := evictedRecord{}
+		if err := json.Unmarshal(value, &evicted); err != nil {
+			return false, err
+		}
+		keys = append(keys, append([]byte{}, k...))
+		if evicted.EvictedBy != "" {
+			keys = append(keys, key(prefixConflict, hashBytes(evicted.EvictedBy), k[1+32:]))
+		}
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s.delete(k)
+	}
+	return nil
+}
+
+// markUnsafeTxs marks the transactions of a disconnected block that are
+// unconfirmed again as unsafe if they signal replaceability or spend from
+// unsafe transactions of the block, along with their descendants.
+func (s *storage) markUnsafeTxs(block *wire.MsgBlock) error {
+	unsafe := map[chainhash.Hash]bool{}
+	hashes := []string{}
+	for _, tx := range block.Transactions[1:] {
+		isUnsafe := store.SignalsReplacement(tx)
+		for _, txIn := range tx.TxIn {
+			isUnsafe = isUnsafe || unsafe[txIn.PreviousOutPoint.Hash]
+		}
+		if isUnsafe {
+			unsafe[tx.TxHash()] = true
+			hashes = append(hashes, tx.TxHash().String())
+		}
+	}
+
+	unconfirmed := []string{}
+	for _, hash := range hashes {
+		transaction, err := s.getTx(hash)
+		if errors.Is(err, command.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if transaction.BlockHash == "" {
+			unconfirmed = append(unconfirmed, hash)
+		}
+	}
+	return s.markUnsafe(unconfirmed)
+}
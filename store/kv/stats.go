@@ -0,0 +1,117 @@
+package kv
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+// blockTxs returns the ids of the transactions of a block of the main
+// chain, none for other blocks.
+func (s *storage) blockTxs(hash string) ([]string, error) {
+	block, err := s.getBlock(hash)
+	if errors.Is(err, command.ErrNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block.TxIDs == nil {
+		return []string{}, nil
+	}
+	return block.TxIDs, nil
+}
+
+// GetBlockTransactions returns the transactions of a block of the main chain
+// in the order of the block.
+func (s *storage) GetBlockTransactions(hash string) ([]model.Transaction, error) {
+	txids, err := s.blockTxs(hash)
+	if err != nil {
+		return nil, err
+	}
+	transactions := make([]model.Transaction, len(txids))
+	for i, txid := range txids {
+		transaction, err := s.getTx(txid)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := transaction.tx()
+		if err != nil {
+			return nil, err
+		}
+		transactions[i] = model.Transaction{
+			Hash:            model.Hash(txid),
+			WitnessHash:     model.Hash(tx.WitnessHash().String()),
+			LockTime:        tx.LockTime,
+			Version:         tx.Version,
+			Safe:            transaction.Safe,
+			Size:            transaction.Size,
+			Weight:          transaction.Weight,
+			Fee:             transaction.Fee,
+			FirstSeen:       transaction.FirstSeen,
+			FirstSeenHeight: transaction.FirstSeenHeight,
+			BlockHash:       model.Hash(transaction.BlockHash),
+			BlockIndex:      transaction.BlockIndex,
+		}
+	}
+	return transactions, nil
+}
+
+// GetSpentOutPoints returns the outputs spent by the transactions of a block
+// of the main chain.
+func (s *storage) GetSpentOutPoints(blockHash string) ([]model.OutPoint, error) {
+	txids, err := s.blockTxs(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	outPoints := []model.OutPoint{}
+	for _, txid := range txids {
+		transaction, err := s.getTx(txid)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := transaction.tx()
+		if err != nil {
+			return nil, err
+		}
+		if blockchain.IsCoinBaseTx(tx) {
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			hash := txIn.PreviousOutPoint.Hash.String()
+			output, err := s.getOutput(hash, txIn.PreviousOutPoint.Index)
+			if err != nil {
+				return nil, err
+			}
+			outPoints = append(outPoints, outPoint(hash, txIn.PreviousOutPoint.Index, output))
+		}
+	}
+	return outPoints, nil
+}
+
+// GetChainTxCount returns the number of transactions in the main chain up
+// to the given height, counting the transaction of the genesis block which
+// is not stored.
+func (s *storage) GetChainTxCount(height int32) (int64, error) {
+	tip, err := s.GetLatestBlockHeight()
+	if err != nil {
+		return 0, err
+	}
+	if height > tip {
+		height = tip
+	}
+	if height < 0 {
+		return 1, nil
+	}
+	hash, err := s.mainChainHash(height)
+	if err != nil {
+		return 0, err
+	}
+	block, err := s.getBlock(hash)
+	if err != nil {
+		return 0, err
+	}
+	return block.ChainTxs + 1, nil
+}
@@ -0,0 +1,114 @@
+package kv
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/store"
+)
+
+// GetTxOut returns an output that is unspent on the main chain, and in the
+// mempool if includeMempool is set. Outputs of unconfirmed transactions are
+// only returned if includeMempool is set, and provably unspendable ones never
+// are.
+func (s *storage) GetTxOut(hash string, index uint32, includeMempool bool) (command.TxOut, error) {
+	output, err := s.getOutput(hash, index)
+	if err != nil {
+		return command.TxOut{}, err
+	}
+	if store.Unspendable(output.PkScript) {
+		return command.TxOut{}, command.ErrNotFound
+	}
+	funding, err := s.getTx(hash)
+	if err != nil {
+		return command.TxOut{}, err
+	}
+	if funding.BlockHash == "" && !includeMempool {
+		return command.TxOut{}, command.ErrNotFound
+	}
+
+	if output.SpendingTxHash != "" {
+		if includeMempool {
+			return command.TxOut{}, command.ErrNotFound
+		}
+		spender, err := s.getTx(output.SpendingTxHash)
+		if err != nil {
+			return command.TxOut{}, err
+		}
+		if spender.BlockHash != "" {
+			return command.TxOut{}, command.ErrNotFound
+		}
+	}
+
+	txOut := command.TxOut{OutPoint: outPoint(hash, index, output)}
+	if funding.BlockHash != "" {
+		txOut.BlockHash = funding.BlockHash
+		txOut.Height = funding.Height
+		txOut.Coinbase = funding.BlockIndex == 0
+	}
+	return txOut, nil
+}
+
+// GetTxOutSetInfo walks the outputs that are unspent on the main chain in
+// the order of their outpoints, on a snapshot of the database. Outputs spent
+// in the mempool count as unspent, and provably unspendable ones are left out
+// like bitcoind does. The outputs are keyed by the hex byte order of their
+// hashes, so they cannot be walked in the internal byte order
+// hash_serialized_3 takes them in.
+func (s *storage) GetTxOutSetInfo(withHash bool) (command.TxOutSetInfo, error) {
+	if withHash {
+		return command.TxOutSetInfo{}, command.NewError(command.ErrRPCInvalidParameter, "hash_type hash_serialized_3 is not supported by the LevelDB storage")
+	}
+	info := command.TxOutSetInfo{}
+	err := s.snapshot(func(s *storage) error {
+		tipHash, tip, err := s.tip()
+		if err != nil {
+			return err
+		}
+		info.Height = tip.Height
+		info.BestBlock = tipHash
+
+		lastHash, counted := "", false
+		funding := txRecord{}
+		return s.iterate(prefix(prefixOutput), false, func(k, value []byte) (bool, error) {
+			hash := hex.EncodeToString(k[1:33])
+			if hash != lastHash {
+				lastHash, counted = hash, false
+				if funding, err = s.getTx(hash); err != nil {
+					return false, err
+				}
+			}
+			if funding.BlockHash == "" {
+				return true, nil
+			}
+
+			output := outputRecord{}
+			if err := json.Unmarshal(value, &output); err != nil {
+				return false, err
+			}
+			if store.Unspendable(output.PkScript) {
+				return true, nil
+			}
+			if output.SpendingTxHash != "" {
+				spender, err := s.getTx(output.SpendingTxHash)
+				if err != nil {
+					return false, err
+				}
+				if spender.BlockHash != "" {
+					return true, nil
+				}
+			}
+
+			if !counted {
+				info.Transactions++
+				counted = true
+			}
+			info.TxOuts++
+			info.BogoSize += int64(50 + len(output.PkScript))
+			info.TotalAmount += output.Value
+			return true, nil
+		})
+	})
+	return info, err
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+// GetMempool returns the unconfirmed transactions in the order they were
+// stored.
+func (s *storage) GetMempool() ([]command.MempoolEntry, error) {
+	transactions := []model.Transaction{}
+	if resp := s.db.Order("id").Find(&transactions, "block_id IS NULL AND hash <> ?", model.Hash(chainhash.Hash{}.String())); resp.Error != nil {
+		return nil, resp.Error
+	}
+	return s.mempoolEntries(transactions)
+}
+
+// GetMempoolEntries returns the transactions with the given hashes that are
+// unconfirmed, the others are left out.
+func (s *storage) GetMempoolEntries(hashes []string) ([]command.MempoolEntry, error) {
+	transactions := []model.Transaction{}
+	for start := 0; start < len(hashes); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		batch := []model.Transaction{}
+		if resp := s.db.Order("id").Find(&batch, "block_id IS NULL AND hash <> ? AND hash IN ?", model.Hash(chainhash.Hash{}.String()), model.Hashes(hashes[start:end])); resp.Error != nil {
+			return nil, resp.Error
+		}
+		transactions = append(transactions, batch...)
+	}
+	return s.mempoolEntries(transactions)
+}
+
+// mempoolEntries looks up the unconfirmed parents and children of the
+// unconfirmed transactions, whether they signal replaceability and whether
+// they are waiting to be relayed.
+func (s *storage) mempoolEntries(transactions []model.Transaction) ([]command.MempoolEntry, error) {
+	entries := make([]command.MempoolEntry, len(transactions))
+	byID := make(map[uint]*command.MempoolEntry, len(transactions))
+	byHash := make(map[model.Hash]*command.MempoolEntry, len(transactions))
+	for i, transaction := range transactions {
+		entries[i] = command.MempoolEntry{
+			Hash:        string(transaction.Hash),
+			WitnessHash: string(transaction.WitnessHash),
+			Size:        transaction.Size,
+			Weight:      transaction.Weight,
+			Fee:         transaction.Fee,
+			Time:        firstSeen(transaction),
+			Height:      transaction.FirstSeenHeight,
+		}
+		byID[transaction.ID] = &entries[i]
+		byHash[transaction.Hash] = &entries[i]
+	}
+
+	for start := 0; start < len(transactions); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(transactions) {
+			end = len(transactions)
+		}
+		ids := make([]uint, 0, end-start)
+		hashes := make([]model.Hash, 0, end-start)
+		for _, transaction := range transactions[start:end] {
+			ids = append(ids, transaction.ID)
+			hashes = append(hashes, transaction.Hash)
+		}
+
+		parents := []struct {
+			SpendingTxID  uint
+			FundingTxHash model.Hash
+		}{}
+		if resp := s.db.Model(&model.OutPoint{}).Distinct("out_points.spending_tx_id", "out_points.funding_tx_hash").
+			Joins("JOIN transactions ON transactions.id = out_points.funding_tx_id AND transactions.deleted_at IS NULL").
+			Where("out_points.spending_tx_id IN ? AND transactions.block_id IS NULL", ids).Scan(&parents); resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, parent := range parents {
+			entry := byID[parent.SpendingTxID]
+			entry.Depends = append(entry.Depends, string(parent.FundingTxHash))
+		}
+
+		children := []struct {
+			FundingTxID    uint
+			SpendingTxHash model.Hash
+		}{}
+		if resp := s.db.Model(&model.OutPoint{}).Distinct("funding_tx_id", "spending_tx_hash").
+			Where("funding_tx_id IN ? AND spending_tx_id IS NOT NULL", ids).Scan(&children); resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, child := range children {
+			entry := byID[child.FundingTxID]
+			entry.SpentBy = append(entry.SpentBy, string(child.SpendingTxHash))
+		}
+
+		sequences := []struct {
+			SpendingTxID uint
+			Sequence     uint32
+		}{}
+		if resp := s.db.Model(&model.OutPoint{}).Select("spending_tx_id, MIN(sequence) AS sequence").
+			Where("spending_tx_id IN ?", ids).Group("spending_tx_id").Scan(&sequences); resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, sequence := range sequences {
+			byID[sequence.SpendingTxID].Signals = sequence.Sequence < wire.MaxTxInSequenceNum-1
+		}
+
+		broadcasts := []model.BroadcastTx{}
+		if resp := s.db.Find(&broadcasts, "hash IN ? AND relayed = ?", hashes, false); resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, broadcast := range broadcasts {
+			byHash[broadcast.Hash].Unbroadcast = true
+		}
+	}
+	return entries, nil
+}
+
+// ExpireMempool removes the unconfirmed transactions that entered the
+// mempool before the given time, along with their descendants.
+func (s *storage) ExpireMempool(before time.Time) error {
+	return s.transaction(func(s *storage) error {
+		transactions := []model.Transaction{}
+		if resp := s.db.Find(&transactions, "block_id IS NULL AND hash <> ? AND first_seen < ?", model.Hash(chainhash.Hash{}.String()), before); resp.Error != nil {
+			return resp.Error
+		}
+
+		expired := 0
+		for _, transaction := range transactions {
+			if !firstSeen(transaction).Before(before) {
+				continue
+			}
+			if err := s.removeTx(transaction, EvictedExpired, ""); err != nil {
+				return err
+			}
+			expired++
+		}
+		if expired > 0 {
+			fmt.Println("Expired", expired, "transactions from the mempool")
+		}
+		return nil
+	})
+}
+
+// GetEvictedTransaction returns a transaction that was evicted from the
+// mempool.
+func (s *storage) GetEvictedTransaction(hash string) (command.Transaction, error) {
+	evicted := model.EvictedTx{}
+	if resp := s.db.Order("id desc").First(&evicted, "hash = ?", model.Hash(hash)); resp.Error != nil {
+		return command.Transaction{}, queryError(resp.Error)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(evicted.Raw)); err != nil {
+		return command.Transaction{}, err
+	}
+
+	transaction := command.Transaction{Tx: tx}
+	if evicted.Reason == EvictedReplaced {
+		transaction.ReplacedBy = string(evicted.EvictedBy)
+	}
+	return transaction, nil
+}
+
+// GetWalletConflicts returns the transactions spending the same outputs as
+// the transaction that it evicted from the mempool or that evicted it.
+func (s *storage) GetWalletConflicts(hash string) ([]string, error) {
+	evicted := []model.EvictedTx{}
+	if resp := s.db.Order("id").Find(&evicted, "evicted_by = ? OR (hash = ? AND evicted_by IS NOT NULL)", model.Hash(hash), model.Hash(hash)); resp.Error != nil {
+		return nil, resp.Error
+	}
+	conflicts := make([]string, len(evicted))
+	for i, e := range evicted {
+		if string(e.Hash) == hash {
+			conflicts[i] = string(e.EvictedBy)
+		} else {
+			conflicts[i] = string(e.Hash)
+		}
+	}
+	return conflicts, nil
+}
+
+// firstSeen returns when the transaction entered the mempool, transactions
+// stored before this was recorded fall back to when they were stored.
+func firstSeen(transaction model.Transaction) time.Time {
+	if transaction.FirstSeen.IsZero() {
+		return transaction.CreatedAt
+	}
+	return transaction.FirstSeen
+}
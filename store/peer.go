@@ -1,10 +1,14 @@
 package store
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
+	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcutil"
@@ -14,6 +18,7 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/catalogfi/indexer/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 func (s *storage) GetBlockLocator() (blockchain.BlockLocator, error) {
@@ -21,17 +26,17 @@ func (s *storage) GetBlockLocator() (blockchain.BlockLocator, error) {
 	if err != nil {
 		return nil, err
 	}
-	locatorIDs := calculateLocator([]int{int(height)})
+	locatorIDs := LocatorHeights(height)
 	blocks := []model.Block{}
 
-	if res := s.db.Find(&blocks, "height in ?", locatorIDs); res.Error != nil {
-		return nil, err
+	if res := s.db.Order("height").Find(&blocks, "height in ? AND is_orphan = ?", locatorIDs, false); res.Error != nil {
+		return nil, res.Error
 	}
 
 	hashes := make([]*chainhash.Hash, len(blocks))
 	indices := make([]int32, len(blocks))
 	for i := range blocks {
-		hash, err := chainhash.NewHashFromStr(blocks[i].Hash)
+		hash, err := chainhash.NewHashFromStr(string(blocks[i].Hash))
 		if err != nil {
 			return hashes, err
 		}
@@ -47,6 +52,17 @@ func (s *storage) GetBlockLocator() (blockchain.BlockLocator, error) {
 	return hashes, nil
 }
 
+// LocatorHeights returns the heights of the main chain blocks that make up
+// the block locator of a chain ending at the given height.
+func LocatorHeights(height int32) []int32 {
+	loc := calculateLocator([]int{int(height)})
+	heights := make([]int32, len(loc))
+	for i, h := range loc {
+		heights[i] = int32(h)
+	}
+	return heights
+}
+
 func calculateLocator(loc []int) []int {
 	if len(loc) == 0 {
 		return []int{}
@@ -74,219 +90,472 @@ func calculateLocator(loc []int) []int {
 }
 
 func (s *storage) PutTx(tx *wire.MsgTx) error {
-	return s.putTx(tx, nil, 0)
+	return s.transaction(func(s *storage) error {
+		created, err := s.putTx(tx)
+		if err != nil {
+			return err
+		}
+		if created {
+			s.notifier.TxAccepted(tx)
+		}
+		return nil
+	})
 }
 
-func (s *storage) putTx(tx *wire.MsgTx, block *model.Block, blockIndex uint32) error {
-	transactionHash := tx.TxHash().String()
-	transaction := &model.Transaction{
-		Hash:     transactionHash,
-		LockTime: tx.LockTime,
-		Version:  tx.Version,
-	}
+const (
+	// insertBatchSize is the number of rows written by each insert.
+	insertBatchSize = 500
+	// lookupBatchSize is the number of rows matched by each set-based
+	// lookup or update.
+	lookupBatchSize = 1000
+)
 
-	fOCResult := s.db.FirstOrCreate(transaction, model.Transaction{Hash: transactionHash})
-	if fOCResult.Error != nil {
-		return fOCResult.Error
+// putTx stores an unconfirmed transaction, replacing the unconfirmed
+// transactions it conflicts with if the BIP125 rules allow it, and reports
+// whether the transaction was not known before.
+func (s *storage) putTx(tx *wire.MsgTx) (bool, error) {
+	replacement, err := s.replaceConflicts(tx)
+	if err != nil {
+		return false, err
 	}
+	created, err := s.putTxs([]*wire.MsgTx{tx}, nil)
+	if err != nil || len(created) == 0 {
+		return false, err
+	}
+	return true, s.setSafe(tx, replacement)
+}
 
-	if block != nil {
-		transaction.BlockID = block.ID
-		transaction.BlockIndex = blockIndex
-		transaction.BlockHash = block.Hash
-		if result := s.db.Save(transaction); result.Error != nil {
-			return result.Error
-		}
+// putTxs stores the transactions and their inputs and outputs, confirming
+// them in block if it is not nil. Transactions that are already known are
+// only moved into the block. The rows are written in batches and the spent
+// outpoints are resolved with set-based updates, so the number of round
+// trips does not grow with the number of inputs and outputs. It returns the
+// transactions that were not known before.
+func (s *storage) putTxs(txs []*wire.MsgTx, block *model.Block) ([]*wire.MsgTx, error) {
+	hashes := make([]model.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = model.Hash(tx.TxHash().String())
 	}
 
-	if fOCResult.RowsAffected == 0 {
-		// If the transaction already exists, we don't need to do anything else
-		return nil
+	known := map[model.Hash]model.Transaction{}
+	for start := 0; start < len(hashes); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		existing := []model.Transaction{}
+		if resp := s.db.Where("hash IN ?", hashes[start:end]).Find(&existing); resp.Error != nil {
+			return nil, resp.Error
+		}
+		for _, transaction := range existing {
+			known[transaction.Hash] = transaction
+		}
 	}
 
-	for i, txIn := range tx.TxIn {
-		inIndex := uint32(i)
-		witness := make([]string, len(txIn.Witness))
-		for i, w := range txIn.Witness {
-			witness[i] = hex.EncodeToString(w)
+	// Unconfirmed transactions enter the mempool at the current height
+	firstSeen, firstSeenHeight := time.Time{}, int32(0)
+	if block == nil {
+		height, err := s.GetLatestBlockHeight()
+		if err != nil {
+			return nil, err
 		}
-		witnessString := strings.Join(witness, ",")
+		firstSeen, firstSeenHeight = time.Now(), height
+	}
 
-		txInOut := model.OutPoint{}
-		if txIn.PreviousOutPoint.Hash.String() != "0000000000000000000000000000000000000000000000000000000000000000" && txIn.PreviousOutPoint.Index != 4294967295 {
-			// Add SpendingTx to the outpoint
-			if result := s.db.First(&txInOut, "funding_tx_hash = ? AND funding_tx_index = ?", txIn.PreviousOutPoint.Hash.String(), txIn.PreviousOutPoint.Index); result.Error != nil {
-				return result.Error
-			}
-			txInOut.SpendingTxID = transaction.ID
-			txInOut.SpendingTxHash = transactionHash
-			txInOut.SpendingTxIndex = inIndex
-			txInOut.Sequence = txIn.Sequence
-			txInOut.SignatureScript = hex.EncodeToString(txIn.SignatureScript)
-			txInOut.Witness = witnessString
-			if res := s.db.Save(&txInOut); res.Error != nil {
-				return res.Error
+	created := []*wire.MsgTx{}
+	transactions := []model.Transaction{}
+	confirmed := []model.Transaction{}
+	for i, tx := range txs {
+		if transaction, ok := known[hashes[i]]; ok {
+			if block != nil {
+				confirmed = append(confirmed, transaction)
+				if resp := s.db.Model(&transaction).Updates(map[string]interface{}{
+					"block_id":    block.ID,
+					"block_hash":  block.Hash,
+					"block_index": uint32(i),
+					"safe":        true,
+				}); resp.Error != nil {
+					return nil, resp.Error
+				}
 			}
 			continue
 		}
 
-		// Create coinbase transactions
-		if res := s.db.Create(&model.OutPoint{
-			SpendingTxID:    transaction.ID,
-			SpendingTxHash:  transactionHash,
-			SpendingTxIndex: inIndex,
-			Sequence:        txIn.Sequence,
-			SignatureScript: hex.EncodeToString(txIn.SignatureScript),
-			Witness:         witnessString,
+		transaction := model.Transaction{
+			Hash:        hashes[i],
+			WitnessHash: model.Hash(tx.WitnessHash().String()),
+			LockTime:    tx.LockTime,
+			Version:     tx.Version,
+			Safe:        block != nil,
+
+			Size:            tx.SerializeSize(),
+			Weight:          3*tx.SerializeSizeStripped() + tx.SerializeSize(),
+			FirstSeen:       firstSeen,
+			FirstSeenHeight: firstSeenHeight,
+		}
+		if block != nil {
+			transaction.BlockID = &block.ID
+			transaction.BlockHash = block.Hash
+			transaction.BlockIndex = uint32(i)
+		}
+		created = append(created, tx)
+		transactions = append(transactions, transaction)
+	}
+	if block != nil {
+		if err := s.putFeeStats(block, confirmed); err != nil {
+			return nil, err
+		}
+	}
+	if len(transactions) == 0 {
+		return nil, nil
+	}
+	if err := s.setFees(created, transactions); err != nil {
+		return nil, err
+	}
+	if resp := s.db.CreateInBatches(&transactions, insertBatchSize); resp.Error != nil {
+		return nil, resp.Error
+	}
 
-			FundingTxHash:  txIn.PreviousOutPoint.Hash.String(),
-			FundingTxIndex: txIn.PreviousOutPoint.Index,
-		}); res.Error != nil {
-			return res.Error
+	// Evicted transactions that are stored again are no longer evicted
+	for start := 0; start < len(transactions); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(transactions) {
+			end = len(transactions)
+		}
+		stored := make([]model.Hash, 0, end-start)
+		for _, transaction := range transactions[start:end] {
+			stored = append(stored, transaction.Hash)
+		}
+		if resp := s.db.Unscoped().Where("hash IN ?", stored).Delete(&model.EvictedTx{}); resp.Error != nil {
+			return nil, resp.Error
 		}
 	}
 
-	for i, txOut := range tx.TxOut {
-		spenderAddress := ""
+	outPoints := []model.OutPoint{}
+	spends := []outPointSpend{}
+	for i, tx := range created {
+		transaction := transactions[i]
+		for j, txIn := range tx.TxIn {
+			if txIn.PreviousOutPoint.Hash.String() != "0000000000000000000000000000000000000000000000000000000000000000" && txIn.PreviousOutPoint.Index != 4294967295 {
+				spends = append(spends, outPointSpend{
+					FundingTxHash:   model.Hash(txIn.PreviousOutPoint.Hash.String()),
+					FundingTxIndex:  txIn.PreviousOutPoint.Index,
+					SpendingTxID:    transaction.ID,
+					SpendingTxHash:  transaction.Hash,
+					SpendingTxIndex: uint32(j),
+					Sequence:        txIn.Sequence,
+					SignatureScript: txIn.SignatureScript,
+					Witness:         model.Witness(txIn.Witness),
+				})
+				continue
+			}
+
+			// Create coinbase transactions
+			outPoints = append(outPoints, model.OutPoint{
+				SpendingTxID:    &transaction.ID,
+				SpendingTxHash:  transaction.Hash,
+				SpendingTxIndex: uint32(j),
+				Sequence:        txIn.Sequence,
+				SignatureScript: txIn.SignatureScript,
+				Witness:         model.Witness(txIn.Witness),
+
+				FundingTxHash:  model.Hash(txIn.PreviousOutPoint.Hash.String()),
+				FundingTxIndex: txIn.PreviousOutPoint.Index,
+			})
+		}
 
-		pkScript, err := txscript.ParsePkScript(txOut.PkScript)
-		if err == nil {
-			addr, err := pkScript.Address(s.params)
+		for j, txOut := range tx.TxOut {
+			spenderAddress, class, err := Spender(txOut.PkScript, s.params)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			spenderAddress = addr.EncodeAddress()
+
+			// Create a new outpoint
+			outPoints = append(outPoints, model.OutPoint{
+				FundingTxID:    &transaction.ID,
+				FundingTxHash:  transaction.Hash,
+				FundingTxIndex: uint32(j),
+				PkScript:       txOut.PkScript,
+				ScriptHash:     model.Hash(ScriptHash(txOut.PkScript)),
+				Value:          txOut.Value,
+				Spender:        spenderAddress,
+				Type:           class,
+			})
 		}
+	}
+	if len(outPoints) > 0 {
+		if resp := s.db.CreateInBatches(&outPoints, insertBatchSize); resp.Error != nil {
+			return nil, resp.Error
+		}
+	}
 
-		// Create a new outpoint
-		if res := s.db.Create(&model.OutPoint{
-			FundingTxID:    transaction.ID,
-			FundingTxHash:  transactionHash,
-			FundingTxIndex: uint32(i),
-			PkScript:       hex.EncodeToString(txOut.PkScript),
-			Value:          txOut.Value,
-			Spender:        spenderAddress,
-			Type:           pkScript.Class().String(),
-		}); res.Error != nil {
-			return res.Error
+	// Outputs created earlier in the same block are stored by now, so they
+	// can be spent like any other.
+	for start := 0; start < len(spends); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(spends) {
+			end = len(spends)
+		}
+		if block != nil {
+			if err := s.removeConflicts(spends[start:end]); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.spendOutPoints(spends[start:end]); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	return created, nil
 }
 
-func (s *storage) PutBlock(block *wire.MsgBlock) error {
-	height := int32(-1)
-	previousBlock := &model.Block{}
-	if block.Header.PrevBlock.String() == s.params.GenesisBlock.BlockHash().String() {
-		genesisBlock := btcutil.NewBlock(s.params.GenesisBlock)
-		genesisBlock.SetHeight(0)
-		if result := s.db.Create(&model.Block{
-			Hash:   genesisBlock.Hash().String(),
-			Height: 0,
-
-			IsOrphan:      false,
-			PreviousBlock: genesisBlock.MsgBlock().Header.PrevBlock.String(),
-			Version:       genesisBlock.MsgBlock().Header.Version,
-			Nonce:         genesisBlock.MsgBlock().Header.Nonce,
-			Timestamp:     genesisBlock.MsgBlock().Header.Timestamp,
-			Bits:          genesisBlock.MsgBlock().Header.Bits,
-			MerkleRoot:    genesisBlock.MsgBlock().Header.MerkleRoot.String(),
-		}); result.Error != nil {
-			return result.Error
-		}
-
-		// This is created for the coinbase transaction
-		resp := s.db.Create(&model.Transaction{
-			Hash: "0000000000000000000000000000000000000000000000000000000000000000",
-		})
-		if resp.Error != nil {
-			return resp.Error
+// setFees sets the fees of the transactions from the values of the outputs
+// they spend, which are either created by one of txs or stored already.
+func (s *storage) setFees(txs []*wire.MsgTx, transactions []model.Transaction) error {
+	values := map[wire.OutPoint]int64{}
+	for i, tx := range txs {
+		hash, err := chainhash.NewHashFromStr(string(transactions[i].Hash))
+		if err != nil {
+			return err
 		}
-
-		height = 1
-	} else {
-		if resp := s.db.First(&previousBlock, "hash = ?", block.Header.PrevBlock.String()); resp.Error != nil {
-			return resp.Error
+		for j, txOut := range tx.TxOut {
+			values[*wire.NewOutPoint(hash, uint32(j))] = txOut.Value
 		}
+	}
 
-		if previousBlock.IsOrphan {
-			newlyOrphanedBlock := &model.Block{}
-			if resp := s.db.First(newlyOrphanedBlock, "height = ? AND is_orphan = ?", previousBlock.Height, false); resp.Error != nil {
-				return resp.Error
+	// The outputs are looked up by the hashes of their transactions, which
+	// the funding index covers
+	missing := []model.Hash{}
+	seen := map[chainhash.Hash]bool{}
+	for _, tx := range txs {
+		if blockchain.IsCoinBaseTx(tx) {
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			if _, ok := values[txIn.PreviousOutPoint]; !ok && !seen[txIn.PreviousOutPoint.Hash] {
+				seen[txIn.PreviousOutPoint.Hash] = true
+				missing = append(missing, model.Hash(txIn.PreviousOutPoint.Hash.String()))
 			}
-			if err := s.orphanBlock(newlyOrphanedBlock); err != nil {
+		}
+	}
+	for start := 0; start < len(missing); start += lookupBatchSize {
+		end := start + lookupBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		outPoints := []model.OutPoint{}
+		if resp := s.db.Select("funding_tx_hash", "funding_tx_index", "value").
+			Where("funding_tx_hash IN ?", missing[start:end]).Find(&outPoints); resp.Error != nil {
+			return resp.Error
+		}
+		for _, op := range outPoints {
+			hash, err := chainhash.NewHashFromStr(string(op.FundingTxHash))
+			if err != nil {
 				return err
 			}
+			values[*wire.NewOutPoint(hash, op.FundingTxIndex)] = op.Value
+		}
+	}
 
-			previousBlock.IsOrphan = false
-			if resp := s.db.Save(&previousBlock); resp.Error != nil {
-				return resp.Error
-			}
+	// Inputs that are not found make the transactions fail to be stored
+	// when their outpoints are spent
+	for i, tx := range txs {
+		if blockchain.IsCoinBaseTx(tx) {
+			continue
+		}
+		fee := int64(0)
+		for _, txIn := range tx.TxIn {
+			fee += values[txIn.PreviousOutPoint]
+		}
+		for _, txOut := range tx.TxOut {
+			fee -= txOut.Value
 		}
+		transactions[i].Fee = fee
+	}
+	return nil
+}
+
+// outPointSpend is an input that spends the outpoint at FundingTxHash and
+// FundingTxIndex.
+type outPointSpend struct {
+	FundingTxHash   model.Hash
+	FundingTxIndex  uint32
+	SpendingTxID    uint
+	SpendingTxHash  model.Hash
+	SpendingTxIndex uint32
+	Sequence        uint32
+	SignatureScript []byte
+	Witness         model.Witness
+}
 
-		height = previousBlock.Height + 1
+// spendOutPoints marks the outpoints as spent with a single UPDATE ... FROM
+// (VALUES ...), which both postgres and sqlite support. Every outpoint has to
+// exist.
+func (s *storage) spendOutPoints(spends []outPointSpend) error {
+	// Hashes and scripts are cast to the type the dialect stores bytes as.
+	// gorm expands slices bound right after a parenthesis into lists, so the
+	// placeholders are preceded by a space to keep the scripts whole.
+	bytesType := s.db.Dialector.DataTypeOf(&schema.Field{DataType: schema.Bytes})
+	row := fmt.Sprintf("(CAST( ? AS %[1]s), CAST( ? AS BIGINT), CAST( ? AS BIGINT), CAST( ? AS %[1]s), CAST( ? AS BIGINT), CAST( ? AS BIGINT), CAST( ? AS %[1]s), CAST( ? AS %[1]s))", bytesType)
+
+	values := make([]string, len(spends))
+	args := make([]interface{}, 0, 1+len(spends)*8)
+	args = append(args, time.Now())
+	for i, spend := range spends {
+		values[i] = row
+		args = append(args,
+			spend.FundingTxHash,
+			int64(spend.FundingTxIndex),
+			int64(spend.SpendingTxID),
+			spend.SpendingTxHash,
+			int64(spend.SpendingTxIndex),
+			int64(spend.Sequence),
+			spend.SignatureScript,
+			spend.Witness,
+		)
 	}
 
-	blockAtHeight := &model.Block{}
-	resp := s.db.First(&blockAtHeight, "height = ?", height)
-	if resp.Error != gorm.ErrRecordNotFound {
-		if resp.Error != nil {
-			return resp.Error
-		}
-		if err := s.orphanBlock(blockAtHeight); err != nil {
+	resp := s.db.Exec(`UPDATE out_points SET
+		updated_at = ?,
+		spending_tx_id = v.column3,
+		spending_tx_hash = v.column4,
+		spending_tx_index = v.column5,
+		sequence = v.column6,
+		signature_script = v.column7,
+		witness = v.column8
+	FROM (VALUES `+strings.Join(values, ", ")+`) AS v
+	WHERE out_points.funding_tx_hash = v.column1 AND out_points.funding_tx_index = v.column2 AND out_points.deleted_at IS NULL`, args...)
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.RowsAffected != int64(len(spends)) {
+		return fmt.Errorf("failed to find the outpoints spent by the transactions: found %d of %d", resp.RowsAffected, len(spends))
+	}
+	return nil
+}
+
+// Spender returns the address an output pays to, empty if its script has
+// none, and the class of its script.
+func Spender(pkScript []byte, params *chaincfg.Params) (string, string, error) {
+	script, err := txscript.ParsePkScript(pkScript)
+	if err != nil {
+		return "", script.Class().String(), nil
+	}
+	addr, err := script.Address(params)
+	if err != nil {
+		return "", "", err
+	}
+	return addr.EncodeAddress(), script.Class().String(), nil
+}
+
+// ScriptHash returns the electrum script hash of the pkScript, the reversed
+// sha256 of the script in hex.
+func ScriptHash(pkScript []byte) string {
+	hash := sha256.Sum256(pkScript)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hex.EncodeToString(hash[:])
+}
+
+// PutBlock stores the block and its transactions in a single database
+// transaction, so that a failure part way through leaves no trace of it.
+func (s *storage) PutBlock(block *wire.MsgBlock) error {
+	return s.transaction(func(s *storage) error {
+		return s.putBlock(block)
+	})
+}
+
+func (s *storage) putBlock(block *wire.MsgBlock) error {
+	blockHash := model.Hash(block.BlockHash().String())
+	if resp := s.db.First(&model.Block{}, "hash = ?", blockHash); resp.Error == nil {
+		// The block is already known
+		return nil
+	} else if !errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+		return resp.Error
+	}
+
+	if block.Header.PrevBlock == *s.params.GenesisHash {
+		if err := s.putGenesisBlock(); err != nil {
 			return err
 		}
 	}
 
+	previousBlock := &model.Block{}
+	if resp := s.db.First(previousBlock, "hash = ?", model.Hash(block.Header.PrevBlock.String())); resp.Error != nil {
+		return resp.Error
+	}
+	tip, err := s.tip()
+	if err != nil {
+		return err
+	}
+
 	bblock := &model.Block{
-		Hash:   block.Header.BlockHash().String(),
-		Height: height,
+		Hash:   blockHash,
+		Height: previousBlock.Height + 1,
 
-		IsOrphan:      false,
-		PreviousBlock: block.Header.PrevBlock.String(),
+		IsOrphan:      true,
+		PreviousBlock: model.Hash(block.Header.PrevBlock.String()),
 		Version:       block.Header.Version,
 		Nonce:         block.Header.Nonce,
 		Timestamp:     block.Header.Timestamp,
 		Bits:          block.Header.Bits,
-		MerkleRoot:    block.Header.MerkleRoot.String(),
-	}
-	if result := s.db.Create(bblock); result.Error != nil {
-		return result.Error
+		MerkleRoot:    model.Hash(block.Header.MerkleRoot.String()),
 	}
-
-	for i, tx := range block.Transactions {
-		if err := s.putTx(tx, bblock, uint32(i)); err != nil {
+	if previousBlock.Hash == tip.Hash {
+		if err := s.connectBlock(bblock, block); err != nil {
 			return err
 		}
+		s.notifier.UpdatedBlockTip(block)
+		return nil
 	}
 
-	aBlock := &model.Block{}
-	if resp := s.db.First(aBlock, "hash = ?", block.Header.BlockHash().String()); resp.Error != nil {
-		return fmt.Errorf("failed to retrieve the stored block: %v", resp.Error)
+	// The block is on a side branch, it is kept as is so that the branch can
+	// be connected once it has more work than the main chain.
+	buf := new(bytes.Buffer)
+	if err := block.Serialize(buf); err != nil {
+		return err
 	}
-	fmt.Println("Block", aBlock.Height, "has been added to the database", aBlock)
-
-	return nil
+	bblock.Raw = buf.Bytes()
+	if resp := s.db.Create(bblock); resp.Error != nil {
+		return resp.Error
+	}
+	fmt.Println("Block", bblock.Height, "has been added to a side branch", bblock.Hash)
+	return s.reorganize(bblock)
 }
 
-func (s *storage) orphanBlock(block *model.Block) error {
-	block.IsOrphan = true
-
-	txs := []model.Transaction{}
-	if resp := s.db.Order("block_index").Find(&txs, "block_hash = ?", block.Hash); resp.Error != nil {
+// putGenesisBlock stores the genesis block, whose transaction is not
+// spendable and so is not stored, unless it is stored already.
+func (s *storage) putGenesisBlock() error {
+	genesisBlock := btcutil.NewBlock(s.params.GenesisBlock)
+	if resp := s.db.First(&model.Block{}, "hash = ?", model.Hash(genesisBlock.Hash().String())); resp.Error == nil {
+		return nil
+	} else if !errors.Is(resp.Error, gorm.ErrRecordNotFound) {
 		return resp.Error
 	}
 
-	for _, tx := range txs {
-		tx.BlockID = 0
-		tx.BlockHash = ""
-		tx.BlockIndex = 0
-		if resp := s.db.Save(&tx); resp.Error != nil {
-			return resp.Error
-		}
+	if err := s.putBlockFilter(genesisBlock.MsgBlock()); err != nil {
+		return err
 	}
-	resp := s.db.Save(block)
-	return resp.Error
+	if result := s.db.Create(&model.Block{
+		Hash:   model.Hash(genesisBlock.Hash().String()),
+		Height: 0,
+
+		IsOrphan:      false,
+		Complete:      true,
+		PreviousBlock: model.Hash(genesisBlock.MsgBlock().Header.PrevBlock.String()),
+		Version:       genesisBlock.MsgBlock().Header.Version,
+		Nonce:         genesisBlock.MsgBlock().Header.Nonce,
+		Timestamp:     genesisBlock.MsgBlock().Header.Timestamp,
+		Bits:          genesisBlock.MsgBlock().Header.Bits,
+		MerkleRoot:    model.Hash(genesisBlock.MsgBlock().Header.MerkleRoot.String()),
+	}); result.Error != nil {
+		return result.Error
+	}
+
+	// This is created for the coinbase transaction
+	return s.db.Create(&model.Transaction{
+		Hash: model.Hash(chainhash.Hash{}.String()),
+	}).Error
 }
 
 func (s *storage) Params() *chaincfg.Params {
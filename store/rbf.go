@@ -0,0 +1,264 @@
+package store
+
+import (
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+const (
+	// maxReplacementEvictions is the number of transactions a replacement
+	// can evict, BIP125 rule 5.
+	maxReplacementEvictions = 100
+
+	// incrementalRelayFee is the fee rate in satoshis per vbyte a
+	// replacement has to pay on top of the fees it replaces, bitcoind's
+	// default -incrementalrelayfee.
+	incrementalRelayFee = 1
+)
+
+// Reasons unconfirmed transactions are evicted for.
+const (
+	EvictedReplaced = "replaced"
+	EvictedConflict = "conflict"
+	EvictedExpired  = "expired"
+	EvictedReorg    = "reorg"
+)
+
+// Mempool looks up unconfirmed transactions, which is all the BIP125 checks
+// need from a storage backend.
+type Mempool interface {
+	GetMempoolEntries(hashes []string) ([]command.MempoolEntry, error)
+}
+
+// replaceConflicts evicts the unconfirmed transactions spending the same
+// outputs as tx if tx can replace them under BIP125, and reports whether
+// there were any. Spending outputs that are spent by a confirmed transaction
+// is an error.
+func (s *storage) replaceConflicts(tx *wire.MsgTx) (bool, error) {
+	if blockchain.IsCoinBaseTx(tx) {
+		return false, nil
+	}
+
+	hash := tx.TxHash().String()
+	outPoints := make([][]interface{}, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		outPoints[i] = []interface{}{model.Hash(txIn.PreviousOutPoint.Hash.String()), txIn.PreviousOutPoint.Index}
+	}
+	spenders := s.db.Model(&model.OutPoint{}).Select("spending_tx_id").
+		Where("(funding_tx_hash, funding_tx_index) IN ? AND spending_tx_id IS NOT NULL AND spending_tx_hash <> ?", outPoints, model.Hash(hash))
+	conflicts := []model.Transaction{}
+	if resp := s.db.Find(&conflicts, "id IN (?)", spenders); resp.Error != nil {
+		return false, resp.Error
+	}
+	if len(conflicts) == 0 {
+		return false, nil
+	}
+
+	for _, conflict := range conflicts {
+		if conflict.BlockID != nil {
+			return false, command.NewError(command.ErrRPCVerify, "bad-txns-inputs-missingorspent")
+		}
+	}
+	hashes := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		hashes[i] = string(conflict.Hash)
+	}
+	originals, err := s.GetMempoolEntries(hashes)
+	if err != nil {
+		return false, err
+	}
+	replacement := []model.Transaction{{Hash: model.Hash(hash)}}
+	if err := s.setFees([]*wire.MsgTx{tx}, replacement); err != nil {
+		return false, err
+	}
+	if err := CheckReplacement(s, tx, replacement[0].Fee, originals); err != nil {
+		return false, err
+	}
+	for _, conflict := range conflicts {
+		if err := s.removeTx(conflict, EvictedReplaced, model.Hash(hash)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// CheckReplacement checks that tx, which pays the given fee, can replace the
+// unconfirmed transactions it conflicts with under the rules of BIP125, and
+// the feerate rule bitcoind adds to them.
+func CheckReplacement(mempool Mempool, tx *wire.MsgTx, fee int64, originals []command.MempoolEntry) error {
+	hash := tx.TxHash().String()
+
+	// Rule 1: the originals signal replaceability, or one of their
+	// unconfirmed ancestors does
+	for _, original := range originals {
+		ancestors, err := mempoolClosure(mempool, []command.MempoolEntry{original}, func(e command.MempoolEntry) []string { return e.Depends })
+		if err != nil {
+			return err
+		}
+		signals := false
+		for _, ancestor := range ancestors {
+			signals = signals || ancestor.Signals
+		}
+		if !signals {
+			return command.NewError(command.ErrRPCVerifyRejected, "txn-mempool-conflict")
+		}
+	}
+
+	// Rule 5: the originals and their descendants are few enough
+	evicted, err := mempoolClosure(mempool, originals, func(e command.MempoolEntry) []string { return e.SpentBy })
+	if err != nil {
+		return err
+	}
+	if len(evicted) > maxReplacementEvictions {
+		return command.NewError(command.ErrRPCVerifyRejected, "too many potential replacements")
+	}
+
+	// Rule 2: the replacement only spends the unconfirmed outputs the
+	// originals spend from
+	parents := map[string]bool{}
+	for _, txIn := range tx.TxIn {
+		parent := txIn.PreviousOutPoint.Hash.String()
+		if _, ok := evicted[parent]; ok {
+			return command.NewError(command.ErrRPCVerifyRejected, "bad-txns-spends-conflicting-tx")
+		}
+		parents[parent] = true
+	}
+	originalParents := map[string]bool{}
+	for _, original := range originals {
+		for _, parent := range original.Depends {
+			originalParents[parent] = true
+		}
+	}
+	added := []string{}
+	for parent := range parents {
+		if !originalParents[parent] {
+			added = append(added, parent)
+		}
+	}
+	unconfirmed, err := mempool.GetMempoolEntries(added)
+	if err != nil {
+		return err
+	}
+	if len(unconfirmed) > 0 {
+		return command.NewError(command.ErrRPCVerifyRejected, "replacement-adds-unconfirmed")
+	}
+
+	vsize := int64((3*tx.SerializeSizeStripped() + tx.SerializeSize() + 3) / 4)
+
+	// The replacement pays a higher feerate than each of the originals
+	for _, original := range originals {
+		// Transactions stored before their sizes were recorded are skipped
+		if original.Weight > 0 && fee*int64(original.VSize()) <= original.Fee*vsize {
+			return command.NewError(command.ErrRPCVerifyRejected, "insufficient fee, rejecting replacement %s; new feerate %v <= old feerate %v",
+				hash, feeRate(fee, vsize), feeRate(original.Fee, int64(original.VSize())))
+		}
+	}
+
+	// Rules 3 and 4: the replacement pays for the fees of the transactions
+	// it evicts, and for its own relay
+	evictedFee := int64(0)
+	for _, e := range evicted {
+		evictedFee += e.Fee
+	}
+	if fee < evictedFee {
+		return command.NewError(command.ErrRPCVerifyRejected, "insufficient fee, rejecting replacement %s, less fees than conflicting txs; %v < %v",
+			hash, btcutil.Amount(fee), btcutil.Amount(evictedFee))
+	}
+	if fee-evictedFee < incrementalRelayFee*vsize {
+		return command.NewError(command.ErrRPCVerifyRejected, "insufficient fee, rejecting replacement %s, not enough additional fees to relay; %v < %v",
+			hash, btcutil.Amount(fee-evictedFee), btcutil.Amount(incrementalRelayFee*vsize))
+	}
+	return nil
+}
+
+// mempoolClosure returns the entries along with the unconfirmed
+// transactions reached from them along edges, by hash.
+func mempoolClosure(mempool Mempool, entries []command.MempoolEntry, edges func(command.MempoolEntry) []string) (map[string]command.MempoolEntry, error) {
+	closure := map[string]command.MempoolEntry{}
+	next := entries
+	for len(next) > 0 {
+		hashes := []string{}
+		for _, entry := range next {
+			if _, ok := closure[entry.Hash]; ok {
+				continue
+			}
+			closure[entry.Hash] = entry
+			for _, hash := range edges(entry) {
+				if _, ok := closure[hash]; !ok {
+					hashes = append(hashes, hash)
+				}
+			}
+		}
+		if len(hashes) == 0 {
+			break
+		}
+
+		var err error
+		if next, err = mempool.GetMempoolEntries(hashes); err != nil {
+			return nil, err
+		}
+	}
+	return closure, nil
+}
+
+// setSafe marks a newly stored unconfirmed transaction as safe unless it
+// signals replaceability, replaced other transactions or has unsafe
+// unconfirmed parents.
+func (s *storage) setSafe(tx *wire.MsgTx, replacement bool) error {
+	if replacement || SignalsReplacement(tx) {
+		return nil
+	}
+	parents := make([]model.Hash, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		parents[i] = model.Hash(txIn.PreviousOutPoint.Hash.String())
+	}
+	unsafe := int64(0)
+	if resp := s.db.Model(&model.Transaction{}).Where("hash IN ? AND block_id IS NULL AND safe = ?", parents, false).Count(&unsafe); resp.Error != nil {
+		return resp.Error
+	}
+	if unsafe > 0 {
+		return nil
+	}
+	return s.db.Model(&model.Transaction{}).Where("hash = ?", model.Hash(tx.TxHash().String())).Update("safe", true).Error
+}
+
+// markUnsafe marks the transactions and their unconfirmed descendants as
+// unsafe.
+func (s *storage) markUnsafe(ids []uint) error {
+	for len(ids) > 0 {
+		if resp := s.db.Model(&model.Transaction{}).Where("id IN ?", ids).Update("safe", false); resp.Error != nil {
+			return resp.Error
+		}
+
+		spenders := s.db.Model(&model.OutPoint{}).Select("spending_tx_id").
+			Where("funding_tx_id IN ? AND spending_tx_id IS NOT NULL", ids)
+		children := []model.Transaction{}
+		if resp := s.db.Select("id").Find(&children, "id IN (?) AND block_id IS NULL AND safe = ?", spenders, true); resp.Error != nil {
+			return resp.Error
+		}
+		ids = make([]uint, len(children))
+		for i, child := range children {
+			ids[i] = child.ID
+		}
+	}
+	return nil
+}
+
+// SignalsReplacement reports whether one of the inputs opts in to
+// replacement as defined by BIP125.
+func SignalsReplacement(tx *wire.MsgTx) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// feeRate formats a fee rate in BTC/kvB like bitcoind does in its errors.
+func feeRate(fee, vsize int64) string {
+	return btcutil.Amount(fee*1000/vsize).String() + "/kvB"
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+	"github.com/catalogfi/indexer/store/storetest"
+)
+
+// testMempool is a mempool of fixed entries.
+type testMempool map[string]command.MempoolEntry
+
+func (m testMempool) GetMempoolEntries(hashes []string) ([]command.MempoolEntry, error) {
+	entries := []command.MempoolEntry{}
+	for _, hash := range hashes {
+		if entry, ok := m[hash]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// testHash returns a distinct transaction hash for each n.
+func testHash(n int) string {
+	return chainhash.Hash{byte(n), byte(n >> 8), 1}.String()
+}
+
+func TestCheckReplacement(t *testing.T) {
+	confirmed := testHash(1)
+	original := testHash(2)
+	ancestor := testHash(3)
+	unconfirmed := testHash(4)
+	descendant := testHash(5)
+
+	// The replacement spends the confirmed output the original spends,
+	// and the outputs of the given transactions
+	replacement := func(spends ...string) *wire.MsgTx {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		for _, spent := range append([]string{confirmed}, spends...) {
+			hash, err := chainhash.NewHashFromStr(spent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, 0), []byte{1}, nil))
+		}
+		tx.AddTxOut(wire.NewTxOut(1000, storetest.PkScript(storetest.Address(1))))
+		return tx
+	}
+	vsize := int64(replacement().SerializeSize())
+
+	tests := []struct {
+		name     string
+		mempool  []command.MempoolEntry
+		original command.MempoolEntry
+		spends   []string
+		fee      int64
+		wantErr  string
+	}{
+		{
+			name:     "valid",
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, Signals: true},
+			fee:      2000,
+		},
+		{
+			name:     "non-signalling original",
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000},
+			fee:      2000,
+			wantErr:  "txn-mempool-conflict",
+		},
+		{
+			name: "signalling ancestor",
+			mempool: []command.MempoolEntry{
+				{Hash: ancestor, Weight: 800, Fee: 1000, SpentBy: []string{original}, Signals: true},
+			},
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, Depends: []string{ancestor}},
+			spends:   []string{ancestor},
+			fee:      2000,
+		},
+		{
+			name:     "lower absolute fee",
+			original: command.MempoolEntry{Hash: original, Weight: 4000, Fee: 1000, Signals: true},
+			fee:      900,
+			wantErr:  "less fees than conflicting txs",
+		},
+		{
+			name:     "lower feerate",
+			original: command.MempoolEntry{Hash: original, Weight: 200, Fee: 1000, Signals: true},
+			fee:      1500,
+			wantErr:  "new feerate",
+		},
+		{
+			name:     "not enough additional fee",
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, Signals: true},
+			fee:      1000 + vsize - 1,
+			wantErr:  "not enough additional fees to relay",
+		},
+		{
+			name:     "too many evictions",
+			mempool:  descendants(descendant, maxReplacementEvictions),
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, SpentBy: []string{descendant}, Signals: true},
+			fee:      1e8,
+			wantErr:  "too many potential replacements",
+		},
+		{
+			name:     "as many evictions as allowed",
+			mempool:  descendants(descendant, maxReplacementEvictions-1),
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, SpentBy: []string{descendant}, Signals: true},
+			fee:      1e8,
+		},
+		{
+			name: "new unconfirmed input",
+			mempool: []command.MempoolEntry{
+				{Hash: unconfirmed, Weight: 800, Fee: 1000},
+			},
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, Signals: true},
+			spends:   []string{unconfirmed},
+			fee:      2000,
+			wantErr:  "replacement-adds-unconfirmed",
+		},
+		{
+			name:     "spends a replaced transaction",
+			mempool:  descendants(descendant, 1),
+			original: command.MempoolEntry{Hash: original, Weight: 800, Fee: 1000, SpentBy: []string{descendant}, Signals: true},
+			spends:   []string{descendant},
+			fee:      2000,
+			wantErr:  "bad-txns-spends-conflicting-tx",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mempool := testMempool{test.original.Hash: test.original}
+			for _, entry := range test.mempool {
+				mempool[entry.Hash] = entry
+			}
+
+			err := CheckReplacement(mempool, replacement(test.spends...), test.fee, []command.MempoolEntry{test.original})
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// descendants returns a chain of n mempool entries starting at first, each
+// spending the previous one.
+func descendants(first string, n int) []command.MempoolEntry {
+	entries := make([]command.MempoolEntry, n)
+	hash := first
+	for i := range entries {
+		entries[i] = command.MempoolEntry{Hash: hash, Weight: 400, Fee: 100}
+		if i+1 < n {
+			hash = testHash(1000 + i)
+			entries[i].SpentBy = []string{hash}
+		}
+	}
+	return entries
+}
+
+// isSafe reports whether the stored transaction is marked safe.
+func isSafe(t *testing.T, s *storage, tx *wire.MsgTx) bool {
+	t.Helper()
+	transaction := model.Transaction{}
+	if err := s.db.First(&transaction, "hash = ?", model.Hash(tx.TxHash().String())).Error; err != nil {
+		t.Fatalf("transaction %s: %v", tx.TxHash(), err)
+	}
+	return transaction.Safe
+}
+
+func TestSafeTransactions(t *testing.T) {
+	s := newTestStorage(t)
+	blocks := storetest.PutChain(t, s, storetest.Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	final := storetest.SpendTx(blocks[0].Transactions[0], 0, 49e8, storetest.Address(2), wire.MaxTxInSequenceNum)
+	finalChild := storetest.SpendTx(final, 0, 48e8, storetest.Address(2), wire.MaxTxInSequenceNum)
+	replaceable := storetest.SpendTx(blocks[1].Transactions[0], 0, 49e8, storetest.Address(3), 0)
+	replaceableChild := storetest.SpendTx(replaceable, 0, 48.9e8, storetest.Address(3), wire.MaxTxInSequenceNum)
+	for _, tx := range []*wire.MsgTx{final, finalChild, replaceable, replaceableChild} {
+		if err := s.PutTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		tx   *wire.MsgTx
+		safe bool
+	}{
+		{"final transaction", final, true},
+		{"child of a safe transaction", finalChild, true},
+		{"replaceable transaction", replaceable, false},
+		{"child of an unsafe transaction", replaceableChild, false},
+	} {
+		if safe := isSafe(t, s, test.tx); safe != test.safe {
+			t.Errorf("%s: got safe %v, want %v", test.name, safe, test.safe)
+		}
+	}
+
+	// A replacement is unsafe even if it does not signal replaceability
+	replacement := storetest.SpendTx(blocks[1].Transactions[0], 0, 48e8, storetest.Address(4), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(replacement); err != nil {
+		t.Fatal(err)
+	}
+	if isSafe(t, s, replacement) {
+		t.Error("replacement is safe")
+	}
+	for _, tx := range []*wire.MsgTx{replaceable, replaceableChild} {
+		if _, err := s.GetTransaction(tx.TxHash().String()); err == nil {
+			t.Errorf("replaced transaction %s is still stored", tx.TxHash())
+		}
+	}
+
+	// Marking a transaction unsafe marks its descendants unsafe
+	transaction := model.Transaction{}
+	if err := s.db.First(&transaction, "hash = ?", model.Hash(final.TxHash().String())).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.markUnsafe([]uint{transaction.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*wire.MsgTx{final, finalChild} {
+		if isSafe(t, s, tx) {
+			t.Errorf("transaction %s is still safe", tx.TxHash())
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/model"
+	"gorm.io/gorm"
+)
+
+// tip returns the last block of the main chain.
+func (s *storage) tip() (*model.Block, error) {
+	tip := &model.Block{}
+	if resp := s.db.Order("height desc").First(tip, "is_orphan = ?", false); resp.Error != nil {
+		return nil, resp.Error
+	}
+	return tip, nil
+}
+
+// connectBlock appends the block to the main chain and confirms its
+// transactions.
+func (s *storage) connectBlock(bblock *model.Block, block *wire.MsgBlock) error {
+	previous := &model.Block{}
+	if resp := s.db.First(previous, "hash = ?", bblock.PreviousBlock); resp.Error != nil {
+		return resp.Error
+	}
+	bblock.ChainTxCount = previous.ChainTxCount + int64(len(block.Transactions))
+	bblock.IsOrphan = false
+	bblock.Complete = true
+	bblock.Raw = nil
+	if resp := s.db.Save(bblock); resp.Error != nil {
+		return resp.Error
+	}
+	if _, err := s.putTxs(block.Transactions, bblock); err != nil {
+		return err
+	}
+	if err := s.putBlockFilter(block); err != nil {
+		return err
+	}
+	fmt.Println("Block", bblock.Height, "has been added to the database", bblock.Hash)
+
+	s.notifier.BlockConnected(block)
+	return nil
+}
+
+// disconnectBlock removes the last block of the main chain. Its coinbase
+// transaction is deleted, and the other transactions become unconfirmed.
+func (s *storage) disconnectBlock(bblock *model.Block) error {
+	block, err := s.GetBlockFromHash(string(bblock.Hash))
+	if err != nil {
+		return err
+	}
+	raw, err := block.Bytes()
+	if err != nil {
+		return err
+	}
+
+	coinbase := model.Transaction{}
+	if resp := s.db.First(&coinbase, "block_id = ? AND block_index = 0", bblock.ID); resp.Error == nil {
+		if err := s.removeTx(coinbase, EvictedReorg, ""); err != nil {
+			return err
+		}
+	} else if !errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+		return resp.Error
+	}
+	if resp := s.db.Model(&model.Transaction{}).Where("block_id = ?", bblock.ID).Updates(map[string]interface{}{
+		"block_id":          nil,
+		"block_hash":        model.Hash(""),
+		"block_index":       0,
+		"first_seen":        time.Now(),
+		"first_seen_height": bblock.Height - 1,
+	}); resp.Error != nil {
+		return resp.Error
+	}
+	if err := s.markUnsafeTxs(block.MsgBlock()); err != nil {
+		return err
+	}
+	if resp := s.db.Unscoped().Where("block_id = ?", bblock.ID).Delete(&model.FeeStat{}); resp.Error != nil {
+		return resp.Error
+	}
+
+	bblock.IsOrphan = true
+	bblock.Complete = false
+	bblock.ChainTxCount = 0
+	bblock.Raw = raw
+	if resp := s.db.Save(bblock); resp.Error != nil {
+		return resp.Error
+	}
+	fmt.Println("Block", bblock.Height, "has been disconnected", bblock.Hash)
+
+	s.notifier.BlockDisconnected(block.MsgBlock())
+	return nil
+}
+
+// reorganize makes the side branch ending at sideTip the main chain if it
+// has more work than the blocks of the main chain after the fork point.
+func (s *storage) reorganize(sideTip *model.Block) error {
+	branch := []*model.Block{sideTip}
+	branchWork := blockchain.CalcWork(sideTip.Bits)
+	fork := &model.Block{}
+	for {
+		block := &model.Block{}
+		if resp := s.db.First(block, "hash = ?", branch[len(branch)-1].PreviousBlock); resp.Error != nil {
+			return resp.Error
+		}
+		if !block.IsOrphan {
+			fork = block
+			break
+		}
+		branch = append(branch, block)
+		branchWork.Add(branchWork, blockchain.CalcWork(block.Bits))
+	}
+
+	mainChain := []*model.Block{}
+	if resp := s.db.Order("height desc").Find(&mainChain, "is_orphan = ? AND height > ?", false, fork.Height); resp.Error != nil {
+		return resp.Error
+	}
+	mainWork := new(big.Int)
+	for _, block := range mainChain {
+		mainWork.Add(mainWork, blockchain.CalcWork(block.Bits))
+	}
+	// On equal work the block seen first stays on the main chain
+	if branchWork.Cmp(mainWork) <= 0 {
+		return nil
+	}
+
+	for _, block := range branch {
+		if len(block.Raw) == 0 {
+			fmt.Println("Cannot reorganize to block", sideTip.Hash, ": block", block.Hash, "on its branch was stored without its transactions")
+			return nil
+		}
+	}
+
+	fmt.Println("Reorganizing from height", fork.Height, ":", len(mainChain), "blocks disconnected and", len(branch), "blocks connected")
+	for _, block := range mainChain {
+		if err := s.disconnectBlock(block); err != nil {
+			return err
+		}
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		block, err := btcutil.NewBlockFromBytes(branch[i].Raw)
+		if err != nil {
+			return err
+		}
+		if err := s.connectBlock(branch[i], block.MsgBlock()); err != nil {
+			return err
+		}
+		// The side tip is the new tip of the main chain
+		if i == 0 {
+			s.notifier.UpdatedBlockTip(block.MsgBlock())
+		}
+	}
+	return nil
+}
+
+// removeConflicts removes the unconfirmed transactions spending the same
+// outpoints as spends, since they can no longer be confirmed.
+func (s *storage) removeConflicts(spends []outPointSpend) error {
+	outPoints := make([][]interface{}, len(spends))
+	spenders := make(map[wire.OutPoint]model.Hash, len(spends))
+	for i, spend := range spends {
+		outPoints[i] = []interface{}{spend.FundingTxHash, spend.FundingTxIndex}
+		hash, err := chainhash.NewHashFromStr(string(spend.FundingTxHash))
+		if err != nil {
+			return err
+		}
+		spenders[*wire.NewOutPoint(hash, spend.FundingTxIndex)] = spend.SpendingTxHash
+	}
+	spent := []model.OutPoint{}
+	if resp := s.db.Select("funding_tx_hash", "funding_tx_index", "spending_tx_id").
+		Find(&spent, "(funding_tx_hash, funding_tx_index) IN ? AND spending_tx_id IS NOT NULL", outPoints); resp.Error != nil {
+		return resp.Error
+	}
+	if len(spent) == 0 {
+		return nil
+	}
+
+	// The conflicts are evicted by the transactions spending their outputs
+	// in the block
+	ids := make([]uint, len(spent))
+	evictedBy := make(map[uint]model.Hash, len(spent))
+	for i, op := range spent {
+		hash, err := chainhash.NewHashFromStr(string(op.FundingTxHash))
+		if err != nil {
+			return err
+		}
+		ids[i] = *op.SpendingTxID
+		evictedBy[*op.SpendingTxID] = spenders[*wire.NewOutPoint(hash, op.FundingTxIndex)]
+	}
+	conflicts := []model.Transaction{}
+	if resp := s.db.Find(&conflicts, "id IN ?", ids); resp.Error != nil {
+		return resp.Error
+	}
+	for _, conflict := range conflicts {
+		if conflict.BlockID != nil {
+			return fmt.Errorf("outputs spent by transaction %v in block %v are spent again", conflict.Hash, conflict.BlockHash)
+		}
+		if err := s.removeTx(conflict, EvictedConflict, evictedBy[conflict.ID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeTx deletes the transaction along with the unconfirmed transactions
+// spending its outputs, and marks the outputs it spent as unspent. Removed
+// unconfirmed transactions are kept as evicted for the given reason, by
+// evictedBy if they conflict with it.
+func (s *storage) removeTx(transaction model.Transaction, reason string, evictedBy model.Hash) error {
+	if resp := s.db.First(&model.Transaction{}, transaction.ID); errors.Is(resp.Error, gorm.ErrRecordNotFound) {
+		// Already removed as the descendant of another transaction
+		return nil
+	} else if resp.Error != nil {
+		return resp.Error
+	}
+
+	spenders := s.db.Model(&model.OutPoint{}).Select("spending_tx_id").
+		Where("funding_tx_id = ? AND spending_tx_id IS NOT NULL", transaction.ID)
+	children := []model.Transaction{}
+	if resp := s.db.Find(&children, "id IN (?)", spenders); resp.Error != nil {
+		return resp.Error
+	}
+	for _, child := range children {
+		if err := s.removeTx(child, reason, ""); err != nil {
+			return err
+		}
+	}
+
+	// Reassemble unconfirmed transactions so that their removal from the
+	// mempool can be reported.
+	var tx *wire.MsgTx
+	if transaction.BlockID == nil {
+		tx = wire.NewMsgTx(transaction.Version)
+		tx.LockTime = transaction.LockTime
+		if err := s.addInputsAndOutputs(string(transaction.Hash), tx); err != nil {
+			return err
+		}
+		buf := new(bytes.Buffer)
+		if err := tx.Serialize(buf); err != nil {
+			return err
+		}
+		if resp := s.db.Create(&model.EvictedTx{
+			Hash:      transaction.Hash,
+			Raw:       buf.Bytes(),
+			Reason:    reason,
+			EvictedBy: evictedBy,
+		}); resp.Error != nil {
+			return resp.Error
+		}
+	}
+
+	if resp := s.db.Unscoped().Where("funding_tx_id = ? OR (funding_tx_id IS NULL AND spending_tx_id = ?)", transaction.ID, transaction.ID).
+		Delete(&model.OutPoint{}); resp.Error != nil {
+		return resp.Error
+	}
+	if resp := s.db.Model(&model.OutPoint{}).Where("spending_tx_id = ?", transaction.ID).Updates(unspent()); resp.Error != nil {
+		return resp.Error
+	}
+	if resp := s.db.Unscoped().Delete(&model.Transaction{}, transaction.ID); resp.Error != nil {
+		return resp.Error
+	}
+
+	if tx != nil {
+		s.notifier.TxRemoved(tx)
+	}
+	return nil
+}
+
+// markUnsafeTxs marks the transactions of a disconnected block that are
+// unconfirmed again as unsafe if they signal replaceability or spend from
+// unsafe transactions of the block, along with their descendants.
+func (s *storage) markUnsafeTxs(block *wire.MsgBlock) error {
+	unsafe := map[chainhash.Hash]bool{}
+	hashes := []model.Hash{}
+	for _, tx := range block.Transactions[1:] {
+		isUnsafe := SignalsReplacement(tx)
+		for _, txIn := range tx.TxIn {
+			isUnsafe = isUnsafe || unsafe[txIn.PreviousOutPoint.Hash]
+		}
+		if isUnsafe {
+			unsafe[tx.TxHash()] = true
+			hashes = append(hashes, model.Hash(tx.TxHash().String()))
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	transactions := []model.Transaction{}
+	if resp := s.db.Select("id").Find(&transactions, "hash IN ? AND block_id IS NULL", hashes); resp.Error != nil {
+		return resp.Error
+	}
+	ids := make([]uint, len(transactions))
+	for i, transaction := range transactions {
+		ids[i] = transaction.ID
+	}
+	return s.markUnsafe(ids)
+}
+
+// unspent returns the columns to update for an outpoint that is no longer
+// spent.
+func unspent() map[string]interface{} {
+	return map[string]interface{}{
+		"spending_tx_id":    nil,
+		"spending_tx_hash":  model.Hash(""),
+		"spending_tx_index": 0,
+		"sequence":          0,
+		"signature_script":  nil,
+		"witness":           model.Witness(nil),
+	}
+}
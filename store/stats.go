@@ -0,0 +1,40 @@
+package store
+
+import (
+	"github.com/catalogfi/indexer/model"
+)
+
+// GetBlockTransactions returns the transactions of a block of the main chain
+// in the order of the block.
+func (s *storage) GetBlockTransactions(hash string) ([]model.Transaction, error) {
+	transactions := []model.Transaction{}
+	if resp := s.db.Order("block_index").Find(&transactions, "block_hash = ?", model.Hash(hash)); resp.Error != nil {
+		return nil, resp.Error
+	}
+	return transactions, nil
+}
+
+// GetSpentOutPoints returns the outputs spent by the transactions of a block
+// of the main chain.
+func (s *storage) GetSpentOutPoints(blockHash string) ([]model.OutPoint, error) {
+	spenders := s.db.Model(&model.Transaction{}).Select("id").Where("block_hash = ?", model.Hash(blockHash))
+	outPoints := []model.OutPoint{}
+	if resp := s.db.Order("spending_tx_id, spending_tx_index").Find(&outPoints, "spending_tx_id IN (?) AND funding_tx_id IS NOT NULL", spenders); resp.Error != nil {
+		return nil, resp.Error
+	}
+	return outPoints, nil
+}
+
+// GetChainTxCount returns the number of transactions in the main chain up
+// to the given height, counting the transaction of the genesis block which
+// is not stored.
+func (s *storage) GetChainTxCount(height int32) (int64, error) {
+	if height < 0 {
+		return 1, nil
+	}
+	block := &model.Block{}
+	if resp := s.db.Order("height desc").First(block, "height <= ? AND is_orphan = ?", height, false); resp.Error != nil {
+		return 0, queryError(resp.Error)
+	}
+	return block.ChainTxCount + 1, nil
+}
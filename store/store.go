@@ -3,26 +3,70 @@ package store
 import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/electrum"
+	"github.com/catalogfi/indexer/esplora"
+	"github.com/catalogfi/indexer/notify"
 	"github.com/catalogfi/indexer/peer"
 	"gorm.io/gorm"
 )
 
-// TODO: test reorgs
-// TODO: test pending transactions
-
 type Storage interface {
 	command.Storage
 	peer.Storage
+	electrum.Storage
+	esplora.Storage
+
+	// CheckConsistency finds blocks that were only partially written. A
+	// partial tip is removed, while partial blocks below it are reported
+	// as an error.
+	CheckConsistency() error
+	// BackfillBlockFilters builds the filters of the main chain blocks that
+	// were stored without one.
+	BackfillBlockFilters() error
 }
 
 type storage struct {
-	params *chaincfg.Params
-	db     *gorm.DB
+	params   *chaincfg.Params
+	db       *gorm.DB
+	notifier notify.Notifier
+}
+
+type Option func(*storage)
+
+// WithNotifier makes the storage report connected and disconnected blocks
+// and newly stored unconfirmed transactions to the given notifier.
+func WithNotifier(notifier notify.Notifier) Option {
+	return func(s *storage) {
+		s.notifier = notifier
+	}
 }
 
-func NewStorage(params *chaincfg.Params, db *gorm.DB) Storage {
-	return &storage{
-		params: params,
-		db:     db,
+func NewStorage(params *chaincfg.Params, db *gorm.DB, opts ...Option) Storage {
+	s := &storage{
+		params:   params,
+		db:       db,
+		notifier: notify.Nop(),
 	}
-}
\ No newline at end of file
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// transaction runs fn against a storage bound to a single database
+// transaction, which is rolled back if fn fails. Notifications made by fn
+// are only sent once the transaction is committed.
+func (s *storage) transaction(fn func(s *storage) error) error {
+	buffer := notify.NewBuffer()
+	if err := s.db.Transaction(func(db *gorm.DB) error {
+		return fn(&storage{
+			params:   s.params,
+			db:       db,
+			notifier: buffer,
+		})
+	}); err != nil {
+		return err
+	}
+	buffer.Flush(s.notifier)
+	return nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/catalogfi/indexer/model"
+	"github.com/catalogfi/indexer/notify"
+	"github.com/catalogfi/indexer/store/storetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDatabases numbers the in-memory databases so that every storage gets
+// its own, even within a single test or benchmark.
+var testDatabases int32
+
+// newTestStorage returns a storage backed by a new in-memory sqlite database
+// migrated to the current schema.
+func newTestStorage(t testing.TB, opts ...Option) *storage {
+	name := fmt.Sprintf("%s-%d", t.Name(), atomic.AddInt32(&testDatabases, 1))
+	db, err := model.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared&_foreign_keys=1"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := model.MigrateUp(db, model.SchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	return NewStorage(storetest.Params, db, opts...).(*storage)
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, notifier notify.Notifier) storetest.Storage {
+		return newTestStorage(t, WithNotifier(notifier))
+	})
+}
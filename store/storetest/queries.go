@@ -0,0 +1,381 @@
+package storetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/blockfilter"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/electrum"
+	"github.com/catalogfi/indexer/esplora"
+)
+
+// history is the chain the query cases run against: blocks 1 and 2 mine to
+// Address(1), block 3 confirms spend, which pays 20e8 to Address(2) and
+// 29e8 to Address(3) out of the coinbase of block 1, and pending spends the
+// output of spend to Address(2), paying 19e8 to Address(3).
+type history struct {
+	blocks  []*wire.MsgBlock
+	spend   *wire.MsgTx
+	pending *wire.MsgTx
+}
+
+func putHistory(t *testing.T, s Storage) history {
+	t.Helper()
+	h := history{blocks: PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 2, 0)}
+	h.spend = SpendTx(h.blocks[0].Transactions[0], 0, 20e8, Address(2), wire.MaxTxInSequenceNum)
+	h.spend.AddTxOut(wire.NewTxOut(29e8, PkScript(Address(3))))
+	block := NewBlock(h.blocks[1].BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), h.spend)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	h.blocks = append(h.blocks, block)
+	h.pending = SpendTx(h.spend, 0, 19e8, Address(3), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(h.pending); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func txid(tx *wire.MsgTx) string {
+	return tx.TxHash().String()
+}
+
+func testElectrum(t *testing.T, s Storage) {
+	h := putHistory(t, s)
+	scriptHash := func(i byte) string {
+		return chainhash.Hash(sha256.Sum256(PkScript(Address(i)))).String()
+	}
+
+	tests := []struct {
+		address byte
+		want    []electrum.Output
+	}{
+		{2, []electrum.Output{{TxHash: txid(h.spend), Index: 0, Value: 20e8, Height: 3, BlockIndex: 1, SpendingTxHash: txid(h.pending)}}},
+		{3, []electrum.Output{{TxHash: txid(h.spend), Index: 1, Value: 29e8, Height: 3, BlockIndex: 1}, {TxHash: txid(h.pending), Index: 0, Value: 19e8}}},
+		{4, []electrum.Output{}},
+	}
+	for _, test := range tests {
+		outputs, err := s.GetScriptHashOutputs(scriptHash(test.address))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(outputs, func(i, j int) bool { return fmt.Sprint(outputs[i]) < fmt.Sprint(outputs[j]) })
+		sort.Slice(test.want, func(i, j int) bool { return fmt.Sprint(test.want[i]) < fmt.Sprint(test.want[j]) })
+		if fmt.Sprint(outputs) != fmt.Sprint(test.want) {
+			t.Fatalf("Address(%d): got outputs %+v, want %+v", test.address, outputs, test.want)
+		}
+	}
+
+	block := h.blocks[2]
+	if txids, err := s.GetBlockTxIDs(block.BlockHash().String()); err != nil || strings.Join(txids, " ") != txid(block.Transactions[0])+" "+txid(h.spend) {
+		t.Fatalf("got txids %v (%v), want the coinbase and spend", txids, err)
+	}
+	if txids, err := s.GetBlockTxIDs(chainhash.Hash{1}.String()); err != nil || len(txids) != 0 {
+		t.Fatalf("got txids %v (%v) for an unknown block, want none", txids, err)
+	}
+}
+
+func testEsplora(t *testing.T, s Storage) {
+	h := putHistory(t, s)
+	block := h.blocks[2]
+	confirmed := esplora.TxStatus{Confirmed: true, BlockHeight: 3, BlockHash: block.BlockHash().String(), BlockTime: block.Header.Timestamp.Unix()}
+
+	if status, err := s.GetTxStatus(txid(h.spend)); err != nil || status != confirmed {
+		t.Fatalf("got status %+v (%v), want %+v", status, err, confirmed)
+	}
+	if status, err := s.GetTxStatus(txid(h.pending)); err != nil || status != (esplora.TxStatus{}) {
+		t.Fatalf("got status %+v (%v) for an unconfirmed transaction", status, err)
+	}
+	if _, err := s.GetTxStatus(chainhash.Hash{1}.String()); !errors.Is(err, command.ErrNotFound) {
+		t.Fatalf("got %v for an unknown transaction, want %v", err, command.ErrNotFound)
+	}
+	statuses, err := s.GetTxStatuses([]string{txid(h.pending), txid(h.spend)})
+	if err != nil || len(statuses) != 2 || statuses[0] != (esplora.TxStatus{}) || statuses[1] != confirmed {
+		t.Fatalf("got statuses %+v (%v), want them in the order of the hashes", statuses, err)
+	}
+	if _, err := s.GetTxStatuses([]string{txid(h.spend), chainhash.Hash{1}.String()}); !errors.Is(err, command.ErrNotFound) {
+		t.Fatalf("got %v with an unknown transaction, want %v", err, command.ErrNotFound)
+	}
+
+	// Transactions are rebuilt with the outputs they spend
+	details, err := s.GetTxDetails([]string{txid(h.pending), txid(h.spend)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		tx     *wire.MsgTx
+		input  *wire.MsgTx
+		value  int64
+		status esplora.TxStatus
+	}{
+		{h.pending, h.spend, 20e8, esplora.TxStatus{}},
+		{h.spend, h.blocks[0].Transactions[0], 50e8, confirmed},
+	} {
+		got := details[i]
+		if got.Tx.TxHash() != want.tx.TxHash() || got.Status != want.status || len(got.Inputs) != 1 ||
+			string(got.Inputs[0].FundingTxHash) != txid(want.input) || got.Inputs[0].Value != want.value {
+			t.Fatalf("got details %+v, want %s spending %s", got, want.tx.TxHash(), want.input.TxHash())
+		}
+	}
+
+	inputs, outputs, err := s.GetTxOutPoints(txid(h.spend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 1 || string(inputs[0].FundingTxHash) != txid(h.blocks[0].Transactions[0]) || len(outputs) != 2 ||
+		outputs[0].Value != 20e8 || string(outputs[0].SpendingTxHash) != txid(h.pending) || outputs[1].Value != 29e8 || outputs[1].SpendingTxHash != "" {
+		t.Fatalf("got inputs %+v and outputs %+v of spend", inputs, outputs)
+	}
+
+	// Confirmed transactions come newest first, a page at a time
+	miner := Address(1).EncodeAddress()
+	chain := []string{txid(h.spend), txid(block.Transactions[0]), txid(h.blocks[1].Transactions[0]), txid(h.blocks[0].Transactions[0])}
+	for _, test := range []struct {
+		lastSeen string
+		limit    int
+		want     []string
+	}{
+		{"", 10, chain},
+		{"", 2, chain[:2]},
+		{chain[1], 10, chain[2:]},
+		{chain[3], 10, []string{}},
+	} {
+		txids, err := s.GetAddressChainTxIDs(miner, test.lastSeen, test.limit)
+		if err != nil || strings.Join(txids, " ") != strings.Join(test.want, " ") {
+			t.Fatalf("after %q: got txids %v (%v), want %v", test.lastSeen, txids, err, test.want)
+		}
+	}
+	for _, i := range []byte{2, 3} {
+		if txids, err := s.GetAddressMempoolTxIDs(Address(i).EncodeAddress(), 10); err != nil || strings.Join(txids, " ") != txid(h.pending) {
+			t.Fatalf("Address(%d): got unconfirmed txids %v (%v), want pending", i, txids, err)
+		}
+	}
+	if txids, err := s.GetAddressMempoolTxIDs(miner, 10); err != nil || len(txids) != 0 {
+		t.Fatalf("got unconfirmed txids %v (%v) for the miner, want none", txids, err)
+	}
+
+	// Outputs spent in the mempool are left out
+	for i, want := range map[byte][]string{2: {}, 3: {txid(h.spend) + ":1", txid(h.pending) + ":0"}} {
+		unspent, err := s.GetAddressUnspent(Address(i).EncodeAddress())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, op := range unspent {
+			got = append(got, fmt.Sprintf("%s:%d", op.FundingTxHash, op.FundingTxIndex))
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("Address(%d): got unspent %v, want %v", i, got, want)
+		}
+	}
+}
+
+// feeRate returns the fee rate of a transaction in sat/vB.
+func feeRate(tx *wire.MsgTx, fee int64) float64 {
+	return float64(fee) * 4 / float64(3*tx.SerializeSizeStripped()+tx.SerializeSize())
+}
+
+func testFeeStats(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	fast := SpendTx(blocks[0].Transactions[0], 0, 50e8-10000, Address(2), wire.MaxTxInSequenceNum)
+	slow := SpendTx(blocks[1].Transactions[0], 0, 50e8-2000, Address(3), wire.MaxTxInSequenceNum)
+	for _, tx := range []*wire.MsgTx{fast, slow} {
+		if err := s.PutTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both are seen at height 2, fast is confirmed at height 3 and slow is
+	// still waiting at height 4
+	block := NewBlock(blocks[1].BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), fast)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	PutChain(t, s, block.BlockHash(), 4, 1, 0)
+
+	confirmed := command.FeeStats{Bucket: command.FeeRateBucket(feeRate(fast, 10000)), Age: 1, Confirmed: 1, Total: 1, FeeRate: feeRate(fast, 10000)}
+	waiting := command.FeeStats{Bucket: command.FeeRateBucket(feeRate(slow, 2000)), Age: -1, Total: 1}
+	tests := []struct {
+		target, blocks int32
+		want           []command.FeeStats
+	}{
+		{1, 10, []command.FeeStats{confirmed, waiting}},
+		{3, 10, []command.FeeStats{confirmed}},
+		{2, 1, []command.FeeStats{waiting}},
+	}
+	for _, test := range tests {
+		stats, err := s.GetFeeStats(test.target, test.blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(stats, func(i, j int) bool { return stats[i].Age > stats[j].Age })
+		if fmt.Sprint(stats) != fmt.Sprint(test.want) {
+			t.Fatalf("target %d over %d blocks: got %+v, want %+v", test.target, test.blocks, stats, test.want)
+		}
+	}
+}
+
+func testStats(t *testing.T, s Storage) {
+	h := putHistory(t, s)
+	block := h.blocks[2]
+	side := PutChain(t, s, h.blocks[1].BlockHash(), 3, 1, 1)[0]
+
+	transactions, err := s.GetBlockTransactions(block.BlockHash().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 2 || string(transactions[0].Hash) != txid(block.Transactions[0]) || string(transactions[1].Hash) != txid(h.spend) ||
+		transactions[1].BlockIndex != 1 || transactions[1].Fee != 1e8 || transactions[1].Size != h.spend.SerializeSize() {
+		t.Fatalf("got transactions %+v, want the coinbase and spend with a fee of 1e8", transactions)
+	}
+	spent, err := s.GetSpentOutPoints(block.BlockHash().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spent) != 1 || string(spent[0].FundingTxHash) != txid(h.blocks[0].Transactions[0]) || spent[0].Value != 50e8 {
+		t.Fatalf("got spent outputs %+v, want the coinbase of block 1", spent)
+	}
+	for _, hash := range []string{side.BlockHash().String(), chainhash.Hash{1}.String()} {
+		if transactions, err := s.GetBlockTransactions(hash); err != nil || len(transactions) != 0 {
+			t.Fatalf("got transactions %+v (%v) for a block off the main chain, want none", transactions, err)
+		}
+		if spent, err := s.GetSpentOutPoints(hash); err != nil || len(spent) != 0 {
+			t.Fatalf("got spent outputs %+v (%v) for a block off the main chain, want none", spent, err)
+		}
+	}
+
+	// The count includes the transaction of the genesis block, and heights
+	// past the tip count up to the tip
+	for height, want := range map[int32]int64{-1: 1, 0: 1, 2: 3, 3: 5, 10: 5} {
+		if count, err := s.GetChainTxCount(height); err != nil || count != want {
+			t.Fatalf("got %d transactions (%v) up to height %d, want %d", count, err, height, want)
+		}
+	}
+}
+
+func testMempool(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 1, 0)
+	parent := SpendTx(blocks[0].Transactions[0], 0, 49e8, Address(2), 0)
+	child := SpendTx(parent, 0, 48e8, Address(3), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SubmitTx(child); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.GetMempoolEntries([]string{txid(child), chainhash.Hash{1}.String(), txid(blocks[0].Transactions[0]), txid(parent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byHash := map[string]command.MempoolEntry{}
+	for _, entry := range entries {
+		byHash[entry.Hash] = entry
+	}
+	p, c := byHash[txid(parent)], byHash[txid(child)]
+	if len(entries) != 2 || p.Fee != 1e8 || p.Height != 1 || p.Size != parent.SerializeSize() || !p.Signals || p.Unbroadcast ||
+		len(p.Depends) != 0 || strings.Join(p.SpentBy, " ") != txid(child) {
+		t.Fatalf("got entries %+v, want the parent spent by the child and signaling replacement", entries)
+	}
+	if c.Fee != 1e8 || c.Signals || !c.Unbroadcast || strings.Join(c.Depends, " ") != txid(parent) || len(c.SpentBy) != 0 {
+		t.Fatalf("got entries %+v, want the unbroadcast child depending on the parent", entries)
+	}
+
+	// The submitted transaction is relayed once
+	if pending, err := s.GetPendingBroadcasts(); err != nil || len(pending) != 1 || pending[0].TxHash() != child.TxHash() {
+		t.Fatalf("got pending broadcasts %v (%v), want the child", pending, err)
+	}
+	if err := s.MarkBroadcast(txid(child)); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := s.GetPendingBroadcasts(); err != nil || len(pending) != 0 {
+		t.Fatalf("got pending broadcasts %v (%v) after relaying, want none", pending, err)
+	}
+
+	// The replacement conflicts with the parent it evicted
+	replacement := SpendTx(blocks[0].Transactions[0], 0, 47e8, Address(4), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(replacement); err != nil {
+		t.Fatal(err)
+	}
+	if conflicts, err := s.GetWalletConflicts(txid(parent)); err != nil || strings.Join(conflicts, " ") != txid(replacement) {
+		t.Fatalf("got conflicts %v (%v) of the parent, want the replacement", conflicts, err)
+	}
+	conflicts, err := s.GetWalletConflicts(txid(replacement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sort.Strings(conflicts); !strings.Contains(strings.Join(conflicts, " "), txid(parent)) {
+		t.Fatalf("got conflicts %v of the replacement, want the parent", conflicts)
+	}
+	if evicted, err := s.GetEvictedTransaction(txid(parent)); err != nil || evicted.ReplacedBy != txid(replacement) {
+		t.Fatalf("got parent replaced by %q (%v), want the replacement", evicted.ReplacedBy, err)
+	}
+
+	// Expired transactions are evicted
+	if err := s.ExpireMempool(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if mempool := mempoolHashes(t, s); len(mempool) != 1 {
+		t.Fatalf("got mempool %v, want the replacement to be kept", mempool)
+	}
+	if err := s.ExpireMempool(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if mempool := mempoolHashes(t, s); len(mempool) != 0 {
+		t.Fatalf("got mempool %v, want it empty", mempool)
+	}
+	if evicted, err := s.GetEvictedTransaction(txid(replacement)); err != nil || evicted.Tx.TxHash() != replacement.TxHash() || evicted.ReplacedBy != "" {
+		t.Fatalf("got expired transaction %+v (%v), want the replacement", evicted, err)
+	}
+}
+
+func testBlockFilters(t *testing.T, s Storage) {
+	genesis := Params.GenesisBlock
+	blocks := PutChain(t, s, genesis.BlockHash(), 1, 2, 0)
+	spend := SpendTx(blocks[0].Transactions[0], 0, 49e8, Address(2), wire.MaxTxInSequenceNum)
+	block := NewBlock(blocks[1].BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), spend)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	side := PutChain(t, s, blocks[1].BlockHash(), 3, 1, 1)[0]
+
+	// The filter of a block covers the scripts it spends, and its header
+	// commits to the header of the previous block
+	prevHeader := chainhash.Hash{}
+	for height, test := range []struct {
+		block          *wire.MsgBlock
+		prevOutScripts [][]byte
+	}{
+		{genesis, nil},
+		{blocks[0], nil},
+		{blocks[1], nil},
+		{block, [][]byte{PkScript(Address(1))}},
+	} {
+		filter, header, err := blockfilter.BuildBasic(test.block, test.prevOutScripts, prevHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := command.BlockFilter{Filter: hex.EncodeToString(filter), Header: header.String()}
+		if got, err := s.GetBlockFilter(test.block.BlockHash().String()); err != nil || got != want {
+			t.Fatalf("height %d: got filter %+v (%v), want %+v", height, got, err, want)
+		}
+		prevHeader = header
+	}
+
+	// Blocks that were not connected have no filter
+	for _, hash := range []string{side.BlockHash().String(), chainhash.Hash{1}.String()} {
+		if _, err := s.GetBlockFilter(hash); !errors.Is(err, command.ErrNotFound) {
+			t.Fatalf("got %v for the filter of %s, want %v", err, hash, command.ErrNotFound)
+		}
+	}
+}
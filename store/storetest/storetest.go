@@ -0,0 +1,678 @@
+// Package storetest is a conformance suite for the storage backends. Every
+// backend runs it against empty regtest storages, so that they agree on
+// how blocks, unconfirmed transactions, reorgs and replacements show up in
+// the queries.
+package storetest
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/electrum"
+	"github.com/catalogfi/indexer/esplora"
+	"github.com/catalogfi/indexer/notify"
+	"github.com/catalogfi/indexer/peer"
+)
+
+// Params are the params of the storages the suite runs against.
+var Params = &chaincfg.RegressionNetParams
+
+// Storage is the part of store.Storage the suite runs against.
+type Storage interface {
+	command.Storage
+	peer.Storage
+	electrum.Storage
+	esplora.Storage
+}
+
+// Run runs the suite, calling newStorage for an empty storage with Params
+// that sends its events to the notifier in each test.
+func Run(t *testing.T, newStorage func(t *testing.T, notifier notify.Notifier) Storage) {
+	for _, test := range []struct {
+		name string
+		fn   func(t *testing.T, s Storage)
+	}{
+		{"PutBlock", testPutBlock},
+		{"PendingTransactions", testPendingTransactions},
+		{"Reorg", testReorg},
+		{"Replacement", testReplacement},
+		{"Unspent", testUnspent},
+		{"AddressIndex", testAddressIndex},
+		{"Electrum", testElectrum},
+		{"Esplora", testEsplora},
+		{"FeeStats", testFeeStats},
+		{"BlockFilters", testBlockFilters},
+		{"Stats", testStats},
+		{"Mempool", testMempool},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStorage(t, notify.Nop()))
+		})
+	}
+	t.Run("Notifications", func(t *testing.T) {
+		r := &recorder{}
+		testNotifications(t, newStorage(t, r), r)
+	})
+}
+
+// Address returns a P2PKH address that only depends on i.
+func Address(i byte) btcutil.Address {
+	address, err := btcutil.NewAddressPubKeyHash(append(make([]byte, 19), i), Params)
+	if err != nil {
+		panic(err)
+	}
+	return address
+}
+
+func PkScript(address btcutil.Address) []byte {
+	script, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		panic(err)
+	}
+	return script
+}
+
+// CoinbaseTx returns a coinbase paying 50 coins to the address, with the
+// height in its script as BIP34 requires and extra to tell apart the
+// coinbases of competing blocks.
+func CoinbaseTx(height int32, extra byte, to btcutil.Address) *wire.MsgTx {
+	script, err := txscript.NewScriptBuilder().AddInt64(int64(height)).AddData([]byte{extra}).Script()
+	if err != nil {
+		panic(err)
+	}
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), script, nil))
+	tx.AddTxOut(wire.NewTxOut(50e8, PkScript(to)))
+	return tx
+}
+
+// SpendTx returns a transaction spending an output of prev to the address.
+func SpendTx(prev *wire.MsgTx, index uint32, value int64, to btcutil.Address, sequence uint32) *wire.MsgTx {
+	hash := prev.TxHash()
+	in := wire.NewTxIn(wire.NewOutPoint(&hash, index), []byte{txscript.OP_TRUE}, nil)
+	in.Sequence = sequence
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(in)
+	tx.AddTxOut(wire.NewTxOut(value, PkScript(to)))
+	return tx
+}
+
+// NewBlock returns a block on top of prev with the given transactions,
+// timestamped offset seconds after the genesis block.
+func NewBlock(prev chainhash.Hash, offset int64, txs ...*wire.MsgTx) *wire.MsgBlock {
+	utxs := make([]*btcutil.Tx, len(txs))
+	for i, tx := range txs {
+		utxs[i] = btcutil.NewTx(tx)
+	}
+	merkles := blockchain.BuildMerkleTreeStore(utxs, false)
+	block := wire.NewMsgBlock(wire.NewBlockHeader(1, &prev, merkles[len(merkles)-1], Params.PowLimitBits, 0))
+	block.Header.Timestamp = Params.GenesisBlock.Header.Timestamp.Add(time.Duration(offset) * time.Second)
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	return block
+}
+
+// PutChain stores n blocks on top of prev starting at the given height,
+// each with only a coinbase to Address(1), and returns them. Chains built
+// with a different extra byte have different hashes.
+func PutChain(t *testing.T, s peer.Storage, prev chainhash.Hash, height int32, n int, extra byte) []*wire.MsgBlock {
+	t.Helper()
+	blocks := []*wire.MsgBlock{}
+	for i := 0; i < n; i++ {
+		block := NewBlock(prev, int64(height)*600+int64(extra), CoinbaseTx(height, extra, Address(1)))
+		if err := s.PutBlock(block); err != nil {
+			t.Fatalf("block %d: %v", height, err)
+		}
+		blocks = append(blocks, block)
+		prev = block.BlockHash()
+		height++
+	}
+	return blocks
+}
+
+// mempoolHashes returns the hashes of the unconfirmed transactions.
+func mempoolHashes(t *testing.T, s Storage) map[string]bool {
+	t.Helper()
+	entries, err := s.GetMempool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]bool{}
+	for _, entry := range entries {
+		hashes[entry.Hash] = true
+	}
+	return hashes
+}
+
+// spender returns the hash of the transaction spending the outpoint, empty
+// if it is unspent.
+func spender(t *testing.T, s Storage, tx *wire.MsgTx, index uint32) string {
+	t.Helper()
+	op, err := s.GetOutPoint(tx.TxHash().String(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(op.SpendingTxHash)
+}
+
+func assertTip(t *testing.T, s Storage, height int32, hash chainhash.Hash) {
+	t.Helper()
+	tip, err := s.GetLatestBlockHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tipHeight, err := s.GetLatestBlockHeight(); err != nil || tipHeight != height || tip != hash.String() {
+		t.Fatalf("got tip %s at height %d (%v), want %s at height %d", tip, tipHeight, err, hash, height)
+	}
+}
+
+// assertChainTxCounts checks the number of transactions in the main chain up
+// to each height from the genesis block.
+func assertChainTxCounts(t *testing.T, s Storage, want ...int64) {
+	t.Helper()
+	for height, count := range want {
+		if got, err := s.GetChainTxCount(int32(height)); err != nil || got != count {
+			t.Fatalf("got %d transactions (%v) up to height %d, want %d", got, err, height, count)
+		}
+	}
+}
+
+// assertConfirmed checks that the transaction is confirmed in the block at
+// the given height, or unconfirmed if block is nil.
+func assertConfirmed(t *testing.T, s Storage, tx *wire.MsgTx, block *wire.MsgBlock, height int32) {
+	t.Helper()
+	transaction, err := s.GetTransaction(tx.TxHash().String())
+	if err != nil {
+		t.Fatalf("transaction %s: %v", tx.TxHash(), err)
+	}
+	if block == nil {
+		if transaction.BlockHash != "" {
+			t.Fatalf("transaction %s is confirmed in %s, want it unconfirmed", tx.TxHash(), transaction.BlockHash)
+		}
+		return
+	}
+	if transaction.BlockHash != block.BlockHash().String() || transaction.Height != height {
+		t.Fatalf("transaction %s is confirmed in %q at height %d, want %s at height %d", tx.TxHash(), transaction.BlockHash, transaction.Height, block.BlockHash(), height)
+	}
+}
+
+func testPutBlock(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	spend := SpendTx(blocks[0].Transactions[0], 0, 49e8, Address(2), wire.MaxTxInSequenceNum)
+	block := NewBlock(blocks[1].BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), spend)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	blocks = append(blocks, block)
+	assertTip(t, s, 3, block.BlockHash())
+
+	// Storing a block again changes nothing
+	if err := s.PutBlock(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	assertTip(t, s, 3, block.BlockHash())
+
+	for i, block := range blocks {
+		height := int32(i + 1)
+		hash, err := s.GetBlockHash(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != block.BlockHash().String() {
+			t.Fatalf("got block %s at height %d, want %s", hash, height, block.BlockHash())
+		}
+		header, err := s.GetHeaderFromHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Header.BlockHash() != block.BlockHash() || header.Height != height {
+			t.Fatalf("got header %s at height %d, want %s at height %d", header.Header.BlockHash(), header.Height, block.BlockHash(), height)
+		}
+		stored, err := s.GetBlockFromHash(block.BlockHash().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored.Transactions()) != len(block.Transactions) {
+			t.Fatalf("got %d transactions in block %d, want %d", len(stored.Transactions()), height, len(block.Transactions))
+		}
+		for j, tx := range block.Transactions {
+			if *stored.Transactions()[j].Hash() != tx.TxHash() {
+				t.Fatalf("got transaction %s at index %d of block %d, want %s", stored.Transactions()[j].Hash(), j, height, tx.TxHash())
+			}
+			assertConfirmed(t, s, tx, block, height)
+		}
+	}
+	if prevHeight, err := s.GetPreviousBlockHeight(block.Header.PrevBlock.String()); err != nil || prevHeight != 2 {
+		t.Fatalf("got height %d (%v) for the previous block, want 2", prevHeight, err)
+	}
+	if _, err := s.GetBlockHash(4); err == nil {
+		t.Fatal("got a block above the tip")
+	}
+	if spent := spender(t, s, blocks[0].Transactions[0], 0); spent != spend.TxHash().String() {
+		t.Fatalf("coinbase of block 1 is spent by %q, want %s", spent, spend.TxHash())
+	}
+}
+
+func testPendingTransactions(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 1, 0)
+	parent := SpendTx(blocks[0].Transactions[0], 0, 49e8, Address(2), wire.MaxTxInSequenceNum)
+	child := SpendTx(parent, 0, 48e8, Address(3), wire.MaxTxInSequenceNum)
+	for _, tx := range []*wire.MsgTx{parent, child} {
+		if err := s.PutTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mempool := mempoolHashes(t, s)
+	if len(mempool) != 2 || !mempool[parent.TxHash().String()] || !mempool[child.TxHash().String()] {
+		t.Fatalf("got mempool %v, want the parent and the child", mempool)
+	}
+	assertConfirmed(t, s, parent, nil, 0)
+	if spent := spender(t, s, parent, 0); spent != child.TxHash().String() {
+		t.Fatalf("output of the parent is spent by %q, want the child", spent)
+	}
+
+	// Confirming the parent leaves the child pending
+	block := NewBlock(blocks[0].BlockHash(), 2*600, CoinbaseTx(2, 0, Address(1)), parent)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	mempool = mempoolHashes(t, s)
+	if len(mempool) != 1 || !mempool[child.TxHash().String()] {
+		t.Fatalf("got mempool %v, want only the child", mempool)
+	}
+	assertConfirmed(t, s, parent, block, 2)
+	assertConfirmed(t, s, child, nil, 0)
+	if spent := spender(t, s, parent, 0); spent != child.TxHash().String() {
+		t.Fatalf("output of the parent is spent by %q, want the child", spent)
+	}
+}
+
+func testReorg(t *testing.T, s Storage) {
+	genesis := Params.GenesisBlock.BlockHash()
+
+	// A1 <- A2 (t1) <- A3 (t2), where t1 spends the coinbase of A1, and t2
+	// spends the coinbase of A2 and the output of t1
+	a1 := PutChain(t, s, genesis, 1, 1, 0)[0]
+	t1 := SpendTx(a1.Transactions[0], 0, 49e8, Address(2), wire.MaxTxInSequenceNum)
+	a2 := NewBlock(a1.BlockHash(), 2*600, CoinbaseTx(2, 0, Address(1)), t1)
+	if err := s.PutBlock(a2); err != nil {
+		t.Fatal(err)
+	}
+	t2 := SpendTx(a2.Transactions[0], 0, 99e8, Address(3), wire.MaxTxInSequenceNum)
+	t1Hash := t1.TxHash()
+	t2.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&t1Hash, 0), nil, nil))
+	a3 := NewBlock(a2.BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), t2)
+	if err := s.PutBlock(a3); err != nil {
+		t.Fatal(err)
+	}
+	assertTip(t, s, 3, a3.BlockHash())
+	assertChainTxCounts(t, s, 1, 2, 4, 6)
+
+	// A1 <- B2 <- B3 <- B4 has more work
+	b := PutChain(t, s, a1.BlockHash(), 2, 2, 1)
+	assertTip(t, s, 3, a3.BlockHash())
+	b = append(b, PutChain(t, s, b[1].BlockHash(), 4, 1, 1)...)
+	assertTip(t, s, 4, b[2].BlockHash())
+	assertChainTxCounts(t, s, 1, 2, 3, 4, 5)
+
+	// t1 is back in the mempool, its output is unspent again, and t2,
+	// which spends a disconnected coinbase, is evicted
+	mempool := mempoolHashes(t, s)
+	if len(mempool) != 1 || !mempool[t1.TxHash().String()] {
+		t.Fatalf("got mempool %v, want only t1", mempool)
+	}
+	assertConfirmed(t, s, t1, nil, 0)
+	if spent := spender(t, s, a1.Transactions[0], 0); spent != t1.TxHash().String() {
+		t.Fatalf("coinbase of A1 is spent by %q, want the unconfirmed t1", spent)
+	}
+	if spent := spender(t, s, t1, 0); spent != "" {
+		t.Fatalf("output of t1 is spent by %s, want unspent", spent)
+	}
+	for _, tx := range []*wire.MsgTx{a2.Transactions[0], a3.Transactions[0], t2} {
+		if _, err := s.GetTransaction(tx.TxHash().String()); err == nil {
+			t.Fatalf("transaction %s of a disconnected block is still stored", tx.TxHash())
+		}
+	}
+	evicted, err := s.GetEvictedTransaction(t2.TxHash().String())
+	if err != nil {
+		t.Fatalf("t2 was not evicted: %v", err)
+	}
+	if evicted.Tx.TxHash() != t2.TxHash() {
+		t.Fatalf("got evicted transaction %s, want t2", evicted.Tx.TxHash())
+	}
+
+	// A1 <- A2 <- A3 <- A4 <- A5 has more work again
+	a := PutChain(t, s, a3.BlockHash(), 4, 2, 0)
+	assertTip(t, s, 5, a[1].BlockHash())
+	assertChainTxCounts(t, s, 1, 2, 4, 6, 7, 8)
+	for height, block := range []*wire.MsgBlock{a1, a2, a3, a[0], a[1]} {
+		hash, err := s.GetBlockHash(int32(height + 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != block.BlockHash().String() {
+			t.Fatalf("got block %s at height %d, want %s", hash, height+1, block.BlockHash())
+		}
+	}
+	if mempool := mempoolHashes(t, s); len(mempool) != 0 {
+		t.Fatalf("got mempool %v, want it empty", mempool)
+	}
+	assertConfirmed(t, s, t1, a2, 2)
+	assertConfirmed(t, s, a2.Transactions[0], a2, 2)
+	assertConfirmed(t, s, t2, a3, 3)
+	assertConfirmed(t, s, a3.Transactions[0], a3, 3)
+	if spent := spender(t, s, t1, 0); spent != t2.TxHash().String() {
+		t.Fatalf("output of t1 is spent by %q, want t2", spent)
+	}
+	if spent := spender(t, s, a2.Transactions[0], 0); spent != t2.TxHash().String() {
+		t.Fatalf("coinbase of A2 is spent by %q, want t2", spent)
+	}
+	for _, block := range b {
+		if _, err := s.GetTransaction(block.Transactions[0].TxHash().String()); err == nil {
+			t.Fatalf("coinbase of disconnected block %s is still stored", block.BlockHash())
+		}
+	}
+}
+
+func testReplacement(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 1, 0)
+	original := SpendTx(blocks[0].Transactions[0], 0, 49e8, Address(2), 0)
+	child := SpendTx(original, 0, 48.9e8, Address(2), wire.MaxTxInSequenceNum)
+	for _, tx := range []*wire.MsgTx{original, child} {
+		if err := s.PutTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The replacement pays a higher fee than the original and its child
+	replacement := SpendTx(blocks[0].Transactions[0], 0, 48e8, Address(3), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(replacement); err != nil {
+		t.Fatal(err)
+	}
+	mempool := mempoolHashes(t, s)
+	if len(mempool) != 1 || !mempool[replacement.TxHash().String()] {
+		t.Fatalf("got mempool %v, want only the replacement", mempool)
+	}
+	for _, tx := range []*wire.MsgTx{original, child} {
+		if _, err := s.GetTransaction(tx.TxHash().String()); err == nil {
+			t.Fatalf("replaced transaction %s is still stored", tx.TxHash())
+		}
+		evicted, err := s.GetEvictedTransaction(tx.TxHash().String())
+		if err != nil {
+			t.Fatalf("replaced transaction %s was not evicted: %v", tx.TxHash(), err)
+		}
+		if evicted.Tx.TxHash() != tx.TxHash() {
+			t.Fatalf("got evicted transaction %s, want %s", evicted.Tx.TxHash(), tx.TxHash())
+		}
+	}
+	if spent := spender(t, s, blocks[0].Transactions[0], 0); spent != replacement.TxHash().String() {
+		t.Fatalf("coinbase is spent by %q, want the replacement", spent)
+	}
+
+	// Confirming a conflicting transaction evicts the replacement
+	conflicting := SpendTx(blocks[0].Transactions[0], 0, 47e8, Address(4), wire.MaxTxInSequenceNum)
+	block := NewBlock(blocks[0].BlockHash(), 2*600, CoinbaseTx(2, 0, Address(1)), conflicting)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if mempool := mempoolHashes(t, s); len(mempool) != 0 {
+		t.Fatalf("got mempool %v, want it empty", mempool)
+	}
+	if _, err := s.GetTransaction(replacement.TxHash().String()); err == nil {
+		t.Fatal("conflicting replacement is still stored")
+	}
+	assertConfirmed(t, s, conflicting, block, 2)
+	if spent := spender(t, s, blocks[0].Transactions[0], 0); spent != conflicting.TxHash().String() {
+		t.Fatalf("coinbase is spent by %q, want the confirmed transaction", spent)
+	}
+}
+
+func testUnspent(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	spend := SpendTx(blocks[0].Transactions[0], 0, 20e8, Address(2), wire.MaxTxInSequenceNum)
+	spend.AddTxOut(wire.NewTxOut(29e8, PkScript(Address(3))))
+	block := NewBlock(blocks[1].BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), spend)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	pending := SpendTx(blocks[1].Transactions[0], 0, 49e8, Address(2), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	// The coinbase of block 1 is spent in block 3, and the coinbase of
+	// block 2 in the mempool, which is enough to leave it out
+	options := command.ListUnspentQueryOptions{MaximumAmount: math.MaxInt64, MaximumCount: math.MaxUint32}
+	unspent, err := s.ListUnspent(0, math.MaxInt32, []string{Address(1).EncodeAddress()}, true, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unspent) != 1 {
+		t.Fatalf("got %d unspent outputs of the miner, want 1", len(unspent))
+	}
+	if op := unspent[0]; string(op.FundingTxHash) != block.Transactions[0].TxHash().String() || op.FundingTxIndex != 0 || op.Value != 50e8 {
+		t.Fatalf("got unspent output %s:%d of %d, want the coinbase of block 3", op.FundingTxHash, op.FundingTxIndex, op.Value)
+	}
+	// Balances only count confirmed transactions
+	if balance, received, err := s.GetAddressBalance([]string{Address(1).EncodeAddress()}); err != nil || balance != 100e8 || received != 150e8 {
+		t.Fatalf("got balance %d and received %d (%v) for the miner, want 100e8 and 150e8", balance, received, err)
+	}
+	if balance, received, err := s.GetAddressBalance([]string{Address(2).EncodeAddress()}); err != nil || balance != 20e8 || received != 20e8 {
+		t.Fatalf("got balance %d and received %d (%v), want 20e8 and 20e8", balance, received, err)
+	}
+
+	txOut, err := s.GetTxOut(spend.TxHash().String(), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txOut.OutPoint.Value != 29e8 || txOut.Height != 3 || txOut.BlockHash != block.BlockHash().String() || txOut.Coinbase {
+		t.Fatalf("got output of %d in %s at height %d (coinbase %v), want 29e8 in %s at height 3", txOut.OutPoint.Value, txOut.BlockHash, txOut.Height, txOut.Coinbase, block.BlockHash())
+	}
+	if txOut, err := s.GetTxOut(block.Transactions[0].TxHash().String(), 0, false); err != nil || !txOut.Coinbase {
+		t.Fatalf("got coinbase %v (%v) for the output of a coinbase", txOut.Coinbase, err)
+	}
+	if _, err := s.GetTxOut(blocks[0].Transactions[0].TxHash().String(), 0, false); err == nil {
+		t.Fatal("got an output spent in a block")
+	}
+	if _, err := s.GetTxOut(blocks[1].Transactions[0].TxHash().String(), 0, false); err != nil {
+		t.Fatalf("output spent in the mempool is not unspent without the mempool: %v", err)
+	}
+	if _, err := s.GetTxOut(blocks[1].Transactions[0].TxHash().String(), 0, true); err == nil {
+		t.Fatal("got an output spent in the mempool with the mempool")
+	}
+	if _, err := s.GetTxOut(pending.TxHash().String(), 0, false); err == nil {
+		t.Fatal("got an unconfirmed output without the mempool")
+	}
+	if _, err := s.GetTxOut(pending.TxHash().String(), 0, true); err != nil {
+		t.Fatalf("unconfirmed output is not unspent with the mempool: %v", err)
+	}
+
+	info, err := s.GetTxOutSetInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Height != 3 || info.BestBlock != block.BlockHash().String() || info.Transactions != 3 || info.TxOuts != 4 || info.TotalAmount != 149e8 {
+		t.Fatalf("got %d transactions and %d outputs of %d at %s height %d, want 3 transactions and 4 outputs of 149e8 at %s height 3", info.Transactions, info.TxOuts, info.TotalAmount, info.BestBlock, info.Height, block.BlockHash())
+	}
+}
+
+// formatDeltas formats the entries of the history of addresses as
+// position in the chain, outpoint, value and address.
+func formatDeltas(deltas []command.AddressDelta) string {
+	lines := make([]string, len(deltas))
+	for i, d := range deltas {
+		lines[i] = fmt.Sprintf("%d/%d %s:%d %d %s", d.Height, d.BlockIndex, d.TxHash, d.Index, d.Value, d.Address)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func testAddressIndex(t *testing.T, s Storage) {
+	blocks := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	fund := SpendTx(blocks[0].Transactions[0], 0, 10e8, Address(3), wire.MaxTxInSequenceNum)
+	fund.AddTxOut(wire.NewTxOut(10e8, PkScript(Address(2))))
+	fund.AddTxOut(wire.NewTxOut(10e8, PkScript(Address(2))))
+	fund.AddTxOut(wire.NewTxOut(0, PkScript(Address(2))))
+	block3 := NewBlock(blocks[1].BlockHash(), 3*600, CoinbaseTx(3, 0, Address(1)), fund)
+	if err := s.PutBlock(block3); err != nil {
+		t.Fatal(err)
+	}
+	// The empty output of Address(2) is spent by the second input, and
+	// the first output is empty too
+	spend := SpendTx(fund, 0, 0, Address(2), wire.MaxTxInSequenceNum)
+	fundHash := fund.TxHash()
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&fundHash, 3), []byte{txscript.OP_TRUE}, nil))
+	spend.AddTxOut(wire.NewTxOut(9e8, PkScript(Address(1))))
+	block4 := NewBlock(block3.BlockHash(), 4*600, CoinbaseTx(4, 0, Address(1)), spend)
+	if err := s.PutBlock(block4); err != nil {
+		t.Fatal(err)
+	}
+
+	a2, a3 := Address(2).EncodeAddress(), Address(3).EncodeAddress()
+	delta := func(tx *wire.MsgTx, index uint32, value int64, address string, height int32) command.AddressDelta {
+		return command.AddressDelta{Address: address, TxHash: tx.TxHash().String(), Index: index, BlockIndex: 1, Height: height, Value: value}
+	}
+	// Entries with the same position and value are ordered by address,
+	// spends first and index
+	history := []command.AddressDelta{
+		delta(fund, 3, 0, a2, 3),
+		delta(fund, 1, 10e8, a2, 3),
+		delta(fund, 2, 10e8, a2, 3),
+		delta(fund, 0, 10e8, a3, 3),
+		delta(spend, 0, -10e8, a3, 4),
+		delta(spend, 1, 0, a2, 4),
+		delta(spend, 0, 0, a2, 4),
+	}
+	all := command.AddressQueryOptions{StartHeight: 0, EndHeight: math.MaxInt32, Limit: -1}
+	tests := []struct {
+		name    string
+		options command.AddressQueryOptions
+		want    []command.AddressDelta
+	}{
+		{"all", all, history},
+		{"page", command.AddressQueryOptions{StartHeight: 0, EndHeight: math.MaxInt32, Offset: 1, Limit: 2}, history[1:3]},
+		{"page past the end", command.AddressQueryOptions{StartHeight: 0, EndHeight: math.MaxInt32, Offset: 6, Limit: 2}, history[6:]},
+		{"offset past the end", command.AddressQueryOptions{StartHeight: 0, EndHeight: math.MaxInt32, Offset: 7, Limit: -1}, history[7:]},
+		{"height range", command.AddressQueryOptions{StartHeight: 4, EndHeight: 4, Limit: -1}, history[4:]},
+		{"empty height range", command.AddressQueryOptions{StartHeight: 5, EndHeight: 10, Limit: -1}, history[:0]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deltas, err := s.GetAddressDeltas([]string{a3, a2}, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := formatDeltas(deltas), formatDeltas(test.want); got != want {
+				t.Fatalf("got deltas\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+
+	// Every page of the transactions is in chain order without duplicates
+	txTests := []struct {
+		name    string
+		options command.AddressQueryOptions
+		want    []string
+	}{
+		{"all", all, []string{fund.TxHash().String(), spend.TxHash().String()}},
+		{"page", command.AddressQueryOptions{StartHeight: 0, EndHeight: math.MaxInt32, Offset: 1, Limit: 1}, []string{spend.TxHash().String()}},
+		{"height range", command.AddressQueryOptions{StartHeight: 3, EndHeight: 3, Limit: -1}, []string{fund.TxHash().String()}},
+	}
+	for _, test := range txTests {
+		t.Run("txids "+test.name, func(t *testing.T) {
+			txids, err := s.GetAddressTxIDs([]string{a2, a3}, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(txids, " ") != strings.Join(test.want, " ") {
+				t.Fatalf("got transactions %v, want %v", txids, test.want)
+			}
+		})
+	}
+
+	if balance, received, err := s.GetAddressBalance([]string{a2, a3}); err != nil || balance != 20e8 || received != 30e8 {
+		t.Fatalf("got balance %d and received %d (%v), want 20e8 and 30e8", balance, received, err)
+	}
+	if balance, received, err := s.GetAddressBalance([]string{Address(4).EncodeAddress()}); err != nil || balance != 0 || received != 0 {
+		t.Fatalf("got balance %d and received %d (%v) for an unused address, want 0", balance, received, err)
+	}
+}
+
+// recorder is a Notifier that records the events it receives.
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) BlockConnected(block *wire.MsgBlock) {
+	r.events = append(r.events, "connected "+block.BlockHash().String())
+}
+
+func (r *recorder) BlockDisconnected(block *wire.MsgBlock) {
+	r.events = append(r.events, "disconnected "+block.BlockHash().String())
+}
+
+func (r *recorder) UpdatedBlockTip(block *wire.MsgBlock) {
+	r.events = append(r.events, "tip "+block.BlockHash().String())
+}
+
+func (r *recorder) TxAccepted(tx *wire.MsgTx) {
+	r.events = append(r.events, "accepted "+tx.TxHash().String())
+}
+
+func (r *recorder) TxRemoved(tx *wire.MsgTx) {
+	r.events = append(r.events, "removed "+tx.TxHash().String())
+}
+
+// flush returns the events recorded since it was last called.
+func (r *recorder) flush() []string {
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func assertEvents(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func testNotifications(t *testing.T, s Storage, r *recorder) {
+	a := PutChain(t, s, Params.GenesisBlock.BlockHash(), 1, 2, 0)
+	assertEvents(t, r.flush(),
+		"connected "+a[0].BlockHash().String(), "tip "+a[0].BlockHash().String(),
+		"connected "+a[1].BlockHash().String(), "tip "+a[1].BlockHash().String())
+
+	tx := SpendTx(a[0].Transactions[0], 0, 49e8, Address(2), wire.MaxTxInSequenceNum)
+	if err := s.PutTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	assertEvents(t, r.flush(), "accepted "+tx.TxHash().String())
+
+	// A side branch is not announced until it becomes the main chain, and
+	// then only its last block is announced as the tip
+	b := PutChain(t, s, a[0].BlockHash(), 2, 1, 1)
+	assertEvents(t, r.flush())
+	b = append(b, PutChain(t, s, b[0].BlockHash(), 3, 1, 1)...)
+	assertEvents(t, r.flush(),
+		"disconnected "+a[1].BlockHash().String(),
+		"connected "+b[0].BlockHash().String(),
+		"connected "+b[1].BlockHash().String(),
+		"tip "+b[1].BlockHash().String())
+
+	// Storing a known block again announces nothing
+	if err := s.PutBlock(b[1]); err != nil {
+		t.Fatal(err)
+	}
+	assertEvents(t, r.flush())
+}
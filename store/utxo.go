@@ -0,0 +1,207 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/command"
+	"github.com/catalogfi/indexer/model"
+)
+
+// GetTxOut returns an output that is unspent on the main chain, and in the
+// mempool if includeMempool is set. Outputs of unconfirmed transactions are
+// only returned if includeMempool is set, and provably unspendable ones never
+// are.
+func (s *storage) GetTxOut(hash string, index uint32, includeMempool bool) (command.TxOut, error) {
+	op := model.OutPoint{}
+	if resp := s.db.Preload("FundingTx.Block").Preload("SpendingTx").
+		First(&op, "funding_tx_hash = ? AND funding_tx_index = ? AND funding_tx_id IS NOT NULL", model.Hash(hash), index); resp.Error != nil {
+		return command.TxOut{}, queryError(resp.Error)
+	}
+	if Unspendable(op.PkScript) {
+		return command.TxOut{}, command.ErrNotFound
+	}
+	funding := op.FundingTx
+	if funding.Block == nil && !includeMempool {
+		return command.TxOut{}, command.ErrNotFound
+	}
+	if op.SpendingTx != nil && (includeMempool || op.SpendingTx.BlockID != nil) {
+		return command.TxOut{}, command.ErrNotFound
+	}
+
+	txOut := command.TxOut{OutPoint: op}
+	if funding.Block != nil {
+		txOut.BlockHash = string(funding.Block.Hash)
+		txOut.Height = funding.Block.Height
+		txOut.Coinbase = funding.BlockIndex == 0
+	}
+	return txOut, nil
+}
+
+// GetTxOutSetInfo walks the outputs that are unspent on the main chain in
+// the order of their outpoints. Outputs spent in the mempool count as
+// unspent, and provably unspendable ones are left out like bitcoind does.
+// The hash is bitcoind's hash_serialized_3, which takes the outputs in the
+// order of bitcoind's UTXO database.
+func (s *storage) GetTxOutSetInfo(withHash bool) (command.TxOutSetInfo, error) {
+	info := command.TxOutSetInfo{}
+	err := s.transaction(func(s *storage) error {
+		tip, err := s.tip()
+		if err != nil {
+			return queryError(err)
+		}
+		info.Height = tip.Height
+		info.BestBlock = string(tip.Hash)
+
+		// Sorting by the internal byte order of the hashes cannot use the
+		// index, so it is only done for the hash
+		order := "out_points.funding_tx_hash"
+		if withHash {
+			order = internalOrder("out_points.funding_tx_hash")
+		}
+		rows, err := s.db.Table("out_points").
+			Select("out_points.funding_tx_hash, out_points.funding_tx_index, out_points.pk_script, out_points.value, blocks.height, transactions.block_index").
+			Joins("JOIN transactions ON transactions.id = out_points.funding_tx_id AND transactions.deleted_at IS NULL").
+			Joins("JOIN blocks ON blocks.id = transactions.block_id AND blocks.is_orphan = ?", false).
+			Joins("LEFT JOIN transactions spenders ON spenders.id = out_points.spending_tx_id AND spenders.deleted_at IS NULL").
+			Where("out_points.deleted_at IS NULL AND (out_points.spending_tx_id IS NULL OR spenders.block_id IS NULL)").
+			Order(order + ", out_points.funding_tx_index").Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		hasher := sha256.New()
+		lastHash := model.Hash("")
+		coins := []coin{}
+		for rows.Next() {
+			var (
+				fundingTxHash  model.Hash
+				fundingTxIndex uint32
+				pkScript       []byte
+				value          int64
+				height         int32
+				blockIndex     uint32
+			)
+			if err := rows.Scan(&fundingTxHash, &fundingTxIndex, &pkScript, &value, &height, &blockIndex); err != nil {
+				return err
+			}
+			if Unspendable(pkScript) {
+				continue
+			}
+
+			if fundingTxHash != lastHash {
+				if err := writeCoins(hasher, string(lastHash), coins); err != nil {
+					return err
+				}
+				coins = coins[:0]
+				info.Transactions++
+				lastHash = fundingTxHash
+			}
+			info.TxOuts++
+			info.BogoSize += int64(50 + len(pkScript))
+			info.TotalAmount += value
+
+			if withHash {
+				coins = append(coins, coin{
+					index:    fundingTxIndex,
+					height:   height,
+					coinbase: blockIndex == 0,
+					txOut:    wire.NewTxOut(value, pkScript),
+				})
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if withHash {
+			if err := writeCoins(hasher, string(lastHash), coins); err != nil {
+				return err
+			}
+			info.Hash = chainhash.Hash(sha256.Sum256(hasher.Sum(nil))).String()
+		}
+		return nil
+	})
+	return info, err
+}
+
+// internalOrder returns the ORDER BY terms that sort a hash column in the
+// internal byte order of the hashes, which are stored in the byte order of
+// their hex encoding.
+func internalOrder(column string) string {
+	terms := make([]string, chainhash.HashSize)
+	for i := range terms {
+		terms[i] = fmt.Sprintf("substr(%s, %d, 1)", column, chainhash.HashSize-i)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// coin is an unspent output of a transaction.
+type coin struct {
+	index    uint32
+	height   int32
+	coinbase bool
+	txOut    *wire.TxOut
+}
+
+// writeCoins serializes the unspent outputs of a transaction like bitcoind's
+// TxOutSer, in the order of their keys in bitcoind's UTXO database, which
+// encode the output index as a VARINT.
+func writeCoins(w io.Writer, txHash string, coins []coin) error {
+	if len(coins) == 0 {
+		return nil
+	}
+	hash, err := chainhash.NewHashFromStr(txHash)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(coins, func(i, j int) bool {
+		return bytes.Compare(varInt(coins[i].index), varInt(coins[j].index)) < 0
+	})
+
+	var buf [4]byte
+	for _, coin := range coins {
+		code := uint32(coin.height) << 1
+		if coin.coinbase {
+			code |= 1
+		}
+		w.Write(hash[:])
+		binary.LittleEndian.PutUint32(buf[:], coin.index)
+		w.Write(buf[:])
+		binary.LittleEndian.PutUint32(buf[:], code)
+		w.Write(buf[:])
+		if err := wire.WriteTxOut(w, 0, 0, coin.txOut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// varInt encodes n like bitcoind's VARINT, most significant group first with
+// an offset on every byte but the last.
+func varInt(n uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n > 0x7f {
+		n = (n >> 7) - 1
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	return tmp[i:]
+}
+
+// Unspendable reports whether an output can never be spent, and so is not
+// part of the UTXO set.
+func Unspendable(pkScript []byte) bool {
+	return (len(pkScript) > 0 && pkScript[0] == txscript.OP_RETURN) || len(pkScript) > txscript.MaxScriptSize
+}
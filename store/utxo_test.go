@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/store/storetest"
+)
+
+func TestVarInt(t *testing.T) {
+	for n, want := range map[uint32]string{
+		0:          "00",
+		127:        "7f",
+		128:        "8000",
+		255:        "807f",
+		256:        "8100",
+		16383:      "fe7f",
+		16384:      "ff00",
+		16511:      "ff7f",
+		16512:      "808000",
+		65535:      "82fe7f",
+		0xffffffff: "8efefefe7f",
+	} {
+		if got := hex.EncodeToString(varInt(n)); got != want {
+			t.Errorf("varInt(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestTxOutSetInfo(t *testing.T) {
+	s := newTestStorage(t)
+	blocks := storetest.PutChain(t, s, storetest.Params.GenesisBlock.BlockHash(), 1, 3, 0)
+	tx := storetest.SpendTx(blocks[0].Transactions[0], 0, 20e8, storetest.Address(2), wire.MaxTxInSequenceNum)
+	tx.AddTxOut(wire.NewTxOut(29e8, storetest.PkScript(storetest.Address(3))))
+	block := storetest.NewBlock(blocks[2].BlockHash(), 4*600, storetest.CoinbaseTx(4, 0, storetest.Address(1)), tx)
+	if err := s.PutBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	// Outputs spent in the mempool are still unspent
+	if err := s.PutTx(storetest.SpendTx(tx, 0, 19e8, storetest.Address(4), wire.MaxTxInSequenceNum)); err != nil {
+		t.Fatal(err)
+	}
+
+	type coin struct {
+		hash  chainhash.Hash
+		index uint32
+		code  uint32
+		txOut *wire.TxOut
+	}
+	coins := []coin{}
+	for height, b := range []*wire.MsgBlock{blocks[1], blocks[2], block} {
+		for i, tx := range b.Transactions {
+			for index, txOut := range tx.TxOut {
+				code := uint32(height+2) << 1
+				if i == 0 {
+					code |= 1
+				}
+				coins = append(coins, coin{hash: tx.TxHash(), index: uint32(index), code: code, txOut: txOut})
+			}
+		}
+	}
+	// bitcoind's UTXO database orders the outputs by the internal byte
+	// order of their hashes
+	sort.Slice(coins, func(i, j int) bool {
+		if c := bytes.Compare(coins[i].hash[:], coins[j].hash[:]); c != 0 {
+			return c < 0
+		}
+		return coins[i].index < coins[j].index
+	})
+	buf := new(bytes.Buffer)
+	total := int64(0)
+	for _, coin := range coins {
+		buf.Write(coin.hash[:])
+		binary.Write(buf, binary.LittleEndian, coin.index)
+		binary.Write(buf, binary.LittleEndian, coin.code)
+		if err := wire.WriteTxOut(buf, 0, 0, coin.txOut); err != nil {
+			t.Fatal(err)
+		}
+		total += coin.txOut.Value
+	}
+	first := sha256.Sum256(buf.Bytes())
+	want := chainhash.Hash(sha256.Sum256(first[:])).String()
+
+	info, err := s.GetTxOutSetInfo(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Hash != want {
+		t.Fatalf("got hash_serialized_3 %s, want %s", info.Hash, want)
+	}
+	if info.Height != 4 || info.BestBlock != block.BlockHash().String() {
+		t.Fatalf("got best block %s at height %d, want %s at height 4", info.BestBlock, info.Height, block.BlockHash())
+	}
+	if info.Transactions != 4 || info.TxOuts != int64(len(coins)) || info.TotalAmount != total {
+		t.Fatalf("got %d transactions, %d outputs and %d in total, want 4, %d and %d", info.Transactions, info.TxOuts, info.TotalAmount, len(coins), total)
+	}
+
+	info, err = s.GetTxOutSetInfo(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Hash != "" || info.TxOuts != int64(len(coins)) {
+		t.Fatalf("got hash %q and %d outputs without the hash, want none and %d", info.Hash, info.TxOuts, len(coins))
+	}
+}
@@ -0,0 +1,192 @@
+// Package validation checks headers and blocks received from peers before
+// they are stored, reusing the checks of btcd's blockchain package with the
+// proof of work and retarget rules of the chain.
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/chain"
+)
+
+const (
+	// medianTimeBlocks is the number of previous blocks the median time
+	// past is taken over.
+	medianTimeBlocks = 11
+
+	// maxTimeOffset is how far in the future a block timestamp can be.
+	maxTimeOffset = blockchain.MaxTimeOffsetSeconds * time.Second
+)
+
+// Chain gives access to the headers of the chain a new header builds on.
+type Chain interface {
+	// HeaderAt returns the header at the given height.
+	HeaderAt(height int32) (*wire.BlockHeader, error)
+}
+
+// CheckHeader checks a header of the chain c at the given height that builds
+// on headers: that it follows the previous header, meets the required target
+// and its own proof of work, has a timestamp after the median time past and
+// not too far after now, and matches the checkpoints. The AuxPoW of a merge
+// mined header has to be checked when it is decoded.
+func CheckHeader(c *chain.Chain, headers Chain, header *wire.BlockHeader, height int32, now time.Time) error {
+	params := c.Params
+	hash := header.BlockHash()
+	prev, err := headers.HeaderAt(height - 1)
+	if err != nil {
+		return fmt.Errorf("failed to get the previous header: %v", err)
+	}
+	if header.PrevBlock != prev.BlockHash() {
+		return fmt.Errorf("header %v does not follow %v", hash, prev.BlockHash())
+	}
+
+	bits, err := c.RequiredBits(headers, prev, height, header.Timestamp)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return fmt.Errorf("header %v has target bits %08x, expected %08x", hash, header.Bits, bits)
+	}
+	if c.AuxPoW != nil {
+		if err := c.AuxPoW.CheckVersion(header, height); err != nil {
+			return err
+		}
+	}
+	if err := checkProofOfWork(c, header); err != nil {
+		return err
+	}
+
+	medianTime, err := medianTimePast(headers, height-1)
+	if err != nil {
+		return err
+	}
+	if !header.Timestamp.After(medianTime) {
+		return fmt.Errorf("header %v has timestamp %v before the median time past %v", hash, header.Timestamp, medianTime)
+	}
+	if header.Timestamp.After(now.Add(maxTimeOffset)) {
+		return fmt.Errorf("header %v has timestamp %v too far in the future", hash, header.Timestamp)
+	}
+
+	for _, checkpoint := range params.Checkpoints {
+		if checkpoint.Height == height && *checkpoint.Hash != hash {
+			return fmt.Errorf("header %v does not match the checkpoint %v at height %d", hash, checkpoint.Hash, height)
+		}
+	}
+	return nil
+}
+
+// CheckBlock checks a block of the chain c at the given height whose header
+// was checked with CheckHeader: its size and weight, its transactions, merkle
+// root and witness commitment, and the height in its coinbase.
+func CheckBlock(c *chain.Chain, block *wire.MsgBlock, height int32) error {
+	params := c.Params
+	b := btcutil.NewBlock(block)
+	if c.PowHash == nil {
+		if err := blockchain.CheckBlockSanity(b, params.PowLimit, blockchain.NewMedianTime()); err != nil {
+			return err
+		}
+	} else if err := checkBlockSanity(b); err != nil {
+		return err
+	}
+	if weight := blockchain.GetBlockWeight(b); weight > blockchain.MaxBlockWeight {
+		return fmt.Errorf("block weight %d is more than the maximum of %d", weight, blockchain.MaxBlockWeight)
+	}
+	if err := blockchain.ValidateWitnessCommitment(b); err != nil {
+		return err
+	}
+
+	if height >= params.BIP0034Height {
+		coinbaseHeight, err := blockchain.ExtractCoinbaseHeight(b.Transactions()[0])
+		if err != nil {
+			return err
+		}
+		if coinbaseHeight != height {
+			return fmt.Errorf("coinbase has height %d, expected %d", coinbaseHeight, height)
+		}
+	}
+	return nil
+}
+
+// checkProofOfWork checks that the target of the header is in range and that
+// the header meets it. The proof of work of a merge mined header is the one
+// of its parent block, which is checked with the AuxPoW.
+func checkProofOfWork(c *chain.Chain, header *wire.BlockHeader) error {
+	hash := header.BlockHash()
+	target := blockchain.CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(c.Params.PowLimit) > 0 {
+		return fmt.Errorf("header %v has a target out of range", hash)
+	}
+	if c.AuxPoW != nil && chain.IsAuxPoW(header.Version) {
+		return nil
+	}
+	powHash := c.ProofOfWorkHash(header)
+	if blockchain.HashToBig(&powHash).Cmp(target) > 0 {
+		return fmt.Errorf("header %v does not meet its proof of work target", hash)
+	}
+	return nil
+}
+
+// checkBlockSanity runs the checks of blockchain.CheckBlockSanity but the
+// proof of work, which btcd takes to be the block hash.
+func checkBlockSanity(b *btcutil.Block) error {
+	block := b.MsgBlock()
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block does not contain any transactions")
+	}
+	if size := block.SerializeSizeStripped(); size > blockchain.MaxBlockBaseSize {
+		return fmt.Errorf("serialized block is too big - got %d, max %d", size, blockchain.MaxBlockBaseSize)
+	}
+
+	transactions := b.Transactions()
+	if !blockchain.IsCoinBase(transactions[0]) {
+		return fmt.Errorf("first transaction in block is not a coinbase")
+	}
+	seen := make(map[chainhash.Hash]struct{}, len(transactions))
+	sigOps := 0
+	for i, tx := range transactions {
+		if i > 0 && blockchain.IsCoinBase(tx) {
+			return fmt.Errorf("block contains second coinbase at index %d", i)
+		}
+		if err := blockchain.CheckTransactionSanity(tx); err != nil {
+			return err
+		}
+		if _, ok := seen[*tx.Hash()]; ok {
+			return fmt.Errorf("block contains duplicate transaction %v", tx.Hash())
+		}
+		seen[*tx.Hash()] = struct{}{}
+
+		sigOps += blockchain.CountSigOps(tx) * blockchain.WitnessScaleFactor
+		if sigOps > blockchain.MaxBlockSigOpsCost {
+			return fmt.Errorf("block contains too many signature operations - got %v, max %v", sigOps, blockchain.MaxBlockSigOpsCost)
+		}
+	}
+
+	merkles := blockchain.BuildMerkleTreeStore(transactions, false)
+	if root := merkles[len(merkles)-1]; !root.IsEqual(&block.Header.MerkleRoot) {
+		return fmt.Errorf("block merkle root is invalid - block header indicates %v, but calculated value is %v", block.Header.MerkleRoot, root)
+	}
+	return nil
+}
+
+// medianTimePast returns the median timestamp of the block at the given
+// height and the blocks before it.
+func medianTimePast(headers Chain, height int32) (time.Time, error) {
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for h := height; h >= 0 && len(timestamps) < medianTimeBlocks; h-- {
+		header, err := headers.HeaderAt(h)
+		if err != nil {
+			return time.Time{}, err
+		}
+		timestamps = append(timestamps, header.Timestamp.Unix())
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	return time.Unix(timestamps[len(timestamps)/2], 0), nil
+}
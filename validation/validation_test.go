@@ -0,0 +1,263 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/catalogfi/indexer/chain"
+)
+
+// headers is a header chain starting at the genesis block.
+type headers []*wire.BlockHeader
+
+func (h headers) HeaderAt(height int32) (*wire.BlockHeader, error) {
+	if height < 0 || int(height) >= len(h) {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+	return h[height], nil
+}
+
+// regtest returns the bitcoin regtest chain with a copy of its params that
+// can be modified.
+func regtest(t *testing.T) *chain.Chain {
+	c, err := chain.Lookup("bitcoin", "regtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := *c
+	params := *c.Params
+	copied.Params = &params
+	return &copied
+}
+
+// mine sets the nonce of the header so that it meets its target, or misses
+// it if valid is false.
+func mine(c *chain.Chain, header *wire.BlockHeader, valid bool) {
+	target := blockchain.CompactToBig(c.Params.PowLimitBits)
+	for {
+		hash := c.ProofOfWorkHash(header)
+		if (blockchain.HashToBig(&hash).Cmp(target) <= 0) == valid {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+// testHeaders returns a chain of n mined headers ten minutes apart.
+func testHeaders(c *chain.Chain, n int) headers {
+	chainHeaders := headers{&c.Params.GenesisBlock.Header}
+	for len(chainHeaders) < n {
+		prev := chainHeaders[len(chainHeaders)-1]
+		prevHash := prev.BlockHash()
+		header := wire.NewBlockHeader(4, &prevHash, &chainhash.Hash{}, c.Params.PowLimitBits, 0)
+		header.Timestamp = prev.Timestamp.Add(10 * time.Minute)
+		mine(c, header, true)
+		chainHeaders = append(chainHeaders, header)
+	}
+	return chainHeaders
+}
+
+func TestCheckHeader(t *testing.T) {
+	c := regtest(t)
+	chainHeaders := testHeaders(c, 12)
+	height := int32(len(chainHeaders))
+	tip := chainHeaders[len(chainHeaders)-1]
+	now := tip.Timestamp.Add(time.Hour)
+	// The median of the timestamps of the last 11 headers
+	medianTime := chainHeaders[len(chainHeaders)-6].Timestamp
+
+	tests := []struct {
+		name    string
+		header  func(header *wire.BlockHeader)
+		params  func(params *chaincfg.Params)
+		noPoW   bool
+		wantErr string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name: "bad prev hash",
+			header: func(header *wire.BlockHeader) {
+				header.PrevBlock = chainhash.Hash{1}
+			},
+			wantErr: "does not follow",
+		},
+		{
+			name: "wrong bits",
+			header: func(header *wire.BlockHeader) {
+				header.Bits = 0x1d00ffff
+			},
+			wantErr: "target bits",
+		},
+		{
+			name:    "insufficient proof of work",
+			noPoW:   true,
+			wantErr: "does not meet its proof of work target",
+		},
+		{
+			name: "timestamp at the median time past",
+			header: func(header *wire.BlockHeader) {
+				header.Timestamp = medianTime
+			},
+			wantErr: "before the median time past",
+		},
+		{
+			name: "timestamp before the median time past",
+			header: func(header *wire.BlockHeader) {
+				header.Timestamp = medianTime.Add(-time.Second)
+			},
+			wantErr: "before the median time past",
+		},
+		{
+			name: "timestamp at the maximum future offset",
+			header: func(header *wire.BlockHeader) {
+				header.Timestamp = now.Add(maxTimeOffset)
+			},
+		},
+		{
+			name: "timestamp too far in the future",
+			header: func(header *wire.BlockHeader) {
+				header.Timestamp = now.Add(maxTimeOffset + time.Second)
+			},
+			wantErr: "too far in the future",
+		},
+		{
+			name: "checkpoint mismatch",
+			params: func(params *chaincfg.Params) {
+				params.Checkpoints = []chaincfg.Checkpoint{{Height: height, Hash: &chainhash.Hash{1}}}
+			},
+			wantErr: "does not match the checkpoint",
+		},
+		{
+			name: "checkpoint at another height",
+			params: func(params *chaincfg.Params) {
+				params.Checkpoints = []chaincfg.Checkpoint{{Height: height + 1, Hash: &chainhash.Hash{1}}}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := regtest(t)
+			if test.params != nil {
+				test.params(c.Params)
+			}
+			prevHash := tip.BlockHash()
+			header := wire.NewBlockHeader(4, &prevHash, &chainhash.Hash{}, c.Params.PowLimitBits, 0)
+			header.Timestamp = tip.Timestamp.Add(10 * time.Minute)
+			if test.header != nil {
+				test.header(header)
+			}
+			mine(c, header, !test.noPoW)
+
+			err := CheckHeader(c, chainHeaders, header, height, now)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// testBlock returns a mined block with a coinbase that has the given height
+// in its script.
+func testBlock(c *chain.Chain, prev *wire.BlockHeader, coinbaseHeight int32) *wire.MsgBlock {
+	script, err := txscript.NewScriptBuilder().AddInt64(int64(coinbaseHeight)).AddData([]byte("test")).Script()
+	if err != nil {
+		panic(err)
+	}
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), script, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50e8, []byte{txscript.OP_TRUE}))
+
+	merkles := blockchain.BuildMerkleTreeStore([]*btcutil.Tx{btcutil.NewTx(coinbase)}, false)
+	prevHash := prev.BlockHash()
+	block := wire.NewMsgBlock(wire.NewBlockHeader(4, &prevHash, merkles[len(merkles)-1], c.Params.PowLimitBits, 0))
+	block.Header.Timestamp = prev.Timestamp.Add(10 * time.Minute)
+	block.AddTransaction(coinbase)
+	return block
+}
+
+func TestCheckBlock(t *testing.T) {
+	c := regtest(t)
+	chainHeaders := testHeaders(c, 12)
+	height := int32(len(chainHeaders))
+	tip := chainHeaders[len(chainHeaders)-1]
+
+	tests := []struct {
+		name           string
+		coinbaseHeight int32
+		block          func(block *wire.MsgBlock)
+		params         func(params *chaincfg.Params)
+		wantErr        string
+	}{
+		{
+			name:           "valid",
+			coinbaseHeight: height,
+		},
+		{
+			name:           "bad merkle root",
+			coinbaseHeight: height,
+			block: func(block *wire.MsgBlock) {
+				block.Header.MerkleRoot = chainhash.Hash{1}
+			},
+			wantErr: "merkle root is invalid",
+		},
+		{
+			name:           "BIP34 coinbase height",
+			coinbaseHeight: height,
+			params: func(params *chaincfg.Params) {
+				params.BIP0034Height = 1
+			},
+		},
+		{
+			name:           "wrong BIP34 coinbase height",
+			coinbaseHeight: height - 1,
+			params: func(params *chaincfg.Params) {
+				params.BIP0034Height = 1
+			},
+			wantErr: fmt.Sprintf("coinbase has height %d, expected %d", height-1, height),
+		},
+		{
+			name:           "wrong coinbase height before BIP34",
+			coinbaseHeight: height - 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := regtest(t)
+			if test.params != nil {
+				test.params(c.Params)
+			}
+			block := testBlock(c, tip, test.coinbaseHeight)
+			if test.block != nil {
+				test.block(block)
+			}
+			mine(c, &block.Header, true)
+
+			err := CheckBlock(c, block, height)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}